@@ -0,0 +1,77 @@
+package main
+
+import (
+	"image"
+	"image/color"
+
+	"gazounomawarinoiranaifuchiwokesu/crop"
+)
+
+// luminanceHistogram counts img's pixels by 8-bit luminance.
+func luminanceHistogram(img image.Image) [256]int {
+	var hist [256]int
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			hist[color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y]++
+		}
+	}
+	return hist
+}
+
+// otsuThreshold returns the luminance that best splits hist into a dark
+// class below it and a light class from it on, by maximizing the variance
+// between the two (Otsu's method). It returns 0 when hist has one tone.
+func otsuThreshold(hist [256]int) int {
+	var total, sum float64
+	for v, n := range hist {
+		total += float64(n)
+		sum += float64(v * n)
+	}
+	// Thresholds in an empty stretch between the tones split them equally
+	// well; the middle of the stretch is taken.
+	var darkCount, darkSum, best float64
+	first, last := 0, 0
+	for t := 1; t < 256; t++ {
+		darkCount += float64(hist[t-1])
+		darkSum += float64((t - 1) * hist[t-1])
+		lightCount := total - darkCount
+		if darkCount == 0 || lightCount == 0 {
+			continue
+		}
+		diff := darkSum/darkCount - (sum-darkSum)/lightCount
+		switch between := darkCount * lightCount * diff * diff; {
+		case between > best:
+			best, first, last = between, t, t
+		case between == best:
+			last = t
+		}
+	}
+	return (first + last) / 2
+}
+
+// adaptiveLevels returns black and white levels for img under -mode
+// adaptive: halfway between the Otsu threshold and the peak of the dark
+// and of the light tones respectively, so a background at either peak is
+// classified whatever its exact luminance. ok is false for an image of a
+// single tone, which has no dark and light classes to split.
+func adaptiveLevels(img image.Image) (lv crop.Levels, ok bool) {
+	hist := luminanceHistogram(img)
+	t := otsuThreshold(hist)
+	if t == 0 {
+		return crop.Levels{}, false
+	}
+	peak := func(from, to int) int {
+		p := from
+		for v := from; v < to; v++ {
+			if hist[v] > hist[p] {
+				p = v
+			}
+		}
+		return p
+	}
+	dark, light := peak(0, t), peak(t, 256)
+	black := uint8((dark + t) / 2)
+	white := uint8((light + t + 1) / 2)
+	return crop.UniformLevels(black, white), true
+}
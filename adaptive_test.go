@@ -0,0 +1,45 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestAdaptiveMode(t *testing.T) {
+	content := image.Rect(20, 30, 80, 70)
+	for _, bg := range []uint8{40, 90} {
+		img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+		draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{bg, bg, bg, 255}}, image.Point{}, draw.Src)
+		draw.Draw(img, content, &image.Uniform{color.RGBA{230, 200, 160, 255}}, image.Point{}, draw.Src)
+
+		res, err := planCrop(img, options{Mode: modeAdaptive})
+		if err != nil {
+			t.Fatalf("Background %d: planCrop() error = %v", bg, err)
+		}
+		if res.Bounds != content || res.Mode != ModeBlack {
+			t.Errorf("Background %d: expected %v (black), got %v (%s)", bg, content, res.Bounds, res.Mode)
+		}
+	}
+
+	// The fixed levels only see the darker background as black.
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{90, 90, 90, 255}}, image.Point{}, draw.Src)
+	draw.Draw(img, content, &image.Uniform{color.RGBA{230, 200, 160, 255}}, image.Point{}, draw.Src)
+	if res, _ := planCrop(img, options{}); res.Bounds == content {
+		t.Errorf("Expected the fixed levels to miss a background of 90")
+	}
+
+	if _, ok := adaptiveLevels(image.NewGray(image.Rect(0, 0, 10, 10))); ok {
+		t.Errorf("Expected no adaptive levels for a single tone")
+	}
+}
+
+func TestOtsuThreshold(t *testing.T) {
+	var hist [256]int
+	hist[40], hist[200] = 100, 50
+	if got := otsuThreshold(hist); got <= 40 || got > 200 {
+		t.Errorf("otsuThreshold() = %d, want between the peaks", got)
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"image"
+	"image/color"
+)
+
+// quantizedAlpha shows an image with every pixel's alpha rounded to fully
+// transparent or fully opaque at a midpoint, for -quantize-alpha. It is
+// only ever detected on, so the output keeps its soft edges.
+type quantizedAlpha struct {
+	image.Image
+	// mid is the lowest alpha (0-255) that becomes opaque.
+	mid uint8
+}
+
+func (q quantizedAlpha) At(x, y int) color.Color {
+	c := color.NRGBAModel.Convert(q.Image.At(x, y)).(color.NRGBA)
+	if c.A < q.mid {
+		return color.NRGBA{}
+	}
+	c.A = 0xff
+	return c
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"path/filepath"
+	"strings"
+)
+
+// annotateWidth is the thickness of the outline -annotate draws.
+const annotateWidth = 2
+
+// annotateImage returns a copy of img with rect outlined in c: the outline
+// covers the outermost annotateWidth pixels of rect, clipped to the image,
+// so it shows exactly where the crop edges fall.
+func annotateImage(img image.Image, rect image.Rectangle, c color.Color) *image.RGBA {
+	b := img.Bounds()
+	out := image.NewRGBA(b)
+	draw.Draw(out, b, img, b.Min, draw.Src)
+
+	rect = rect.Intersect(b)
+	fill := &image.Uniform{c}
+	for _, edge := range []image.Rectangle{
+		image.Rect(rect.Min.X, rect.Min.Y, rect.Max.X, rect.Min.Y+annotateWidth),
+		image.Rect(rect.Min.X, rect.Max.Y-annotateWidth, rect.Max.X, rect.Max.Y),
+		image.Rect(rect.Min.X, rect.Min.Y, rect.Min.X+annotateWidth, rect.Max.Y),
+		image.Rect(rect.Max.X-annotateWidth, rect.Min.Y, rect.Max.X, rect.Max.Y),
+	} {
+		draw.Draw(out, edge.Intersect(rect), fill, image.Point{}, draw.Src)
+	}
+	return out
+}
+
+// writeAnnotated saves img with rect outlined in the -annotate-color hex
+// next to outPath as a PNG, with "_annotated" added before the extension
+// (processed_a_annotated.png), and returns the name written.
+func writeAnnotated(img image.Image, rect image.Rectangle, outPath, hex string) (string, error) {
+	c, ok := parseHexColor(hex)
+	if !ok {
+		c = color.RGBA{0xff, 0, 0, 0xff}
+	}
+	path := strings.TrimSuffix(outPath, filepath.Ext(outPath)) + "_annotated.png"
+	if err := saveImage(path, annotateImage(img, rect, c), "png", saveOptions{}); err != nil {
+		return "", err
+	}
+	return filepath.Base(path), nil
+}
+
+// diffTint is laid over the margins -dry-run-diff shows as cropped away.
+var diffTint = color.NRGBA{0xff, 0, 0, 0x80}
+
+// diffImage returns a copy of img with everything outside rect, the
+// margins a crop to rect removes, tinted red.
+func diffImage(img image.Image, rect image.Rectangle) *image.RGBA {
+	b := img.Bounds()
+	out := image.NewRGBA(b)
+	draw.Draw(out, b, img, b.Min, draw.Src)
+	for _, s := range frameStrips(b, rect.Intersect(b)) {
+		draw.Draw(out, s.Rect, &image.Uniform{diffTint}, image.Point{}, draw.Over)
+	}
+	return out
+}
+
+// writeDiff saves img with the margins outside rect tinted next to outPath
+// as a PNG, with "_diff" added before the extension (processed_a_diff.png),
+// and returns the name written.
+func writeDiff(img image.Image, rect image.Rectangle, outPath string) (string, error) {
+	path := strings.TrimSuffix(outPath, filepath.Ext(outPath)) + "_diff.png"
+	if err := saveImage(path, diffImage(img, rect), "png", saveOptions{}); err != nil {
+		return "", err
+	}
+	return filepath.Base(path), nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAnnotate(t *testing.T) {
+	dir := t.TempDir()
+	img := image.NewRGBA(image.Rect(0, 0, 60, 60))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(10, 10, 50, 50), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	writePNG(t, filepath.Join(dir, "a.png"), img)
+
+	res, err := processImage(filepath.Join(dir, "a.png"), dir, "a.png", options{Annotate: true, AnnotateColor: "#00ff00"})
+	if err != nil {
+		t.Fatalf("processImage() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, res.Output)); err != nil {
+		t.Errorf("Expected the cropped output as well, got %v", err)
+	}
+	annotated, _, err := loadImage(filepath.Join(dir, "processed_a_annotated.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if annotated.Bounds() != img.Bounds() {
+		t.Fatalf("Expected the annotated copy at the original size, got %v", annotated.Bounds())
+	}
+
+	green := color.RGBA{0, 0xff, 0, 0xff}
+	for _, p := range []image.Point{{10, 10}, {11, 30}, {30, 10}, {30, 11}, {49, 49}, {48, 30}, {30, 48}} {
+		if got := color.RGBAModel.Convert(annotated.At(p.X, p.Y)); got != green {
+			t.Errorf("Expected the outline at %v, got %v", p, got)
+		}
+	}
+	for p, want := range map[image.Point]color.Color{{12, 30}: color.White, {9, 30}: color.Black, {30, 50}: color.Black} {
+		if got := color.RGBAModel.Convert(annotated.At(p.X, p.Y)); got != color.RGBAModel.Convert(want) {
+			t.Errorf("Expected %v untouched at %v, got %v", want, p, got)
+		}
+	}
+}
+
+func TestDryRunDiff(t *testing.T) {
+	dir := t.TempDir()
+	img := image.NewRGBA(image.Rect(0, 0, 60, 60))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(10, 20, 50, 40), &image.Uniform{color.RGBA{0, 0, 200, 255}}, image.Point{}, draw.Src)
+	writePNG(t, filepath.Join(dir, "a.png"), img)
+
+	res, err := processImage(filepath.Join(dir, "a.png"), dir, "a.png", options{DryRunDiff: true})
+	if err != nil {
+		t.Fatalf("processImage() error = %v", err)
+	}
+	if res.Output != "" || res.Bounds != image.Rect(10, 20, 50, 40) {
+		t.Errorf("Expected the planned bounds and no output, got %v %q", res.Bounds, res.Output)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "processed_a.png")); !os.IsNotExist(err) {
+		t.Errorf("Expected no crop written, got err = %v", err)
+	}
+
+	diff, _, err := loadImage(filepath.Join(dir, "processed_a_diff.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// White under the half-transparent red tint.
+	tinted := color.RGBA{0xff, 0x7f, 0x7f, 0xff}
+	for _, p := range []image.Point{{0, 0}, {30, 10}, {5, 30}, {55, 30}, {30, 45}} {
+		if got := color.RGBAModel.Convert(diff.At(p.X, p.Y)); got != tinted {
+			t.Errorf("Expected the margin tinted at %v, got %v", p, got)
+		}
+	}
+	for _, p := range []image.Point{{10, 20}, {30, 30}, {49, 39}} {
+		if got, want := color.RGBAModel.Convert(diff.At(p.X, p.Y)), img.At(p.X, p.Y); got != want {
+			t.Errorf("Expected the content untouched at %v, got %v", p, got)
+		}
+	}
+}
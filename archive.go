@@ -0,0 +1,205 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// tarKind reports whether path names a tar archive, judging by its
+// extension, and whether the archive is gzip-compressed.
+func tarKind(path string) (isTar, gzipped bool) {
+	name := strings.ToLower(filepath.Base(path))
+	switch {
+	case strings.HasSuffix(name, ".tar"):
+		return true, false
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return true, true
+	}
+	return false, false
+}
+
+// processTar crops every image in the tar archive at tarPath into a new
+// archive "processed_<name>" next to it, compressed like the original.
+// Entries that aren't images are left out. With -dry-run, each image's crop
+// is only logged and no archive is written.
+func processTar(tarPath string, opts options) (err error) {
+	_, gzipped := tarKind(tarPath)
+
+	in, err := os.Open(tarPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	var r io.Reader = in
+	if gzipped {
+		gz, err := gzip.NewReader(in)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	// Like saveImage, build the archive under a temporary name so a failed
+	// run leaves nothing half-written.
+	outPath := filepath.Join(outputDir(filepath.Dir(tarPath), opts), opts.prefix()+filepath.Base(tarPath))
+	var out *os.File
+	var gzw *gzip.Writer
+	var tw *tar.Writer
+	if !opts.DryRun {
+		if opts.OutDir != "" {
+			if err := os.MkdirAll(filepath.Dir(outPath), 0o755); err != nil {
+				return err
+			}
+		}
+		out, err = os.CreateTemp(filepath.Dir(outPath), "."+filepath.Base(outPath)+".tmp-*")
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err != nil {
+				out.Close()
+				os.Remove(out.Name())
+			}
+		}()
+		var w io.Writer = out
+		if gzipped {
+			gzw = gzip.NewWriter(out)
+			w = gzw
+		}
+		tw = tar.NewWriter(w)
+	}
+
+	rep, err := openReports(opts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := rep.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	noBorder := 0
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		// Entries can't be sought, so buffer each one for sniffing and
+		// decoding.
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		if _, ok := formatByMIME(detectContentType(data)); !ok {
+			if err := rep.Add(fileResult{Filename: hdr.Name, Status: "skipped: unsupported format"}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		fmt.Fprintf(logOutput, "Processing: %s\n", hdr.Name)
+		if opts.DryRun {
+			res, err := planTarEntry(data, opts)
+			res.Filename = hdr.Name
+			if err != nil {
+				fmt.Fprintf(logOutput, "  Failed to process %s: %v\n", hdr.Name, err)
+				res.Status = "failed: " + err.Error()
+				if errors.Is(err, ErrNoBorder) {
+					noBorder++
+				}
+			}
+			if err := rep.Add(res); err != nil {
+				return err
+			}
+			continue
+		}
+		var buf bytes.Buffer
+		res, err := cropStream(bytes.NewReader(data), &buf, opts)
+		res.Filename = hdr.Name
+		if err != nil {
+			fmt.Fprintf(logOutput, "  Failed to process %s: %v\n", hdr.Name, err)
+			res.Status = "failed: " + err.Error()
+			if errors.Is(err, ErrNoBorder) {
+				noBorder++
+			}
+		} else {
+			name := hdr.Name
+			if opts.Format != "" {
+				name = strings.TrimSuffix(name, path.Ext(name)) + formats[opts.Format].Extension
+			}
+			outHdr := &tar.Header{
+				Typeflag: tar.TypeReg,
+				Name:     name,
+				Mode:     hdr.Mode,
+				ModTime:  hdr.ModTime,
+				Size:     int64(buf.Len()),
+			}
+			if err := tw.WriteHeader(outHdr); err != nil {
+				return err
+			}
+			if _, err := tw.Write(buf.Bytes()); err != nil {
+				return err
+			}
+			res.Output = name
+		}
+		if err := rep.Add(res); err != nil {
+			return err
+		}
+	}
+
+	if opts.DryRun {
+		return noBorderError(noBorder)
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if gzw != nil {
+		if err := gzw.Close(); err != nil {
+			return err
+		}
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(out.Name(), outPath); err != nil {
+		return err
+	}
+	fmt.Fprintf(logOutput, "Saved %s\n", filepath.Base(outPath))
+	return noBorderError(noBorder)
+}
+
+// planTarEntry detects the crop of the image data of a tar entry for
+// -dry-run and logs it, like processImage does for a file.
+func planTarEntry(data []byte, opts options) (fileResult, error) {
+	img, _, err := decodeImage(bytes.NewReader(data))
+	if err != nil {
+		return fileResult{}, err
+	}
+	res, err := planCrop(img, opts)
+	if err != nil {
+		return res, err
+	}
+	if strings.HasPrefix(res.Status, "skipped:") {
+		opts.logf("  Would skip: %s\n", strings.TrimPrefix(res.Status, "skipped: "))
+		return res, nil
+	}
+	opts.logf("  %s\n", describeDryRun(img.Bounds(), res))
+	return res, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProcessTar(t *testing.T) {
+	for _, name := range []string{"images.tar", "images.tar.gz"} {
+		t.Run(name, func(t *testing.T) {
+			_, gzipped := tarKind(name)
+			dir := t.TempDir()
+			tarPath := filepath.Join(dir, name)
+
+			entries := map[string][]byte{
+				"scans/a.png": pngBytes(t, borderedImage(image.Rect(0, 0, 60, 60), image.Rect(10, 10, 50, 40))),
+				"scans/b.png": pngBytes(t, borderedImage(image.Rect(0, 0, 60, 60), image.Rect(5, 20, 45, 30))),
+				"README.txt":  []byte("not an image"),
+			}
+			writeTar(t, tarPath, gzipped, entries)
+
+			if err := processPath(tarPath, options{}); err != nil {
+				t.Fatalf("processPath() error = %v", err)
+			}
+
+			got := readTar(t, filepath.Join(dir, "processed_"+name), gzipped)
+			expected := map[string]image.Point{
+				"scans/a.png": {40, 30},
+				"scans/b.png": {40, 10},
+			}
+			if len(got) != len(expected) {
+				t.Fatalf("Expected %d entries, got %d", len(expected), len(got))
+			}
+			for entry, size := range expected {
+				data, ok := got[entry]
+				if !ok {
+					t.Errorf("Missing entry %s", entry)
+					continue
+				}
+				img, err := png.Decode(bytes.NewReader(data))
+				if err != nil {
+					t.Fatalf("Decoding %s: %v", entry, err)
+				}
+				if img.Bounds().Size() != size {
+					t.Errorf("%s: expected size %v, got %v", entry, size, img.Bounds().Size())
+				}
+			}
+		})
+	}
+}
+
+func TestProcessTarDryRun(t *testing.T) {
+	dir := t.TempDir()
+	tarPath := filepath.Join(dir, "images.tar")
+	writeTar(t, tarPath, false, map[string][]byte{
+		"scans/a.png": pngBytes(t, borderedImage(image.Rect(0, 0, 60, 60), image.Rect(10, 10, 50, 40))),
+	})
+
+	var buf bytes.Buffer
+	saved := logOutput
+	logOutput = &buf
+	defer func() { logOutput = saved }()
+	if err := processPath(tarPath, options{DryRun: true}); err != nil {
+		t.Fatalf("processPath() error = %v", err)
+	}
+	if want := "Would crop 60x60 to 40x30 at 10,10,50,40"; !strings.Contains(buf.String(), want) {
+		t.Errorf("Expected %q in the output:\n%s", want, buf.String())
+	}
+
+	// Neither the archive nor its temporary file is written.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected the dry run to write nothing, got %v", entries)
+	}
+}
+
+// pngBytes returns img encoded as a PNG.
+func pngBytes(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func writeTar(t *testing.T, path string, gzipped bool, entries map[string][]byte) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var w io.Writer = f
+	var gz *gzip.Writer
+	if gzipped {
+		gz = gzip.NewWriter(f)
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+	for name, data := range entries {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readTar(t *testing.T, path string, gzipped bool) map[string][]byte {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if gzipped {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = gz
+	}
+	entries := map[string][]byte{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[hdr.Name] = data
+	}
+}
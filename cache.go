@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cacheFilename is the -checksum-skip cache kept in each processed directory.
+const cacheFilename = ".cropper-cache.json"
+
+// cacheEntry identifies the input and settings a file was last processed with.
+type cacheEntry struct {
+	Size     int64  `json:"size"`
+	ModTime  int64  `json:"mtime"`
+	Settings string `json:"settings"`
+}
+
+// processCache remembers which files were already processed so repeated runs
+// over the same folder can skip them.
+type processCache struct {
+	path    string
+	Entries map[string]cacheEntry `json:"entries"`
+}
+
+// loadCache reads the cache for dirPath. A missing cache is empty.
+func loadCache(dirPath string) (*processCache, error) {
+	c := &processCache{
+		path:    filepath.Join(dirPath, cacheFilename),
+		Entries: map[string]cacheEntry{},
+	}
+	data, err := os.ReadFile(c.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	if c.Entries == nil {
+		c.Entries = map[string]cacheEntry{}
+	}
+	return c, nil
+}
+
+// Fresh reports whether name was already processed with the same size,
+// modification time and settings.
+func (c *processCache) Fresh(name string, info fs.FileInfo, settings string) bool {
+	e, ok := c.Entries[name]
+	return ok && e == newCacheEntry(info, settings)
+}
+
+// Mark records that name was processed with the given settings.
+func (c *processCache) Mark(name string, info fs.FileInfo, settings string) {
+	c.Entries[name] = newCacheEntry(info, settings)
+}
+
+// Save writes the cache back to its directory.
+func (c *processCache) Save() error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.path, append(data, '\n'), 0o644)
+}
+
+func newCacheEntry(info fs.FileInfo, settings string) cacheEntry {
+	return cacheEntry{Size: info.Size(), ModTime: info.ModTime().UnixNano(), Settings: settings}
+}
+
+// settingsKey returns a digest of the options that affect the output, so that
+// changing any of them invalidates the cache.
+func settingsKey(opts options) (string, error) {
+	// Clear the options that only affect logging, reporting or interaction.
+	opts.TrimReport = ""
+	opts.TruncateReport = false
+	opts.JSONReport = ""
+	opts.DryRun = false
+	opts.Reviewer = nil
+	opts.MoveBad = false
+	opts.FailuresDir = ""
+	opts.ChecksumSkip = false
+	opts.ModifiedSince = time.Time{}
+	opts.DebugTrace = false
+	opts.TraceFile = ""
+	opts.MaxFiles = 0
+	opts.Dedupe = ""
+	opts.Verify = false
+	opts.Incremental = false
+	opts.Workers = 0
+	opts.ImageTimeout = 0
+	opts.ContactSheet = ""
+	opts.Columns = 0
+	opts.ThumbSize = 0
+
+	data, err := json.Marshal(opts)
+	if err != nil {
+		return "", fmt.Errorf("checksum cache settings: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestChecksumSkip(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(20, 20, 80, 80), &image.Uniform{color.White}, image.Point{}, draw.Src)
+
+	dir := t.TempDir()
+	writePNG(t, filepath.Join(dir, "a.png"), img)
+	outPath := filepath.Join(dir, "processed_a.png")
+
+	opts := options{ChecksumSkip: true}
+	if err := processDirectory(dir, opts); err != nil {
+		t.Fatalf("processDirectory() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, cacheFilename)); err != nil {
+		t.Fatalf("Expected cache file, got err = %v", err)
+	}
+
+	// Second run: the unchanged file is skipped, so its output is not
+	// recreated.
+	if err := os.Remove(outPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := processDirectory(dir, opts); err != nil {
+		t.Fatalf("processDirectory() error = %v", err)
+	}
+	if _, err := os.Stat(outPath); !os.IsNotExist(err) {
+		t.Errorf("Expected unchanged file to be skipped, got err = %v", err)
+	}
+
+	// Changing a setting invalidates the cache.
+	opts.MinContentFraction = 0.01
+	if err := processDirectory(dir, opts); err != nil {
+		t.Fatalf("processDirectory() error = %v", err)
+	}
+	if _, err := os.Stat(outPath); err != nil {
+		t.Errorf("Expected reprocessing after a settings change, got err = %v", err)
+	}
+
+	// Touching the file invalidates the cache too.
+	if err := os.Remove(outPath); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "a.png"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := processDirectory(dir, opts); err != nil {
+		t.Fatalf("processDirectory() error = %v", err)
+	}
+	if _, err := os.Stat(outPath); err != nil {
+		t.Errorf("Expected reprocessing after a modification, got err = %v", err)
+	}
+}
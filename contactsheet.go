@@ -0,0 +1,65 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"path/filepath"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// sheetLabelHeight is the height of the filename strip under each
+// thumbnail on a contact sheet.
+const sheetLabelHeight = 16
+
+// writeContactSheet composes the images names in dir into a grid with the
+// given number of columns and saves it as a PNG at path. Each cell holds
+// the image scaled to fit thumbSize and its filename beneath it.
+func writeContactSheet(path, dir string, names []string, columns, thumbSize int, filter string) error {
+	columns = max(1, min(columns, len(names)))
+	rows := (len(names) + columns - 1) / columns
+	cell := image.Pt(thumbSize, thumbSize+sheetLabelHeight)
+
+	sheet := image.NewRGBA(image.Rect(0, 0, columns*cell.X, rows*cell.Y))
+	draw.Draw(sheet, sheet.Bounds(), &image.Uniform{color.White}, image.Point{}, draw.Src)
+
+	for i, name := range names {
+		img, _, err := loadImage(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		thumb, err := resizeImage(img, fitSize(img.Bounds().Size(), thumbSize), filter)
+		if err != nil {
+			return err
+		}
+
+		// Center the thumbnail in its cell, above the label.
+		origin := image.Pt(i%columns*cell.X, i/columns*cell.Y)
+		size := thumb.Bounds().Size()
+		at := origin.Add(image.Pt((thumbSize-size.X)/2, (thumbSize-size.Y)/2))
+		draw.Draw(sheet, image.Rectangle{Min: at, Max: at.Add(size)}, thumb, thumb.Bounds().Min, draw.Src)
+		drawLabel(sheet, origin.Add(image.Pt(0, thumbSize)), thumbSize, name)
+	}
+
+	return saveImage(path, sheet, "png", saveOptions{})
+}
+
+// drawLabel writes name in black into the width-pixel-wide strip at origin,
+// cutting it short with "..." if it doesn't fit.
+func drawLabel(dst draw.Image, origin image.Point, width int, name string) {
+	face := basicfont.Face7x13
+	fit := width / face.Advance
+	if len(name) > fit {
+		name = name[:max(0, fit-3)] + "..."
+	}
+	d := &font.Drawer{
+		Dst:  dst,
+		Src:  image.Black,
+		Face: face,
+		Dot:  fixed.P(origin.X+2, origin.Y+face.Ascent+1),
+	}
+	d.DrawString(name)
+}
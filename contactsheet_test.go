@@ -0,0 +1,41 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"path/filepath"
+	"testing"
+)
+
+func TestContactSheet(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.png", "b.png", "c.png"} {
+		img := image.NewRGBA(image.Rect(0, 0, 200, 120))
+		draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+		draw.Draw(img, image.Rect(20, 20, 180, 100), &image.Uniform{color.RGBA{200, 0, 0, 255}}, image.Point{}, draw.Src)
+		writePNG(t, filepath.Join(dir, name), img)
+	}
+
+	sheetPath := filepath.Join(t.TempDir(), "sheet.png")
+	opts := options{ContactSheet: sheetPath, Columns: 2, ThumbSize: 50, ResizeFilter: "catmullrom"}
+	if err := processDirectory(dir, opts); err != nil {
+		t.Fatalf("processDirectory() error = %v", err)
+	}
+
+	sheet, format, err := loadImage(sheetPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "png" {
+		t.Errorf("Expected a png contact sheet, got %s", format)
+	}
+	// Three thumbnails in two columns make two rows of labeled cells.
+	if got, expected := sheet.Bounds().Size(), image.Pt(2*50, 2*(50+sheetLabelHeight)); got != expected {
+		t.Errorf("Expected sheet size %v, got %v", expected, got)
+	}
+	// The second cell of the first row holds b.png's cropped content.
+	if r, g, b, _ := sheet.At(75, 25).RGBA(); r>>8 < 150 || g>>8 > 50 || b>>8 > 50 {
+		t.Errorf("Expected the red crop in the second cell, got %v", sheet.At(75, 25))
+	}
+}
@@ -0,0 +1,94 @@
+package crop
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// Result is the outcome of cropping one image with Batch or BatchStream.
+type Result struct {
+	// Name is the input's key.
+	Name string
+	// Bounds is the rectangle of the input that was kept.
+	Bounds image.Rectangle
+	// Data is the encoded output, nil if the image failed.
+	Data []byte
+	// Err is why the image failed, or nil.
+	Err error
+}
+
+// Batch crops every image in inputs, keyed by name, as Stream would,
+// running at most opts.Workers at a time. It never touches the filesystem.
+// Every input gets a Result; the returned error joins the failures, if any.
+func Batch(inputs map[string]io.Reader, opts ProcessOptions) (map[string]Result, error) {
+	results := make(map[string]Result, len(inputs))
+	var errs []error
+	for result := range BatchStream(context.Background(), inputs, opts) {
+		results[result.Name] = result
+		if result.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", result.Name, result.Err))
+		}
+	}
+	return results, errors.Join(errs...)
+}
+
+// BatchStream is Batch delivering each Result on the returned channel as
+// soon as its image is done, in completion order. The channel is closed
+// once every image is done. Cancelling ctx stops starting new images and
+// drops the results not yet received, then closes the channel.
+func BatchStream(ctx context.Context, inputs map[string]io.Reader, opts ProcessOptions) <-chan Result {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	out := make(chan Result)
+	go func() {
+		defer close(out)
+		var wg sync.WaitGroup
+		defer wg.Wait()
+
+		sem := make(chan struct{}, workers)
+		for name, r := range inputs {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+
+				result := streamResult(name, r, opts)
+				select {
+				case out <- result:
+				case <-ctx.Done():
+				}
+			}()
+		}
+	}()
+	return out
+}
+
+// streamResult crops the image read from r into a Result named name.
+func streamResult(name string, r io.Reader, opts ProcessOptions) Result {
+	var out bytes.Buffer
+	bounds, err := Stream(r, &out, opts)
+	result := Result{Name: name, Bounds: bounds, Err: err}
+	if err == nil {
+		result.Data = out.Bytes()
+	}
+	return result
+}
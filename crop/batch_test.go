@@ -0,0 +1,111 @@
+package crop_test
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"gazounomawarinoiranaifuchiwokesu/crop"
+)
+
+// batchInputs returns PNG inputs with the content bounds each should be
+// cropped to, plus a "notes.txt" input that is not an image.
+func batchInputs(t *testing.T) (map[string]io.Reader, map[string]image.Rectangle) {
+	t.Helper()
+	inputs := map[string]io.Reader{}
+	expected := map[string]image.Rectangle{
+		"a.png": image.Rect(10, 10, 50, 50),
+		"b.png": image.Rect(20, 5, 90, 40),
+		"c.png": image.Rect(0, 30, 60, 100),
+	}
+	for name, content := range expected {
+		img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+		draw.Draw(img, img.Bounds(), &image.Uniform{color.White}, image.Point{}, draw.Src)
+		draw.Draw(img, content, &image.Uniform{color.RGBA{40, 80, 120, 255}}, image.Point{}, draw.Src)
+		inputs[name] = encodePNG(t, img)
+	}
+	inputs["notes.txt"] = strings.NewReader("not an image")
+	return inputs, expected
+}
+
+func TestBatch(t *testing.T) {
+	inputs, expected := batchInputs(t)
+
+	opts := crop.DefaultProcessOptions()
+	opts.Workers = 2
+	results, err := crop.Batch(inputs, opts)
+	if err == nil || !strings.Contains(err.Error(), "notes.txt") {
+		t.Errorf("Expected an error naming notes.txt, got %v", err)
+	}
+	if len(results) != len(inputs) {
+		t.Fatalf("Expected %d results, got %d", len(inputs), len(results))
+	}
+
+	for name, content := range expected {
+		res := results[name]
+		if res.Err != nil || res.Bounds != content {
+			t.Errorf("%s: expected bounds %v, got %v (err %v)", name, content, res.Bounds, res.Err)
+			continue
+		}
+		out, err := png.Decode(bytes.NewReader(res.Data))
+		if err != nil {
+			t.Fatalf("%s: decoding output: %v", name, err)
+		}
+		if got := out.Bounds().Size(); got != content.Size() {
+			t.Errorf("%s: expected a %v output, got %v", name, content.Size(), got)
+		}
+	}
+	if res := results["notes.txt"]; res.Err == nil || res.Data != nil || res.Name != "notes.txt" {
+		t.Errorf("notes.txt: expected a failed result, got %+v", res)
+	}
+}
+
+func TestBatchStream(t *testing.T) {
+	inputs, expected := batchInputs(t)
+
+	opts := crop.DefaultProcessOptions()
+	opts.Workers = 2
+	seen := map[string]int{}
+	timeout := time.After(10 * time.Second)
+	results := crop.BatchStream(context.Background(), inputs, opts)
+	for done := false; !done; {
+		select {
+		case res, ok := <-results:
+			if !ok {
+				done = true
+				break
+			}
+			seen[res.Name]++
+			if content, ok := expected[res.Name]; ok && res.Bounds != content {
+				t.Errorf("%s: expected bounds %v, got %v", res.Name, content, res.Bounds)
+			}
+		case <-timeout:
+			t.Fatalf("Channel not closed; got results for %v", seen)
+		}
+	}
+	for name := range inputs {
+		if seen[name] != 1 {
+			t.Errorf("%s: expected one result, got %d", name, seen[name])
+		}
+	}
+
+	// Cancelled up front, nothing is processed and the channel still closes.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	inputs, _ = batchInputs(t)
+	opts.Workers = 1
+	n := 0
+	for range crop.BatchStream(ctx, inputs, opts) {
+		n++
+	}
+	if n != 0 {
+		t.Errorf("Expected no results after cancellation, got %d", n)
+	}
+}
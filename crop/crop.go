@@ -0,0 +1,598 @@
+// Package crop finds and removes the black or white border around an
+// image, such as the frame of a scan or the letterbox of a screenshot.
+//
+// The background is decided by the colors at the image's corners (or,
+// when the corners are inconclusive, the midpoints of its edges), and the
+// rows and columns that are mostly that color are trimmed from each side.
+// An image whose four corners are fully transparent has its transparent
+// margin trimmed instead:
+//
+//	bounds := crop.Bounds(img, crop.DefaultOptions())
+//	cropped, err := crop.Image(img, crop.DefaultOptions())
+//
+// Scanner exposes the scan itself for callers with their own notion of
+// background, such as the border-remover command's colored backgrounds.
+package crop
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// The defaults of Options.
+const (
+	// DefaultBlackThreshold is high enough to catch dark gray shadows and
+	// borders.
+	DefaultBlackThreshold = 60
+	DefaultWhiteThreshold = 195
+	DefaultNoiseTolerance = 0.95
+	DefaultLookaheadGap   = 5
+	DefaultAlphaThreshold = 128
+)
+
+// ErrEmpty is returned by Image when the whole image is background.
+var ErrEmpty = errors.New("image is completely black or empty")
+
+// Levels holds the black and white thresholds (0-255) of each of the R, G
+// and B channels. A color is black when every channel is at or below its
+// Black level, and white when every channel is at or above its White
+// level.
+type Levels struct {
+	Black [3]uint8
+	White [3]uint8
+}
+
+// UniformLevels returns the Levels that apply black and white to all three
+// channels.
+func UniformLevels(black, white uint8) Levels {
+	return Levels{
+		Black: [3]uint8{black, black, black},
+		White: [3]uint8{white, white, white},
+	}
+}
+
+// IsBlack reports whether c is black under l.
+func (l Levels) IsBlack(c color.Color) bool {
+	r, g, b, _ := c.RGBA()
+	return r>>8 <= uint32(l.Black[0]) && g>>8 <= uint32(l.Black[1]) && b>>8 <= uint32(l.Black[2])
+}
+
+// IsWhite reports whether c is white under l.
+func (l Levels) IsWhite(c color.Color) bool {
+	r, g, b, _ := c.RGBA()
+	return r>>8 >= uint32(l.White[0]) && g>>8 >= uint32(l.White[1]) && b>>8 >= uint32(l.White[2])
+}
+
+// Loosen returns l with the black levels raised and the white levels
+// lowered by slack, within 0-255.
+func (l Levels) Loosen(slack uint8) Levels {
+	for i := range l.Black {
+		l.Black[i] = uint8(min(int(l.Black[i])+int(slack), 255))
+		l.White[i] = uint8(max(int(l.White[i])-int(slack), 0))
+	}
+	return l
+}
+
+// Options controls border detection.
+type Options struct {
+	// Levels are the black and white thresholds. They are used as given,
+	// so start from DefaultOptions.
+	Levels Levels
+	// NoiseTolerance is the fraction of a row or column that must be
+	// background to trim it. Zero uses DefaultNoiseTolerance.
+	NoiseTolerance float64
+	// LookaheadGap is how many lines past a line that is not background
+	// are checked: if they all are, the line is taken for noise (e.g. a
+	// scratch) and trimmed too. Zero uses DefaultLookaheadGap.
+	LookaheadGap int
+	// Transparent detects a transparent background in an image whose four
+	// corners all have alpha 0, whatever their color.
+	Transparent bool
+	// AlphaThreshold is the alpha (0-255) a pixel of a transparent
+	// background must be below. Zero uses DefaultAlphaThreshold.
+	AlphaThreshold uint8
+	// EdgeSampleRate, when positive, has VoteBackground poll every
+	// EdgeSampleRate-th pixel along the four edges (1 being all of them)
+	// instead of the corners and edge midpoints.
+	EdgeSampleRate int
+}
+
+// DefaultOptions returns the options the border-remover command uses by
+// default.
+func DefaultOptions() Options {
+	return Options{
+		Levels:         UniformLevels(DefaultBlackThreshold, DefaultWhiteThreshold),
+		NoiseTolerance: DefaultNoiseTolerance,
+		LookaheadGap:   DefaultLookaheadGap,
+		Transparent:    true,
+		AlphaThreshold: DefaultAlphaThreshold,
+	}
+}
+
+// Background is the color of an image's border.
+type Background int
+
+const (
+	// None means no black or white border was found.
+	None Background = iota
+	Black
+	White
+	// Transparent means the corners are fully transparent, and the border
+	// is the pixels below Options.AlphaThreshold whatever their color.
+	Transparent
+)
+
+func (b Background) String() string {
+	switch b {
+	case Black:
+		return "black"
+	case White:
+		return "white"
+	case Transparent:
+		return "transparent"
+	default:
+		return "none"
+	}
+}
+
+// IsBlack reports whether each of c's R, G and B values is at most
+// threshold.
+func IsBlack(c color.Color, threshold uint8) bool {
+	return UniformLevels(threshold, 255).IsBlack(c)
+}
+
+// IsWhite reports whether each of c's R, G and B values is at least
+// threshold.
+func IsWhite(c color.Color, threshold uint8) bool {
+	return UniformLevels(0, threshold).IsWhite(c)
+}
+
+// IsTransparent reports whether c's alpha is below threshold.
+func IsTransparent(c color.Color, threshold uint8) bool {
+	_, _, _, a := c.RGBA()
+	return a>>8 < uint32(threshold)
+}
+
+// IsBackground reports whether c is the background color bg under o.
+func (o Options) IsBackground(c color.Color, bg Background) bool {
+	switch bg {
+	case Black:
+		return o.Levels.IsBlack(c)
+	case White:
+		return o.Levels.IsWhite(c)
+	case Transparent:
+		threshold := o.AlphaThreshold
+		if threshold == 0 {
+			threshold = DefaultAlphaThreshold
+		}
+		return IsTransparent(c, threshold)
+	default:
+		return false
+	}
+}
+
+// Vote is how DetectBackground decided an image's background.
+type Vote struct {
+	Background Background
+	// Midpoints is set when none of the corners was black or white, so the
+	// midpoints of the edges decided.
+	Midpoints bool
+	// Tie is set when black only won a tie with white.
+	Tie bool
+}
+
+// DetectBackground returns the border color of img; see VoteBackground.
+func DetectBackground(img image.Image, opts Options) Background {
+	return VoteBackground(img, opts).Background
+}
+
+// VoteBackground decides the border color of img: Transparent when
+// opts.Transparent is set and its four corners all have alpha 0, as their
+// color says nothing about the background, and otherwise the majority of
+// its four corners, or else of the midpoints of its four edges. With
+// opts.EdgeSampleRate the majority of the sampled edge pixels decides
+// instead. A tie between black and white goes to black.
+func VoteBackground(img image.Image, opts Options) Vote {
+	if opts.Transparent && TransparentCorners(img) {
+		return Vote{Background: Transparent}
+	}
+	if opts.EdgeSampleRate > 0 {
+		bg, tie := PollEdges(img, opts.EdgeSampleRate, opts.Levels)
+		return Vote{Background: bg, Tie: tie}
+	}
+	corners, midpoints := SamplePoints(img.Bounds())
+	if bg, tie := Poll(img, corners, opts.Levels); bg != None {
+		return Vote{Background: bg, Tie: tie}
+	}
+	bg, tie := Poll(img, midpoints, opts.Levels)
+	return Vote{Background: bg, Midpoints: true, Tie: tie}
+}
+
+// SamplePoints returns the four corners of bounds and the midpoints of its
+// four edges, which DetectBackground samples.
+func SamplePoints(bounds image.Rectangle) (corners, midpoints []image.Point) {
+	corners = []image.Point{
+		{bounds.Min.X, bounds.Min.Y},
+		{bounds.Max.X - 1, bounds.Min.Y},
+		{bounds.Min.X, bounds.Max.Y - 1},
+		{bounds.Max.X - 1, bounds.Max.Y - 1},
+	}
+
+	midX := bounds.Min.X + bounds.Dx()/2
+	midY := bounds.Min.Y + bounds.Dy()/2
+	midpoints = []image.Point{
+		{midX, bounds.Min.Y},
+		{midX, bounds.Max.Y - 1},
+		{bounds.Min.X, midY},
+		{bounds.Max.X - 1, midY},
+	}
+	return corners, midpoints
+}
+
+// TransparentCorners reports whether the four corners of img all have
+// alpha 0.
+func TransparentCorners(img image.Image) bool {
+	corners, _ := SamplePoints(img.Bounds())
+	for _, p := range corners {
+		if _, _, _, a := img.At(p.X, p.Y).RGBA(); a != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Poll returns the majority background color of points in img, which is
+// None if none of them is black or white. tie is set when black only won
+// a tie with white.
+func Poll(img image.Image, points []image.Point, lv Levels) (bg Background, tie bool) {
+	black, white := 0, 0
+	for _, p := range points {
+		c := img.At(p.X, p.Y)
+		if lv.IsBlack(c) {
+			black++
+		} else if lv.IsWhite(c) {
+			white++
+		}
+	}
+	return majority(black, white)
+}
+
+// PollEdges is Poll over every step-th pixel along each of the four edges
+// of img, starting at the corners.
+func PollEdges(img image.Image, step int, lv Levels) (bg Background, tie bool) {
+	b := img.Bounds()
+	black, white := 0, 0
+	count := func(x, y int) {
+		c := img.At(x, y)
+		if lv.IsBlack(c) {
+			black++
+		} else if lv.IsWhite(c) {
+			white++
+		}
+	}
+	for x := b.Min.X; x < b.Max.X; x += step {
+		count(x, b.Min.Y)
+		count(x, b.Max.Y-1)
+	}
+	for y := b.Min.Y; y < b.Max.Y; y += step {
+		count(b.Min.X, y)
+		count(b.Max.X-1, y)
+	}
+	return majority(black, white)
+}
+
+// majority returns the background color of the black and white tallies;
+// see Poll.
+func majority(black, white int) (bg Background, tie bool) {
+	switch {
+	case black > white:
+		return Black, false
+	case white > black:
+		return White, false
+	case black > 0:
+		return Black, true
+	default:
+		return None, false
+	}
+}
+
+// Bounds returns the part of img inside its border, in img's coordinates.
+// It is img.Bounds() when no border is found, and empty when the whole
+// image is background.
+func Bounds(img image.Image, opts Options) image.Rectangle {
+	bg := DetectBackground(img, opts)
+	bounds := img.Bounds()
+	if bg == None {
+		return bounds
+	}
+	isBackground := func(x, y int) bool {
+		return opts.IsBackground(img.At(x, y), bg)
+	}
+	return Scan(bounds, isBackground, opts)
+}
+
+// BoundsInRect is Bounds within region of img only, as if img were cropped
+// to it: the background, transparent included, is decided at the region's
+// corners and the scans never leave it. The bounds are in img's
+// coordinates, so the results of the tiles of a very large image can be
+// merged directly. An empty rectangle means the region is all background.
+func BoundsInRect(img image.Image, region image.Rectangle, opts Options) image.Rectangle {
+	region = region.Intersect(img.Bounds())
+	if region.Empty() {
+		return image.Rectangle{}
+	}
+	// The standard image types keep their type and coordinates in a
+	// SubImage.
+	var sub image.Image = clipped{img, region}
+	if s, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		sub = s.SubImage(region)
+	}
+	return Bounds(sub, opts)
+}
+
+// clipped restricts an image to a rectangle without copying it or changing
+// its coordinates.
+type clipped struct {
+	image.Image
+	rect image.Rectangle
+}
+
+func (c clipped) Bounds() image.Rectangle { return c.rect }
+
+// Scan trims the lines of bounds that isBackground classifies as mostly
+// background from each side in turn; see Scanner.
+func Scan(bounds image.Rectangle, isBackground func(x, y int) bool, opts Options) image.Rectangle {
+	return NewScanner(isBackground, opts).Scan(bounds)
+}
+
+// Side is a side of an image.
+type Side int
+
+// The sides in the order Scanner trims them, unless ColumnsFirst is set.
+const (
+	Top Side = iota
+	Bottom
+	Left
+	Right
+)
+
+func (s Side) String() string {
+	return [...]string{"top", "bottom", "left", "right"}[s]
+}
+
+// Scanner trims the lines that are mostly background from the sides of an
+// image: the top, then the bottom, then the left and the right. Columns are
+// measured only within the rows kept, so content in the trimmed top and
+// bottom margins can't hold a column. ColumnsFirst swaps the axes.
+type Scanner struct {
+	// IsBackground classifies the pixels of each side's lines, indexed by
+	// Side.
+	IsBackground [4]func(x, y int) bool
+	// NoiseTolerance is the fraction of a line of each side that must be
+	// background to trim it, indexed by Side. Zero uses
+	// DefaultNoiseTolerance.
+	NoiseTolerance [4]float64
+	// LookaheadGap is as in Options.
+	LookaheadGap int
+	// MaxDepth, if positive, is how far in from its edge each side may be
+	// trimmed.
+	MaxDepth int
+	// MinRun, if positive, keeps the trim of a side only if it starts with
+	// that many removable lines at the edge.
+	MinRun int
+	// MinContentRun, if positive, also trims a line whose runs of content
+	// are all shorter than it, such as the cross-section of a thin line
+	// running into the margin.
+	MinContentRun int
+	// ColumnsFirst trims the left and the right before the top and the
+	// bottom, measuring rows only within the columns kept.
+	ColumnsFirst bool
+	// Stop, if set, is called before each line is measured; once it
+	// reports true, no more lines are trimmed.
+	Stop func() bool
+	// Trace, if set, receives a message for each line measured and each
+	// decision made.
+	Trace func(format string, args ...any)
+}
+
+// NewScanner returns a Scanner that measures every side with isBackground
+// and the tolerance and lookahead of opts.
+func NewScanner(isBackground func(x, y int) bool, opts Options) Scanner {
+	s := Scanner{LookaheadGap: opts.LookaheadGap}
+	for side := range s.IsBackground {
+		s.IsBackground[side] = isBackground
+		s.NoiseTolerance[side] = opts.NoiseTolerance
+	}
+	return s
+}
+
+func (s Scanner) tracef(format string, args ...any) {
+	if s.Trace != nil {
+		s.Trace(format, args...)
+	}
+}
+
+// removable reports whether line i of side is mostly background, measured
+// across from to to (exclusive) along the other axis.
+func (s Scanner) removable(side Side, i, from, to int) bool {
+	if s.Stop != nil && s.Stop() {
+		return false
+	}
+	tolerance := s.NoiseTolerance[side]
+	if tolerance == 0 {
+		tolerance = DefaultNoiseTolerance
+	}
+	isBackground := s.IsBackground[side]
+	vertical := side == Left || side == Right
+
+	n, run, longestRun := 0, 0, 0
+	for j := from; j < to; j++ {
+		x, y := j, i
+		if vertical {
+			x, y = i, j
+		}
+		if isBackground(x, y) {
+			n++
+			run = 0
+		} else {
+			run++
+			longestRun = max(longestRun, run)
+		}
+	}
+	removable := float64(n)/float64(to-from) >= tolerance ||
+		(s.MinContentRun > 0 && longestRun > 0 && longestRun < s.MinContentRun)
+	line := "row"
+	if vertical {
+		line = "col"
+	}
+	s.tracef("%s %d: %d/%d background, removable=%t", line, i, n, to-from, removable)
+	return removable
+}
+
+// trim scans side from edge by step and returns the first line kept. The
+// scan gives up at stop (exclusive); limit (exclusive) is the far end of
+// the lines, which the lookahead must stay before. A line that is not
+// removable is still trimmed if the gap lines after it are.
+func (s Scanner) trim(side Side, edge, stop, limit, step, from, to int) int {
+	gap := s.LookaheadGap
+	if gap == 0 {
+		gap = DefaultLookaheadGap
+	}
+	line := "row"
+	if side == Left || side == Right {
+		line = "col"
+	}
+
+	i := edge
+	for ; i != stop; i += step {
+		if s.removable(side, i, from, to) {
+			continue
+		}
+		passed := (limit-i)*step > gap
+		for k := 1; passed && k <= gap; k++ {
+			passed = s.removable(side, i+k*step, from, to)
+		}
+		if !passed {
+			s.tracef("%s: lookahead past %s %d failed, stopping", side, line, i)
+			break
+		}
+		s.tracef("%s: lookahead past %s %d passed, continuing", side, line, i)
+	}
+	return i
+}
+
+// keepRun returns kept, the first line kept by the trim of side from edge,
+// or edge if MinRun undoes the trim: it only stands if it starts with a
+// run of MinRun removable lines at the edge. The run lies within the
+// trimmed lines, as the scan would have trimmed it.
+func (s Scanner) keepRun(side Side, edge, kept, step, from, to int) int {
+	trimmed := (kept - edge) * step
+	if s.MinRun <= 0 || trimmed == 0 {
+		return kept
+	}
+	run := trimmed >= s.MinRun
+	for k := 0; run && k < s.MinRun; k++ {
+		run = s.removable(side, edge+k*step, from, to)
+	}
+	if run {
+		return kept
+	}
+	line := "row"
+	if side == Left || side == Right {
+		line = "col"
+	}
+	s.tracef("%s: fewer than %d removable %ss at the edge, not trimming", side, s.MinRun, line)
+	return edge
+}
+
+// Scan returns the part of bounds left after trimming each side. It is
+// empty if every line is background.
+func (s Scanner) Scan(bounds image.Rectangle) image.Rectangle {
+	// The first side scanned finds out whether the image is empty.
+	first := Top
+	if s.ColumnsFirst {
+		first = Left
+	}
+
+	// depth returns where the scan from edge by step gives up.
+	depth := func(edge, limit, step int) int {
+		if s.MaxDepth <= 0 {
+			return limit
+		}
+		if step > 0 {
+			return min(edge+s.MaxDepth, limit)
+		}
+		return max(edge-s.MaxDepth, limit)
+	}
+
+	// side trims side from edge towards limit (exclusive), measuring its
+	// lines from from to to.
+	side := func(side Side, edge, limit, step, from, to int) int {
+		stop := depth(edge, limit, step)
+		kept := s.trim(side, edge, stop, limit, step, from, to)
+		if side == first {
+			// An image that is background all the way from its first
+			// side is empty whatever MaxDepth says, so keep looking
+			// past the limit before clamping to it.
+			if kept == stop && stop != limit && s.trim(side, kept, limit, limit, step, from, to) >= limit {
+				return limit
+			}
+			if kept >= limit {
+				return kept
+			}
+		}
+		return s.keepRun(side, edge, kept, step, from, to)
+	}
+
+	if s.ColumnsFirst {
+		minX := side(Left, bounds.Min.X, bounds.Max.X, 1, bounds.Min.Y, bounds.Max.Y)
+		if minX >= bounds.Max.X {
+			s.tracef("every col is background")
+			return image.Rectangle{}
+		}
+		maxX := side(Right, bounds.Max.X-1, minX-1, -1, bounds.Min.Y, bounds.Max.Y) + 1
+		minY := side(Top, bounds.Min.Y, bounds.Max.Y, 1, minX, maxX)
+		maxY := side(Bottom, bounds.Max.Y-1, minY-1, -1, minX, maxX) + 1
+		return image.Rect(minX, minY, maxX, maxY)
+	}
+
+	minY := side(Top, bounds.Min.Y, bounds.Max.Y, 1, bounds.Min.X, bounds.Max.X)
+	if minY >= bounds.Max.Y {
+		s.tracef("every row is background")
+		return image.Rectangle{}
+	}
+	maxY := side(Bottom, bounds.Max.Y-1, minY-1, -1, bounds.Min.X, bounds.Max.X) + 1
+	minX := side(Left, bounds.Min.X, bounds.Max.X, 1, minY, maxY)
+	maxX := side(Right, bounds.Max.X-1, minX-1, -1, minY, maxY) + 1
+	return image.Rect(minX, minY, maxX, maxY)
+}
+
+// Image returns img cropped to Bounds. The result shares img's pixels when
+// img supports SubImage. ErrEmpty is returned if the whole image is
+// background.
+func Image(img image.Image, opts Options) (image.Image, error) {
+	bounds := Bounds(img, opts)
+	if bounds.Empty() {
+		return nil, ErrEmpty
+	}
+	return SubImage(img, bounds, false), nil
+}
+
+// SubImage returns the part of img inside rect. Unless independent is set,
+// the result shares img's pixels when img supports SubImage; otherwise it
+// is a copy with its origin at (0, 0).
+func SubImage(img image.Image, rect image.Rectangle, independent bool) image.Image {
+	if sub, ok := img.(interface {
+		SubImage(r image.Rectangle) image.Image
+	}); ok && !independent {
+		return sub.SubImage(rect)
+	}
+
+	dst := image.NewRGBA(rect.Sub(rect.Min))
+	draw.Draw(dst, dst.Bounds(), img, rect.Min, draw.Src)
+	return dst
+}
@@ -0,0 +1,329 @@
+package crop
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestIsBlack(t *testing.T) {
+	tests := []struct {
+		name     string
+		color    color.Color
+		expected bool
+	}{
+		{"Black", color.RGBA{0, 0, 0, 255}, true},
+		{"Near Black", color.RGBA{10, 10, 10, 255}, true},
+		{"Old Threshold Limit (15)", color.RGBA{15, 15, 15, 255}, true},
+		{"New Threshold Limit (60)", color.RGBA{60, 60, 60, 255}, true},
+		{"Above Check (61)", color.RGBA{61, 61, 61, 255}, false},
+		{"White", color.RGBA{255, 255, 255, 255}, false},
+		{"Red", color.RGBA{255, 0, 0, 255}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsBlack(tt.color, DefaultBlackThreshold); got != tt.expected {
+				t.Errorf("IsBlack() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestIsBlackThresholds(t *testing.T) {
+	gray := color.Gray{80}
+	for _, tt := range []struct {
+		threshold uint8
+		expected  bool
+	}{
+		{60, false},
+		{79, false},
+		{80, true},
+		{120, true},
+	} {
+		if got := IsBlack(gray, tt.threshold); got != tt.expected {
+			t.Errorf("IsBlack(%v, %d) = %v, want %v", gray, tt.threshold, got, tt.expected)
+		}
+	}
+	if !IsWhite(color.Gray{200}, DefaultWhiteThreshold) || IsWhite(color.Gray{200}, 210) {
+		t.Errorf("IsWhite: expected gray 200 to be white at %d but not at 210", DefaultWhiteThreshold)
+	}
+}
+
+func TestFindContentBounds(t *testing.T) {
+	// Helper to create a uniform image
+	createImage := func(w, h int, c color.Color) *image.RGBA {
+		img := image.NewRGBA(image.Rect(0, 0, w, h))
+		draw.Draw(img, img.Bounds(), &image.Uniform{c}, image.Point{}, draw.Src)
+		return img
+	}
+
+	// Helper to draw a rect
+	drawRect := func(img *image.RGBA, x0, y0, x1, y1 int, c color.Color) {
+		draw.Draw(img, image.Rect(x0, y0, x1, y1), &image.Uniform{c}, image.Point{}, draw.Src)
+	}
+
+	t.Run("Black Border with White Content", func(t *testing.T) {
+		// 100x100 black image (target: Black)
+		img := createImage(100, 100, color.Black)
+		// White content rect at (20,20)-(80,80)
+		// Even if content is white, it should NOT be cropped because target is Black.
+		// Wait, if target is Black, white pixels are "content".
+		drawRect(img, 20, 20, 80, 80, color.White)
+
+		bounds := Bounds(img, DefaultOptions())
+		// Expect crop to the white box
+		expected := image.Rect(20, 20, 80, 80)
+		if bounds != expected {
+			t.Errorf("Expected %v, got %v", expected, bounds)
+		}
+	})
+
+	t.Run("White Border with Black Content", func(t *testing.T) {
+		// 100x100 white image (target: White)
+		img := createImage(100, 100, color.White)
+		// Black content rect at (20,20)-(80,80)
+		// Target is White, so Black pixels are content.
+		drawRect(img, 20, 20, 80, 80, color.Black)
+
+		bounds := Bounds(img, DefaultOptions())
+		expected := image.Rect(20, 20, 80, 80)
+		if bounds != expected {
+			t.Errorf("Expected %v, got %v", expected, bounds)
+		}
+	})
+
+	t.Run("Mixed Background (Ambiguous)", func(t *testing.T) {
+		// If corners are mixed, we expect NO cropping (safe fallback).
+		img := createImage(100, 100, color.Gray16{Y: 30000}) // Gray
+		// TopLeft: Black
+		img.Set(0, 0, color.Black)
+		// BottomRight: White
+		img.Set(99, 99, color.White)
+
+		bounds := Bounds(img, DefaultOptions())
+		expected := image.Rect(0, 0, 100, 100)
+		if bounds != expected {
+			t.Errorf("Expected full image %v, got %v", expected, bounds)
+		}
+	})
+
+	t.Run("Black Border protects White Content edge", func(t *testing.T) {
+		// Scenario: Black border, but inside there is a White block touching the crop edge.
+		// If we didn't lock the mode to Black, the White block might be eaten if we treated White as removable too.
+		img := createImage(100, 100, color.Black)
+		// Draw White Content at (10, 10) to (90, 90)
+		drawRect(img, 10, 10, 90, 90, color.White)
+
+		// Corners are Black (0,0), (99,0) etc. -> Mode = Black.
+		// Process should remove black border 0-10.
+		// At y=10, row becomes White.
+		// Since Mode=Black, White pixels are NOT removable.
+		// So cropping should stop exactly at 10.
+
+		bounds := Bounds(img, DefaultOptions())
+		expected := image.Rect(10, 10, 90, 90)
+		if bounds != expected {
+			t.Errorf("Expected %v, got %v", expected, bounds)
+		}
+	})
+
+	t.Run("Noise Tolerance (Black Border)", func(t *testing.T) {
+		// 100x100 Black
+		img := createImage(100, 100, color.Black)
+		// Content
+		drawRect(img, 20, 20, 80, 80, color.White)
+
+		// Add noise to the black border (e.g. at y=5, put some white dots)
+		// 95% tolerance means in a 100px row, we can have up to 5 bad pixels.
+		for x := 0; x < 4; x++ {
+			img.Set(x, 5, color.White)
+		}
+
+		bounds := Bounds(img, DefaultOptions())
+		expected := image.Rect(20, 20, 80, 80)
+		if bounds != expected {
+			t.Errorf("Expected %v, got %v", expected, bounds)
+		}
+	})
+
+	t.Run("Lookahead Gap (Skipping dirty lines)", func(t *testing.T) {
+		// 100x100 Black
+		img := createImage(100, 100, color.Black)
+		// Content starts at 30
+		drawRect(img, 30, 30, 70, 70, color.White)
+
+		// Dirty line at y=10 (Full white line)
+		// This line is NOT removable (it's 100% white, and mode is Black).
+		// But it's followed by 19 lines of pure Black (11 to 29).
+		// Logic with lookaheadGap=5 should skip this single dirty line IF lookahead sees removable lines.
+		// Wait, lookaheadGap=5 checks only next 5 lines.
+		// The lines 11,12,13,14,15 are Black (Removable).
+		// So y=10 should be skipped.
+		for x := 0; x < 100; x++ {
+			img.Set(x, 10, color.White)
+		}
+
+		bounds := Bounds(img, DefaultOptions())
+		expected := image.Rect(30, 30, 70, 70)
+		if bounds != expected {
+			t.Errorf("Expected %v, got %v", expected, bounds)
+		}
+	})
+}
+
+func TestCropImageIndependent(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	rect := image.Rect(2, 2, 8, 8)
+
+	// By default the crop shares the source's pixels.
+	shared := SubImage(src, rect, false).(draw.Image)
+	shared.Set(3, 3, color.White)
+	if src.RGBAAt(3, 3) != (color.RGBA{255, 255, 255, 255}) {
+		t.Fatalf("Expected shared crop to write through to the source")
+	}
+
+	copied := SubImage(src, rect, true).(draw.Image)
+	if copied.Bounds().Size() != rect.Size() {
+		t.Fatalf("Expected size %v, got %v", rect.Size(), copied.Bounds().Size())
+	}
+	b := copied.Bounds()
+	copied.Set(b.Min.X+2, b.Min.Y+2, color.Black)
+	if got := src.RGBAAt(4, 4); got != (color.RGBA{}) {
+		t.Errorf("Mutating the copy changed the source: %v", got)
+	}
+	if got := copied.At(b.Min.X+1, b.Min.Y+1); got != (color.RGBA{255, 255, 255, 255}) {
+		t.Errorf("Expected copy to start with the source's pixels, got %v", got)
+	}
+}
+
+func TestImage(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(10, 20, 60, 90), &image.Uniform{color.RGBA{200, 30, 30, 255}}, image.Point{}, draw.Src)
+
+	cropped, err := Image(img, DefaultOptions())
+	if err != nil {
+		t.Fatalf("Image() error = %v", err)
+	}
+	if got, want := cropped.Bounds(), image.Rect(10, 20, 60, 90); got != want {
+		t.Errorf("Expected bounds %v, got %v", want, got)
+	}
+
+	blank := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	if _, err := Image(blank, DefaultOptions()); err != ErrEmpty {
+		t.Errorf("Expected ErrEmpty for a blank image, got %v", err)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	// A dark gray frame around white content is only black with a higher
+	// black threshold.
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Gray{80}}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(20, 20, 80, 80), &image.Uniform{color.White}, image.Point{}, draw.Src)
+
+	if got := Bounds(img, DefaultOptions()); got != img.Bounds() {
+		t.Errorf("Expected no crop with the default threshold, got %v", got)
+	}
+	opts := DefaultOptions()
+	opts.Levels = UniformLevels(90, DefaultWhiteThreshold)
+	if got, want := Bounds(img, opts), image.Rect(20, 20, 80, 80); got != want {
+		t.Errorf("Expected %v with black threshold 90, got %v", want, got)
+	}
+
+	// A dirty line 10 rows in is skipped by the default lookahead, but
+	// stops one long enough to reach the content.
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(30, 30, 70, 70), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, 10, 100, 11), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	opts = DefaultOptions()
+	opts.LookaheadGap = 25
+	if got, want := Bounds(img, opts), image.Rect(30, 10, 70, 70); got != want {
+		t.Errorf("Expected the dirty line to stop a 25-line lookahead at %v, got %v", want, got)
+	}
+}
+
+func TestTransparent(t *testing.T) {
+	// Opaque black content on a transparent margin whose color channels
+	// are white, with a transparent hole in the middle.
+	img := image.NewNRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.NRGBA{255, 255, 255, 0}}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(20, 30, 80, 70), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(30, 40, 70, 60), &image.Uniform{color.Transparent}, image.Point{}, draw.Src)
+
+	if got := DetectBackground(img, DefaultOptions()); got != Transparent {
+		t.Errorf("Expected a transparent background, got %v", got)
+	}
+	if got, want := Bounds(img, DefaultOptions()), image.Rect(20, 30, 80, 70); got != want {
+		t.Errorf("Expected only the outer margin trimmed to %v, got %v", want, got)
+	}
+
+	// A single opaque corner makes the corners vote on color again.
+	img.Set(0, 0, color.Black)
+	if got := DetectBackground(img, DefaultOptions()); got == Transparent {
+		t.Errorf("Expected an opaque corner to rule out a transparent background")
+	}
+}
+
+// borderedImage returns a size x size image with content of the other
+// color covering all but a border margin wide.
+func borderedImage(size, margin int, border, content color.Color) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(img, img.Bounds(), &image.Uniform{border}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(margin, margin, size-margin, size-margin), &image.Uniform{content}, image.Point{}, draw.Src)
+	return img
+}
+
+func TestEdgeSampleRate(t *testing.T) {
+	// On a clean border every sample rate agrees with polling all of the
+	// edge pixels.
+	for _, tt := range []struct {
+		border, content color.Color
+		want            Background
+	}{
+		{color.Black, color.White, Black},
+		{color.White, color.Black, White},
+	} {
+		img := borderedImage(500, 20, tt.border, tt.content)
+		full := VoteBackground(img, Options{Levels: DefaultOptions().Levels, EdgeSampleRate: 1})
+		if full.Background != tt.want {
+			t.Fatalf("Full edge vote = %v, want %v", full.Background, tt.want)
+		}
+		for _, rate := range []int{2, 7, 64, 1000} {
+			opts := Options{Levels: DefaultOptions().Levels, EdgeSampleRate: rate}
+			if got := VoteBackground(img, opts); got != full {
+				t.Errorf("Edge vote every %d pixels = %+v, want %+v as for every pixel", rate, got, full)
+			}
+		}
+	}
+
+	// White specks on three corners of a black border outvote it at the
+	// corners, but not along the edges.
+	img := borderedImage(100, 10, color.Black, color.Gray{128})
+	for _, p := range []image.Point{{0, 0}, {99, 0}, {0, 99}} {
+		img.Set(p.X, p.Y, color.White)
+	}
+	if got := DetectBackground(img, DefaultOptions()); got != White {
+		t.Errorf("Expected the corners to vote white, got %v", got)
+	}
+	opts := DefaultOptions()
+	opts.EdgeSampleRate = 4
+	if got := DetectBackground(img, opts); got != Black {
+		t.Errorf("Expected the edges to vote black, got %v", got)
+	}
+}
+
+func BenchmarkEdgeSampleRate(b *testing.B) {
+	img := borderedImage(8000, 200, color.Black, color.White)
+	for _, rate := range []int{1, 16} {
+		opts := Options{Levels: DefaultOptions().Levels, EdgeSampleRate: rate}
+		b.Run(fmt.Sprintf("Every%d", rate), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				VoteBackground(img, opts)
+			}
+		})
+	}
+}
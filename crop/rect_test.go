@@ -0,0 +1,57 @@
+package crop_test
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"gazounomawarinoiranaifuchiwokesu/crop"
+)
+
+// clipped hides the SubImage method of the image it wraps.
+type clipped struct {
+	image.Image
+}
+
+func TestBoundsInRect(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 200, 200))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(120, 20, 180, 60), &image.Uniform{color.RGBA{200, 30, 30, 255}}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(10, 130, 70, 190), &image.Uniform{color.RGBA{30, 30, 200, 255}}, image.Point{}, draw.Src)
+	// A transparent tile with opaque content in the bottom right.
+	draw.Draw(img, image.Rect(100, 100, 200, 200), &image.Uniform{color.Transparent}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(140, 150, 170, 180), &image.Uniform{color.RGBA{30, 200, 30, 255}}, image.Point{}, draw.Src)
+
+	opts := crop.DefaultOptions()
+	for _, region := range []image.Rectangle{
+		image.Rect(100, 0, 200, 100),
+		image.Rect(0, 100, 100, 200),
+		image.Rect(0, 0, 100, 100),
+		image.Rect(100, 100, 200, 200),
+	} {
+		// The same tile as an image of its own, at the origin.
+		tile := image.NewRGBA(image.Rect(0, 0, region.Dx(), region.Dy()))
+		draw.Draw(tile, tile.Bounds(), img, region.Min, draw.Src)
+		want := crop.Bounds(tile, opts)
+		if !want.Empty() {
+			want = want.Add(region.Min)
+		}
+
+		for name, src := range map[string]image.Image{
+			"RGBA":             img,
+			"without SubImage": clipped{img},
+		} {
+			if got := crop.BoundsInRect(src, region, opts); got != want {
+				t.Errorf("%s region %v: expected %v, got %v", name, region, want, got)
+			}
+		}
+	}
+
+	if got, want := crop.BoundsInRect(img, image.Rect(100, 100, 200, 200), opts), image.Rect(140, 150, 170, 180); got != want {
+		t.Errorf("Expected the transparent tile trimmed to %v, got %v", want, got)
+	}
+	if got := crop.BoundsInRect(img, image.Rect(300, 300, 400, 400), opts); !got.Empty() {
+		t.Errorf("Expected empty bounds for a region outside the image, got %v", got)
+	}
+}
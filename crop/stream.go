@@ -0,0 +1,62 @@
+package crop
+
+import (
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+// ProcessOptions controls Stream, Batch and BatchStream.
+type ProcessOptions struct {
+	// Options controls border detection.
+	Options
+	// Format is the output format: "png", "jpeg" or "gif". Empty keeps
+	// the input's format.
+	Format string
+	// Workers is how many images Batch and BatchStream crop at once.
+	// Zero uses runtime.GOMAXPROCS.
+	Workers int
+}
+
+// DefaultProcessOptions returns DefaultOptions, keeping the input's
+// format.
+func DefaultProcessOptions() ProcessOptions {
+	return ProcessOptions{Options: DefaultOptions()}
+}
+
+// Stream decodes an image from r, crops it to Bounds and encodes the
+// result to w, in opts.Format if set or else the input's format. It never
+// touches the filesystem. Any format registered with the image package can
+// be read, but only PNG, JPEG and GIF written. It returns the rectangle of
+// the input that was kept; ErrEmpty is returned, and nothing written, if
+// the whole image is background.
+func Stream(r io.Reader, w io.Writer, opts ProcessOptions) (image.Rectangle, error) {
+	img, format, err := image.Decode(r)
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+	if opts.Format != "" {
+		format = opts.Format
+	}
+	if format != "png" && format != "jpeg" && format != "gif" {
+		return image.Rectangle{}, fmt.Errorf("%w: can't encode %s", image.ErrFormat, format)
+	}
+
+	bounds := Bounds(img, opts.Options)
+	if bounds.Empty() {
+		return bounds, ErrEmpty
+	}
+	cropped := SubImage(img, bounds, false)
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(w, cropped, nil)
+	case "gif":
+		err = gif.Encode(w, cropped, nil)
+	default:
+		err = png.Encode(w, cropped)
+	}
+	return bounds, err
+}
@@ -0,0 +1,104 @@
+package crop_test
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"gazounomawarinoiranaifuchiwokesu/crop"
+)
+
+// encodePNG returns img encoded as a PNG.
+func encodePNG(t *testing.T, img image.Image) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestStream(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(20, 30, 80, 60), &image.Uniform{color.White}, image.Point{}, draw.Src)
+
+	var jpg bytes.Buffer
+	if err := jpeg.Encode(&jpg, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		in     *bytes.Buffer
+		format string
+	}{
+		{"png", encodePNG(t, img), "png"},
+		{"jpeg", &jpg, "jpeg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			bounds, err := crop.Stream(tt.in, &out, crop.DefaultProcessOptions())
+			if err != nil {
+				t.Fatalf("Stream() error = %v", err)
+			}
+			if expected := image.Rect(20, 30, 80, 60); bounds != expected {
+				t.Errorf("Expected bounds %v, got %v", expected, bounds)
+			}
+
+			got, format, err := image.Decode(&out)
+			if err != nil {
+				t.Fatalf("Decoding output: %v", err)
+			}
+			if format != tt.format {
+				t.Errorf("Expected %s output, got %s", tt.format, format)
+			}
+			if size, expected := got.Bounds().Size(), image.Pt(60, 30); size != expected {
+				t.Errorf("Expected size %v, got %v", expected, size)
+			}
+		})
+	}
+}
+
+func TestStreamForcedFormat(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 50, 50))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(10, 10, 40, 40), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+
+	opts := crop.DefaultProcessOptions()
+	opts.Format = "jpeg"
+	var out bytes.Buffer
+	if _, err := crop.Stream(encodePNG(t, img), &out, opts); err != nil {
+		t.Fatalf("Stream() error = %v", err)
+	}
+	if _, format, err := image.DecodeConfig(&out); err != nil || format != "jpeg" {
+		t.Errorf("Expected jpeg output, got %q (err %v)", format, err)
+	}
+
+	opts.Format = "webp"
+	if _, err := crop.Stream(encodePNG(t, img), &bytes.Buffer{}, opts); !errors.Is(err, image.ErrFormat) {
+		t.Errorf("Expected image.ErrFormat for webp output, got %v", err)
+	}
+}
+
+func TestStreamErrors(t *testing.T) {
+	_, err := crop.Stream(bytes.NewReader([]byte("not an image")), &bytes.Buffer{}, crop.DefaultProcessOptions())
+	if !errors.Is(err, image.ErrFormat) {
+		t.Errorf("Expected image.ErrFormat, got %v", err)
+	}
+
+	black := image.NewRGBA(image.Rect(0, 0, 20, 20))
+	draw.Draw(black, black.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	var out bytes.Buffer
+	if _, err := crop.Stream(encodePNG(t, black), &out, crop.DefaultProcessOptions()); !errors.Is(err, crop.ErrEmpty) {
+		t.Errorf("Expected crop.ErrEmpty, got %v", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("Expected nothing written for an empty crop, got %d bytes", out.Len())
+	}
+}
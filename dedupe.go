@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Dedupe modes for outputs with identical contents.
+const (
+	dedupeLink   = "link"
+	dedupeDelete = "delete"
+)
+
+// dedupeOutputs finds files among names in dir with identical contents and,
+// keeping the first of each set, replaces the rest with hard links to it
+// (dedupeLink) or deletes them (dedupeDelete). A name listed more than once,
+// as when two inputs wrote the same output, is one file, not a duplicate.
+func dedupeOutputs(dir string, names []string, mode string) error {
+	seen := make(map[[sha256.Size]byte]string)
+	done := make(map[string]bool)
+	for _, name := range names {
+		if done[name] {
+			continue
+		}
+		done[name] = true
+		path := filepath.Join(dir, name)
+		sum, err := hashFile(path)
+		if err != nil {
+			return err
+		}
+		first, dup := seen[sum]
+		if !dup {
+			seen[sum] = name
+			continue
+		}
+
+		if mode == dedupeLink {
+			if err := replaceWithLink(filepath.Join(dir, first), path); err != nil {
+				return err
+			}
+			fmt.Fprintf(logOutput, "  Linked duplicate %s to %s\n", name, first)
+		} else {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			fmt.Fprintf(logOutput, "  Deleted duplicate %s of %s\n", name, first)
+		}
+	}
+	return nil
+}
+
+// replaceWithLink replaces path with a hard link to target. The link is made
+// under a temporary name next to path and renamed over it, so path is never
+// missing, even if linking fails.
+func replaceWithLink(target, path string) error {
+	// Reserve a free name, then free it for the link.
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".link-*")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	if err := os.Remove(tmp.Name()); err != nil {
+		return err
+	}
+	if err := os.Link(target, tmp.Name()); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+func hashFile(path string) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	f, err := os.Open(path)
+	if err != nil {
+		return sum, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return sum, err
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDedupe(t *testing.T) {
+	for _, mode := range []string{dedupeLink, dedupeDelete} {
+		t.Run(mode, func(t *testing.T) {
+			dir := t.TempDir()
+
+			// The same content inside borders of different widths.
+			for i, name := range []string{"a.png", "b.png"} {
+				img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+				draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+				off := 10 * (i + 1)
+				draw.Draw(img, image.Rect(off, off, off+40, off+40), &image.Uniform{color.White}, image.Point{}, draw.Src)
+				writePNG(t, filepath.Join(dir, name), img)
+			}
+
+			if err := processDirectory(dir, options{Dedupe: mode}); err != nil {
+				t.Fatalf("processDirectory() error = %v", err)
+			}
+
+			a, err := os.Stat(filepath.Join(dir, "processed_a.png"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := os.Stat(filepath.Join(dir, "processed_b.png"))
+			switch mode {
+			case dedupeLink:
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !os.SameFile(a, b) {
+					t.Error("Expected processed_b.png to be a hard link to processed_a.png")
+				}
+			case dedupeDelete:
+				if !os.IsNotExist(err) {
+					t.Errorf("Expected processed_b.png to be deleted, got %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestReplaceWithLinkFailure(t *testing.T) {
+	// Linking to a missing file fails without losing the duplicate.
+	dir := t.TempDir()
+	path := filepath.Join(dir, "processed_b.png")
+	if err := os.WriteFile(path, []byte("duplicate"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := replaceWithLink(filepath.Join(dir, "missing.png"), path); err == nil {
+		t.Fatal("Expected an error linking to a missing file")
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "duplicate" {
+		t.Errorf("Expected processed_b.png kept, got %q, %v", data, err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("Expected no temporary files left, got %v", entries)
+	}
+}
+
+func TestDedupeRepeatedName(t *testing.T) {
+	// With -format png, a.gif and a.png both write processed_a.png.
+	for _, mode := range []string{dedupeLink, dedupeDelete} {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "processed_a.png")
+		if err := os.WriteFile(path, []byte("output"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := dedupeOutputs(dir, []string{"processed_a.png", "processed_a.png"}, mode); err != nil {
+			t.Fatalf("%s: dedupeOutputs() error = %v", mode, err)
+		}
+		if got, err := os.ReadFile(path); err != nil || string(got) != "output" {
+			t.Errorf("%s: expected the output kept, got %q (%v)", mode, got, err)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"sort"
+)
+
+// readDirChunk is how many directory entries are read at a time, so huge
+// directories start processing immediately and never sit in memory whole.
+var readDirChunk = 256
+
+// dirReader is the part of *os.File used to list a directory.
+type dirReader interface {
+	ReadDir(n int) ([]fs.DirEntry, error)
+}
+
+// forEachDirEntry calls fn for each entry of d, reading chunk entries at a
+// time. Entries are sorted by name within each chunk, so directories smaller
+// than a chunk are processed in name order. fn returns fs.SkipAll to stop
+// early.
+func forEachDirEntry(d dirReader, chunk int, fn func(fs.DirEntry) error) error {
+	for {
+		entries, err := d.ReadDir(chunk)
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+		for _, entry := range entries {
+			if ferr := fn(entry); errors.Is(ferr, fs.SkipAll) {
+				return nil
+			} else if ferr != nil {
+				return ferr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// countingDir records the size of each ReadDir request.
+type countingDir struct {
+	dirReader
+	calls []int
+}
+
+func (d *countingDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	d.calls = append(d.calls, n)
+	return d.dirReader.ReadDir(n)
+}
+
+func TestForEachDirEntryChunks(t *testing.T) {
+	const total = 1000
+	fsys := fstest.MapFS{}
+	for i := 0; i < total; i++ {
+		fsys[fmt.Sprintf("dir/f%04d.png", i)] = &fstest.MapFile{}
+	}
+	f, err := fsys.Open("dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	d := &countingDir{dirReader: f.(fs.ReadDirFile)}
+
+	var names []string
+	err = forEachDirEntry(d, 64, func(e fs.DirEntry) error {
+		names = append(names, e.Name())
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("forEachDirEntry() error = %v", err)
+	}
+	if len(names) != total {
+		t.Fatalf("Expected %d entries, got %d", total, len(names))
+	}
+	// 1000 entries in chunks of 64 take 16 reads, plus one that hits EOF.
+	if len(d.calls) < total/64 {
+		t.Errorf("Expected chunked reads, got %d calls", len(d.calls))
+	}
+	for _, n := range d.calls {
+		if n != 64 {
+			t.Errorf("Expected every read to ask for 64 entries, got %d", n)
+		}
+	}
+
+	// Returning fs.SkipAll stops without reading further.
+	f2, _ := fsys.Open("dir")
+	defer f2.Close()
+	d = &countingDir{dirReader: f2.(fs.ReadDirFile)}
+	seen := 0
+	err = forEachDirEntry(d, 64, func(e fs.DirEntry) error {
+		seen++
+		if seen == 10 {
+			return fs.SkipAll
+		}
+		return nil
+	})
+	if err != nil || seen != 10 || len(d.calls) != 1 {
+		t.Errorf("Expected to stop after 10 entries and 1 read, got %d entries, %d reads, err %v", seen, len(d.calls), err)
+	}
+}
+
+func TestMaxFiles(t *testing.T) {
+	dir := t.TempDir()
+	img := image.NewRGBA(image.Rect(0, 0, 20, 20))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(5, 5, 15, 15), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	for _, name := range []string{"a.png", "b.png", "c.png"} {
+		writePNG(t, filepath.Join(dir, name), img)
+	}
+
+	if err := processDirectory(dir, options{MaxFiles: 2}); err != nil {
+		t.Fatalf("processDirectory() error = %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var outputs []string
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), "processed_") {
+			outputs = append(outputs, e.Name())
+		}
+	}
+	if len(outputs) != 2 {
+		t.Errorf("Expected 2 outputs with -max-files 2, got %v", outputs)
+	}
+}
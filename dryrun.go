@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"strings"
+)
+
+// describeDryRun returns the -dry-run line for an image of imgBounds
+// planned as res.
+func describeDryRun(imgBounds image.Rectangle, res fileResult) string {
+	size := imgBounds.Size()
+	b := res.Bounds
+	if b == imgBounds {
+		reason := "no border found"
+		switch {
+		case strings.HasPrefix(res.Status, "kept original: "):
+			reason = strings.TrimPrefix(res.Status, "kept original: ")
+		case strings.HasPrefix(res.Status, "unchanged: "):
+			reason = strings.TrimPrefix(res.Status, "unchanged: ")
+		case res.Mode == ModeNone:
+			reason = "no background detected"
+		}
+		return fmt.Sprintf("Would keep %dx%d uncropped (%s)", size.X, size.Y, reason)
+	}
+	return fmt.Sprintf("Would crop %dx%d to %dx%d at %s: top %d, bottom %d, left %d, right %d",
+		size.X, size.Y, b.Dx(), b.Dy(), formatBounds(b),
+		b.Min.Y-imgBounds.Min.Y, imgBounds.Max.Y-b.Max.Y, b.Min.X-imgBounds.Min.X, imgBounds.Max.X-b.Max.X)
+}
+
+// decodeFailureError is the error a -dry-run returns when n of its images
+// failed to decode, or nil if n is zero.
+func decodeFailureError(n int) error {
+	if n == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %d image(s)", ErrDecode, n)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDryRun(t *testing.T) {
+	dir := t.TempDir()
+	writePNG(t, filepath.Join(dir, "scan.png"), borderedImage(image.Rect(0, 0, 100, 80), image.Rect(10, 5, 70, 60)))
+	photo := image.NewRGBA(image.Rect(0, 0, 50, 50))
+	for y := 0; y < 50; y++ {
+		for x := 0; x < 50; x++ {
+			photo.Set(x, y, color.RGBA{uint8(100 + x*3), uint8(50 + y*3), 128, 255})
+		}
+	}
+	writePNG(t, filepath.Join(dir, "photo.png"), photo)
+	writePNG(t, filepath.Join(dir, "processed_old.png"), photo)
+
+	var buf bytes.Buffer
+	saved := logOutput
+	logOutput = &buf
+	defer func() { logOutput = saved }()
+	if err := processDirectory(dir, options{DryRun: true, ChecksumSkip: true}); err != nil {
+		t.Fatalf("processDirectory() error = %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"Would crop 100x80 to 60x55 at 10,5,70,60: top 5, bottom 20, left 10, right 30",
+		"Would keep 50x50 uncropped (no background detected)",
+		"Skipping processed_old.png: already processed",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in the output:\n%s", want, out)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Errorf("Expected the dry run to write nothing, got %v", entries)
+	}
+
+	// A file that fails to decode fails the run.
+	if err := os.WriteFile(filepath.Join(dir, "broken.png"), []byte("\x89PNG\r\n\x1a\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := processDirectory(dir, options{DryRun: true}); !errors.Is(err, ErrDecode) {
+		t.Errorf("Expected an ErrDecode error, got %v", err)
+	}
+}
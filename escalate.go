@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"image"
+)
+
+// escalation is one rung of the -auto-escalate ladder: a description for
+// the log and the change it makes to the options.
+type escalation struct {
+	name   string
+	loosen func(*options)
+}
+
+// escalationLadder loosens detection step by step. The steps are
+// cumulative, so each rung keeps the settings of the ones before it.
+var escalationLadder = []escalation{
+	{"noise-tolerance 0.90", func(o *options) { o.NoiseTolerance = loosenFraction(o.NoiseTolerance, 0.90) }},
+	{"black level 90, white level 165", func(o *options) {
+		lv := o.levels()
+		for i := range lv.Black {
+			lv.Black[i] = max(lv.Black[i], 90)
+			lv.White[i] = min(lv.White[i], 165)
+		}
+		o.Levels = &lv
+	}},
+	{"noise-tolerance 0.80", func(o *options) { o.NoiseTolerance = loosenFraction(o.NoiseTolerance, 0.80) }},
+	{"corner-agreement-tolerance 32", func(o *options) { o.CornerAgreementTolerance = max(o.CornerAgreementTolerance, 32) }},
+}
+
+// escalateMinFraction is the smallest fraction of the image an escalated
+// crop may keep when -min-content-fraction is not set. Looser settings
+// eat into the content more easily, so a smaller crop is taken as the
+// content having been trimmed away.
+const escalateMinFraction = 0.05
+
+// loosenFraction returns the looser (lower) of the background fraction
+// current, where zero is the default, and f.
+func loosenFraction(current, f float64) float64 {
+	if current == 0 {
+		current = defaultNoiseTolerance
+	}
+	return min(current, f)
+}
+
+// escalationFound reports whether det is a crop -auto-escalate accepts: a
+// background was found and the bounds are neither empty, the whole image,
+// nor an implausibly small or thin part of it.
+func escalationFound(img image.Image, det detection, opts options) bool {
+	if det.Mode == ModeNone || det.Bounds.Empty() || det.Bounds == img.Bounds() {
+		return false
+	}
+	minFraction := opts.MinContentFraction
+	if minFraction == 0 {
+		minFraction = escalateMinFraction
+	}
+	whole := img.Bounds()
+	fraction := float64(det.Bounds.Dx()*det.Bounds.Dy()) / float64(whole.Dx()*whole.Dy())
+	if fraction < minFraction {
+		return false
+	}
+	return opts.MaxAspectChange == 0 || aspectChange(whole, det.Bounds) <= opts.MaxAspectChange
+}
+
+// escalate retries detection on img with each rung of escalationLadder in
+// turn, and returns the first detection escalationFound accepts with the
+// options it was made with.
+func escalate(img image.Image, opts options) (detection, options, bool) {
+	loose := opts
+	for _, step := range escalationLadder {
+		step.loosen(&loose)
+		det, detOpts := detectModes(img, loose)
+		if opts.ctx != nil && opts.ctx.Err() != nil {
+			break
+		}
+		if escalationFound(img, det, detOpts) {
+			opts.logf("  Auto-escalate: cropped with %s\n", step.name)
+			return det, detOpts, true
+		}
+		if opts.trace != nil {
+			fmt.Fprintf(opts.trace, "  trace: auto-escalate with %s found no crop\n", step.name)
+		}
+	}
+	opts.logf("  Auto-escalate: no looser setting found a crop\n")
+	return detection{}, opts, false
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestAutoEscalate(t *testing.T) {
+	// A dark gray frame, just above the default black level, around
+	// orange content.
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{80, 80, 80, 255}}, image.Point{}, draw.Src)
+	content := image.Rect(20, 15, 85, 70)
+	draw.Draw(img, content, &image.Uniform{color.RGBA{200, 120, 40, 255}}, image.Point{}, draw.Src)
+
+	res, err := planCrop(img, options{})
+	if err != nil {
+		t.Fatalf("planCrop() error = %v", err)
+	}
+	if res.Bounds != img.Bounds() {
+		t.Fatalf("Expected the default settings to find no crop, got %v", res.Bounds)
+	}
+
+	res, err = planCrop(img, options{AutoEscalate: true})
+	if err != nil {
+		t.Fatalf("planCrop() error = %v", err)
+	}
+	if res.Bounds != content || res.Mode != ModeBlack || res.Status != "cropped" {
+		t.Errorf("Expected an escalated black crop to %v, got %v (%s, %s)", content, res.Bounds, res.Mode, res.Status)
+	}
+
+	// A plain photo has no border at any rung.
+	photo := image.NewRGBA(image.Rect(0, 0, 50, 50))
+	for y := 0; y < 50; y++ {
+		for x := 0; x < 50; x++ {
+			photo.Set(x, y, color.RGBA{uint8(100 + x*3), uint8(50 + y*3), 128, 255})
+		}
+	}
+	res, err = planCrop(photo, options{AutoEscalate: true})
+	if err != nil {
+		t.Fatalf("planCrop() error = %v", err)
+	}
+	if res.Bounds != photo.Bounds() {
+		t.Errorf("Expected the photo to be left uncropped, got %v", res.Bounds)
+	}
+}
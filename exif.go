@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"strings"
+)
+
+// exifTag locates an EXIF tag: in the Exif sub-directory or in the first
+// image file directory.
+type exifTag struct {
+	sub bool
+	tag uint16
+}
+
+// exifTags are the tags -output-template and -metadata-options can refer
+// to by name.
+var exifTags = map[string]exifTag{
+	"DateTimeOriginal":  {sub: true, tag: 0x9003},
+	"DateTimeDigitized": {sub: true, tag: 0x9004},
+	"DateTime":          {tag: 0x0132},
+	"Make":              {tag: 0x010f},
+	"Model":             {tag: 0x0110},
+	"ImageDescription":  {tag: 0x010e},
+}
+
+// tiffTagExifIFD points from the first directory to the Exif sub-directory.
+const tiffTagExifIFD = 0x8769
+
+// jpegEXIF returns the TIFF-structured EXIF payload of the JPEG file in
+// data, from its APP1 segment.
+func jpegEXIF(data []byte) ([]byte, bool) {
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, false
+	}
+	for i := 2; i+4 <= len(data) && data[i] == 0xff; {
+		marker := data[i+1]
+		if marker == 0xda || marker == 0xd9 { // start of scan, end of image
+			break
+		}
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+		if size < 2 || i+2+size > len(data) {
+			break
+		}
+		segment := data[i+4 : i+2+size]
+		if marker == 0xe1 {
+			if payload, ok := bytes.CutPrefix(segment, []byte("Exif\x00\x00")); ok {
+				return payload, true
+			}
+		}
+		i += 2 + size
+	}
+	return nil, false
+}
+
+// exifString returns the text of the ASCII tag name in the EXIF payload
+// tiff, as returned by jpegEXIF.
+func exifString(tiff []byte, name string) (string, bool) {
+	loc, ok := exifTags[name]
+	if !ok {
+		return "", false
+	}
+	order, entries, err := tiffEntries(tiff)
+	if err != nil {
+		return "", false
+	}
+	if loc.sub {
+		entries = exifSubDirectory(tiff, order, entries)
+	}
+	for _, e := range entries {
+		if e.tag != loc.tag || e.typ != tiffTypeASCII || e.count == 0 {
+			continue
+		}
+		value := tiff[e.offset+8 : e.offset+12]
+		if e.count > 4 {
+			off := int(order.Uint32(value))
+			if off < 0 || off+int(e.count) > len(tiff) {
+				return "", false
+			}
+			value = tiff[off : off+int(e.count)]
+		}
+		s := strings.TrimSpace(strings.TrimRight(string(value[:min(int(e.count), len(value))]), "\x00"))
+		return s, s != ""
+	}
+	return "", false
+}
+
+// exifSubDirectory returns the entries of the Exif sub-directory that the
+// first directory's entries point to, or nil.
+func exifSubDirectory(tiff []byte, order binary.ByteOrder, entries []tiffEntry) []tiffEntry {
+	for _, e := range entries {
+		if e.tag == tiffTagExifIFD && e.typ == tiffTypeLong && e.count == 1 {
+			sub, err := tiffDirectory(tiff, order, int(order.Uint32(tiff[e.offset+8:])))
+			if err != nil {
+				return nil
+			}
+			return sub
+		}
+	}
+	return nil
+}
+
+// sourceEXIF returns the EXIF payload of the image file at path if it is a
+// JPEG (format "jpeg") that has one; other formats report none.
+func sourceEXIF(path, format string) []byte {
+	if format != "jpeg" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	exif, _ := jpegEXIF(data)
+	return exif
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// withDateTimeOriginal returns the JPEG file jpg with an APP1 EXIF segment
+// recording date as its DateTimeOriginal.
+func withDateTimeOriginal(jpg []byte, date string) []byte {
+	le := binary.LittleEndian
+	tiff := []byte("II*\x00")
+	tiff = le.AppendUint32(tiff, 8)
+	// IFD0: one entry pointing to the Exif sub-directory at 26.
+	tiff = le.AppendUint16(tiff, 1)
+	tiff = le.AppendUint16(tiff, tiffTagExifIFD)
+	tiff = le.AppendUint16(tiff, tiffTypeLong)
+	tiff = le.AppendUint32(tiff, 1)
+	tiff = le.AppendUint32(tiff, 26)
+	tiff = le.AppendUint32(tiff, 0)
+	// Exif sub-directory: DateTimeOriginal, stored at 44.
+	value := append([]byte(date), 0)
+	tiff = le.AppendUint16(tiff, 1)
+	tiff = le.AppendUint16(tiff, 0x9003)
+	tiff = le.AppendUint16(tiff, tiffTypeASCII)
+	tiff = le.AppendUint32(tiff, uint32(len(value)))
+	tiff = le.AppendUint32(tiff, 44)
+	tiff = le.AppendUint32(tiff, 0)
+	tiff = append(tiff, value...)
+
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := binary.BigEndian.AppendUint16([]byte{0xff, 0xe1}, uint16(len(segment)+2))
+	app1 = append(app1, segment...)
+	return append(append(jpg[:2:2], app1...), jpg[2:]...)
+}
+
+func TestOutputTemplateEXIF(t *testing.T) {
+	dir := t.TempDir()
+
+	img := image.NewRGBA(image.Rect(0, 0, 80, 80))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(20, 20, 60, 60), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	data := withDateTimeOriginal(buf.Bytes(), "2024:05:01 12:34:56")
+	if err := os.WriteFile(filepath.Join(dir, "photo.jpg"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	writePNG(t, filepath.Join(dir, "scan.png"), img)
+
+	opts := options{OutputTemplate: "{exif:DateTimeOriginal|nodate}_{name}"}
+	for filename, expected := range map[string]string{
+		"photo.jpg": "processed_2024-05-01_12-34-56_photo.jpg",
+		"scan.png":  "processed_nodate_scan.png",
+	} {
+		res, err := processImage(filepath.Join(dir, filename), dir, filename, opts)
+		if err != nil {
+			t.Fatalf("%s: processImage() error = %v", filename, err)
+		}
+		if res.Output != expected {
+			t.Errorf("%s: expected output %s, got %s", filename, expected, res.Output)
+		}
+		if _, err := os.Stat(filepath.Join(dir, expected)); err != nil {
+			t.Errorf("%s: %v", filename, err)
+		}
+	}
+}
+
+func TestCheckOutputTemplate(t *testing.T) {
+	for _, tmpl := range []string{"{name}", "{exif:Model}-{name}", "{exif:DateTime|x}"} {
+		if err := checkOutputTemplate(tmpl); err != nil {
+			t.Errorf("checkOutputTemplate(%q) = %v", tmpl, err)
+		}
+	}
+	for _, tmpl := range []string{"{size}", "{exif:Flash}", "../{name}"} {
+		if err := checkOutputTemplate(tmpl); err == nil {
+			t.Errorf("checkOutputTemplate(%q): expected an error", tmpl)
+		}
+	}
+}
+
+func TestOutputTemplateStaysInOutDir(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(t.TempDir(), "out")
+	img := image.NewRGBA(image.Rect(0, 0, 60, 60))
+	draw.Draw(img, image.Rect(10, 10, 50, 50), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	writePNG(t, filepath.Join(dir, "a.png"), img)
+
+	// Library callers and per-image options don't go through main's check.
+	for _, tmpl := range []string{"/../../escaped", "{exif:Model|..}"} {
+		opts := options{OutDir: out, NoPrefix: true, OutputTemplate: tmpl}
+		if _, err := processImage(filepath.Join(dir, "a.png"), dir, "a.png", opts); err == nil {
+			t.Errorf("%q: expected an error", tmpl)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(out), "escaped.png")); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing written outside -out, got err = %v", err)
+	}
+
+	if _, err := expandOutputTemplate("{exif:Model|}", "a.png", nil); err == nil {
+		t.Error("Expected an error for an empty name")
+	}
+}
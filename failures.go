@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// isCollectedFailure reports whether processImage's res and err make a
+// file -failures-dir collects: any error, or a -image-timeout skip.
+func isCollectedFailure(res fileResult, err error) bool {
+	return err != nil || strings.HasPrefix(res.Status, "skipped: timed out")
+}
+
+// collectFailure copies the file at path, which failed to process, into dir
+// under its own name for -failures-dir. A file that -move-bad has already
+// moved is copied from the quarantine.
+func collectFailure(dir, path string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	src := path
+	if _, err := os.Stat(src); errors.Is(err, fs.ErrNotExist) {
+		src = filepath.Join(filepath.Dir(path), quarantineDir, filepath.Base(path))
+	}
+	if err := copyFile(filepath.Join(dir, filepath.Base(path)), src); err != nil {
+		return err
+	}
+	fmt.Fprintf(logOutput, "  Copied %s to %s\n", filepath.Base(path), dir)
+	return nil
+}
+
+// failuresSummary logs how many failed files -failures-dir collected, if
+// any.
+func failuresSummary(n int, dir string) {
+	if n > 0 {
+		fmt.Fprintf(logOutput, "Copied %d failed file(s) to %s for review\n", n, dir)
+	}
+}
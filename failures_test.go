@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFailuresDir(t *testing.T) {
+	dir := t.TempDir()
+	failures := filepath.Join(t.TempDir(), "failures")
+
+	img := image.NewRGBA(image.Rect(0, 0, 60, 60))
+	draw.Draw(img, image.Rect(10, 10, 50, 50), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	writePNG(t, filepath.Join(dir, "good.png"), img)
+	writePNG(t, filepath.Join(dir, "corrupt.png"), img)
+	data, err := os.ReadFile(filepath.Join(dir, "corrupt.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt := data[:len(data)/2]
+	if err := os.WriteFile(filepath.Join(dir, "corrupt.png"), corrupt, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := processDirectory(dir, options{FailuresDir: failures}); err != nil {
+		t.Fatalf("processDirectory() error = %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(failures, "corrupt.png"))
+	if err != nil || !bytes.Equal(got, corrupt) {
+		t.Fatalf("Expected a copy of corrupt.png in the failures dir (%v)", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "corrupt.png")); err != nil {
+		t.Errorf("Expected the original to stay in place, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(failures, "good.png")); !os.IsNotExist(err) {
+		t.Errorf("Expected only failed files collected, got err = %v for good.png", err)
+	}
+
+	// With -move-bad the file is copied from the quarantine.
+	os.RemoveAll(failures)
+	if err := processDirectory(dir, options{FailuresDir: failures, MoveBad: true}); err != nil {
+		t.Fatalf("processDirectory() with -move-bad error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(failures, "corrupt.png")); err != nil {
+		t.Errorf("Expected corrupt.png collected after -move-bad, got %v", err)
+	}
+}
+
+func TestFailuresDirStdinList(t *testing.T) {
+	root := t.TempDir()
+	failures := filepath.Join(t.TempDir(), "failures")
+
+	// Two truncated files with the same name in different directories.
+	var paths []string
+	var contents [][]byte
+	for i, sub := range []string{"a", "b"} {
+		path := filepath.Join(root, sub, "scan.png")
+		if err := os.Mkdir(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		writePNG(t, path, image.NewRGBA(image.Rect(0, 0, 60+i, 60)))
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = data[:len(data)/2]
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+		contents = append(contents, data)
+	}
+
+	list := strings.NewReader(strings.Join(paths, "\n"))
+	if err := processList(list, io.Discard, options{FailuresDir: failures}); err != nil {
+		t.Fatalf("processList() error = %v", err)
+	}
+	for i, path := range paths {
+		copied := filepath.Join(failures, mirrorSubdir(filepath.Dir(path)), "scan.png")
+		got, err := os.ReadFile(copied)
+		if err != nil || !bytes.Equal(got, contents[i]) {
+			t.Errorf("Expected a copy of %s at %s (%v)", path, copied, err)
+		}
+	}
+}
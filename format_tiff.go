@@ -0,0 +1,43 @@
+//go:build tiff
+
+package main
+
+import (
+	"bytes"
+	"image"
+	"io"
+
+	"golang.org/x/image/tiff"
+)
+
+// Build with -tags tiff to read and write TIFF files.
+func init() {
+	registerFormat(formatInfo{
+		Name:       "tiff",
+		MIME:       "image/tiff",
+		Extension:  ".tif",
+		Extensions: []string{".tif", ".tiff"},
+		Decode:     true,
+		Encode:     true,
+		encode:     encodeTIFF,
+	})
+}
+
+// encodeTIFF writes img as a Deflate-compressed TIFF, recording
+// so.Resolution in place of the encoder's fixed 72 dpi.
+func encodeTIFF(w io.Writer, img image.Image, so saveOptions) error {
+	opts := &tiff.Options{Compression: tiff.Deflate}
+	if so.Resolution == nil {
+		return tiff.Encode(w, img, opts)
+	}
+
+	var buf bytes.Buffer
+	if err := tiff.Encode(&buf, img, opts); err != nil {
+		return err
+	}
+	if err := setTIFFResolution(buf.Bytes(), *so.Resolution); err != nil {
+		return err
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,50 @@
+//go:build tiff
+
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/image/tiff"
+)
+
+func TestTIFFKeepsPixelAspect(t *testing.T) {
+	dir := t.TempDir()
+
+	img := image.NewRGBA(image.Rect(0, 0, 100, 60))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(20, 10, 80, 50), &image.Uniform{color.RGBA{30, 60, 90, 255}}, image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	if err := tiff.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	resolution := tiffResolution{X: [2]uint32{200, 1}, Y: [2]uint32{100, 1}, Unit: 2}
+	if err := setTIFFResolution(buf.Bytes(), resolution); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "scan.tif")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := processImage(path, dir, "scan.tif", options{})
+	if err != nil {
+		t.Fatalf("processImage() error = %v", err)
+	}
+	if res.Bounds != image.Rect(20, 10, 80, 50) {
+		t.Errorf("Expected the content cropped, got %v", res.Bounds)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, res.Output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := readTIFFResolution(data); !ok || got != resolution {
+		t.Errorf("Expected the output to keep resolution %v, got %v (%t)", resolution, got, ok)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// formatInfo describes an image format known to the tool.
+type formatInfo struct {
+	// Name is the format name reported by image.Decode.
+	Name string
+	// MIME is the content type http.DetectContentType reports for it.
+	MIME string
+	// Extension is appended to output names that lack one.
+	Extension string
+	// Extensions lists every file extension recognized as the format,
+	// lower-case and including Extension.
+	Extensions []string
+	Decode     bool
+	Encode     bool
+	// encode writes formats that encodeImage has no built-in case for,
+	// such as those registered behind build tags.
+	encode func(w io.Writer, img image.Image, so saveOptions) error
+}
+
+// formats is the registry of supported formats, keyed by name. Optional
+// formats behind build tags add themselves with registerFormat from init.
+var formats = map[string]formatInfo{
+	"gif":  {Name: "gif", MIME: "image/gif", Extension: ".gif", Extensions: []string{".gif"}, Decode: true, Encode: true},
+	"jpeg": {Name: "jpeg", MIME: "image/jpeg", Extension: ".jpg", Extensions: []string{".jpg", ".jpeg", ".jpe"}, Decode: true, Encode: true},
+	"png":  {Name: "png", MIME: "image/png", Extension: ".png", Extensions: []string{".png"}, Decode: true, Encode: true},
+}
+
+func registerFormat(f formatInfo) {
+	formats[f.Name] = f
+}
+
+// formatByMIME returns the decodable format with the given content type.
+func formatByMIME(mime string) (formatInfo, bool) {
+	for _, f := range formats {
+		if f.Decode && f.MIME == mime {
+			return f, true
+		}
+	}
+	return formatInfo{}, false
+}
+
+// formatByExtension returns the decodable format a file extension such as
+// ".JPG" belongs to.
+func formatByExtension(ext string) (formatInfo, bool) {
+	ext = strings.ToLower(ext)
+	for _, f := range formats {
+		if f.Decode && slices.Contains(f.Extensions, ext) {
+			return f, true
+		}
+	}
+	return formatInfo{}, false
+}
+
+// listFormats writes one line per registered format with its capabilities.
+func listFormats(w io.Writer) {
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := formats[name]
+		decode, encode := "-", "-"
+		if f.Decode {
+			decode = "decode"
+		}
+		if f.Encode {
+			encode = "encode"
+		}
+		fmt.Fprintf(w, "%-6s %-6s %-6s %s\n", f.Name, decode, encode, f.MIME)
+	}
+}
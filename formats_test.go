@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestListFormats(t *testing.T) {
+	var buf bytes.Buffer
+	listFormats(&buf)
+	out := buf.String()
+
+	for _, want := range []string{
+		"gif    decode encode image/gif",
+		"jpeg   decode encode image/jpeg",
+		"png    decode encode image/png",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in listing:\n%s", want, out)
+		}
+	}
+}
+
+func TestFormatByMIME(t *testing.T) {
+	if f, ok := formatByMIME("image/png"); !ok || f.Name != "png" {
+		t.Errorf("formatByMIME(image/png) = %v, %v", f, ok)
+	}
+	if _, ok := formatByMIME("text/plain"); ok {
+		t.Errorf("Expected text/plain to be unsupported")
+	}
+}
+
+func TestFormatByExtension(t *testing.T) {
+	if f, ok := formatByExtension(".JPEG"); !ok || f.Name != "jpeg" {
+		t.Errorf("formatByExtension(.JPEG) = %v, %v", f, ok)
+	}
+	if _, ok := formatByExtension(".txt"); ok {
+		t.Errorf("Expected .txt to be unsupported")
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"image"
+	"path/filepath"
+	"strings"
+
+	"gazounomawarinoiranaifuchiwokesu/crop"
+)
+
+// frameStrip is one side of the border around the content.
+type frameStrip struct {
+	Side string
+	Rect image.Rectangle
+}
+
+// frameStrips splits bounds minus the content rectangle inner into its
+// border strips: top and bottom span the full width, left and right fill
+// the height between them. Sides without a border are left out.
+func frameStrips(bounds, inner image.Rectangle) []frameStrip {
+	strips := []frameStrip{
+		{"top", image.Rect(bounds.Min.X, bounds.Min.Y, bounds.Max.X, inner.Min.Y)},
+		{"bottom", image.Rect(bounds.Min.X, inner.Max.Y, bounds.Max.X, bounds.Max.Y)},
+		{"left", image.Rect(bounds.Min.X, inner.Min.Y, inner.Min.X, inner.Max.Y)},
+		{"right", image.Rect(inner.Max.X, inner.Min.Y, bounds.Max.X, inner.Max.Y)},
+	}
+	var out []frameStrip
+	for _, s := range strips {
+		if !s.Rect.Empty() {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// writeFrameStrips saves the border strips of img around inner next to
+// outPath, with the side added before the extension (processed_a_top.png),
+// and returns the names written.
+func writeFrameStrips(img image.Image, inner image.Rectangle, outPath, format string, so saveOptions) ([]string, error) {
+	ext := filepath.Ext(outPath)
+	base := strings.TrimSuffix(outPath, ext)
+	var names []string
+	for _, s := range frameStrips(img.Bounds(), inner) {
+		path := base + "_" + s.Side + ext
+		if err := saveImage(path, crop.SubImage(img, s.Rect, false), format, so); err != nil {
+			return names, err
+		}
+		names = append(names, filepath.Base(path))
+	}
+	return names, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"path/filepath"
+	"testing"
+)
+
+func TestExtractFrame(t *testing.T) {
+	dir := t.TempDir()
+
+	img := image.NewRGBA(image.Rect(0, 0, 120, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(15, 10, 90, 70), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	writePNG(t, filepath.Join(dir, "a.png"), img)
+
+	res, err := processImage(filepath.Join(dir, "a.png"), dir, "a.png", options{ExtractFrame: true})
+	if err != nil {
+		t.Fatalf("processImage() error = %v", err)
+	}
+	if res.Bounds != image.Rect(15, 10, 90, 70) {
+		t.Fatalf("Expected content bounds (15,10)-(90,70), got %v", res.Bounds)
+	}
+
+	// Margins: top 10, bottom 30, left 15, right 30.
+	expected := map[string]image.Point{
+		"top":    image.Pt(120, 10),
+		"bottom": image.Pt(120, 30),
+		"left":   image.Pt(15, 60),
+		"right":  image.Pt(30, 60),
+	}
+	for side, size := range expected {
+		strip, _, err := loadImage(filepath.Join(dir, "processed_a_"+side+".png"))
+		if err != nil {
+			t.Errorf("%s: %v", side, err)
+			continue
+		}
+		if got := strip.Bounds().Size(); got != size {
+			t.Errorf("%s: expected %v, got %v", side, size, got)
+		}
+		if r, _, _, _ := strip.At(0, 0).RGBA(); r != 0 {
+			t.Errorf("%s: expected a black border strip", side)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// parseFuzz parses a -fuzz value such as "10%" or "10" into a percentage of
+// the channel range. Empty means no fuzz mode.
+func parseFuzz(s string) (float64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	p, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "%"), 64)
+	if err != nil || p < 0 || p > 100 {
+		return 0, fmt.Errorf("invalid fuzz %q: want a percentage between 0%% and 100%%", s)
+	}
+	return p, nil
+}
+
+// colorDistance returns the root mean square of the differences between
+// the R, G and B channels of a and b, in 8-bit levels. This is the distance
+// ImageMagick compares against -fuzz.
+func colorDistance(a, b color.Color) float64 {
+	ar, ag, ab, _ := a.RGBA()
+	br, bg, bb, _ := b.RGBA()
+	dr := float64(ar>>8) - float64(br>>8)
+	dg := float64(ag>>8) - float64(bg>>8)
+	db := float64(ab>>8) - float64(bb>>8)
+	return math.Sqrt((dr*dr + dg*dg + db*db) / 3)
+}
+
+// withinFuzz reports whether c is within percent of the channel range of
+// the background color ref.
+func withinFuzz(c, ref color.Color, percent float64) bool {
+	return colorDistance(c, ref) <= percent/100*255
+}
+
+// cornerAgreement returns the average color of img's four corners if each
+// corner is within tolerance (see colorDistance) of it, for
+// -corner-agreement-tolerance.
+func cornerAgreement(img image.Image, tolerance int) (color.Color, bool) {
+	corners, _ := samplePoints(img.Bounds())
+	var r, g, b uint32
+	for _, p := range corners {
+		cr, cg, cb, _ := img.At(p.X, p.Y).RGBA()
+		r, g, b = r+cr>>8, g+cg>>8, b+cb>>8
+	}
+	n := uint32(len(corners))
+	mean := color.RGBA{uint8((r + n/2) / n), uint8((g + n/2) / n), uint8((b + n/2) / n), 0xff}
+	for _, p := range corners {
+		if colorDistance(img.At(p.X, p.Y), mean) > float64(tolerance) {
+			return nil, false
+		}
+	}
+	return mean, true
+}
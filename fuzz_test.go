@@ -0,0 +1,76 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestFuzz(t *testing.T) {
+	// A dark slate background with a slightly lighter inner band, neither
+	// of which is black or white, around red content.
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{50, 60, 70, 255}}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(10, 10, 90, 90), &image.Uniform{color.RGBA{60, 70, 80, 255}}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(20, 20, 80, 80), &image.Uniform{color.RGBA{200, 30, 30, 255}}, image.Point{}, draw.Src)
+
+	tests := []struct {
+		fuzz     string
+		expected image.Rectangle
+	}{
+		{"0%", image.Rect(10, 10, 90, 90)},
+		{"10%", image.Rect(20, 20, 80, 80)},
+	}
+	for _, tt := range tests {
+		got := detect(img, options{Fuzz: tt.fuzz})
+		if got.Bounds != tt.expected || got.Mode != ModeColor {
+			t.Errorf("-fuzz %s: expected %v (color), got %v (%s)", tt.fuzz, tt.expected, got.Bounds, got.Mode)
+		}
+	}
+}
+
+func TestParseFuzz(t *testing.T) {
+	for s, expected := range map[string]float64{"": 0, "0%": 0, "10%": 10, "2.5": 2.5} {
+		if got, err := parseFuzz(s); err != nil || got != expected {
+			t.Errorf("parseFuzz(%q) = %v, %v, want %v", s, got, err, expected)
+		}
+	}
+	for _, s := range []string{"abc", "-1%", "101%"} {
+		if _, err := parseFuzz(s); err == nil {
+			t.Errorf("parseFuzz(%q): expected an error", s)
+		}
+	}
+}
+
+func TestCornerAgreementTolerance(t *testing.T) {
+	// A slate background shading slightly from top to bottom, so no two
+	// corner rows match exactly, around red content.
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	for y := 0; y < 100; y++ {
+		shade := uint8(y * 8 / 99)
+		draw.Draw(img, image.Rect(0, y, 100, y+1), &image.Uniform{color.RGBA{50 + shade, 60 + shade, 70 + shade, 255}}, image.Point{}, draw.Src)
+	}
+	draw.Draw(img, image.Rect(20, 20, 80, 80), &image.Uniform{color.RGBA{200, 30, 30, 255}}, image.Point{}, draw.Src)
+
+	if got := detect(img, options{}); got.Mode != ModeNone {
+		t.Fatalf("Without -corner-agreement-tolerance: expected no background, got %s", got.Mode)
+	}
+	got := detect(img, options{CornerAgreementTolerance: 6})
+	if got.Bounds != image.Rect(20, 20, 80, 80) || got.Mode != ModeColor || got.Reason != "agreeing-corners" {
+		t.Errorf("Tolerance 6: expected (20,20)-(80,80) (color, agreeing-corners), got %v (%s, %s)", got.Bounds, got.Mode, got.Reason)
+	}
+	if got := detect(img, options{CornerAgreementTolerance: 2}); got.Mode != ModeNone || got.Bounds != img.Bounds() {
+		t.Errorf("Tolerance 2: expected the corners to disagree, got %v (%s)", got.Bounds, got.Mode)
+	}
+
+	// The slate corners are neither black nor white; -strict-corners and
+	// the padding fill check them against the agreed color instead.
+	res, err := planCrop(img, options{CornerAgreementTolerance: 6, StrictCorners: true})
+	if err != nil || res.Status != "cropped" || res.Mode != ModeColor {
+		t.Errorf("With -strict-corners: expected a color crop, got %s %q, %v", res.Mode, res.Status, err)
+	}
+	if fill := backgroundFill(img, res.Mode, res.isBackground); fill != (color.RGBA{54, 64, 74, 255}) {
+		t.Errorf("Expected the padding fill to be the slate corners' mean, got %v", fill)
+	}
+}
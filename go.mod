@@ -1,3 +1,5 @@
 module gazounomawarinoiranaifuchiwokesu
 
 go 1.23.3
+
+require golang.org/x/image v0.30.0
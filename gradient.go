@@ -0,0 +1,47 @@
+package main
+
+import (
+	"image"
+	"image/color"
+)
+
+// gradientBackground is the vertical gradient background of -gradient-bg:
+// each row's expected color is interpolated between the average of the top
+// corners and that of the bottom corners.
+type gradientBackground struct {
+	top, bottom  [3]float64
+	minY, height int
+}
+
+// newGradientBackground samples img's corners for its gradient.
+func newGradientBackground(img image.Image) gradientBackground {
+	corners, _ := samplePoints(img.Bounds())
+	average := func(a, b image.Point) [3]float64 {
+		ar, ag, ab, _ := img.At(a.X, a.Y).RGBA()
+		br, bg, bb, _ := img.At(b.X, b.Y).RGBA()
+		return [3]float64{
+			float64(ar>>8+br>>8) / 2,
+			float64(ag>>8+bg>>8) / 2,
+			float64(ab>>8+bb>>8) / 2,
+		}
+	}
+	return gradientBackground{
+		top:    average(corners[0], corners[1]),
+		bottom: average(corners[2], corners[3]),
+		minY:   img.Bounds().Min.Y,
+		height: img.Bounds().Dy(),
+	}
+}
+
+// at returns the expected background color of row y.
+func (g gradientBackground) at(y int) color.Color {
+	t := 0.0
+	if g.height > 1 {
+		t = float64(y-g.minY) / float64(g.height-1)
+	}
+	var c [3]uint8
+	for i := range c {
+		c[i] = uint8(g.top[i] + (g.bottom[i]-g.top[i])*t + 0.5)
+	}
+	return color.RGBA{c[0], c[1], c[2], 0xff}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestGradientBg(t *testing.T) {
+	// A background shading from light gray at the top to dark gray at the
+	// bottom, around centered content.
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	for y := 0; y < 100; y++ {
+		v := uint8(230 - y*2)
+		draw.Draw(img, image.Rect(0, y, 100, y+1), &image.Uniform{color.RGBA{v, v, v, 255}}, image.Point{}, draw.Src)
+	}
+	content := image.Rect(30, 30, 70, 70)
+	draw.Draw(img, content, &image.Uniform{color.RGBA{200, 40, 40, 255}}, image.Point{}, draw.Src)
+
+	if got := detect(img, options{}).Bounds; got == content {
+		t.Fatalf("Expected the fixed levels to leave part of the gradient, got %v", got)
+	}
+	got := detect(img, options{GradientBg: 8})
+	if got.Bounds != content || got.Mode != ModeGradient {
+		t.Errorf("With -gradient-bg: expected %v (gradient), got %v (%s)", content, got.Bounds, got.Mode)
+	}
+
+	// A window onto the gradient inside the content is background for the
+	// fill ratio, and the corners pass -strict-corners.
+	draw.Draw(img, image.Rect(40, 40, 60, 50), img, image.Pt(0, 40), draw.Src)
+	got = detect(img, options{GradientBg: 8, TrimReport: "report.csv"})
+	if expected := 40*40 - 20*10; got.Content != expected {
+		t.Errorf("Expected %d content pixels, got %d", expected, got.Content)
+	}
+	res, err := planCrop(img, options{GradientBg: 8, StrictCorners: true})
+	if err != nil || res.Status != "cropped" {
+		t.Errorf("With -strict-corners: expected the gradient corners to crop, got %q, %v", res.Status, err)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// hueSVSlack is how far a pixel's saturation and value may stray from the
+// reference background color, on a 0-1 scale, and still match under
+// -hue-tolerance. It absorbs JPEG noise and uneven lighting while keeping
+// black text and white highlights out of the background.
+const hueSVSlack = 0.2
+
+// hueMinSaturation is the saturation below which a color is too gray for
+// its hue to mean anything.
+const hueMinSaturation = 0.05
+
+// hsv is a color in HSV space: H in degrees [0, 360), S and V in [0, 1].
+type hsv struct {
+	H, S, V float64
+}
+
+// toHSV converts c to HSV.
+func toHSV(c color.Color) hsv {
+	r, g, b, _ := c.RGBA()
+	rf, gf, bf := float64(r)/0xffff, float64(g)/0xffff, float64(b)/0xffff
+	hi := max(rf, gf, bf)
+	lo := min(rf, gf, bf)
+	d := hi - lo
+
+	var h float64
+	switch {
+	case d == 0:
+		h = 0
+	case hi == rf:
+		h = 60 * math.Mod((gf-bf)/d, 6)
+	case hi == gf:
+		h = 60 * ((bf-rf)/d + 2)
+	default:
+		h = 60 * ((rf-gf)/d + 4)
+	}
+	if h < 0 {
+		h += 360
+	}
+
+	var s float64
+	if hi > 0 {
+		s = d / hi
+	}
+	return hsv{H: h, S: s, V: hi}
+}
+
+// hueDistance returns the angle in degrees between two hues.
+func hueDistance(a, b float64) float64 {
+	d := math.Abs(a - b)
+	return min(d, 360-d)
+}
+
+// cornerHSV returns the mean color of img's four corners for hue-based
+// detection. It fails if the corners are too gray to have a hue or their
+// hues are more than tolerance degrees apart.
+func cornerHSV(img image.Image, tolerance float64) (hsv, bool) {
+	corners, _ := samplePoints(img.Bounds())
+	var sinSum, cosSum, sSum, vSum float64
+	samples := make([]hsv, len(corners))
+	for i, p := range corners {
+		c := toHSV(img.At(p.X, p.Y))
+		if c.S < hueMinSaturation {
+			return hsv{}, false
+		}
+		samples[i] = c
+		sin, cos := math.Sincos(c.H * math.Pi / 180)
+		sinSum += sin
+		cosSum += cos
+		sSum += c.S
+		vSum += c.V
+	}
+
+	n := float64(len(corners))
+	ref := hsv{H: math.Atan2(sinSum, cosSum) * 180 / math.Pi, S: sSum / n, V: vSum / n}
+	if ref.H < 0 {
+		ref.H += 360
+	}
+	for _, c := range samples {
+		if hueDistance(c.H, ref.H) > tolerance {
+			return hsv{}, false
+		}
+	}
+	return ref, true
+}
+
+// matchesHue reports whether c is within tolerance degrees of ref's hue and
+// within hueSVSlack of its saturation and value.
+func matchesHue(c color.Color, ref hsv, tolerance float64) bool {
+	p := toHSV(c)
+	return hueDistance(p.H, ref.H) <= tolerance &&
+		math.Abs(p.S-ref.S) <= hueSVSlack &&
+		math.Abs(p.V-ref.V) <= hueSVSlack
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand"
+	"testing"
+)
+
+func TestHueTolerance(t *testing.T) {
+	// A pastel-blue border with JPEG-like noise, which is neither black nor
+	// white, around colorful content and dark text.
+	rng := rand.New(rand.NewSource(1))
+	noise := func(v int) uint8 { return uint8(v + rng.Intn(17) - 8) }
+	img := image.NewRGBA(image.Rect(0, 0, 100, 80))
+	for y := 0; y < 80; y++ {
+		for x := 0; x < 100; x++ {
+			img.Set(x, y, color.RGBA{noise(175), noise(205), noise(240), 255})
+		}
+	}
+	draw.Draw(img, image.Rect(20, 15, 50, 65), &image.Uniform{color.RGBA{220, 40, 40, 255}}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(50, 15, 80, 40), &image.Uniform{color.RGBA{40, 180, 60, 255}}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(50, 50, 80, 65), &image.Uniform{color.RGBA{20, 20, 30, 255}}, image.Point{}, draw.Src)
+
+	if got := detect(img, options{}); got.Mode != ModeNone || got.Bounds != img.Bounds() {
+		t.Fatalf("Without -hue-tolerance: expected the image untouched, got %v (%s)", got.Bounds, got.Mode)
+	}
+
+	got := detect(img, options{HueTolerance: 30})
+	if expected := image.Rect(20, 15, 80, 65); got.Bounds != expected || got.Mode != ModeHue {
+		t.Errorf("With -hue-tolerance 30: expected %v (hue), got %v (%s)", expected, got.Bounds, got.Mode)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// lastRunFilename is the -incremental marker kept in each processed
+// directory. It holds the start time of the last complete run, or with
+// -in-place the time its replacements finished.
+const lastRunFilename = ".cropper-lastrun"
+
+// readLastRun returns the time recorded in dirPath's marker, or the zero
+// time if there is none, so a first run processes everything.
+func readLastRun(dirPath string) (time.Time, error) {
+	data, err := os.ReadFile(filepath.Join(dirPath, lastRunFilename))
+	if errors.Is(err, fs.ErrNotExist) {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339Nano, strings.TrimSpace(string(data)))
+}
+
+// writeLastRun records t in dirPath's marker.
+func writeLastRun(dirPath string, t time.Time) error {
+	data := []byte(t.Format(time.RFC3339Nano) + "\n")
+	return os.WriteFile(filepath.Join(dirPath, lastRunFilename), data, 0o644)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIncremental(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(20, 20, 80, 80), &image.Uniform{color.White}, image.Point{}, draw.Src)
+
+	dir := t.TempDir()
+	writePNG(t, filepath.Join(dir, "a.png"), img)
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "a.png"), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a marker, everything is processed.
+	opts := options{Incremental: true}
+	if err := processDirectory(dir, opts); err != nil {
+		t.Fatalf("processDirectory() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "processed_a.png")); err != nil {
+		t.Fatalf("Expected a.png processed on the first run, got %v", err)
+	}
+	lastRun, err := readLastRun(dir)
+	if err != nil || lastRun.IsZero() {
+		t.Fatalf("Expected a marker after the first run, got %v, %v", lastRun, err)
+	}
+
+	// The second run only picks up the newly added file.
+	if err := os.Remove(filepath.Join(dir, "processed_a.png")); err != nil {
+		t.Fatal(err)
+	}
+	writePNG(t, filepath.Join(dir, "b.png"), img)
+	later := lastRun.Add(time.Second)
+	if err := os.Chtimes(filepath.Join(dir, "b.png"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := processDirectory(dir, opts); err != nil {
+		t.Fatalf("processDirectory() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "processed_a.png")); !os.IsNotExist(err) {
+		t.Errorf("Expected a.png skipped on the second run, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "processed_b.png")); err != nil {
+		t.Errorf("Expected b.png processed on the second run, got %v", err)
+	}
+}
+
+func TestIncrementalFailure(t *testing.T) {
+	// A borderless image fails -require-border.
+	full := image.NewRGBA(image.Rect(0, 0, 40, 40))
+	draw.Draw(full, full.Bounds(), &image.Uniform{color.RGBA{200, 30, 30, 255}}, image.Point{}, draw.Src)
+	dir := t.TempDir()
+	writePNG(t, filepath.Join(dir, "a.png"), full)
+
+	opts := options{Incremental: true, RequireBorder: true}
+	if err := processDirectory(dir, opts); err == nil {
+		t.Fatal("Expected -require-border to fail the run")
+	}
+	if _, err := os.Stat(filepath.Join(dir, lastRunFilename)); !os.IsNotExist(err) {
+		t.Errorf("Expected no marker after a failed run, got %v", err)
+	}
+}
+
+func TestIncrementalInPlace(t *testing.T) {
+	// Nested borders: every crop exposes another one. The image is large
+	// enough that the replacement lands on a later file-system clock tick
+	// than the start of the run.
+	img := image.NewRGBA(image.Rect(0, 0, 1600, 1600))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(200, 200, 1400, 1400), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(400, 400, 1200, 1200), &image.Uniform{color.RGBA{200, 30, 30, 255}}, image.Point{}, draw.Src)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "scan.png")
+	writePNG(t, path, img)
+
+	opts := options{Incremental: true, InPlace: true}
+	if err := processDirectory(dir, opts); err != nil {
+		t.Fatalf("processDirectory() error = %v", err)
+	}
+	if got := readImageSize(t, path); got != image.Pt(1200, 1200) {
+		t.Fatalf("Expected the first run to crop to 1200x1200, got %v", got)
+	}
+
+	// The replaced original is not newer than the marker, so the second
+	// run leaves it alone.
+	if err := processDirectory(dir, opts); err != nil {
+		t.Fatalf("processDirectory() error = %v", err)
+	}
+	if got := readImageSize(t, path); got != image.Pt(1200, 1200) {
+		t.Errorf("Expected scan.png skipped on the second run, got %v", got)
+	}
+}
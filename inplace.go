@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// reserveTemp creates an empty, hidden file next to path for -in-place to
+// write its output to. Like writeFileAtomic's temporary files, a leftover
+// from a crash is skipped by later runs.
+func reserveTemp(path string) (string, error) {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".inplace-*")
+	if err != nil {
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+// replaceOriginal checks that the image at tmp decodes, gives it the
+// permissions of the original at path and renames it over the original.
+// The rename is atomic, so path holds either the original or the complete
+// output, never a partial file.
+func replaceOriginal(tmp, path string) error {
+	if _, _, err := loadImage(tmp); err != nil {
+		return fmt.Errorf("output failed to decode, original kept: %w", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInPlace(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "scan.png")
+	writePNG(t, path, borderedImage(image.Rect(0, 0, 60, 60), image.Rect(10, 10, 50, 50)))
+	if err := os.Chmod(path, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := processImage(path, dir, "scan.png", options{InPlace: true})
+	if err != nil {
+		t.Fatalf("processImage() error = %v", err)
+	}
+	if res.Output != "scan.png" {
+		t.Errorf("Expected output scan.png, got %q", res.Output)
+	}
+	if got := readImageSize(t, path); got != image.Pt(40, 40) {
+		t.Errorf("Expected the original replaced by a 40x40 crop, got %v", got)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected permissions 0600 kept, got %v", info.Mode().Perm())
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected only the replaced original in the directory, got %v", entries)
+	}
+}
+
+func TestReplaceOriginalRejectsUndecodable(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "scan.png")
+	writePNG(t, path, borderedImage(image.Rect(0, 0, 60, 60), image.Rect(10, 10, 50, 50)))
+	tmp, err := reserveTemp(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(tmp, []byte("\x89PNG truncated"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := replaceOriginal(tmp, path); err == nil {
+		t.Fatal("Expected an error for an output that doesn't decode")
+	}
+	if got := readImageSize(t, path); got != image.Pt(60, 60) {
+		t.Errorf("Expected the original kept, got a %v image", got)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/gif"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProcessDirectoryWorkers(t *testing.T) {
+	dir := t.TempDir()
+	const n = 8
+	for i := 0; i < n; i++ {
+		writePNG(t, filepath.Join(dir, fmt.Sprintf("scan%d.png", i)), borderedImage(image.Rect(0, 0, 60, 60), image.Rect(10, 10, 50, 50)))
+	}
+	// A PNG cut off after its header is taken for an image but can't be
+	// decoded.
+	whole, err := os.ReadFile(filepath.Join(dir, "scan0.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "broken.png"), whole[:40], 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	saved := logOutput
+	logOutput = &buf
+	defer func() { logOutput = saved }()
+
+	if err := processDirectory(dir, options{Workers: 4}); err != nil {
+		t.Fatalf("processDirectory() error = %v", err)
+	}
+	for i := 0; i < n; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("processed_scan%d.png", i))
+		if got := readImageSize(t, path); got != image.Pt(40, 40) {
+			t.Errorf("%s: expected a 40x40 output, got %v", path, got)
+		}
+	}
+
+	// Each image's messages come together, in directory order.
+	log := buf.String()
+	last := -1
+	for i := 0; i < n; i++ {
+		block := fmt.Sprintf("Processing: scan%d.png\n  Saved processed_scan%d.png\n", i, i)
+		at := strings.Index(log, block)
+		if at < 0 {
+			t.Fatalf("Expected the messages of scan%d.png together, got:\n%s", i, log)
+		}
+		if at < last {
+			t.Errorf("Expected scan%d.png after the images before it, got:\n%s", i, log)
+		}
+		last = at
+	}
+
+	// The broken image doesn't stop the others and is listed at the end.
+	if !strings.Contains(log, "1 file(s) failed:\n  broken.png: ") {
+		t.Errorf("Expected a summary of the failure, got:\n%s", log)
+	}
+}
+
+func TestProcessDirectoryWorkersSameOutput(t *testing.T) {
+	// Each stem has a GIF and a PNG that -format png writes to the same
+	// file; as in a sequential run, the PNG, which comes later, wins.
+	dir := t.TempDir()
+	const n = 6
+	for i := 0; i < n; i++ {
+		f, err := os.Create(filepath.Join(dir, fmt.Sprintf("scan%d.gif", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := gif.Encode(f, borderedImage(image.Rect(0, 0, 60, 60), image.Rect(20, 20, 40, 40)), nil); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		writePNG(t, filepath.Join(dir, fmt.Sprintf("scan%d.png", i)), borderedImage(image.Rect(0, 0, 60, 60), image.Rect(10, 10, 50, 50)))
+	}
+
+	var buf bytes.Buffer
+	saved := logOutput
+	logOutput = &buf
+	defer func() { logOutput = saved }()
+
+	if err := processDirectory(dir, options{Workers: 4, Format: "png"}); err != nil {
+		t.Fatalf("processDirectory() error = %v", err)
+	}
+	for i := 0; i < n; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("processed_scan%d.png", i))
+		if got := readImageSize(t, path); got != image.Pt(40, 40) {
+			t.Errorf("%s: expected the PNG's 40x40 crop, got %v", path, got)
+		}
+	}
+}
+
+func TestProcessDirectoryWorkersMaxFiles(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 4; i++ {
+		writePNG(t, filepath.Join(dir, fmt.Sprintf("scan%d.png", i)), borderedImage(image.Rect(0, 0, 60, 60), image.Rect(10, 10, 50, 50)))
+	}
+
+	var buf bytes.Buffer
+	saved := logOutput
+	logOutput = &buf
+	defer func() { logOutput = saved }()
+
+	if err := processDirectory(dir, options{Workers: 4, MaxFiles: 2}); err != nil {
+		t.Fatalf("processDirectory() error = %v", err)
+	}
+	// The stop message follows the messages of the images before it.
+	log := buf.String()
+	want := "  Saved processed_scan1.png\nReached -max-files 2, stopping\n"
+	if !strings.Contains(log, want) {
+		t.Errorf("Expected %q in the output:\n%s", want, log)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"math"
+)
+
+// image/jpeg always subsamples chroma 4:2:0. For -jpeg-subsampling 444 this
+// file encodes a baseline JPEG with all three components at full
+// resolution, reusing the entropy coder of the lossless crop.
+
+// jpegZigzag maps a coefficient's zigzag index to its natural (row-major)
+// index in the 8x8 block.
+var jpegZigzag = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10,
+	17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34,
+	27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36,
+	29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46,
+	53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// jpegQuant holds the luminance and chrominance quantization tables of
+// JPEG Annex K, in natural order.
+var jpegQuant = [2][64]int{
+	{
+		16, 11, 10, 16, 24, 40, 51, 61,
+		12, 12, 14, 19, 26, 58, 60, 55,
+		14, 13, 16, 24, 40, 57, 69, 56,
+		14, 17, 22, 29, 51, 87, 80, 62,
+		18, 22, 37, 56, 68, 109, 103, 77,
+		24, 35, 55, 64, 81, 104, 113, 92,
+		49, 64, 78, 87, 103, 121, 120, 101,
+		72, 92, 95, 98, 112, 100, 103, 99,
+	},
+	{
+		17, 18, 24, 47, 99, 99, 99, 99,
+		18, 21, 26, 66, 99, 99, 99, 99,
+		24, 26, 56, 99, 99, 99, 99, 99,
+		47, 66, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+	},
+}
+
+// jpegCos[x][u] is C(u)/2 * cos((2x+1)uπ/16), the forward DCT's basis.
+var jpegCos = func() (c [8][8]float64) {
+	for x := range c {
+		for u := range c[x] {
+			c[x][u] = math.Cos(float64(2*x+1)*float64(u)*math.Pi/16) / 2
+			if u == 0 {
+				c[x][u] /= math.Sqrt2
+			}
+		}
+	}
+	return c
+}()
+
+// encodeJPEG444 writes img to w as a baseline JPEG without chroma
+// subsampling, at image/jpeg's default quality. Grayscale images have no
+// chroma to subsample and go to image/jpeg.
+func encodeJPEG444(w io.Writer, img image.Image) error {
+	if _, ok := img.(*image.Gray); ok {
+		return jpeg.Encode(w, img, nil)
+	}
+
+	// Scale the Annex K tables the way image/jpeg does.
+	scale := 200 - 2*jpeg.DefaultQuality
+	var quant [2][64]int
+	dqt := []byte{}
+	for t := range quant {
+		dqt = append(dqt, byte(t))
+		for k := range quant[t] {
+			q := (jpegQuant[t][jpegZigzag[k]]*scale + 50) / 100
+			q = min(max(q, 1), 255)
+			quant[t][k] = q
+			dqt = append(dqt, byte(q))
+		}
+	}
+
+	b := img.Bounds()
+	j := &jpegImage{
+		width:    b.Dx(),
+		height:   b.Dy(),
+		hmax:     1,
+		vmax:     1,
+		segments: [][]byte{appendJPEGSegment(nil, 0xdb, dqt)},
+	}
+	bw, bh := (b.Dx()+7)/8, (b.Dy()+7)/8
+	for i := 0; i < 3; i++ {
+		j.comps = append(j.comps, jpegComponent{
+			id: uint8(i + 1), h: 1, v: 1, tq: uint8(min(i, 1)),
+			bw: bw, bh: bh, blocks: make([]jpegBlock, bw*bh),
+		})
+	}
+
+	var samples [3][64]float64
+	for by := 0; by < bh; by++ {
+		for bx := 0; bx < bw; bx++ {
+			for k := 0; k < 64; k++ {
+				// Blocks past the edge repeat the last row and column.
+				x := min(b.Min.X+bx*8+k%8, b.Max.X-1)
+				y := min(b.Min.Y+by*8+k/8, b.Max.Y-1)
+				r, g, bl, _ := img.At(x, y).RGBA()
+				yy, cb, cr := color.RGBToYCbCr(uint8(r>>8), uint8(g>>8), uint8(bl>>8))
+				samples[0][k] = float64(yy) - 128
+				samples[1][k] = float64(cb) - 128
+				samples[2][k] = float64(cr) - 128
+			}
+			for i := range j.comps {
+				c := &j.comps[i]
+				fdct(&samples[i], &c.blocks[by*bw+bx], &quant[c.tq])
+			}
+		}
+	}
+
+	data, err := j.crop(image.Rect(0, 0, j.width, j.height))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// fdct transforms the level-shifted samples of one block, in natural order,
+// and stores them in out quantized by quant, both in zigzag order.
+func fdct(samples *[64]float64, out *jpegBlock, quant *[64]int) {
+	var rows [64]float64
+	for y := 0; y < 8; y++ {
+		for u := 0; u < 8; u++ {
+			var s float64
+			for x := 0; x < 8; x++ {
+				s += samples[y*8+x] * jpegCos[x][u]
+			}
+			rows[y*8+u] = s
+		}
+	}
+	for k := 0; k < 64; k++ {
+		u, v := jpegZigzag[k]%8, jpegZigzag[k]/8
+		var s float64
+		for y := 0; y < 8; y++ {
+			s += rows[y*8+u] * jpegCos[y][v]
+		}
+		out[k] = int32(math.Round(s / float64(quant[k])))
+	}
+}
@@ -0,0 +1,686 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"math/bits"
+	"os"
+)
+
+// This file implements -no-reencode-jpeg: cropping a baseline JPEG on its
+// quantized DCT coefficients. The blocks inside the crop are kept as they
+// are and only entropy coded again, so no pixel changes. The crop's top-left
+// corner must lie on the MCU grid; its size is free.
+
+// jpegBlock holds the 64 quantized coefficients of an 8x8 block, in zigzag
+// order.
+type jpegBlock [64]int32
+
+// jpegComponent is one color component of a baseline JPEG.
+type jpegComponent struct {
+	id, h, v, tq uint8
+	// dc and ac select the Huffman tables the scan decodes it with.
+	dc, ac uint8
+	// bw and bh are the number of blocks across and down.
+	bw, bh int
+	blocks []jpegBlock
+}
+
+// jpegImage is a decoded baseline JPEG down to its DCT coefficients.
+type jpegImage struct {
+	width, height int
+	comps         []jpegComponent
+	hmax, vmax    int
+	// segments are the APPn, COM and DQT segments, marker included, to
+	// copy to the output unchanged.
+	segments [][]byte
+}
+
+// mcuSize returns the size in pixels of the image's MCUs, the units a
+// lossless crop moves its top-left corner by.
+func (j *jpegImage) mcuSize() image.Point {
+	if len(j.comps) == 1 {
+		return image.Pt(8, 8)
+	}
+	return image.Pt(8*j.hmax, 8*j.vmax)
+}
+
+// mcuLayout returns the blocks each component contributes to an MCU and the
+// MCUs across and down for an image of the given size.
+func (j *jpegImage) mcuLayout(width, height int) (hs, vs []int, mcusX, mcusY int) {
+	if len(j.comps) == 1 {
+		// A single component is coded block by block, whatever its
+		// sampling factors.
+		return []int{1}, []int{1}, (width + 7) / 8, (height + 7) / 8
+	}
+	for _, c := range j.comps {
+		hs = append(hs, int(c.h))
+		vs = append(vs, int(c.v))
+	}
+	mcu := j.mcuSize()
+	return hs, vs, (width + mcu.X - 1) / mcu.X, (height + mcu.Y - 1) / mcu.Y
+}
+
+// huffmanDecoder decodes one canonical Huffman table (JPEG Annex F.2.2.3).
+type huffmanDecoder struct {
+	mincode, maxcode, valptr [17]int32
+	vals                     []uint8
+}
+
+func newHuffmanDecoder(counts [16]uint8, vals []uint8) *huffmanDecoder {
+	d := &huffmanDecoder{vals: vals}
+	code, k := int32(0), int32(0)
+	for l := 1; l <= 16; l++ {
+		n := int32(counts[l-1])
+		d.maxcode[l] = -1
+		if n > 0 {
+			d.valptr[l], d.mincode[l] = k, code
+			code += n
+			k += n
+			d.maxcode[l] = code - 1
+		}
+		code <<= 1
+	}
+	return d
+}
+
+// jpegBitReader reads the entropy-coded data of a scan, removing the
+// stuffed zero bytes after 0xff.
+type jpegBitReader struct {
+	data []byte
+	pos  int
+	acc  uint32
+	n    uint
+}
+
+var errJPEGTruncated = errors.New("jpeg: truncated scan")
+
+func (r *jpegBitReader) bit() (int32, error) {
+	if r.n == 0 {
+		if r.pos >= len(r.data) {
+			return 0, errJPEGTruncated
+		}
+		b := r.data[r.pos]
+		r.pos++
+		if b == 0xff {
+			if r.pos >= len(r.data) || r.data[r.pos] != 0x00 {
+				return 0, errors.New("jpeg: unexpected marker in scan")
+			}
+			r.pos++
+		}
+		r.acc, r.n = uint32(b), 8
+	}
+	r.n--
+	return int32(r.acc>>r.n) & 1, nil
+}
+
+// receive reads an s-bit value and extends its sign (Annex F.2.2.1).
+func (r *jpegBitReader) receive(s uint8) (int32, error) {
+	var v int32
+	for i := uint8(0); i < s; i++ {
+		b, err := r.bit()
+		if err != nil {
+			return 0, err
+		}
+		v = v<<1 | b
+	}
+	if s > 0 && v < 1<<(s-1) {
+		v += -1<<s + 1
+	}
+	return v, nil
+}
+
+func (r *jpegBitReader) decode(d *huffmanDecoder) (uint8, error) {
+	var code int32
+	for l := 1; l <= 16; l++ {
+		b, err := r.bit()
+		if err != nil {
+			return 0, err
+		}
+		code = code<<1 | b
+		if code <= d.maxcode[l] {
+			return d.vals[d.valptr[l]+code-d.mincode[l]], nil
+		}
+	}
+	return 0, errors.New("jpeg: bad Huffman code")
+}
+
+// restart skips the RSTn marker ending a restart interval.
+func (r *jpegBitReader) restart() error {
+	r.n = 0
+	if r.pos+1 >= len(r.data) || r.data[r.pos] != 0xff || r.data[r.pos+1]&0xf8 != 0xd0 {
+		return errors.New("jpeg: missing restart marker")
+	}
+	r.pos += 2
+	return nil
+}
+
+// parseJPEG decodes a baseline, single-scan JPEG down to its coefficients.
+// Other JPEGs, such as progressive ones, are reported as unsupported.
+func parseJPEG(data []byte) (*jpegImage, error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, errors.New("jpeg: missing SOI marker")
+	}
+	j := &jpegImage{}
+	var dc, ac [4]*huffmanDecoder
+	restart := 0
+	for pos := 2; ; {
+		for pos < len(data) && data[pos] == 0xff && pos+1 < len(data) && data[pos+1] == 0xff {
+			pos++ // fill bytes
+		}
+		if pos+4 > len(data) || data[pos] != 0xff {
+			return nil, errors.New("jpeg: bad marker")
+		}
+		marker := data[pos+1]
+		end := pos + 2 + int(binary.BigEndian.Uint16(data[pos+2:]))
+		if end > len(data) || end < pos+4 {
+			return nil, errors.New("jpeg: bad segment length")
+		}
+		p := data[pos+4 : end]
+
+		switch {
+		case marker == 0xc0 || marker == 0xc1:
+			if err := j.parseSOF(p); err != nil {
+				return nil, err
+			}
+		case marker >= 0xc2 && marker <= 0xcf && marker != 0xc4 && marker != 0xc8:
+			return nil, fmt.Errorf("jpeg: SOF%d (not baseline) is not supported", marker-0xc0)
+		case marker == 0xc4:
+			for len(p) > 0 {
+				if len(p) < 17 || p[0]>>4 > 1 || p[0]&0x0f > 3 {
+					return nil, errors.New("jpeg: bad DHT segment")
+				}
+				var counts [16]uint8
+				copy(counts[:], p[1:17])
+				total := 0
+				for _, c := range counts {
+					total += int(c)
+				}
+				if len(p) < 17+total {
+					return nil, errors.New("jpeg: bad DHT segment")
+				}
+				d := newHuffmanDecoder(counts, p[17:17+total])
+				if p[0]>>4 == 0 {
+					dc[p[0]&0x0f] = d
+				} else {
+					ac[p[0]&0x0f] = d
+				}
+				p = p[17+total:]
+			}
+		case marker == 0xdd:
+			if len(p) < 2 {
+				return nil, errors.New("jpeg: bad DRI segment")
+			}
+			restart = int(binary.BigEndian.Uint16(p))
+		case marker == 0xdb || marker == 0xfe || marker >= 0xe0 && marker <= 0xef:
+			j.segments = append(j.segments, data[pos:end])
+		case marker == 0xda:
+			if j.comps == nil {
+				return nil, errors.New("jpeg: scan before frame header")
+			}
+			n, err := j.decodeScan(p, data[end:], dc, ac, restart)
+			if err != nil {
+				return nil, err
+			}
+			if next := nextJPEGMarker(data, end+n); next != 0xd9 {
+				return nil, errors.New("jpeg: only single-scan JPEGs are supported")
+			}
+			return j, nil
+		default:
+			return nil, fmt.Errorf("jpeg: unsupported marker 0x%02x", marker)
+		}
+		pos = end
+	}
+}
+
+func (j *jpegImage) parseSOF(p []byte) error {
+	if len(p) < 6 || p[0] != 8 {
+		return errors.New("jpeg: only 8-bit JPEGs are supported")
+	}
+	j.height = int(binary.BigEndian.Uint16(p[1:]))
+	j.width = int(binary.BigEndian.Uint16(p[3:]))
+	n := int(p[5])
+	if j.width == 0 || j.height == 0 || n == 0 || len(p) < 6+3*n {
+		return errors.New("jpeg: bad SOF segment")
+	}
+	j.comps = make([]jpegComponent, n)
+	j.hmax, j.vmax = 1, 1
+	for i := range j.comps {
+		c := p[6+3*i:]
+		h, v := c[1]>>4, c[1]&0x0f
+		if h < 1 || h > 4 || v < 1 || v > 4 {
+			return errors.New("jpeg: bad sampling factors")
+		}
+		j.comps[i] = jpegComponent{id: c[0], h: h, v: v, tq: c[2]}
+		j.hmax, j.vmax = max(j.hmax, int(h)), max(j.vmax, int(v))
+	}
+	return nil
+}
+
+// decodeScan decodes the scan with header p and entropy-coded data scan,
+// returning the number of bytes of scan it used.
+func (j *jpegImage) decodeScan(p, scan []byte, dc, ac [4]*huffmanDecoder, restart int) (int, error) {
+	if len(p) < 1 || int(p[0]) != len(j.comps) || len(p) < 1+2*len(j.comps)+3 {
+		return 0, errors.New("jpeg: only scans of every component are supported")
+	}
+	for i := range j.comps {
+		if p[1+2*i] != j.comps[i].id {
+			return 0, errors.New("jpeg: scan components out of order")
+		}
+		j.comps[i].dc, j.comps[i].ac = p[2+2*i]>>4, p[2+2*i]&0x0f
+		if j.comps[i].dc > 3 || j.comps[i].ac > 3 || dc[j.comps[i].dc] == nil || ac[j.comps[i].ac] == nil {
+			return 0, errors.New("jpeg: missing Huffman table")
+		}
+	}
+
+	hs, vs, mcusX, mcusY := j.mcuLayout(j.width, j.height)
+	for i := range j.comps {
+		c := &j.comps[i]
+		c.bw, c.bh = mcusX*hs[i], mcusY*vs[i]
+		c.blocks = make([]jpegBlock, c.bw*c.bh)
+	}
+
+	r := &jpegBitReader{data: scan}
+	pred := make([]int32, len(j.comps))
+	for m := 0; m < mcusX*mcusY; m++ {
+		if restart > 0 && m > 0 && m%restart == 0 {
+			if err := r.restart(); err != nil {
+				return 0, err
+			}
+			clear(pred)
+		}
+		mx, my := m%mcusX, m/mcusX
+		for i := range j.comps {
+			c := &j.comps[i]
+			for by := 0; by < vs[i]; by++ {
+				for bx := 0; bx < hs[i]; bx++ {
+					b := &c.blocks[(my*vs[i]+by)*c.bw+mx*hs[i]+bx]
+					if err := r.decodeBlock(b, dc[c.dc], ac[c.ac], &pred[i]); err != nil {
+						return 0, err
+					}
+				}
+			}
+		}
+	}
+	return r.pos, nil
+}
+
+func (r *jpegBitReader) decodeBlock(b *jpegBlock, dc, ac *huffmanDecoder, pred *int32) error {
+	s, err := r.decode(dc)
+	if err != nil {
+		return err
+	}
+	diff, err := r.receive(s)
+	if err != nil {
+		return err
+	}
+	*pred += diff
+	b[0] = *pred
+	for k := 1; k < 64; k++ {
+		rs, err := r.decode(ac)
+		if err != nil {
+			return err
+		}
+		run, size := int(rs>>4), rs&0x0f
+		if size == 0 {
+			if run != 15 {
+				break // end of block
+			}
+			k += 15
+			continue
+		}
+		k += run
+		if k > 63 {
+			return errors.New("jpeg: bad AC run")
+		}
+		if b[k], err = r.receive(size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nextJPEGMarker returns the first marker in data at or after pos, skipping
+// stuffed bytes and restart markers, or 0 if there is none.
+func nextJPEGMarker(data []byte, pos int) byte {
+	for ; pos+1 < len(data); pos++ {
+		if data[pos] == 0xff {
+			if m := data[pos+1]; m != 0x00 && m != 0xff && m&0xf8 != 0xd0 {
+				return m
+			}
+		}
+	}
+	return 0
+}
+
+// crop returns a JPEG file holding the part of j inside rect, which must be
+// inside the image and have its top-left corner on the MCU grid.
+func (j *jpegImage) crop(rect image.Rectangle) ([]byte, error) {
+	mcu := j.mcuSize()
+	if !rect.In(image.Rect(0, 0, j.width, j.height)) || rect.Empty() {
+		return nil, fmt.Errorf("jpeg: crop %v outside the image", rect)
+	}
+	if rect.Min.X%mcu.X != 0 || rect.Min.Y%mcu.Y != 0 {
+		return nil, fmt.Errorf("jpeg: crop %v not aligned to the %dx%d MCU grid", rect, mcu.X, mcu.Y)
+	}
+
+	width, height := rect.Dx(), rect.Dy()
+	hs, vs, mcusX, mcusY := j.mcuLayout(width, height)
+	mx0, my0 := rect.Min.X/mcu.X, rect.Min.Y/mcu.Y
+
+	// Code the kept blocks twice: once to count the symbols for optimal
+	// Huffman tables, as the original tables may lack codes for the new DC
+	// differences at the crop edge, and once to write them.
+	enc := &jpegEncoder{counting: true}
+	for pass := 0; pass < 2; pass++ {
+		pred := make([]int32, len(j.comps))
+		for my := 0; my < mcusY; my++ {
+			for mx := 0; mx < mcusX; mx++ {
+				for i := range j.comps {
+					c := &j.comps[i]
+					for by := 0; by < vs[i]; by++ {
+						for bx := 0; bx < hs[i]; bx++ {
+							x, y := (mx0+mx)*hs[i]+bx, (my0+my)*vs[i]+by
+							enc.block(&c.blocks[y*c.bw+x], &pred[i])
+						}
+					}
+				}
+			}
+		}
+		if pass == 0 {
+			enc.buildTables()
+		}
+	}
+	enc.flush()
+
+	out := []byte{0xff, 0xd8}
+	for _, s := range j.segments {
+		out = append(out, s...)
+	}
+	sof := []byte{8, byte(height >> 8), byte(height), byte(width >> 8), byte(width), byte(len(j.comps))}
+	for i, c := range j.comps {
+		hv := c.h<<4 | c.v
+		if len(j.comps) == 1 {
+			hv = 0x11
+		}
+		sof = append(sof, c.id, hv, j.comps[i].tq)
+	}
+	out = appendJPEGSegment(out, 0xc0, sof)
+	dht := append([]byte{0x00}, enc.dcCounts[:]...)
+	dht = append(dht, enc.dcVals...)
+	dht = append(dht, 0x10)
+	dht = append(dht, enc.acCounts[:]...)
+	dht = append(dht, enc.acVals...)
+	out = appendJPEGSegment(out, 0xc4, dht)
+	sos := []byte{byte(len(j.comps))}
+	for _, c := range j.comps {
+		sos = append(sos, c.id, 0x00)
+	}
+	sos = append(sos, 0, 63, 0)
+	out = appendJPEGSegment(out, 0xda, sos)
+	out = append(out, enc.out...)
+	return append(out, 0xff, 0xd9), nil
+}
+
+func appendJPEGSegment(out []byte, marker byte, payload []byte) []byte {
+	out = append(out, 0xff, marker)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(payload)+2))
+	return append(out, payload...)
+}
+
+// jpegEncoder entropy codes blocks with one DC and one AC Huffman table.
+// While counting, it only tallies the symbols for buildTables.
+type jpegEncoder struct {
+	counting       bool
+	dcFreq, acFreq [257]int64
+
+	dcCounts, acCounts [16]uint8
+	dcVals, acVals     []uint8
+	dcCode, acCode     [256]uint16
+	dcSize, acSize     [256]uint8
+
+	out []byte
+	acc uint32
+	n   uint
+}
+
+func (e *jpegEncoder) block(b *jpegBlock, pred *int32) {
+	diff := b[0] - *pred
+	*pred = b[0]
+	size := magnitude(diff)
+	e.symbol(false, size)
+	e.bits(diff, size)
+
+	run := 0
+	for k := 1; k < 64; k++ {
+		if b[k] == 0 {
+			run++
+			continue
+		}
+		for ; run > 15; run -= 16 {
+			e.symbol(true, 0xf0)
+		}
+		size := magnitude(b[k])
+		e.symbol(true, uint8(run)<<4|size)
+		e.bits(b[k], size)
+		run = 0
+	}
+	if run > 0 {
+		e.symbol(true, 0x00) // end of block
+	}
+}
+
+// magnitude returns the number of bits of v's magnitude, its JPEG size
+// category.
+func magnitude(v int32) uint8 {
+	if v < 0 {
+		v = -v
+	}
+	return uint8(bits.Len32(uint32(v)))
+}
+
+func (e *jpegEncoder) symbol(isAC bool, s uint8) {
+	switch {
+	case e.counting && isAC:
+		e.acFreq[s]++
+	case e.counting:
+		e.dcFreq[s]++
+	case isAC:
+		e.write(uint32(e.acCode[s]), uint(e.acSize[s]))
+	default:
+		e.write(uint32(e.dcCode[s]), uint(e.dcSize[s]))
+	}
+}
+
+// bits writes the size low bits of v, in JPEG's one's-complement form for
+// negative values.
+func (e *jpegEncoder) bits(v int32, size uint8) {
+	if e.counting || size == 0 {
+		return
+	}
+	if v < 0 {
+		v += 1<<size - 1
+	}
+	e.write(uint32(v), uint(size))
+}
+
+func (e *jpegEncoder) write(v uint32, n uint) {
+	e.acc = e.acc<<n | v&(1<<n-1)
+	e.n += n
+	for e.n >= 8 {
+		b := byte(e.acc >> (e.n - 8))
+		e.out = append(e.out, b)
+		if b == 0xff {
+			e.out = append(e.out, 0x00)
+		}
+		e.n -= 8
+	}
+	e.acc &= 1<<e.n - 1
+}
+
+// flush pads the last byte with one bits.
+func (e *jpegEncoder) flush() {
+	if e.n > 0 {
+		e.write(1<<(8-e.n)-1, 8-e.n)
+	}
+}
+
+// buildTables derives the Huffman tables from the counted symbols and
+// switches from counting to writing.
+func (e *jpegEncoder) buildTables() {
+	e.dcCounts, e.dcVals = optimalHuffman(e.dcFreq)
+	e.acCounts, e.acVals = optimalHuffman(e.acFreq)
+	e.dcCode, e.dcSize = huffmanCodes(e.dcCounts, e.dcVals)
+	e.acCode, e.acSize = huffmanCodes(e.acCounts, e.acVals)
+	e.counting = false
+}
+
+// optimalHuffman builds a Huffman table of codes at most 16 bits long for
+// the symbol frequencies freq, following JPEG Annex K.2. freq[256] is
+// reserved so that no code is all one bits.
+func optimalHuffman(freq [257]int64) (counts [16]uint8, vals []uint8) {
+	freq[256] = 1
+	var codesize [257]int
+	var others [257]int
+	for i := range others {
+		others[i] = -1
+	}
+	for {
+		c1, c2 := -1, -1
+		v1, v2 := int64(math.MaxInt64), int64(math.MaxInt64)
+		for i, f := range freq {
+			if f > 0 && f <= v1 {
+				v1, c1 = f, i
+			}
+		}
+		for i, f := range freq {
+			if f > 0 && f <= v2 && i != c1 {
+				v2, c2 = f, i
+			}
+		}
+		if c2 < 0 {
+			break
+		}
+		freq[c1] += freq[c2]
+		freq[c2] = 0
+		codesize[c1]++
+		for others[c1] >= 0 {
+			c1 = others[c1]
+			codesize[c1]++
+		}
+		others[c1] = c2
+		codesize[c2]++
+		for others[c2] >= 0 {
+			c2 = others[c2]
+			codesize[c2]++
+		}
+	}
+
+	var lengths [258]int
+	for _, size := range codesize {
+		if size > 0 {
+			lengths[size]++
+		}
+	}
+	// Shorten codes longer than 16 bits (Figure K.3).
+	for i := len(lengths) - 1; i > 16; i-- {
+		for lengths[i] > 0 {
+			j := i - 2
+			for lengths[j] == 0 {
+				j--
+			}
+			lengths[i] -= 2
+			lengths[i-1]++
+			lengths[j+1] += 2
+			lengths[j]--
+		}
+	}
+	// Drop the reserved symbol, which has one of the longest codes.
+	i := 16
+	for lengths[i] == 0 {
+		i--
+	}
+	lengths[i]--
+	for l := 1; l <= 16; l++ {
+		counts[l-1] = uint8(lengths[l])
+	}
+
+	for size := 1; size < len(lengths); size++ {
+		for s := 0; s < 256; s++ {
+			if codesize[s] == size {
+				vals = append(vals, uint8(s))
+			}
+		}
+	}
+	return counts, vals
+}
+
+// huffmanCodes assigns the canonical codes of a Huffman table (Annex C).
+func huffmanCodes(counts [16]uint8, vals []uint8) (code [256]uint16, size [256]uint8) {
+	c, k := uint16(0), 0
+	for l := 1; l <= 16; l++ {
+		for i := 0; i < int(counts[l-1]); i++ {
+			code[vals[k]], size[vals[k]] = c, uint8(l)
+			c++
+			k++
+		}
+		c <<= 1
+	}
+	return code, size
+}
+
+// writeLosslessJPEG writes the crop of the JPEG at src to dst without
+// re-encoding it, for -no-reencode-jpeg. It returns the rectangle actually
+// kept, which -snap-mcu may have widened, and false after warning when the
+// crop can't be lossless, so the caller re-encodes instead.
+func writeLosslessJPEG(src, dst string, imgBounds, bounds image.Rectangle, opts options) (image.Rectangle, bool, error) {
+	lossy := func(reason string) (image.Rectangle, bool, error) {
+		opts.logf("  Warning: %s, re-encoding the JPEG (lossy)\n", reason)
+		return bounds, false, nil
+	}
+	size := bounds.Size()
+	switch {
+	case !bounds.In(imgBounds):
+		return lossy("padding needs new pixels")
+	case needsRotation(size, opts.Orient):
+		return lossy("-orient rotates the crop")
+	case fitSize(size, opts.MaxDim) != size:
+		return lossy("-max-dim resizes the crop")
+	}
+
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return bounds, false, err
+	}
+	j, err := parseJPEG(data)
+	if err != nil {
+		return lossy(err.Error())
+	}
+	rect := bounds.Sub(imgBounds.Min)
+	mcu := j.mcuSize()
+	if opts.SnapMCU {
+		rect.Min.X -= rect.Min.X % mcu.X
+		rect.Min.Y -= rect.Min.Y % mcu.Y
+	} else if rect.Min.X%mcu.X != 0 || rect.Min.Y%mcu.Y != 0 {
+		return lossy(fmt.Sprintf("crop %v is off the %dx%d MCU grid (see -snap-mcu)", bounds, mcu.X, mcu.Y))
+	}
+	out, err := j.crop(rect)
+	if err != nil {
+		return lossy(err.Error())
+	}
+
+	write := func() error {
+		return writeFileAtomic(dst, func(w io.Writer) error {
+			_, err := w.Write(out)
+			return err
+		})
+	}
+	if err := writeVerified(dst, rect.Size(), opts, write); err != nil {
+		return bounds, false, err
+	}
+	return rect.Add(imgBounds.Min), true, nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// jpegTestImage returns a JPEG of a colorful gradient inside a black border.
+func jpegTestImage(t *testing.T, gray bool) []byte {
+	t.Helper()
+	var img interface {
+		image.Image
+		Set(x, y int, c color.Color)
+	}
+	if gray {
+		img = image.NewGray(image.Rect(0, 0, 100, 80))
+	} else {
+		img = image.NewRGBA(image.Rect(0, 0, 100, 80))
+	}
+	for y := 0; y < 80; y++ {
+		for x := 0; x < 100; x++ {
+			c := color.RGBA{0, 0, 0, 255}
+			if image.Pt(x, y).In(image.Rect(32, 16, 88, 64)) {
+				c = color.RGBA{uint8(x * 5), uint8(y * 7), uint8(x * y), 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// assertSamePixels checks that got shows exactly the pixels of want from
+// offset on.
+func assertSamePixels(t *testing.T, got, want image.Image, offset image.Point) {
+	t.Helper()
+	b := got.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if g, w := got.At(x, y), want.At(x+offset.X, y+offset.Y); g != w {
+				t.Fatalf("Pixel (%d,%d) = %v, expected %v", x, y, g, w)
+			}
+		}
+	}
+}
+
+func TestLosslessJPEGCrop(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		gray bool
+		rect image.Rectangle
+	}{
+		{"ycbcr 4:2:0", false, image.Rect(16, 16, 91, 69)},
+		{"gray", true, image.Rect(24, 8, 99, 61)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data := jpegTestImage(t, tc.gray)
+			j, err := parseJPEG(data)
+			if err != nil {
+				t.Fatalf("parseJPEG() error = %v", err)
+			}
+			out, err := j.crop(tc.rect)
+			if err != nil {
+				t.Fatalf("crop() error = %v", err)
+			}
+			if !bytes.HasPrefix(out, []byte{0xff, 0xd8}) || !bytes.HasSuffix(out, []byte{0xff, 0xd9}) {
+				t.Fatal("Expected the crop to start with SOI and end with EOI")
+			}
+
+			original, err := jpeg.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			cropped, err := jpeg.Decode(bytes.NewReader(out))
+			if err != nil {
+				t.Fatalf("Decoding the lossless crop: %v", err)
+			}
+			if got := cropped.Bounds().Size(); got != tc.rect.Size() {
+				t.Fatalf("Expected size %v, got %v", tc.rect.Size(), got)
+			}
+			assertSamePixels(t, cropped, original, tc.rect.Min)
+		})
+	}
+
+	j, err := parseJPEG(jpegTestImage(t, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := j.crop(image.Rect(8, 16, 80, 64)); err == nil {
+		t.Error("Expected an error for a crop off the 16x16 MCU grid")
+	}
+
+	progressive := []byte{0xff, 0xd8, 0xff, 0xc2, 0x00, 0x02}
+	if _, err := parseJPEG(progressive); err == nil {
+		t.Error("Expected progressive JPEGs to be unsupported")
+	}
+}
+
+func TestNoReencodeJPEG(t *testing.T) {
+	dir := t.TempDir()
+	data := jpegTestImage(t, false)
+	if err := os.WriteFile(filepath.Join(dir, "photo.jpg"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	original, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := processImage(filepath.Join(dir, "photo.jpg"), dir, "photo.jpg", options{NoReencodeJPEG: true, SnapMCU: true})
+	if err != nil {
+		t.Fatalf("processImage() error = %v", err)
+	}
+	if res.Bounds.Min.X%16 != 0 || res.Bounds.Min.Y%16 != 0 {
+		t.Fatalf("Expected -snap-mcu to align the crop to 16x16 MCUs, got %v", res.Bounds)
+	}
+	out, _, err := loadImage(filepath.Join(dir, res.Output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := out.Bounds().Size(); got != res.Bounds.Size() {
+		t.Fatalf("Expected output size %v, got %v", res.Bounds.Size(), got)
+	}
+	assertSamePixels(t, out, original, res.Bounds.Min)
+
+	// Padding can't be done on DCT blocks, so that crop is re-encoded.
+	res, err = processImage(filepath.Join(dir, "photo.jpg"), dir, "photo.jpg", options{NoReencodeJPEG: true, Padding: "40"})
+	if err != nil {
+		t.Fatalf("processImage() with padding error = %v", err)
+	}
+	out, _, err = loadImage(filepath.Join(dir, res.Output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := out.Bounds().Size(); got != res.Bounds.Size() {
+		t.Errorf("Expected a re-encoded %v output, got %v", res.Bounds.Size(), got)
+	}
+}
@@ -1,412 +1,2790 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"image"
 	"image/color"
-	"image/draw"
+	"image/gif"
 	"image/jpeg"
 	"image/png"
+	"io"
+	"io/fs"
+	"math"
 	"net/http"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"gazounomawarinoiranaifuchiwokesu/crop"
+)
+
+// Thresholds for "Black-ish" and "White-ish" pixels.
+const (
+	blackThreshold = crop.DefaultBlackThreshold
+	whiteThreshold = crop.DefaultWhiteThreshold
+)
+
+// defaultNoiseTolerance is the fraction of a row or column that must be
+// background to trim it when -noise-tolerance is not set.
+const defaultNoiseTolerance = crop.DefaultNoiseTolerance
+
+// The -scan-order values: scanVerticalFirst trims the top and bottom, then
+// the left and right within the rows kept; scanHorizontalFirst the other
+// way around.
+const (
+	scanVerticalFirst   = "vh"
+	scanHorizontalFirst = "hv"
+)
+
+// levels returns the thresholds to detect with: opts.Levels, or
+// defaultLevels when it is not set.
+func (opts options) levels() crop.Levels {
+	if opts.Levels != nil {
+		return *opts.Levels
+	}
+	return defaultLevels
+}
+
+// parseLevels validates the -black-threshold and -white-threshold values
+// and the per-channel levels derived from them, and returns the levels.
+// Zero is a valid black level: only pure black is background then.
+func parseLevels(blackAll, whiteAll int, black, white [3]int) (crop.Levels, error) {
+	if blackAll < 0 || blackAll > 255 || whiteAll < 0 || whiteAll > 255 {
+		return crop.Levels{}, errors.New("-black-threshold and -white-threshold must be between 0 and 255")
+	}
+	if blackAll >= whiteAll {
+		return crop.Levels{}, errors.New("-black-threshold must be below -white-threshold")
+	}
+	var lv crop.Levels
+	for i, channel := range []string{"r", "g", "b"} {
+		if black[i] < 0 || black[i] > 255 || white[i] < 0 || white[i] > 255 {
+			return crop.Levels{}, fmt.Errorf("-black-%s and -white-%s must be between 0 and 255", channel, channel)
+		}
+		if black[i] >= white[i] {
+			return crop.Levels{}, fmt.Errorf("-black-%s must be below -white-%s", channel, channel)
+		}
+		lv.Black[i] = uint8(black[i])
+		lv.White[i] = uint8(white[i])
+	}
+	return lv, nil
+}
+
+// logOutput receives the per-file progress messages. It is switched to
+// stderr when stdout carries machine-readable output.
+var logOutput io.Writer = os.Stdout
+
+// logWriter returns where the progress messages for the current image go.
+func (opts options) logWriter() io.Writer {
+	if opts.log != nil {
+		return opts.log
+	}
+	return logOutput
+}
+
+// logf writes a progress message for the current image; see logWriter.
+func (opts options) logf(format string, args ...any) {
+	fmt.Fprintf(opts.logWriter(), format, args...)
+}
+
+// options holds the user-configurable settings for a run.
+type options struct {
+	// MaxAspectChange rejects crops whose aspect ratio differs from the
+	// original by more than this factor. Zero disables the check.
+	MaxAspectChange float64
+
+	// DetectOnlyBorderWidth enables a pre-check of the outermost N pixels
+	// on each side: if none of them is mostly background, the image is
+	// treated as full-bleed and the full scan is skipped. Zero disables it.
+	DetectOnlyBorderWidth int
+
+	// VignetteTolerance loosens the black/white thresholds by up to this many
+	// levels toward the corners, ramping down to zero at the center.
+	VignetteTolerance int
+
+	// Fuzz switches detection to ImageMagick's -trim semantics: the
+	// background is the top-left corner's color, and pixels within this
+	// percentage of the channel range of it (see colorDistance) count as
+	// background, e.g. "10%". Empty uses the black and white detection.
+	Fuzz string
+
+	// Levels overrides the black and white thresholds per channel, e.g. a
+	// looser blue level for a scanner with a blue cast. Nil keeps
+	// defaultLevels; see options.levels.
+	Levels *crop.Levels
+
+	// HueTolerance, in degrees, detects a colored background that is
+	// neither black nor white (e.g. a pastel frame) by comparing each
+	// pixel's hue with the corners', ignoring small saturation and value
+	// differences. It only applies when the corners agree on a hue. Zero
+	// disables it.
+	HueTolerance float64
+
+	// Mode selects how the background is detected: "bw" uses the fixed
+	// Levels, "transparent" (or empty) also trims the transparent border
+	// of images with fully transparent corners, "adaptive" derives the levels of each image from
+	// its luminance histogram (see adaptiveLevels), "auto-color" also
+	// accepts agreeing colored corners and "none" doesn't crop. A chain
+	// such as "bw,auto-color,none" tries each in turn until one finds a
+	// crop; see detectModes.
+	Mode string
+	// transparent is set under the "transparent" mode; see optionsForMode.
+	transparent bool
+
+	// EdgeSampleRate has the black-or-white vote poll every
+	// EdgeSampleRate-th pixel along the image's edges instead of its
+	// corners and edge midpoints, so that content or noise at a few
+	// sample points can't swing it. Zero keeps the corner vote; 1 polls
+	// every edge pixel. The trimming scans are not affected.
+	EdgeSampleRate int
+
+	// CornerAgreementTolerance detects any other colored background when
+	// each of the four corners is within this distance (RMS over R, G and
+	// B, 0-255) of their average: that average is the background, matched
+	// within the same distance. Corners that disagree more leave the image
+	// uncropped. Zero disables it.
+	CornerAgreementTolerance int
+
+	// GradientBg detects a background that shades smoothly from top to
+	// bottom: each row's expected color is interpolated between the top
+	// and the bottom corners, and pixels within this distance (RMS over
+	// R, G and B, 0-255) of it are background. Zero disables it.
+	GradientBg int
+
+	// Reference is the path of an image of the empty background, e.g. the
+	// bare stage of a product shoot, with the same dimensions as the
+	// inputs. Pixels whose R, G and B each differ from the reference's by
+	// at most DiffTolerance levels are background.
+	Reference     string
+	DiffTolerance int
+	// reference is the loaded Reference image.
+	reference *referenceImage
+
+	// AlphaThreshold treats pixels less opaque than this (0-255) as
+	// background whatever their color, so feathered edges are trimmed.
+	// Zero disables it. It is also the threshold of the transparent
+	// background of the "transparent" mode, which is
+	// crop.DefaultAlphaThreshold when it is zero.
+	AlphaThreshold int
+
+	// QuantizeAlpha rounds every pixel's alpha to 0 below this midpoint
+	// (1-255) and to 255 from it on before detection, so antialiased edges
+	// give a sharp, consistent boundary. The output is not affected. Zero
+	// disables it.
+	QuantizeAlpha int
+
+	// PreserveColor lists "#rrggbb" colors, separated by commas, that are
+	// never background, e.g. an intentional white matte that must survive
+	// while a black scanner border is trimmed.
+	PreserveColor string
+
+	// BgMatchTolerance additionally requires a black or white background
+	// pixel to be within this distance (RMS over R, G and B, 0-255) of the
+	// background sampled at the corners, so content close to the
+	// background color isn't eroded. Zero disables it.
+	BgMatchTolerance int
+
+	// PerSideBackground samples each side's own edge and trims it against
+	// that background, black or white, instead of the one detected for the
+	// whole image, so mismatched bars (black on top, white below) are all
+	// removed.
+	PerSideBackground bool
+
+	// AutoEscalate retries an image whose detection finds no background or
+	// no crop with the progressively looser settings of escalationLadder,
+	// keeping the first that gives a plausible crop.
+	AutoEscalate bool
+
+	// NoiseTolerance is the fraction of a row or column that must be
+	// background for it to be trimmed. Zero uses the default of 0.95.
+	NoiseTolerance float64
+
+	// ToleranceTop, ToleranceBottom, ToleranceLeft and ToleranceRight set
+	// the fraction of a row or column that must be background for it to be
+	// trimmed on that side. Zero uses NoiseTolerance.
+	ToleranceTop    float64
+	ToleranceBottom float64
+	ToleranceLeft   float64
+	ToleranceRight  float64
+
+	// MaxDetectDepth stops each side's scan this many pixels in from its
+	// edge, so no side is trimmed by more than that and wide images scan
+	// quickly. Zero scans the whole image.
+	MaxDetectDepth int
+
+	// ScanOrder is which axis the scans trim first, scanVerticalFirst (or
+	// empty) or scanHorizontalFirst. The second axis measures its lines
+	// only within those the first kept, so content that reaches into one
+	// margin only, such as the foot of an L, can give different crops.
+	ScanOrder string
+
+	// MinRun is the number of consecutive removable lines a side needs
+	// right at its edge to be trimmed at all, so a single line that happens
+	// to be background doesn't start a trim. Unlike the lookahead, which
+	// skips gaps inside a border, it is checked from the edge only. Zero
+	// disables it.
+	MinRun int
+
+	// IgnoreProtrusions is the minimum run of consecutive content pixels a
+	// row or column needs to count as content. Thinner features, such as
+	// lines sticking out of the content into the margin, are trimmed. It
+	// must stay below the size of real content features. Zero disables it.
+	IgnoreProtrusions int
+
+	// Despeckle ignores content specks up to 2*Despeckle pixels across
+	// when detecting the borders. Zero disables it.
+	Despeckle int
+
+	// CloseRadius bridges background gaps up to 2*CloseRadius pixels
+	// across between content when detecting the borders, so a dashed or
+	// dotted frame reads as a solid line. Zero disables it.
+	CloseRadius int
+
+	// PaletteMatch trims a paletted image (e.g. an indexed PNG) by palette
+	// index rather than by color: pixels of the background index are
+	// background, whatever color it is. Other images are unaffected.
+	PaletteMatch bool
+	// BgIndex is the background index for PaletteMatch. A negative index
+	// takes the one most of the corners have.
+	BgIndex int
+
+	// SnapBlocks moves each detected edge up to one JPEG block further in
+	// past lines that are only faintly off the background, so compression
+	// artifacts next to the content don't leave a band. Content within
+	// blockArtifactSlack levels of the background can be trimmed with them.
+	SnapBlocks bool
+
+	// KeepLargest crops to the largest connected region of content,
+	// dropping smaller separate marks. With Despeckle, specks are removed
+	// before the regions are compared.
+	KeepLargest bool
+
+	// MinBorder leaves a side uncropped when less than this many pixels
+	// would be trimmed from it. Zero trims any amount.
+	MinBorder int
+
+	// TwoColorBorder peels a second border band inside the first when it is
+	// uniformly the other background color (a white mat around a black
+	// frame, or vice versa).
+	TwoColorBorder bool
+
+	// StrictCorners only crops when all four corners are the same
+	// background class; other images are left untouched.
+	StrictCorners bool
+
+	// MinWhiteRatio only crops images in which at least this fraction of
+	// the pixels is near-white, i.e. document scans rather than photos. Zero
+	// disables the check.
+	MinWhiteRatio float64
+
+	// MinContentFraction rejects crops whose area is below this fraction of
+	// the original area. Zero disables the check.
+	MinContentFraction float64
+
+	// Padding adds margin back around the detected content; see
+	// parsePadding for the syntax.
+	Padding string
+	// PaddingColor fills padding that extends past the original image:
+	// "auto" (or empty) for the image's background, "#rrggbb", or "clip"
+	// to keep padding within the image instead.
+	PaddingColor string
+
+	// PreserveOriginalAspect widens the crop on one axis, after padding,
+	// so the output keeps the aspect ratio of the original.
+	PreserveOriginalAspect bool
+
+	// MaxDim scales the cropped image down so its longer side is at most
+	// this many pixels. Zero disables resizing.
+	MaxDim int
+	// ResizeFilter names the scaler used when resizing: "nearest",
+	// "bilinear" or "catmullrom".
+	ResizeFilter string
+
+	// Copy makes the cropped image an independent copy instead of a
+	// SubImage sharing the source's pixels; see cropImage.
+	Copy bool
+
+	// JPEGSubsampling is the chroma subsampling of JPEG output, "420" or
+	// "444". Empty means the encoder's default, 4:2:0.
+	JPEGSubsampling string
+
+	// PNGBitDepth is the bits per channel of PNG output, 8 or 16. Zero
+	// keeps the source's depth.
+	PNGBitDepth int
+
+	// Orient rotates outputs 90 degrees clockwise where needed so they are
+	// all "portrait" or all "landscape". Empty leaves them as cropped.
+	Orient string
+
+	// Verify decodes every output after writing it and checks its size,
+	// writing it again once if the check fails.
+	Verify bool
+
+	// PreserveExactBytes copies the original file verbatim when nothing
+	// would be changed, instead of decoding and re-encoding it.
+	PreserveExactBytes bool
+
+	// NoReencodeJPEG crops JPEGs losslessly on their DCT blocks when the
+	// crop's top-left corner lies on the MCU grid, re-encoding (lossy)
+	// only when it doesn't. SnapMCU widens the crop outward to the grid
+	// so the lossless path always applies.
+	NoReencodeJPEG bool
+	SnapMCU        bool
+
+	// OnlyIfSmaller copies the original instead of writing a crop that
+	// encodes to at least as many bytes, unless the format changes.
+	OnlyIfSmaller bool
+
+	// Format forces the output encoding, e.g. "png". Empty keeps the
+	// input's format.
+	Format string
+
+	// OutDir writes the outputs under this directory, created if missing,
+	// instead of next to their images. Images given by path on standard
+	// input go in a subdirectory mirroring their own; see mirrorSubdir.
+	// NoPrefix drops the processed_ prefix of outputs written there.
+	OutDir   string
+	NoPrefix bool
+	// outSubdir is the subdirectory of OutDir for the current image.
+	outSubdir string
+	// InPlace replaces each image with its output instead of writing
+	// processed_<name>; see replaceOriginal.
+	InPlace bool
+
+	// OutputTemplate names outputs processed_<template><ext> instead of
+	// processed_<name>; see expandOutputTemplate for its placeholders,
+	// e.g. "{exif:DateTimeOriginal}_{name}". Empty keeps the input's name.
+	OutputTemplate string
+
+	// ExtractFrame writes the border instead of the content: one file per
+	// side, with the crop rectangle as the inner edge, named like
+	// processed_a_top.png. Orientation and resizing don't apply to them,
+	// and they are not reported as the result's Output.
+	ExtractFrame bool
+
+	// Annotate also writes a copy of the original with the crop rectangle
+	// outlined in AnnotateColor (#rrggbb), named like
+	// processed_a_annotated.png, to check crops at a glance.
+	Annotate      bool
+	AnnotateColor string
+
+	// DryRunDiff writes, instead of the crop, a copy of the original with
+	// the margins that would be cropped away tinted red, named like
+	// processed_a_diff.png.
+	DryRunDiff bool
+
+	// Frames selects frames of a multi-frame input (an animated GIF) by
+	// index, e.g. "0,2,5" or "1-3", and writes each cropped as
+	// processed_<name>_f<index>.png. Images with one frame only have frame 0.
+	Frames string
+	// UniformCrop crops every selected frame to the union of their content
+	// instead of each to its own.
+	UniformCrop bool
+
+	// DryRun detects every image's crop and logs it, with the pixels it
+	// would trim from each edge, without writing, moving or caching
+	// anything. A run in which images failed to decode returns an error
+	// wrapping ErrDecode.
+	DryRun bool
+
+	// NoCrop skips border detection and writes every image whole, so only
+	// the format, orientation and resize settings apply. It turns the tool
+	// into a batch converter.
+	NoCrop bool
+
+	// MaxFiles stops processing a directory after this many images. Zero
+	// means no limit.
+	MaxFiles int
+
+	// Workers is how many images a directory run works on at once. Zero
+	// uses one, as do runs with a Reviewer.
+	Workers int
+
+	// IncludeHidden processes dotfiles instead of skipping them.
+	IncludeHidden bool
+
+	// NoOpOnColorImages leaves images without a detectable black or white
+	// background completely untouched instead of writing a copy.
+	NoOpOnColorImages bool
+
+	// RequireBorder fails every image that would not be trimmed, as a QA
+	// gate for folders that should all have borders. A run with such
+	// failures returns an error wrapping ErrNoBorder.
+	RequireBorder bool
+
+	// KeepUniform keeps images that consist entirely of background color
+	// instead of failing them as empty.
+	KeepUniform bool
+
+	// Reviewer, when set, asks the user to confirm each crop before it is
+	// written (-review).
+	Reviewer *reviewer
+
+	// Dedupe replaces outputs of a directory run that are identical to an
+	// earlier one with hard links to it ("link") or deletes them ("delete").
+	// Empty disables it.
+	Dedupe string
+
+	// ContactSheet is the path of a PNG showing every output of a directory
+	// run as a grid of Columns thumbnails at most ThumbSize pixels across,
+	// each labeled with its filename. Empty disables it.
+	ContactSheet string
+	Columns      int
+	ThumbSize    int
+
+	// EmbedProvenance writes a CropInfo tEXt chunk describing the crop
+	// into PNG outputs.
+	EmbedProvenance bool
+
+	// ChecksumSkip skips files already processed with the same size,
+	// modification time and settings, tracked in a per-directory cache.
+	ChecksumSkip bool
+
+	// MoveBad moves corrupt or truncated images into a "quarantine"
+	// subdirectory next to them.
+	MoveBad bool
+
+	// FailuresDir, when set, receives a copy of every file that fails or
+	// times out, under its own name, as a worklist for manual review.
+	FailuresDir string
+
+	// MetadataOptions names the PNG tEXt keyword (or the "<key>=" prefix of
+	// a JPEG's EXIF ImageDescription) under which an image may carry its
+	// own options, as JSON like a sidecar's. They override the flags for
+	// that image; a sidecar overrides them in turn. Empty disables it.
+	MetadataOptions string
+
+	// ModifiedSince skips files whose modification time is before it. The
+	// zero time disables the filter.
+	ModifiedSince time.Time
+
+	// Incremental only processes files modified since the last complete
+	// run over a directory, recorded in its lastRunFilename marker.
+	Incremental bool
+
+	// DebugTrace logs every row and column decision of the border scan.
+	// It is meant for debugging a single image: with a directory, TraceFile
+	// must name the file to trace.
+	DebugTrace bool
+	TraceFile  string
+	// trace receives the scan decisions for the current image.
+	trace io.Writer
+	// log receives the progress messages for the current image, which a
+	// directory run buffers to print them together. Nil writes them to
+	// logOutput directly.
+	log io.Writer
+
+	// MaxMemory is a soft limit, in bytes, on the estimated decoded size of
+	// the images held at once. Zero disables the limit.
+	MaxMemory int64
+	// memory enforces MaxMemory across the files of a run.
+	memory *memoryBudget
+
+	// ImageTimeout limits the time spent detecting each image's border; an
+	// image that takes longer is skipped as timed out. Decoding is not
+	// covered. Zero disables the limit.
+	ImageTimeout time.Duration
+	// ctx is checked once per scanned row or column and cancels the scan
+	// when done.
+	ctx context.Context
+
+	// TrimReport is the path of a CSV file that receives one row per file
+	// attempted. Empty disables the report.
+	TrimReport string
+	// TruncateReport overwrites an existing report instead of appending.
+	TruncateReport bool
+	// JSONReport is the path of a JSON file describing every file attempted.
+	// Empty disables the report.
+	JSONReport string
+	// ProgressiveScan adds the minimum-area rotated rectangle around the
+	// content to the JSON report, for content rotated within its frame.
+	ProgressiveScan bool
+}
+
+func main() {
+	var opts options
+	flag.Float64Var(&opts.MaxAspectChange, "max-aspect-change", 0, "reject crops whose aspect ratio differs from the original by more than this factor (0 = disabled)")
+	flag.IntVar(&opts.DetectOnlyBorderWidth, "detect-only-border-width", 0, "skip the full scan when none of the outermost N pixels on each side is mostly background (0 = always scan)")
+	flag.IntVar(&opts.VignetteTolerance, "vignette-tolerance", 0, "extra background tolerance (0-255) at the corners, ramping to 0 at the center, for vignetted frames")
+	blackAll := flag.Int("black-threshold", blackThreshold, "highest R, G and B value (0-255) of a black background pixel; -black-r, -black-g and -black-b override it per channel")
+	whiteAll := flag.Int("white-threshold", whiteThreshold, "lowest R, G and B value (0-255) of a white background pixel; -white-r, -white-g and -white-b override it per channel")
+	var blackLevels, whiteLevels [3]int
+	for i, channel := range []string{"r", "g", "b"} {
+		flag.IntVar(&blackLevels[i], "black-"+channel, blackThreshold, "highest "+strings.ToUpper(channel)+" value (0-255) of a black background pixel")
+		flag.IntVar(&whiteLevels[i], "white-"+channel, whiteThreshold, "lowest "+strings.ToUpper(channel)+" value (0-255) of a white background pixel")
+	}
+	flag.StringVar(&opts.Fuzz, "fuzz", "", "ImageMagick-style trim: treat colors within P% of the top-left corner's color as background, e.g. 10% (empty = black and white detection)")
+	flag.IntVar(&opts.GradientBg, "gradient-bg", 0, "trim a vertical gradient background: pixels within this RMS distance (0-255) of their row's color, interpolated between the top and bottom corners (0 = off)")
+	flag.StringVar(&opts.Reference, "reference", "", "image of the empty background, the same size as the inputs: trim where the input matches it within -diff-tolerance (empty = off)")
+	flag.IntVar(&opts.DiffTolerance, "diff-tolerance", 16, "largest per-channel difference (0-255) from -reference that still counts as background")
+	flag.IntVar(&opts.EdgeSampleRate, "edge-sample-rate", 0, "decide between a black and a white background by every Nth pixel along the edges instead of the corners and edge midpoints (1 = every edge pixel, 0 = corners)")
+	flag.IntVar(&opts.CornerAgreementTolerance, "corner-agreement-tolerance", 0, "trim a colored background when the four corners are within this RMS distance (0-255) of their average color (0 = off)")
+	flag.StringVar(&opts.Mode, "mode", "", "background detection mode, or a comma-separated chain tried in order until one crops, e.g. \"bw,auto-color,none\": bw (fixed -black-*/-white-* levels), transparent (bw, and transparent borders of images with fully transparent corners), adaptive (levels from each image's histogram), auto-color (also agreeing colored corners), none (empty = transparent)")
+	flag.Float64Var(&opts.HueTolerance, "hue-tolerance", 0, "trim a colored (e.g. pastel) background whose hue is within this many degrees of the corners' (0 = black and white only)")
+	flag.IntVar(&opts.QuantizeAlpha, "quantize-alpha", 0, "before detection, make pixels with alpha below this midpoint (1-255) fully transparent and the rest fully opaque (0 = off)")
+	flag.IntVar(&opts.AlphaThreshold, "alpha-threshold", 0, "treat pixels with alpha below this (0-255) as background, to trim feathered transparent edges; also the cutoff of a transparent border (0 = off, and 128 for transparent borders)")
+	flag.Float64Var(&opts.NoiseTolerance, "noise-tolerance", 0, "fraction of a row or column that must be background to trim it (0 = default 0.95)")
+	flag.StringVar(&opts.PreserveColor, "preserve-color", "", "comma-separated #rrggbb colors to always keep as content, e.g. an intentional matte (empty = none)")
+	flag.BoolVar(&opts.AutoEscalate, "auto-escalate", false, "when detection finds no background or no crop, retry with progressively looser thresholds and tolerances and keep the first plausible crop")
+	flag.BoolVar(&opts.PerSideBackground, "per-side-background", false, "trim each side against its own edge's background (black or white) instead of one mode for the whole image")
+	flag.IntVar(&opts.BgMatchTolerance, "bg-match-tolerance", 0, "only count pixels within this RMS distance (0-255) of the corners' background color as background (0 = off)")
+	flag.Float64Var(&opts.ToleranceTop, "tolerance-top", 0, "fraction of a row that must be background to trim it from the top (0 = -noise-tolerance)")
+	flag.Float64Var(&opts.ToleranceBottom, "tolerance-bottom", 0, "fraction of a row that must be background to trim it from the bottom (0 = -noise-tolerance)")
+	flag.Float64Var(&opts.ToleranceLeft, "tolerance-left", 0, "fraction of a column that must be background to trim it from the left (0 = -noise-tolerance)")
+	flag.Float64Var(&opts.ToleranceRight, "tolerance-right", 0, "fraction of a column that must be background to trim it from the right (0 = -noise-tolerance)")
+	flag.IntVar(&opts.MaxDetectDepth, "max-detect-depth", 0, "trim at most N pixels from each side, stopping each scan there (0 = no limit)")
+	flag.StringVar(&opts.ScanOrder, "scan-order", scanVerticalFirst, "which axis to trim first: vh (top and bottom, then left and right within the rows kept) or hv (left and right, then top and bottom within the columns kept)")
+	flag.IntVar(&opts.MinRun, "min-run", 0, "only trim a side that starts with at least N consecutive removable lines at its edge (0 = off)")
+	flag.IntVar(&opts.IgnoreProtrusions, "ignore-protrusions", 0, "trim rows and columns whose content runs are all shorter than N pixels, ignoring thin lines sticking into the margin (0 = off)")
+	flag.IntVar(&opts.CloseRadius, "close-radius", 0, "bridge background gaps up to twice this radius between content, so dashed or dotted frames count as solid (0 = off)")
+	flag.IntVar(&opts.Despeckle, "despeckle", 0, "ignore isolated content specks up to this radius when detecting borders (0 = off)")
+	flag.BoolVar(&opts.PaletteMatch, "palette-match", false, "trim paletted images by the background's palette index instead of its color")
+	flag.IntVar(&opts.BgIndex, "bg-index", -1, "palette index to trim with -palette-match (-1 = the corners' index)")
+	flag.BoolVar(&opts.SnapBlocks, "snap-blocks", false, "step up to 8px further past faint JPEG block artifacts next to the content")
+	flag.BoolVar(&opts.KeepLargest, "keep-largest", false, "crop to the largest connected content region only, dropping smaller separate marks such as date stamps")
+	flag.BoolVar(&opts.TwoColorBorder, "two-color-border", false, "also peel a second border band of the other color (e.g. a black frame inside a white mat)")
+	flag.BoolVar(&opts.StrictCorners, "strict-corners", false, "only crop when all four corners are background of the detected mode (e.g. all black, or all of an agreeing color); leave other images untouched")
+	flag.IntVar(&opts.MinBorder, "min-border", 0, "only trim a side when its border is at least N pixels thick")
+	flag.Float64Var(&opts.MinWhiteRatio, "min-white-ratio", 0, "only crop images in which at least this fraction of the pixels is near-white, skipping photos (0 = disabled)")
+	flag.Float64Var(&opts.MinContentFraction, "min-content-fraction", 0, "reject crops whose area is below this fraction of the original area (0 = disabled)")
+	flag.StringVar(&opts.Padding, "padding", "", "margin to keep around the content: N, Npx or N% for all sides, or top,right,bottom,left")
+	flag.StringVar(&opts.PaddingColor, "padding-color", paddingColorAuto, "fill -padding that extends past the image edge with this color: auto (the detected background), #rrggbb, or clip to keep padding within the image")
+	flag.BoolVar(&opts.PreserveOriginalAspect, "preserve-original-aspect", false, "trim less on one axis where needed so every output keeps its original aspect ratio")
+	flag.StringVar(&opts.Orient, "orient", "", "rotate outputs 90 degrees clockwise where needed to make them all portrait or all landscape")
+	flag.IntVar(&opts.MaxDim, "max-dim", 0, "scale the cropped image down so its longer side is at most this many pixels (0 = no resize)")
+	flag.StringVar(&opts.ResizeFilter, "resize-filter", "catmullrom", "resize filter: nearest, bilinear or catmullrom")
+	flag.IntVar(&opts.MaxFiles, "max-files", 0, "stop after processing this many images in a directory (0 = no limit)")
+	flag.IntVar(&opts.Workers, "jobs", runtime.NumCPU(), "process this many images of a directory at once; images that may write the same output are still processed in turn")
+	flag.BoolVar(&opts.IncludeHidden, "hidden", false, "process hidden files (names starting with \".\") too")
+	flag.BoolVar(&opts.RequireBorder, "require-border", false, "fail every image with no border to trim, and exit with an error if there were any")
+	flag.BoolVar(&opts.NoOpOnColorImages, "no-op-on-color-images", false, "write nothing for images without a black or white background")
+	flag.BoolVar(&opts.KeepUniform, "keep-uniform", false, "keep solid background-colored images as-is instead of reporting them as empty")
+	review := flag.Bool("review", false, "preview each crop and ask y/n/s before saving (auto-accepts when stdin is not a terminal)")
+	flag.IntVar(&opts.PNGBitDepth, "png-bit-depth", 0, "bits per channel of PNG output: 8 or 16 (0 = same as the source)")
+	flag.StringVar(&opts.JPEGSubsampling, "jpeg-subsampling", "", "chroma subsampling of JPEG output: 420 or 444 (empty = 420)")
+	flag.StringVar(&opts.OutDir, "out", "", "write outputs under this directory, created if missing, instead of next to the originals (empty = next to them)")
+	flag.BoolVar(&opts.NoPrefix, "no-prefix", false, "with -out, name outputs like their originals, without the processed_ prefix")
+	flag.BoolVar(&opts.InPlace, "in-place", false, "replace each image with its output, atomically and keeping its permissions, instead of writing processed_<name>")
+	flag.StringVar(&opts.OutputTemplate, "output-template", "", "name outputs processed_<template>, with {name} and EXIF placeholders such as {exif:DateTimeOriginal|nodate} (empty = processed_<name>)")
+	flag.StringVar(&opts.Format, "format", "", "encode outputs in this format, e.g. jpeg or png (empty = keep each input's format; see -list-formats)")
+	flag.StringVar(&opts.Frames, "frames", "", "crop and write only these frames of animated images, e.g. \"0,2,5\" or \"1-3\", each as name_fN (empty = first frame only)")
+	flag.BoolVar(&opts.UniformCrop, "uniform-crop", false, "with -frames, crop every frame to the union of their content bounds")
+	flag.BoolVar(&opts.Annotate, "annotate", false, "also write processed_<name>_annotated.png: the original with the crop rectangle outlined")
+	flag.StringVar(&opts.AnnotateColor, "annotate-color", "#ff0000", "color of the -annotate outline, as #rrggbb")
+	flag.BoolVar(&opts.DryRun, "dry-run", false, "only report each image's size, the detected crop and the pixels it would trim from each edge; write nothing")
+	flag.BoolVar(&opts.DryRunDiff, "dry-run-diff", false, "don't crop; write processed_<name>_diff.png with the margins that would be removed tinted red")
+	flag.BoolVar(&opts.ExtractFrame, "extract-frame", false, "write the top, bottom, left and right border strips as separate files instead of the cropped content")
+	flag.BoolVar(&opts.NoCrop, "no-crop", false, "don't crop at all, only re-encode (with -format, -max-dim, -orient and so on)")
+	flag.BoolVar(&opts.Verify, "verify", false, "decode each output after saving and check its size, writing it again once if that fails")
+	flag.BoolVar(&opts.PreserveExactBytes, "preserve-exact-bytes", false, "copy the original file byte for byte when no crop or other change is needed")
+	flag.BoolVar(&opts.NoReencodeJPEG, "no-reencode-jpeg", false, "crop JPEG to JPEG losslessly on 8x8 DCT blocks when the crop starts on the MCU grid, re-encoding (lossy) otherwise")
+	flag.BoolVar(&opts.SnapMCU, "snap-mcu", false, "with -no-reencode-jpeg, widen crops outward to the MCU grid so they are always lossless")
+	flag.BoolVar(&opts.OnlyIfSmaller, "only-if-smaller", false, "copy the original instead when the cropped output wouldn't be smaller than it (not with a -format change)")
+	flag.BoolVar(&opts.Copy, "copy", false, "always copy the cropped pixels instead of sharing the source image's buffer")
+	flag.StringVar(&opts.Dedupe, "dedupe", "", "after processing a directory, replace outputs identical to an earlier one with hard links (link) or delete them (delete)")
+	flag.StringVar(&opts.ContactSheet, "contact-sheet", "", "after processing a directory, write a PNG grid of all its cropped outputs to this path")
+	flag.IntVar(&opts.Columns, "columns", 4, "number of thumbnails per row on the -contact-sheet")
+	flag.IntVar(&opts.ThumbSize, "thumb-size", 200, "longest side in pixels of each -contact-sheet thumbnail")
+	flag.BoolVar(&opts.EmbedProvenance, "embed-provenance", false, "embed a \""+provenanceKey+"\" tEXt chunk with the original size and crop rectangle in PNG outputs")
+	flag.BoolVar(&opts.ChecksumSkip, "checksum-skip", false, "skip files already processed with the same inputs and settings (cached in "+cacheFilename+")")
+	flag.StringVar(&opts.MetadataOptions, "metadata-options", "", "read per-image options (sidecar JSON) embedded in each image's PNG text chunk with this keyword, or its EXIF ImageDescription after \"<keyword>=\"")
+	flag.StringVar(&opts.FailuresDir, "failures-dir", "", "copy every file that fails or times out into this directory for later review")
+	flag.BoolVar(&opts.MoveBad, "move-bad", false, "move corrupt or truncated images into a \"quarantine\" subdirectory")
+	modifiedSince := flag.String("modified-since", "", "only process files modified at or after this time (RFC3339 or @unix)")
+	flag.BoolVar(&opts.Incremental, "incremental", false, "only process files modified since the last complete run over the directory (tracked in "+lastRunFilename+")")
+	flag.DurationVar(&opts.ImageTimeout, "image-timeout", 0, "skip an image whose border detection takes longer than this, e.g. 30s (0 = no limit)")
+	maxMemory := flag.String("max-memory", "", "soft limit on the decoded size of images held at once, e.g. 2GB (empty = no limit)")
+	flag.StringVar(&opts.TrimReport, "trim-report", "", "write a CSV row for every file attempted to this path")
+	flag.BoolVar(&opts.TruncateReport, "truncate-report", false, "truncate the -trim-report file instead of appending to it")
+	colorReport := flag.Bool("border-color-report", false, "only survey the dominant background colors of the images and print a histogram (nothing is cropped)")
+	flag.StringVar(&opts.JSONReport, "json-report", "", "write a JSON description of every file attempted to this path")
+	flag.BoolVar(&opts.DebugTrace, "debug-trace", false, "log every row and column decision of the border scan (single image or -trace-file only)")
+	flag.StringVar(&opts.TraceFile, "trace-file", "", "with -debug-trace, only trace the file with this name")
+	flag.BoolVar(&opts.ProgressiveScan, "progressive-scan", false, "add the minimum-area rotated rectangle around the content to the -json-report")
+	stdinList := flag.Bool("stdin-list", false, "read image paths from stdin, one per line, and print \"path<TAB>status<TAB>bounds\" for each")
+	formatList := flag.Bool("list-formats", false, "print the supported image formats and exit")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: go run main.go [flags] <directory_path|image_path|archive.tar[.gz]>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *formatList {
+		listFormats(os.Stdout)
+		return
+	}
+
+	if flag.NArg() < 1 && !*stdinList {
+		flag.Usage()
+		return
+	}
+
+	if opts.Workers < 1 {
+		fmt.Println("Error: -jobs must be at least 1")
+		os.Exit(2)
+	}
+
+	// The per-channel levels default to the all-channel thresholds.
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for i, channel := range []string{"r", "g", "b"} {
+		if !set["black-"+channel] {
+			blackLevels[i] = *blackAll
+		}
+		if !set["white-"+channel] {
+			whiteLevels[i] = *whiteAll
+		}
+	}
+	lv, err := parseLevels(*blackAll, *whiteAll, blackLevels, whiteLevels)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(2)
+	}
+	opts.Levels = &lv
+
+	if err := validateOptions(opts); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(2)
+	}
+
+	if opts.Reference != "" {
+		var err error
+		if opts, err = withReference(opts); err != nil {
+			fmt.Printf("Error: -reference: %v\n", err)
+			os.Exit(2)
+		}
+	}
+	if opts.BgIndex >= 0 && !opts.PaletteMatch {
+		fmt.Println("Error: -bg-index needs -palette-match")
+		os.Exit(2)
+	}
+
+	if opts.NoPrefix && opts.OutDir == "" {
+		fmt.Println("Error: -no-prefix needs -out, or the outputs would overwrite the originals")
+		os.Exit(2)
+	}
+	if opts.InPlace {
+		for _, f := range []struct {
+			name string
+			set  bool
+		}{
+			{"-out", opts.OutDir != ""},
+			{"-no-prefix", opts.NoPrefix},
+			{"-output-template", opts.OutputTemplate != ""},
+			{"-frames", opts.Frames != ""},
+			{"-extract-frame", opts.ExtractFrame},
+			// The "duplicate" outputs are the originals.
+			{"-dedupe", opts.Dedupe != ""},
+		} {
+			if f.set {
+				fmt.Printf("Error: -in-place can't be combined with %s\n", f.name)
+				os.Exit(2)
+			}
+		}
+	}
+
+	if opts.UniformCrop && opts.Frames == "" {
+		fmt.Println("Error: -uniform-crop needs -frames")
+		os.Exit(2)
+	}
+
+	if opts.SnapMCU && !opts.NoReencodeJPEG {
+		fmt.Println("Error: -snap-mcu needs -no-reencode-jpeg")
+		os.Exit(2)
+	}
+
+	if opts.ContactSheet != "" && (opts.Columns < 1 || opts.ThumbSize < 1) {
+		fmt.Println("Error: -columns and -thumb-size must be at least 1")
+		os.Exit(2)
+	}
+
+	if *modifiedSince != "" {
+		t, err := parseTimestamp(*modifiedSince)
+		if err != nil {
+			fmt.Printf("Error: -modified-since: %v\n", err)
+			os.Exit(2)
+		}
+		opts.ModifiedSince = t
+	}
+
+	if *maxMemory != "" {
+		n, err := parseByteSize(*maxMemory)
+		if err != nil {
+			fmt.Printf("Error: -max-memory: %v\n", err)
+			os.Exit(2)
+		}
+		opts.MaxMemory = n
+	}
+
+	if _, ok := resizeFilters[opts.ResizeFilter]; !ok {
+		fmt.Printf("Error: unknown -resize-filter %q\n", opts.ResizeFilter)
+		os.Exit(2)
+	}
+
+	// The trace is far too verbose for a whole batch.
+	if opts.DebugTrace && opts.TraceFile == "" {
+		if info, err := os.Stat(flag.Arg(0)); *stdinList || (err == nil && info.IsDir()) {
+			fmt.Println("Error: -debug-trace needs a single image argument or -trace-file")
+			os.Exit(2)
+		}
+	}
+
+	if *review {
+		if isTerminal(os.Stdin) {
+			opts.Reviewer = newReviewer(os.Stdin, os.Stdout)
+		} else {
+			fmt.Println("Warning: -review needs an interactive terminal, accepting all crops")
+		}
+	}
+
+	if *stdinList {
+		// Keep stdout for the status lines.
+		logOutput = os.Stderr
+		if err := processList(os.Stdin, os.Stdout, opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *colorReport {
+		tally, err := surveyBorderColors(flag.Arg(0), opts)
+		if err != nil {
+			fmt.Printf("Error surveying %s: %v\n", flag.Arg(0), err)
+			os.Exit(1)
+		}
+		writeColorHistogram(os.Stdout, tally)
+		return
+	}
+
+	err = processPath(flag.Arg(0), opts)
+	if err != nil {
+		fmt.Printf("Error processing %s: %v\n", flag.Arg(0), err)
+		os.Exit(1)
+	}
+
+	fmt.Println("Processing complete.")
+}
+
+// validateOptions checks the option values that don't depend on which
+// flags were given, for main and for the per-image options of mergeOptions.
+func validateOptions(opts options) error {
+	if opts.MaxAspectChange != 0 && opts.MaxAspectChange < 1 {
+		return errors.New("-max-aspect-change must be 0 or at least 1")
+	}
+	if opts.VignetteTolerance < 0 || opts.VignetteTolerance > 255 {
+		return errors.New("-vignette-tolerance must be between 0 and 255")
+	}
+	for _, side := range []struct {
+		flag  string
+		value float64
+	}{
+		{"noise-tolerance", opts.NoiseTolerance},
+		{"tolerance-top", opts.ToleranceTop},
+		{"tolerance-bottom", opts.ToleranceBottom},
+		{"tolerance-left", opts.ToleranceLeft},
+		{"tolerance-right", opts.ToleranceRight},
+	} {
+		if side.value < 0 || side.value > 1 {
+			return fmt.Errorf("-%s must be between 0 and 1", side.flag)
+		}
+	}
+	lv := opts.levels()
+	for i, channel := range []string{"r", "g", "b"} {
+		if lv.Black[i] >= lv.White[i] {
+			return fmt.Errorf("-black-%s must be below -white-%s", channel, channel)
+		}
+	}
+	if _, err := parseFuzz(opts.Fuzz); err != nil {
+		return fmt.Errorf("-fuzz: %w", err)
+	}
+	if opts.HueTolerance < 0 || opts.HueTolerance > 180 {
+		return errors.New("-hue-tolerance must be between 0 and 180")
+	}
+	if _, err := parseModes(opts.Mode); err != nil {
+		return fmt.Errorf("-mode: %w", err)
+	}
+	if opts.GradientBg < 0 || opts.GradientBg > 255 {
+		return errors.New("-gradient-bg must be between 0 and 255")
+	}
+	if opts.DiffTolerance < 0 || opts.DiffTolerance > 255 {
+		return errors.New("-diff-tolerance must be between 0 and 255")
+	}
+	if opts.ScanOrder != "" && opts.ScanOrder != scanVerticalFirst && opts.ScanOrder != scanHorizontalFirst {
+		return fmt.Errorf("-scan-order must be %q or %q", scanVerticalFirst, scanHorizontalFirst)
+	}
+	if opts.EdgeSampleRate < 0 {
+		return errors.New("-edge-sample-rate must not be negative")
+	}
+	if opts.CornerAgreementTolerance < 0 || opts.CornerAgreementTolerance > 255 {
+		return errors.New("-corner-agreement-tolerance must be between 0 and 255")
+	}
+	if opts.BgIndex < -1 || opts.BgIndex > 255 {
+		return errors.New("-bg-index must be between 0 and 255, or -1")
+	}
+	if opts.ImageTimeout < 0 {
+		return errors.New("-image-timeout must not be negative")
+	}
+	if _, err := parsePreserveColors(opts.PreserveColor); err != nil {
+		return fmt.Errorf("-preserve-color: %w", err)
+	}
+	if opts.BgMatchTolerance < 0 || opts.BgMatchTolerance > 255 {
+		return errors.New("-bg-match-tolerance must be between 0 and 255")
+	}
+	if opts.AlphaThreshold < 0 || opts.AlphaThreshold > 255 {
+		return errors.New("-alpha-threshold must be between 0 and 255")
+	}
+	if opts.QuantizeAlpha < 0 || opts.QuantizeAlpha > 255 {
+		return errors.New("-quantize-alpha must be between 0 and 255")
+	}
+	if opts.MinContentFraction < 0 || opts.MinContentFraction > 1 {
+		return errors.New("-min-content-fraction must be between 0 and 1")
+	}
+	if opts.MinWhiteRatio < 0 || opts.MinWhiteRatio > 1 {
+		return errors.New("-min-white-ratio must be between 0 and 1")
+	}
+	if opts.PNGBitDepth != 0 && opts.PNGBitDepth != 8 && opts.PNGBitDepth != 16 {
+		return errors.New("-png-bit-depth must be 8 or 16")
+	}
+	if s := opts.JPEGSubsampling; s != "" && !jpegSubsamplings[s] {
+		return fmt.Errorf("unknown -jpeg-subsampling %q: want 420 or 444", s)
+	}
+	if f, ok := formats[opts.Format]; opts.Format != "" && (!ok || !f.Encode) {
+		return fmt.Errorf("-format %q can't be written; see -list-formats", opts.Format)
+	}
+	if err := checkOutputTemplate(opts.OutputTemplate); err != nil {
+		return fmt.Errorf("-output-template: %w", err)
+	}
+	if opts.Frames != "" {
+		if _, err := parseFrames(opts.Frames); err != nil {
+			return fmt.Errorf("-frames: %w", err)
+		}
+	}
+	if opts.Orient != "" && opts.Orient != orientPortrait && opts.Orient != orientLandscape {
+		return fmt.Errorf("-orient must be %q or %q", orientPortrait, orientLandscape)
+	}
+	if opts.Dedupe != "" && opts.Dedupe != dedupeLink && opts.Dedupe != dedupeDelete {
+		return fmt.Errorf("-dedupe must be %q or %q", dedupeLink, dedupeDelete)
+	}
+	if _, err := parsePadding(opts.Padding); err != nil {
+		return err
+	}
+	if _, ok := parseHexColor(opts.AnnotateColor); opts.Annotate && !ok {
+		return fmt.Errorf("-annotate-color must be #rrggbb, got %q", opts.AnnotateColor)
+	}
+	if _, err := parsePaddingColor(opts.PaddingColor); err != nil {
+		return err
+	}
+	return nil
+}
+
+// processPath processes path, which may be either a directory of images or
+// a single image file.
+func processPath(path string, opts options) (err error) {
+	if opts.MaxMemory > 0 && opts.memory == nil {
+		opts.memory = newMemoryBudget(opts.MaxMemory)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		fmt.Fprintf(logOutput, "Processing images in: %s\n", path)
+		return processDirectory(path, opts)
+	}
+	if isTar, _ := tarKind(path); isTar {
+		if opts.InPlace {
+			return errors.New("-in-place doesn't support archives")
+		}
+		fmt.Fprintf(logOutput, "Processing images in archive: %s\n", path)
+		return processTar(path, opts)
+	}
+
+	rep, err := openReports(opts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := rep.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	filename := filepath.Base(path)
+	fmt.Fprintf(logOutput, "Processing: %s\n", filename)
+	res, err := processImage(path, filepath.Dir(path), filename, opts)
+	if opts.FailuresDir != "" && !opts.DryRun && isCollectedFailure(res, err) {
+		if cerr := collectFailure(opts.FailuresDir, path); cerr != nil {
+			return cerr
+		}
+	}
+	if err != nil {
+		res.Status = "failed: " + err.Error()
+		if rerr := rep.Add(res); rerr != nil {
+			return rerr
+		}
+		return err
+	}
+	if res.Output != "" {
+		fmt.Fprintf(logOutput, "  Saved %s\n", res.Output)
+	}
+	return rep.Add(res)
+}
+
+func processDirectory(dirPath string, opts options) (err error) {
+	if opts.MaxMemory > 0 && opts.memory == nil {
+		opts.memory = newMemoryBudget(opts.MaxMemory)
+	}
+
+	dir, err := os.Open(dirPath)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	rep, err := openReports(opts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := rep.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	// Files from before the last complete run were handled by it. The
+	// marker is updated only if this run finishes.
+	start := time.Now()
+	if opts.Incremental {
+		lastRun, err := readLastRun(dirPath)
+		if err != nil {
+			return err
+		}
+		if lastRun.After(opts.ModifiedSince) {
+			opts.ModifiedSince = lastRun
+		}
+	}
+
+	var cache *processCache
+	var settings string
+	if opts.ChecksumSkip {
+		cache, err = loadCache(dirPath)
+		if err != nil {
+			return err
+		}
+		settings, err = settingsKey(opts)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if opts.DryRun {
+				return
+			}
+			if cerr := cache.Save(); err == nil {
+				err = cerr
+			}
+		}()
+	}
+
+	// Up to workers images are processed at once. Each image's messages are
+	// buffered, and its messages and report entry are passed to finish,
+	// which writes them in directory order so the log reads as if the images
+	// were processed one by one.
+	workers := max(opts.Workers, 1)
+	// Review prompts are answered one image at a time.
+	if opts.Reviewer != nil {
+		workers = 1
+	}
+	var (
+		mu       sync.Mutex // guards everything below and the cache
+		wg       sync.WaitGroup
+		sem      = make(chan struct{}, workers)
+		pending  = map[int]func() error{}
+		next     int
+		entries  int
+		fatal    error
+		failures []string
+		// lastOf holds, per outputKey, the done channel of the latest
+		// image dispatched with that key, which the next one waits for.
+		lastOf = map[string]chan struct{}{}
+	)
+	finish := func(i int, step func() error) {
+		mu.Lock()
+		defer mu.Unlock()
+		pending[i] = step
+		for step := pending[next]; step != nil; step = pending[next] {
+			delete(pending, next)
+			next++
+			if fatal == nil {
+				fatal = step()
+			}
+		}
+	}
+	// entry returns the position of the next entry in directory order.
+	entry := func() int {
+		entries++
+		return entries - 1
+	}
+
+	processed := 0
+	stopped := false
+	noBorder, collected, undecodable := 0, 0, 0
+	var outputs []string
+	err = forEachDirEntry(dir, readDirChunk, func(file fs.DirEntry) error {
+		filename := file.Name()
+		fullPath := filepath.Join(dirPath, filename)
+
+		skip := func(reason string) error {
+			finish(entry(), func() error {
+				if opts.DryRun {
+					fmt.Fprintf(logOutput, "Skipping %s: %s\n", filename, reason)
+				}
+				return rep.Add(fileResult{Filename: filename, Status: "skipped: " + reason})
+			})
+			return nil
+		}
+
+		if file.IsDir() {
+			finish(entry(), func() error {
+				return rep.Add(fileResult{Filename: filename, Status: "skipped: directory"})
+			})
+			return nil
+		}
+
+		if reason := skipReason(fullPath, opts); reason != "" {
+			return skip(reason)
+		}
+
+		var info fs.FileInfo
+		var fileSettings string
+		if cache != nil {
+			var err error
+			info, err = file.Info()
+			if err != nil {
+				return err
+			}
+			// A sidecar changes the settings for its image only.
+			fileSettings = settings
+			if fopts, err := applySidecar(fullPath, opts); err == nil {
+				if fileSettings, err = settingsKey(fopts); err != nil {
+					return err
+				}
+			}
+			mu.Lock()
+			fresh := cache.Fresh(filename, info, fileSettings)
+			mu.Unlock()
+			if fresh {
+				return skip("unchanged since last run")
+			}
+		}
+
+		if opts.MaxFiles > 0 && processed >= opts.MaxFiles {
+			finish(entry(), func() error {
+				fmt.Fprintf(logOutput, "Reached -max-files %d, stopping\n", opts.MaxFiles)
+				return nil
+			})
+			stopped = true
+			return fs.SkipAll
+		}
+		processed++
+
+		sem <- struct{}{}
+		mu.Lock()
+		err := fatal
+		mu.Unlock()
+		if err != nil {
+			<-sem
+			return err
+		}
+		i := entry()
+		key := outputKey(filename, opts)
+		prev, done := lastOf[key], make(chan struct{})
+		lastOf[key] = done
+		wg.Add(1)
+		go func() {
+			defer func() {
+				close(done)
+				<-sem
+				wg.Done()
+			}()
+			// An earlier image that may write the same output goes first,
+			// as it would in a sequential run.
+			if prev != nil {
+				<-prev
+			}
+
+			var buf bytes.Buffer
+			fileOpts := opts
+			fileOpts.log = &buf
+			fmt.Fprintf(&buf, "Processing: %s\n", filename)
+			res, err := processImage(fullPath, dirPath, filename, fileOpts)
+
+			finish(i, func() error {
+				logOutput.Write(buf.Bytes())
+				if opts.FailuresDir != "" && !opts.DryRun && isCollectedFailure(res, err) {
+					if cerr := collectFailure(opts.FailuresDir, fullPath); cerr != nil {
+						return cerr
+					}
+					collected++
+				}
+				if err != nil {
+					fmt.Fprintf(logOutput, "  Failed to process %s: %v\n", filename, err)
+					res.Status = "failed: " + err.Error()
+					failures = append(failures, fmt.Sprintf("%s: %v", filename, err))
+					if errors.Is(err, ErrNoBorder) {
+						noBorder++
+					}
+					if errors.Is(err, ErrDecode) {
+						undecodable++
+					}
+				} else {
+					if res.Output != "" {
+						fmt.Fprintf(logOutput, "  Saved %s\n", res.Output)
+						outputs = append(outputs, res.Output)
+					}
+					if cache != nil && !opts.DryRun {
+						// The image itself changed with -in-place.
+						if opts.InPlace && res.Output != "" {
+							if info, err = os.Stat(fullPath); err != nil {
+								return err
+							}
+						}
+						cache.Mark(filename, info, fileSettings)
+					}
+				}
+				return rep.Add(res)
+			})
+		}()
+		return nil
+	})
+	wg.Wait()
+	if err == nil {
+		err = fatal
+	}
+	if err != nil {
+		return err
+	}
+	// Failures don't stop the run, so list them again where they are seen.
+	if len(failures) > 0 {
+		fmt.Fprintf(logOutput, "%d file(s) failed:\n", len(failures))
+		for _, f := range failures {
+			fmt.Fprintf(logOutput, "  %s\n", f)
+		}
+	}
+	failuresSummary(collected, opts.FailuresDir)
+
+	// Build the sheet before deduplication can delete any outputs.
+	if opts.ContactSheet != "" && len(outputs) > 0 {
+		if err := writeContactSheet(opts.ContactSheet, outputDir(dirPath, opts), outputs, opts.Columns, opts.ThumbSize, opts.ResizeFilter); err != nil {
+			return err
+		}
+		fmt.Fprintf(logOutput, "Wrote contact sheet %s\n", opts.ContactSheet)
+	}
+
+	if opts.Dedupe != "" {
+		if err := dedupeOutputs(outputDir(dirPath, opts), outputs, opts.Dedupe); err != nil {
+			return err
+		}
+	}
+
+	// A run cut short by -max-files left older files unprocessed, and one
+	// with failures (including -require-border) left them to be retried,
+	// so neither may move the marker past them.
+	if opts.Incremental && !stopped && len(failures) == 0 && !opts.DryRun {
+		// -in-place gave every image it replaced a newer modification
+		// time, so only mark the run once the replacements are done, or
+		// the next run would crop them again.
+		if opts.InPlace {
+			start = time.Now()
+		}
+		if err := writeLastRun(dirPath, start); err != nil {
+			return err
+		}
+	}
+	if opts.DryRun {
+		if err := decodeFailureError(undecodable); err != nil {
+			return err
+		}
+	}
+	return noBorderError(noBorder)
+}
+
+// outputKey groups the images of a directory that may be written to the same
+// output file: those with the same name but for the extension, which -format
+// or -frames can give the same output name, and every image under
+// -output-template, whose names come from the metadata.
+func outputKey(filename string, opts options) string {
+	if opts.OutputTemplate != "" {
+		return ""
+	}
+	return strings.TrimSuffix(filename, filepath.Ext(filename))
+}
+
+// noBorderError is the error a run returns when -require-border failed n
+// of its images, or nil if n is zero.
+func noBorderError(n int) error {
+	if n == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w in %d image(s)", ErrNoBorder, n)
+}
+
+// skipReason returns why the file at path should not be processed, or an
+// empty string if it should.
+func skipReason(path string, opts options) string {
+	filename := filepath.Base(path)
+
+	// Skip hidden files unless asked to include them
+	if !opts.IncludeHidden && strings.HasPrefix(filename, ".") {
+		return "hidden file"
+	}
+
+	// Skip already processed files to avoid infinite loops or double processing
+	if strings.HasPrefix(filename, outputPrefix) {
+		return "already processed"
+	}
+
+	// Per-image options are read alongside their image, not processed
+	if strings.HasSuffix(filename, sidecarSuffix) {
+		return "sidecar options file"
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "unreadable: " + err.Error()
+	}
+
+	// Opening a named pipe or device to sniff it could block or have side
+	// effects, so only regular files are looked at
+	if info.IsDir() {
+		return "directory"
+	}
+	if !info.Mode().IsRegular() {
+		return "non-regular file"
+	}
+	if info.Size() == 0 {
+		return "empty file"
+	}
+
+	// Skip files older than -modified-since before the expensive decode
+	if !opts.ModifiedSince.IsZero() && info.ModTime().Before(opts.ModifiedSince) {
+		return "not modified since " + opts.ModifiedSince.Format(time.RFC3339)
+	}
+
+	// Check if file is a supported image based on content (MIME type)
+	if !isSupportedImage(path) {
+		return "unsupported format"
+	}
+	return ""
+}
+
+// parseTimestamp parses an RFC3339 time or a Unix timestamp written as
+// "@<seconds>".
+func parseTimestamp(s string) (time.Time, error) {
+	if secs, ok := strings.CutPrefix(s, "@"); ok {
+		n, err := strconv.ParseInt(secs, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid unix timestamp %q", s)
+		}
+		return time.Unix(n, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q: want RFC3339 or @unix", s)
+	}
+	return t, nil
+}
+
+func isSupportedImage(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	// Only read the first 512 bytes to determine the content type
+	buffer := make([]byte, 512)
+	_, err = file.Read(buffer)
+	if err != nil {
+		return false
+	}
+
+	mime := detectContentType(buffer)
+	if _, ok := formatByMIME(mime); ok {
+		return true
+	}
+
+	// Sniffing only knows a few magic numbers and gives up with
+	// application/octet-stream on anything unusual. Trust a known image
+	// extension if a decoder accepts the file's header; decoding the
+	// pixels is left to processImage.
+	if mime != "application/octet-stream" {
+		return false
+	}
+	if _, ok := formatByExtension(filepath.Ext(path)); !ok {
+		return false
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return false
+	}
+	cfg, _, err := image.DecodeConfig(file)
+	return err == nil && cfg.Width > 0 && cfg.Height > 0
+}
+
+// detectContentType sniffs the MIME type of a file from its first bytes. It
+// is a variable so tests can simulate inconclusive sniffing.
+var detectContentType = http.DetectContentType
+
+// fileResult records what happened to a single file, for reporting.
+type fileResult struct {
+	Filename string
+	// Size is the size of the original image.
+	Size image.Point
+	// Bounds is the crop rectangle in the original image's coordinates.
+	Bounds image.Rectangle
+	Mode   backgroundMode
+	// ModeReason is how Mode was decided; see detectModeReason.
+	ModeReason string
+	Status     string
+	// Output is the name of the file written, or empty if none was.
+	Output string
+	// Centroid is the mean position of the content pixels in the original
+	// image's coordinates. It is only computed for the JSON report.
+	Centroid *centroid
+	// RotatedRect is the minimum-area rotated rectangle around the content,
+	// computed only with -progressive-scan.
+	RotatedRect *rotatedRect
+	// FillRatio is the fraction of Bounds that is content rather than
+	// background, computed only for the reports when an output is written.
+	// A low ratio hints at a loose crop, e.g. a nested border.
+	FillRatio *float64
+	// background classifies the original image's pixels as planCrop
+	// detected them, with the levels of the mode it chose; nil if it
+	// detected no background. See isBackground.
+	background func(x, y int) bool
+	// content is the detection's count of content pixels; see
+	// detection.Content.
+	content int
+}
+
+// isBackground reports whether the pixel at (x, y) of the original image
+// is background as planCrop detected it.
+func (r fileResult) isBackground(x, y int) bool {
+	return r.background != nil && r.background(x, y)
+}
+
+// Offset returns the position of the crop within the original image.
+// Subtracting it from a point in the original image's coordinate space maps
+// that point into the cropped image.
+func (r fileResult) Offset() image.Point {
+	return r.Bounds.Min
+}
+
+func processImage(filePath, dirPath, filename string, opts options) (res fileResult, err error) {
+	res = fileResult{Filename: filename}
+
+	// Options embedded by the image's producer apply first, so that a
+	// sidecar next to it can still override them.
+	if opts.MetadataOptions != "" {
+		if opts, err = applyMetadataOptions(filePath, opts); err != nil {
+			return res, err
+		}
+	}
+	opts, err = applySidecar(filePath, opts)
+	if err != nil {
+		return res, err
+	}
+	if opts.DebugTrace && (opts.TraceFile == "" || opts.TraceFile == filename) {
+		opts.trace = opts.logWriter()
+	}
+
+	// Hold the image's estimated decoded size against the memory budget
+	// until it has been written. Files whose header can't be read fail in
+	// loadImage below.
+	if opts.memory != nil {
+		if cost, err := estimateMemory(filePath); err == nil {
+			opts.memory.Acquire(cost)
+			defer opts.memory.Release(cost)
+		}
+	}
+
+	if opts.Frames != "" && !opts.DryRun {
+		return processFrames(filePath, outputDir(dirPath, opts), filename, opts)
+	}
+
+	img, format, err := loadImage(filePath)
+	if err != nil {
+		if opts.MoveBad && !opts.DryRun && errors.Is(err, ErrDecode) {
+			if qerr := quarantine(filePath, dirPath); qerr != nil {
+				return res, fmt.Errorf("%w (quarantine failed: %v)", err, qerr)
+			}
+			opts.logf("  Moved %s to %s\n", filename, quarantineDir)
+		}
+		return res, err
+	}
+
+	res, err = planCrop(img, opts)
+	res.Filename = filename
+	if err != nil || strings.HasPrefix(res.Status, "skipped:") {
+		if opts.DryRun && err == nil {
+			opts.logf("  Would skip: %s\n", strings.TrimPrefix(res.Status, "skipped: "))
+		}
+		return res, err
+	}
+	bounds := res.Bounds
+
+	if opts.Reviewer != nil && !opts.NoCrop && !opts.DryRun {
+		decision, err := opts.Reviewer.Review(filename, img, bounds)
+		if err != nil {
+			return res, err
+		}
+		switch decision {
+		case reviewReject:
+			res.Status = "kept original: rejected in review"
+			bounds = img.Bounds()
+			res.Bounds = bounds
+		case reviewSkip:
+			res.Status = "skipped: skipped in review"
+			return res, nil
+		}
+	}
+
+	// The pixels of bounds outside the detected content were trimmed as
+	// background.
+	if opts.TrimReport != "" || opts.JSONReport != "" {
+		fill := 0.0
+		if !bounds.Empty() {
+			fill = float64(res.content) / float64(bounds.Dx()*bounds.Dy())
+		}
+		res.FillRatio = &fill
+	}
+
+	if opts.DryRun {
+		opts.logf("  %s\n", describeDryRun(img.Bounds(), res))
+		return res, nil
+	}
+
+	inputFormat := format
+	outFilename := opts.prefix() + filename
+	if opts.OutputTemplate != "" {
+		name, err := expandOutputTemplate(opts.OutputTemplate, filename, sourceEXIF(filePath, inputFormat))
+		if err != nil {
+			return res, fmt.Errorf("-output-template: %w", err)
+		}
+		outFilename = opts.prefix() + name + filepath.Ext(filename)
+	}
+	if opts.Format != "" && opts.Format != format {
+		format = opts.Format
+		outFilename = strings.TrimSuffix(outFilename, filepath.Ext(outFilename))
+	}
+	// Append extension if missing (e.g. for extensionless screenshots)
+	if filepath.Ext(outFilename) == "" {
+		outFilename += formats[format].Extension
+	}
+	outDir := outputDir(dirPath, opts)
+	if opts.OutDir != "" {
+		if err := os.MkdirAll(outDir, 0o755); err != nil {
+			return res, err
+		}
+	}
+	outPath := filepath.Join(outDir, outFilename)
+	if !opts.InPlace && sameFile(outPath, filePath) {
+		return res, fmt.Errorf("output %s would overwrite the original", outPath)
+	}
+
+	if opts.Annotate {
+		name, err := writeAnnotated(img, bounds, outPath, opts.AnnotateColor)
+		if err != nil {
+			return res, err
+		}
+		opts.logf("  Saved %s\n", name)
+	}
+
+	if opts.DryRunDiff {
+		name, err := writeDiff(img, bounds, outPath)
+		if err != nil {
+			return res, err
+		}
+		opts.logf("  Saved %s\n", name)
+		return res, nil
+	}
+
+	if opts.ExtractFrame {
+		names, err := writeFrameStrips(img, bounds, outPath, format, saveOptions{JPEGSubsampling: opts.JPEGSubsampling, PNGBitDepth: opts.PNGBitDepth})
+		for _, name := range names {
+			opts.logf("  Saved %s\n", name)
+		}
+		return res, err
+	}
+
+	// With -in-place, the output is written next to the original under a
+	// temporary name and only replaces it once written and decoded.
+	if opts.InPlace {
+		if format != inputFormat {
+			return res, fmt.Errorf("-in-place can't change the format from %s to %s", inputFormat, format)
+		}
+		var tmp string
+		if tmp, err = reserveTemp(filePath); err != nil {
+			return res, err
+		}
+		defer func() {
+			if err == nil && res.Output != "" {
+				err = replaceOriginal(tmp, filePath)
+			}
+			if err != nil {
+				os.Remove(tmp)
+			}
+		}()
+		outFilename, outPath = filename, tmp
+	}
+
+	// Re-encoding an unchanged image can still change its bytes, so copy
+	// the original when nothing would be transformed.
+	if opts.PreserveExactBytes && isPassthrough(img.Bounds(), bounds, format, opts) {
+		write := func() error { return copyFile(outPath, filePath) }
+		if err := writeVerified(outPath, img.Bounds().Size(), opts, write); err != nil {
+			return res, err
+		}
+		res.Output = outFilename
+		return res, nil
+	}
+
+	if opts.NoReencodeJPEG && inputFormat == "jpeg" && format == "jpeg" {
+		kept, ok, err := writeLosslessJPEG(filePath, outPath, img.Bounds(), bounds, opts)
+		if err != nil {
+			return res, err
+		}
+		if ok {
+			res.Bounds = kept
+			res.Output = outFilename
+			return res, nil
+		}
+	}
+
+	croppedImg, err := renderCrop(img, res, opts)
+	if err != nil {
+		return res, err
+	}
+
+	so := saveOptionsFor(res, opts)
+	if resolution, ok := sourceResolution(filePath, inputFormat); ok {
+		if needsRotation(bounds.Size(), opts.Orient) {
+			resolution = resolution.swapped()
+		}
+		if format == "tiff" {
+			so.Resolution = &resolution
+		} else if !resolution.square() {
+			opts.logf("  Warning: %s has non-square pixels (%v), which %s output can't record\n", filename, resolution, format)
+		}
+	}
+	write := func() error { return saveImage(outPath, croppedImg, format, so) }
+
+	// With -only-if-smaller, a crop whose encoding isn't smaller than the
+	// original file (a re-encode at a higher quality, say) isn't worth
+	// it, so the original is copied instead.
+	if opts.OnlyIfSmaller && format == inputFormat {
+		var buf bytes.Buffer
+		if err := encodeImage(&buf, croppedImg, format, so); err != nil {
+			return res, err
+		}
+		info, err := os.Stat(filePath)
+		if err != nil {
+			return res, err
+		}
+		if int64(buf.Len()) >= info.Size() {
+			res.Status = fmt.Sprintf("kept original: output %d bytes, not smaller than %d", buf.Len(), info.Size())
+			res.Bounds = img.Bounds()
+			write := func() error { return copyFile(outPath, filePath) }
+			if err := writeVerified(outPath, img.Bounds().Size(), opts, write); err != nil {
+				return res, err
+			}
+			res.Output = outFilename
+			return res, nil
+		}
+		write = func() error {
+			return writeFileAtomic(outPath, func(w io.Writer) error {
+				_, err := w.Write(buf.Bytes())
+				return err
+			})
+		}
+	}
+
+	if err := writeVerified(outPath, croppedImg.Bounds().Size(), opts, write); err != nil {
+		return res, err
+	}
+	res.Output = outFilename
+	return res, nil
+}
+
+// planCrop detects img's border and applies the crop guards and padding
+// from opts. The returned result's Bounds is the rectangle to keep; a Status
+// starting with "skipped:" means the image should be left alone. Size and
+// Mode are filled in even when an error is returned.
+func planCrop(img image.Image, opts options) (fileResult, error) {
+	var res fileResult
+	res.Size = img.Bounds().Size()
+
+	if opts.NoCrop {
+		res.Bounds = img.Bounds()
+		res.Status = "unchanged: no crop"
+		return res, nil
+	}
+
+	if _, err := parseModes(opts.Mode); err != nil {
+		return res, err
+	}
+	if _, err := parseFuzz(opts.Fuzz); err != nil {
+		return res, err
+	}
+	if _, err := parsePreserveColors(opts.PreserveColor); err != nil {
+		return res, err
+	}
+	opts, err := withReference(opts)
+	if err != nil {
+		return res, err
+	}
+	if ref := opts.reference; ref != nil && ref.img.Bounds().Size() != res.Size {
+		return res, fmt.Errorf("reference %s is %v, image is %v", ref.path, ref.img.Bounds().Size(), res.Size)
+	}
+
+	if opts.MinWhiteRatio > 0 {
+		if ratio := whiteRatio(img, opts.levels()); ratio < opts.MinWhiteRatio {
+			opts.logf("  Only %.2f%% of the image is white (minimum %.2f%%), not a document, leaving untouched\n", ratio*100, opts.MinWhiteRatio*100)
+			res.Bounds = img.Bounds()
+			res.Status = fmt.Sprintf("skipped: white ratio %.4f below %.4f", ratio, opts.MinWhiteRatio)
+			return res, nil
+		}
+	}
+
+	if opts.ImageTimeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), opts.ImageTimeout)
+		defer cancel()
+		opts.ctx = ctx
+	}
+	// The rest of the plan uses the options of the mode that was chosen,
+	// e.g. its adaptive levels.
+	var det detection
+	det, opts = detectModes(img, opts)
+	if opts.AutoEscalate && det.Reason != "mode-none" && (det.Mode == ModeNone || det.Bounds.Empty() || det.Bounds == img.Bounds()) {
+		if d, o, ok := escalate(img, opts); ok {
+			det, opts = d, o
+		}
+	}
+	if opts.ctx != nil && opts.ctx.Err() != nil {
+		opts.logf("  Timed out after %v, skipping\n", opts.ImageTimeout)
+		res.Bounds = img.Bounds()
+		res.Status = fmt.Sprintf("skipped: timed out after %v", opts.ImageTimeout)
+		return res, nil
+	}
+	bounds := det.Bounds
+	res.Mode = det.Mode
+	res.ModeReason = det.Reason
+	res.background = det.IsBackground
+	res.content = det.Content
+	if opts.JSONReport != "" {
+		if c, ok := contentCentroid(det.Bounds, det.isBackground); ok {
+			res.Centroid = &c
+		}
+		if opts.ProgressiveScan {
+			if r, ok := contentRotatedRect(det.Bounds, det.isBackground); ok {
+				res.RotatedRect = &r
+			}
+		}
+	}
+	if opts.StrictCorners && !cornersAgree(img.Bounds(), det) {
+		opts.logf("  Corners disagree on the background, leaving untouched\n")
+		res.Bounds = img.Bounds()
+		res.Status = "skipped: corners disagree"
+		return res, nil
+	}
+	if det.Mode == ModeNone && opts.NoOpOnColorImages {
+		// No border detected (e.g. a full-frame photo): leave it entirely
+		// alone rather than writing an identical copy.
+		opts.logf("  No background detected, leaving untouched\n")
+		res.Bounds = img.Bounds()
+		res.Status = "skipped: no background detected"
+		return res, nil
+	}
+	if bounds.Empty() {
+		if !opts.KeepUniform {
+			return res, ErrEmptyCrop
+		}
+		// Every row is background: this is a solid-color asset (e.g. a
+		// placeholder) rather than a bordered image, so keep it as-is.
+		opts.logf("  Image is a uniform background color, keeping original\n")
+		bounds = img.Bounds()
+	}
+
+	res.Status = "cropped"
+	if det.Bounds.Empty() {
+		res.Status = "unchanged: uniform image"
+	} else if bounds == img.Bounds() {
+		res.Status = "unchanged"
+	}
+
+	// Sanity check: a crop that turns the image into a thin strip is almost
+	// always a detection error, so keep the original instead.
+	if opts.MaxAspectChange > 0 {
+		if change := aspectChange(img.Bounds(), bounds); change > opts.MaxAspectChange {
+			opts.logf("  Warning: crop %v changes aspect ratio by %.2fx (limit %.2fx), keeping original\n", bounds, change, opts.MaxAspectChange)
+			res.Status = fmt.Sprintf("kept original: aspect change %.2fx exceeds %.2fx", change, opts.MaxAspectChange)
+			bounds = img.Bounds()
+		}
+	}
+
+	// A crop down to a tiny fraction of the image is usually a speck being
+	// mistaken for the content.
+	if opts.MinContentFraction > 0 {
+		fraction := float64(bounds.Dx()*bounds.Dy()) / float64(img.Bounds().Dx()*img.Bounds().Dy())
+		if fraction < opts.MinContentFraction {
+			opts.logf("  Warning: crop %v keeps only %.2f%% of the image (minimum %.2f%%), keeping original\n", bounds, fraction*100, opts.MinContentFraction*100)
+			res.Status = fmt.Sprintf("kept original: content fraction %.4f below %.4f", fraction, opts.MinContentFraction)
+			bounds = img.Bounds()
+		}
+	}
+
+	if opts.RequireBorder && bounds == img.Bounds() {
+		return res, ErrNoBorder
+	}
+
+	// Keep a margin around the content. Percentages are resolved against
+	// this image's crop, so they scale across differently-sized images.
+	if bounds != img.Bounds() && opts.Padding != "" {
+		pad, err := parsePadding(opts.Padding)
+		if err != nil {
+			return res, err
+		}
+		if opts.PaddingColor == paddingColorClip {
+			bounds = pad.expand(bounds, img.Bounds())
+		} else {
+			bounds = pad.grow(bounds)
+		}
+	}
+
+	if opts.PreserveOriginalAspect && bounds != img.Bounds() && !bounds.Empty() {
+		bounds = matchAspect(img.Bounds(), bounds, img.Bounds().Union(bounds))
+	}
+	if !bounds.In(img.Bounds()) {
+		if err := checkPaddedSize(bounds, opts.MaxMemory); err != nil {
+			return res, err
+		}
+	}
+	res.Bounds = bounds
+	return res, nil
+}
+
+// renderCrop crops img to res.Bounds and applies the orientation and resize
+// from opts.
+func renderCrop(img image.Image, res fileResult, opts options) (image.Image, error) {
+	bounds := res.Bounds
+	// If the bounds match the original image, no cropping is needed, but we save it anyway as per requirement
+	// Or we could skip. For now, let's proceed with cropping (which will just be a copy) and saving.
+
+	var croppedImg image.Image
+	if bounds.In(img.Bounds()) {
+		croppedImg = crop.SubImage(img, bounds, opts.Copy)
+	} else {
+		// Padding reaches past the original image; see -padding-color.
+		fill, err := parsePaddingColor(opts.PaddingColor)
+		if err != nil {
+			return nil, err
+		}
+		if fill == nil {
+			fill = backgroundFill(img, res.Mode, res.isBackground)
+		}
+		croppedImg = padImage(img, bounds, fill)
+	}
+
+	croppedImg, err := orientImage(croppedImg, opts.Orient)
+	if err != nil {
+		return nil, err
+	}
+
+	if size := fitSize(croppedImg.Bounds().Size(), opts.MaxDim); size != croppedImg.Bounds().Size() {
+		return resizeImage(croppedImg, size, opts.ResizeFilter)
+	}
+	return croppedImg, nil
+}
+
+// isPassthrough reports whether writing the crop of an image with the given
+// bounds would reproduce it unchanged: no crop, format change, orientation,
+// resize or embedded metadata. format is the output format, which must be
+// the input's.
+func isPassthrough(imgBounds, crop image.Rectangle, format string, opts options) bool {
+	size := imgBounds.Size()
+	return crop == imgBounds &&
+		(opts.Format == "" || opts.Format == format) &&
+		!needsRotation(size, opts.Orient) &&
+		fitSize(size, opts.MaxDim) == size &&
+		!(opts.EmbedProvenance && format == "png")
+}
+
+// saveOptionsFor returns the encoder settings for writing res's output.
+func saveOptionsFor(res fileResult, opts options) saveOptions {
+	so := saveOptions{JPEGSubsampling: opts.JPEGSubsampling, PNGBitDepth: opts.PNGBitDepth}
+	if opts.EmbedProvenance {
+		so.Text = map[string]string{provenanceKey: provenance(res.Size, res.Bounds)}
+	}
+	return so
+}
+
+// Errors returned by loadImage, processImage and saveImage, so callers can
+// tell failure kinds apart with errors.Is.
+var (
+	// ErrDecode means a file could not be decoded, e.g. because it was
+	// only partially downloaded.
+	ErrDecode = errors.New("corrupt/truncated image")
+	// ErrUnsupportedFormat means the image format cannot be decoded or
+	// encoded.
+	ErrUnsupportedFormat = errors.New("unsupported format")
+	// ErrEmptyCrop means detection found no content at all.
+	ErrEmptyCrop = crop.ErrEmpty
+	// ErrNoBorder means -require-border found nothing to trim.
+	ErrNoBorder = errors.New("no border found")
 )
 
-// Thresholds for "Black-ish" and "White-ish" pixels.
-// We increased the black threshold to 60 to catch dark gray shadows/borders.
+// quarantineDir is the subdirectory that -move-bad moves corrupt files into.
+const quarantineDir = "quarantine"
+
+func loadImage(path string) (image.Image, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+
+	return decodeImage(file)
+}
+
+// decodeImage decodes an image from r, classifying failures as
+// ErrUnsupportedFormat or ErrDecode.
+func decodeImage(r io.Reader) (image.Image, string, error) {
+	img, format, err := image.Decode(r)
+	if errors.Is(err, image.ErrFormat) {
+		return nil, "", fmt.Errorf("%w: %v", ErrUnsupportedFormat, err)
+	} else if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrDecode, err)
+	}
+	// Guard against headers that decode to a degenerate image, which would
+	// otherwise produce a garbage crop.
+	if img.Bounds().Empty() {
+		return nil, "", fmt.Errorf("%w: zero-sized image", ErrDecode)
+	}
+	return img, format, nil
+}
+
+// quarantine moves filePath into the quarantine subdirectory of dirPath.
+func quarantine(filePath, dirPath string) error {
+	dst := filepath.Join(dirPath, quarantineDir)
+	if err := os.MkdirAll(dst, 0o755); err != nil {
+		return err
+	}
+	return os.Rename(filePath, filepath.Join(dst, filepath.Base(filePath)))
+}
+
+// backgroundMode is the background color that findContentBounds trims away.
+type backgroundMode int
+
 const (
-	blackThreshold = 60
-	whiteThreshold = 195
+	ModeNone backgroundMode = iota
+	ModeBlack
+	ModeWhite
+	// ModeHue matches a colored background by hue; see -hue-tolerance.
+	ModeHue
+	// ModeColor matches the top-left corner's color within -fuzz.
+	ModeColor
+	// ModePalette matches one palette index of a paletted image; see
+	// -palette-match.
+	ModePalette
+	// ModeGradient matches a vertical gradient between the top and bottom
+	// corners' colors; see -gradient-bg.
+	ModeGradient
+	// ModeReference matches the same pixel of a reference image; see
+	// -reference.
+	ModeReference
+	// ModeTransparent matches pixels less opaque than the alpha threshold,
+	// for images whose corners are fully transparent.
+	ModeTransparent
 )
 
-// isBlack checks if a color is considered "black".
-// Kept for testing purposes and potential single-pixel checks.
-func isBlack(c color.Color) bool {
-	r, g, b, _ := c.RGBA()
-	r8 := r >> 8
-	g8 := g >> 8
-	b8 := b >> 8
-	return r8 <= blackThreshold && g8 <= blackThreshold && b8 <= blackThreshold
+func (m backgroundMode) String() string {
+	switch m {
+	case ModeBlack:
+		return "black"
+	case ModeWhite:
+		return "white"
+	case ModeHue:
+		return "hue"
+	case ModeColor:
+		return "color"
+	case ModePalette:
+		return "palette"
+	case ModeGradient:
+		return "gradient"
+	case ModeReference:
+		return "reference"
+	case ModeTransparent:
+		return "transparent"
+	default:
+		return "none"
+	}
 }
 
-func main() {
-	if len(os.Args) < 2 {
-		fmt.Println("Usage: go run main.go <directory_path>")
-		return
-	}
+// defaultLevels applies blackThreshold and whiteThreshold to all channels.
+var defaultLevels = crop.UniformLevels(blackThreshold, whiteThreshold)
 
-	dirPath := os.Args[1]
-	fmt.Printf("Processing images in: %s\n", dirPath)
+// cropBackground returns the crop package's Background for mode, which is
+// crop.None for the modes it doesn't detect.
+func (m backgroundMode) cropBackground() crop.Background {
+	switch m {
+	case ModeBlack:
+		return crop.Black
+	case ModeWhite:
+		return crop.White
+	case ModeTransparent:
+		return crop.Transparent
+	default:
+		return crop.None
+	}
+}
 
-	err := processDirectory(dirPath)
-	if err != nil {
-		fmt.Printf("Error processing directory: %v\n", err)
-		os.Exit(1)
+// backgroundModeOf returns the backgroundMode of the crop package's bg.
+func backgroundModeOf(bg crop.Background) backgroundMode {
+	switch bg {
+	case crop.Black:
+		return ModeBlack
+	case crop.White:
+		return ModeWhite
+	case crop.Transparent:
+		return ModeTransparent
+	default:
+		return ModeNone
 	}
+}
 
-	fmt.Println("Processing complete.")
+// isBackgroundColor reports whether c is the background color for mode
+// under lv.
+func isBackgroundColor(c color.Color, mode backgroundMode, lv crop.Levels) bool {
+	return crop.Options{Levels: lv}.IsBackground(c, mode.cropBackground())
 }
 
-func processDirectory(dirPath string) error {
-	files, err := os.ReadDir(dirPath)
-	if err != nil {
-		return err
+// vignetteSlack returns the extra tolerance for the pixel at (x, y) under
+// -vignette-tolerance: it ramps linearly from 0 at the center of bounds to
+// tolerance at the corners, so darkening toward the frame counts as
+// background while dark content in the middle is protected.
+func vignetteSlack(bounds image.Rectangle, x, y int, tolerance int) uint8 {
+	cx := float64(bounds.Min.X+bounds.Max.X-1) / 2
+	cy := float64(bounds.Min.Y+bounds.Max.Y-1) / 2
+	maxDist := math.Hypot(cx-float64(bounds.Min.X), cy-float64(bounds.Min.Y))
+	if maxDist == 0 {
+		return 0
 	}
+	t := math.Hypot(float64(x)-cx, float64(y)-cy) / maxDist
+	return uint8(t * float64(tolerance))
+}
 
-	for _, file := range files {
-		if file.IsDir() {
-			continue
-		}
+// borderBandFraction is the share of background pixels an outer band needs
+// for hasBorderBand to consider it part of a border.
+const borderBandFraction = 0.5
 
-		filename := file.Name()
+// borderBandSamples is the number of positions sampled along each band.
+const borderBandSamples = 64
 
-		// Skip hidden files
-		if strings.HasPrefix(filename, ".") {
-			continue
-		}
+// hasBorderBand reports whether any of the four outer bands of bounds, each
+// width pixels deep, is mostly background. Only about borderBandSamples
+// positions along each band are checked, so it is a cheap test that lets
+// full-bleed images skip the full scan.
+func hasBorderBand(bounds image.Rectangle, width int, isBackground func(x, y int) bool) bool {
+	width = min(width, bounds.Dx(), bounds.Dy())
+	stepX := max(1, bounds.Dx()/borderBandSamples)
+	stepY := max(1, bounds.Dy()/borderBandSamples)
 
-		// Skip already processed files to avoid infinite loops or double processing
-		if strings.HasPrefix(filename, "processed_") {
-			continue
+	// Each band is sampled along its length (step) and fully across its depth.
+	bands := []struct {
+		r            image.Rectangle
+		stepX, stepY int
+	}{
+		{image.Rect(bounds.Min.X, bounds.Min.Y, bounds.Max.X, bounds.Min.Y+width), stepX, 1},
+		{image.Rect(bounds.Min.X, bounds.Max.Y-width, bounds.Max.X, bounds.Max.Y), stepX, 1},
+		{image.Rect(bounds.Min.X, bounds.Min.Y, bounds.Min.X+width, bounds.Max.Y), 1, stepY},
+		{image.Rect(bounds.Max.X-width, bounds.Min.Y, bounds.Max.X, bounds.Max.Y), 1, stepY},
+	}
+
+	for _, band := range bands {
+		matchCount, total := 0, 0
+		for y := band.r.Min.Y; y < band.r.Max.Y; y += band.stepY {
+			for x := band.r.Min.X; x < band.r.Max.X; x += band.stepX {
+				if isBackground(x, y) {
+					matchCount++
+				}
+				total++
+			}
+		}
+		if float64(matchCount)/float64(total) >= borderBandFraction {
+			return true
 		}
+	}
+	return false
+}
 
-		fullPath := filepath.Join(dirPath, filename)
+// detectMode determines the target background color (Black or White).
+// The 4 corners of the image vote first; if none of them is black or white
+// (e.g. rounded-corner overlays or watermarks), the midpoints of the 4 edges
+// vote instead before giving up.
+func detectMode(img image.Image, lv crop.Levels) backgroundMode {
+	mode, _ := detectModeReason(img, crop.Options{Levels: lv})
+	return mode
+}
 
-		// Check if file is a supported image based on content (MIME type)
-		if !isSupportedImage(fullPath) {
-			continue
+// detectModeReason is detectMode, also returning a short code for how the
+// mode was decided, for triaging images that were not cropped:
+//
+//	detected-black, detected-white    the corners' majority, or the
+//	                                  sampled edge pixels' with
+//	                                  o.EdgeSampleRate
+//	tie-black                         as many black as white corners
+//	midpoints-black, midpoints-white  the edge midpoints' majority, the
+//	midpoints-tie-black               corners being neither black nor white
+//	colored-corners                   no sample point is black or white,
+//	                                  and the corners agree on a color
+//	tie-no-background                 no sample point is black or white,
+//	                                  nor do the corners agree on a color
+//	transparent-corners               all four corners have alpha 0, with
+//	                                  o.Transparent set
+func detectModeReason(img image.Image, o crop.Options) (backgroundMode, string) {
+	// The color of a fully transparent pixel is meaningless; it is usually
+	// stored as black.
+	vote := crop.VoteBackground(img, o)
+	mode := backgroundModeOf(vote.Background)
+	switch {
+	case mode == ModeTransparent:
+		return mode, "transparent-corners"
+	case mode == ModeNone:
+		// Agreeing corners hint at a colored border that auto-color
+		// would crop; otherwise there is likely no border at all.
+		if _, ok := cornerAgreement(img, autoColorTolerance); ok {
+			return mode, "colored-corners"
 		}
+		return mode, "tie-no-background"
+	}
+	reason := "detected-" + mode.String()
+	if vote.Tie {
+		reason = "tie-" + mode.String()
+	}
+	if vote.Midpoints {
+		reason = "midpoints-" + strings.TrimPrefix(reason, "detected-")
+	}
+	return mode, reason
+}
 
-		fmt.Printf("Processing: %s\n", filename)
+// whiteRatio returns the fraction of img's pixels that are near-white.
+func whiteRatio(img image.Image, lv crop.Levels) float64 {
+	bounds := img.Bounds()
+	white := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if lv.IsWhite(img.At(x, y)) {
+				white++
+			}
+		}
+	}
+	return float64(white) / float64(bounds.Dx()*bounds.Dy())
+}
 
-		if err := processImage(fullPath, dirPath, filename); err != nil {
-			fmt.Printf("  Failed to process %s: %v\n", filename, err)
-		} else {
-			fmt.Printf("  Saved processed_%s\n", filename)
+// contentPixels returns the number of pixels inside bounds that are not
+// background.
+func contentPixels(bounds image.Rectangle, isBackground func(x, y int) bool) int {
+	content := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if !isBackground(x, y) {
+				content++
+			}
 		}
 	}
-	return nil
+	return content
 }
 
-func isSupportedImage(path string) bool {
-	file, err := os.Open(path)
-	if err != nil {
-		return false
+// cornerConsensus returns the background mode all four corners of img agree
+// on, or ModeNone if they don't.
+func cornerConsensus(img image.Image, lv crop.Levels) backgroundMode {
+	if crop.TransparentCorners(img) {
+		return ModeTransparent
 	}
-	defer file.Close()
+	corners, _ := samplePoints(img.Bounds())
+	mode := ModeNone
+	for i, p := range corners {
+		var m backgroundMode
+		switch {
+		case lv.IsBlack(img.At(p.X, p.Y)):
+			m = ModeBlack
+		case lv.IsWhite(img.At(p.X, p.Y)):
+			m = ModeWhite
+		default:
+			return ModeNone
+		}
+		if i > 0 && m != mode {
+			return ModeNone
+		}
+		mode = m
+	}
+	return mode
+}
 
-	// Only read the first 512 bytes to determine the content type
-	buffer := make([]byte, 512)
-	_, err = file.Read(buffer)
-	if err != nil {
-		return false
+// cornersAgree reports whether all four corners of bounds are background
+// under det, by the classifier the scans used. An image without a detected
+// background has nothing to disagree on.
+func cornersAgree(bounds image.Rectangle, det detection) bool {
+	if det.Mode == ModeNone {
+		return true
+	}
+	corners, _ := samplePoints(bounds)
+	for _, p := range corners {
+		if !det.isBackground(p.X, p.Y) {
+			return false
+		}
 	}
+	return true
+}
 
-	contentType := http.DetectContentType(buffer)
-	return contentType == "image/jpeg" || contentType == "image/png"
+// samplePoints returns the 4 corners and the 4 edge midpoints of bounds,
+// which are sampled to determine the background.
+func samplePoints(bounds image.Rectangle) (corners, midpoints []image.Point) {
+	return crop.SamplePoints(bounds)
 }
 
-func processImage(filePath, dirPath, filename string) error {
-	img, format, err := loadImage(filePath)
-	if err != nil {
-		return err
+// sampledBackground returns the mean color of the corners and edge
+// midpoints of img that are background for mode.
+func sampledBackground(img image.Image, mode backgroundMode, lv crop.Levels) color.Color {
+	corners, midpoints := samplePoints(img.Bounds())
+	var r, g, b, n uint32
+	for _, p := range append(corners, midpoints...) {
+		c := img.At(p.X, p.Y)
+		if !isBackgroundColor(c, mode, lv) {
+			continue
+		}
+		cr, cg, cb, _ := c.RGBA()
+		r, g, b, n = r+cr>>8, g+cg>>8, b+cb>>8, n+1
 	}
+	if n == 0 {
+		return color.Black
+	}
+	return color.RGBA{uint8(r / n), uint8(g / n), uint8(b / n), 0xff}
+}
 
-	bounds := findContentBounds(img)
-	if bounds.Empty() {
-		return fmt.Errorf("image is completely black or empty")
+// sideModes votes the background mode of each edge of img on its two
+// corners and its midpoint, for -per-side-background. An edge that is
+// neither black nor white gets ModeNone.
+func sideModes(img image.Image, lv crop.Levels) (top, bottom, left, right backgroundMode) {
+	corners, midpoints := samplePoints(img.Bounds())
+	vote := func(points ...image.Point) backgroundMode {
+		bg, _ := crop.Poll(img, points, lv)
+		return backgroundModeOf(bg)
 	}
+	top = vote(corners[0], corners[1], midpoints[0])
+	bottom = vote(corners[2], corners[3], midpoints[1])
+	left = vote(corners[0], corners[2], midpoints[2])
+	right = vote(corners[1], corners[3], midpoints[3])
+	return top, bottom, left, right
+}
 
-	// If the bounds match the original image, no cropping is needed, but we save it anyway as per requirement
-	// Or we could skip. For now, let's proceed with cropping (which will just be a copy) and saving.
+// clippedImage restricts an image to a rectangle without copying it or
+// changing its coordinates.
+type clippedImage struct {
+	image.Image
+	rect image.Rectangle
+}
 
-	croppedImg := cropImage(img, bounds)
+func (c clippedImage) Bounds() image.Rectangle { return c.rect }
 
-	outFilename := "processed_" + filename
-	// Append extension if missing (e.g. for extensionless screenshots)
-	if filepath.Ext(outFilename) == "" {
-		if format == "jpeg" {
-			outFilename += ".jpg"
-		} else if format == "png" {
-			outFilename += ".png"
-		}
-	}
-	outPath := filepath.Join(dirPath, outFilename)
+// detection is the outcome of scanning an image for its content area.
+type detection struct {
+	Bounds image.Rectangle
+	Mode   backgroundMode
+	// Reason is how Mode was decided; see detectModeReason. The hue and
+	// fuzz modes report "hue-corners" and "fuzz", the gradient mode
+	// "gradient-corners", the color mode of
+	// -corner-agreement-tolerance "agreeing-corners" and -mode none
+	// "mode-none".
+	Reason string
+	// IsBackground classifies img's pixels as Mode's background, as the
+	// scans did; nil when no background was detected.
+	IsBackground func(x, y int) bool
+	// Content is the number of content pixels inside Bounds by
+	// IsBackground, counted only for the reports' fill ratio.
+	Content int
+}
 
-	return saveImage(outPath, croppedImg, format)
+// isBackground reports whether the pixel at (x, y) is background under
+// d, which nothing is when no background was detected.
+func (d detection) isBackground(x, y int) bool {
+	return d.IsBackground != nil && d.IsBackground(x, y)
 }
 
-func loadImage(path string) (image.Image, string, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, "", err
+// transparentBelow returns the alpha a pixel of a transparent background
+// must be below: AlphaThreshold, or crop's default when it is not set.
+func (opts options) transparentBelow() uint8 {
+	if opts.AlphaThreshold > 0 {
+		return uint8(opts.AlphaThreshold)
 	}
-	defer file.Close()
+	return crop.DefaultAlphaThreshold
+}
 
-	img, format, err := image.Decode(file)
-	if err != nil {
-		return nil, "", err
-	}
-	return img, format, nil
+// findContentBounds finds img's content with the default options of the
+// crop package at the levels lv, which detect matches when no other
+// option is set.
+func findContentBounds(img image.Image, lv crop.Levels) image.Rectangle {
+	o := crop.DefaultOptions()
+	o.Levels = lv
+	return crop.Bounds(img, o)
 }
 
-// isPixelRemovable determines if a pixel is considered "background" (very dark or very light).
-// However, for a row to be removed, it usually must be uniform.
-// We'll handle uniformity in the scanning logic.
+// centroid is the mean position of a set of pixels.
+type centroid struct {
+	X, Y float64
+}
 
-func findContentBounds(img image.Image) image.Rectangle {
-	bounds := img.Bounds()
-	minX, minY := bounds.Max.X, bounds.Max.Y
-	maxX, maxY := bounds.Min.X, bounds.Min.Y
+// findContentBoundsAndCentroid returns the content bounds together with the
+// centroid of the content (non-background) pixels inside them. ok is false
+// when there are no content pixels.
+func findContentBoundsAndCentroid(img image.Image, opts options) (bounds image.Rectangle, c centroid, ok bool) {
+	det := detect(img, opts)
+	c, ok = contentCentroid(det.Bounds, det.isBackground)
+	return det.Bounds, c, ok
+}
 
-	// Helpers to check color type
-	isPixelBlack := func(r8, g8, b8 uint32) bool {
-		return r8 <= blackThreshold && g8 <= blackThreshold && b8 <= blackThreshold
-	}
-	isPixelWhite := func(r8, g8, b8 uint32) bool {
-		return r8 >= whiteThreshold && g8 >= whiteThreshold && b8 >= whiteThreshold
+// contentCentroid returns the mean coordinate of the pixels inside bounds
+// that are not background.
+func contentCentroid(bounds image.Rectangle, isBackground func(x, y int) bool) (centroid, bool) {
+	var sumX, sumY float64
+	n := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if !isBackground(x, y) {
+				sumX += float64(x)
+				sumY += float64(y)
+				n++
+			}
+		}
 	}
-
-	// 1. Determine the target background color (Black or White) based on corners.
-	// We check the 4 corners of the image.
-	corners := []struct{ x, y int }{
-		{bounds.Min.X, bounds.Min.Y},
-		{bounds.Max.X - 1, bounds.Min.Y},
-		{bounds.Min.X, bounds.Max.Y - 1},
-		{bounds.Max.X - 1, bounds.Max.Y - 1},
+	if n == 0 {
+		return centroid{}, false
 	}
+	return centroid{X: sumX / float64(n), Y: sumY / float64(n)}, true
+}
 
-	blackCornerCount := 0
-	whiteCornerCount := 0
-
-	for _, p := range corners {
-		c := img.At(p.x, p.y)
-		r, g, b, _ := c.RGBA()
-		r8, g8, b8 := r>>8, g>>8, b>>8
+func detect(img image.Image, opts options) detection {
+	bounds := img.Bounds()
 
-		if isPixelBlack(r8, g8, b8) {
-			blackCornerCount++
-		} else if isPixelWhite(r8, g8, b8) {
-			whiteCornerCount++
+	tracef := func(format string, args ...any) {
+		if opts.trace != nil {
+			fmt.Fprintf(opts.trace, "  trace: "+format+"\n", args...)
 		}
 	}
 
-	type TargetMode int
-	const (
-		ModeNone TargetMode = iota
-		ModeBlack
-		ModeWhite
-	)
+	// Detect on the quantized alpha; a paletted image is left alone for
+	// -palette-match, which compares indices rather than colors.
+	if _, paletted := img.(*image.Paletted); opts.QuantizeAlpha > 0 && !(paletted && opts.PaletteMatch) {
+		img = quantizedAlpha{img, uint8(opts.QuantizeAlpha)}
+	}
 
-	var mode TargetMode
-	if blackCornerCount > whiteCornerCount {
-		mode = ModeBlack
-	} else if whiteCornerCount > blackCornerCount {
-		mode = ModeWhite
-	} else {
-		// Tie or neither.
-		// If we found some black corners but no white, use black (and vice versa).
-		if blackCornerCount > 0 {
-			mode = ModeBlack
-		} else if whiteCornerCount > 0 {
-			mode = ModeWhite
-		} else {
-			// If corners are colors (neither black nor white), check edges?
-			// For now, if corners aren't background, we assume no cropping needed.
-			mode = ModeNone
+	lv := opts.levels()
+	mode, reason := detectModeReason(img, crop.Options{Levels: lv, Transparent: opts.transparent, EdgeSampleRate: opts.EdgeSampleRate})
+	var hueRef hsv
+	if mode == ModeNone && opts.HueTolerance > 0 {
+		if ref, ok := cornerHSV(img, opts.HueTolerance); ok {
+			mode, hueRef, reason = ModeHue, ref, "hue-corners"
+		}
+	}
+	// Like ImageMagick's -trim, -fuzz takes the top-left corner's color as
+	// the background, whatever it is.
+	var fuzzRef color.Color
+	fuzz, err := parseFuzz(opts.Fuzz)
+	if mode == ModeNone && opts.Fuzz == "" && opts.CornerAgreementTolerance > 0 {
+		if ref, ok := cornerAgreement(img, opts.CornerAgreementTolerance); ok {
+			mode, fuzzRef, reason = ModeColor, ref, "agreeing-corners"
+			fuzz = float64(opts.CornerAgreementTolerance) / 255 * 100
 		}
 	}
+	if opts.Fuzz != "" && err == nil {
+		mode, fuzzRef, reason = ModeColor, img.At(bounds.Min.X, bounds.Min.Y), "fuzz"
+	}
+	// With -gradient-bg, each row has its own expected background color.
+	var gradient gradientBackground
+	if opts.GradientBg > 0 {
+		mode, gradient, reason = ModeGradient, newGradientBackground(img), "gradient-corners"
+	}
+	// An indexed image is matched on the index itself, which is exact and
+	// skips converting every pixel to RGBA.
+	paletted, _ := img.(*image.Paletted)
+	var bgIndex uint8
+	if opts.PaletteMatch && paletted != nil {
+		mode = ModePalette
+		bgIndex, reason = paletteBackground(paletted, opts.BgIndex)
+		tracef("background palette index %d", bgIndex)
+	}
+	// With -reference, each pixel has its own expected background color:
+	// the reference's pixel at the same offset from its bounds' origin, as
+	// the two needn't share one (e.g. a SubImage or a cropped frame).
+	var refImg image.Image
+	var refOffset image.Point
+	if opts.reference != nil {
+		mode, refImg, reason = ModeReference, opts.reference.img, "reference"
+		refOffset = refImg.Bounds().Min.Sub(bounds.Min)
+	}
+	tracef("background mode %s (%s)", mode, reason)
+	// Colors under -preserve-color are content whatever the mode.
+	preserved, _ := parsePreserveColors(opts.PreserveColor)
 
 	if mode == ModeNone {
 		// No detectable background color at corners, return original bounds
-		return bounds
+		return detection{Bounds: bounds, Mode: mode, Reason: reason}
 	}
 
-	// Helpers to check row/col uniformity
-	// A row is removable if it is MOSTLY (>95%) the Target Color.
-	const noiseTolerance = 0.95
-	const lookaheadGap = 5 // Ensure we skip over thin noise lines if real background continues
-
-	isRowRemovable := func(y int) bool {
-		width := bounds.Dx()
-		matchCount := 0
-
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+	// backgroundFor classifies pixels as background for mode, which is the
+	// detected mode except for the sides of -per-side-background.
+	backgroundFor := func(mode backgroundMode) func(x, y int) bool {
+		// With -bg-match-tolerance, black and white background must also
+		// be close to the color actually sampled.
+		var bgRef color.Color
+		if opts.BgMatchTolerance > 0 && (mode == ModeBlack || mode == ModeWhite) {
+			bgRef = sampledBackground(img, mode, lv)
+			tracef("sampled background %v", bgRef)
+		}
+		return func(x, y int) bool {
+			if mode == ModePalette {
+				return paletted.ColorIndexAt(x, y) == bgIndex
+			}
 			c := img.At(x, y)
-			r, g, b, _ := c.RGBA()
-			r8, g8, b8 := r>>8, g>>8, b>>8
-
-			if mode == ModeBlack && isPixelBlack(r8, g8, b8) {
-				matchCount++
-			} else if mode == ModeWhite && isPixelWhite(r8, g8, b8) {
-				matchCount++
+			if opts.AlphaThreshold > 0 {
+				if _, _, _, a := c.RGBA(); a>>8 < uint32(opts.AlphaThreshold) {
+					return true
+				}
 			}
+			if len(preserved) > 0 && isPreserved(c, preserved) {
+				return false
+			}
+			if mode == ModeHue {
+				return matchesHue(c, hueRef, opts.HueTolerance)
+			}
+			if mode == ModeColor {
+				return withinFuzz(c, fuzzRef, fuzz)
+			}
+			if mode == ModeGradient {
+				return colorDistance(c, gradient.at(y)) <= float64(opts.GradientBg)
+			}
+			if mode == ModeReference {
+				return withinDifference(c, refImg.At(x+refOffset.X, y+refOffset.Y), opts.DiffTolerance)
+			}
+			if mode == ModeTransparent {
+				return crop.IsTransparent(c, opts.transparentBelow())
+			}
+			var bg bool
+			if opts.VignetteTolerance > 0 {
+				bg = isBackgroundColor(c, mode, lv.Loosen(vignetteSlack(bounds, x, y, opts.VignetteTolerance)))
+			} else {
+				bg = isBackgroundColor(c, mode, lv)
+			}
+			return bg && (bgRef == nil || colorDistance(c, bgRef) <= float64(opts.BgMatchTolerance))
+		}
+	}
+	isBackground := backgroundFor(mode)
+	// The reports' fill ratio counts the content on the final mask.
+	content := func(r image.Rectangle) int {
+		if opts.TrimReport == "" && opts.JSONReport == "" {
+			return 0
 		}
+		return contentPixels(r, isBackground)
+	}
 
-		total := float64(width)
-		return float64(matchCount)/total >= noiseTolerance
+	// Fast path for full-bleed images: if none of the outer bands looks like
+	// a border, skip the four-direction scan entirely.
+	if opts.DetectOnlyBorderWidth > 0 && !hasBorderBand(bounds, opts.DetectOnlyBorderWidth, isBackground) {
+		tracef("no border band in the outer %dpx, treating as full-bleed", opts.DetectOnlyBorderWidth)
+		return detection{Bounds: bounds, Mode: mode, Reason: reason, IsBackground: isBackground, Content: content(bounds)}
 	}
 
-	isColRemovable := func(x int) bool {
-		height := bounds.Dy()
-		matchCount := 0
+	refine := func(isBackground func(x, y int) bool) func(x, y int) bool {
+		// Ignore isolated content specks (e.g. scanner dust) by opening
+		// the detection mask. The output pixels are not affected.
+		if opts.Despeckle > 0 {
+			isBackground = newContentMask(bounds, isBackground).Open(opts.Despeckle).IsBackground
+		}
+		// Close the gaps of dashed and dotted frames so the scans stop at
+		// them instead of trimming through.
+		if opts.CloseRadius > 0 {
+			isBackground = newContentMask(bounds, isBackground).Close(opts.CloseRadius).IsBackground
+		}
+		return isBackground
+	}
+	isBackground = refine(isBackground)
 
-		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-			c := img.At(x, y)
-			r, g, b, _ := c.RGBA()
-			r8, g8, b8 := r>>8, g>>8, b>>8
+	// With -per-side-background, each side is trimmed against its own
+	// edge's background, e.g. a black bar on top and a white one below.
+	// The steps after the scans keep using the detected mode.
+	sideBackground := func(sideMode backgroundMode) func(x, y int) bool {
+		if sideMode == ModeNone || sideMode == mode {
+			return isBackground
+		}
+		return refine(backgroundFor(sideMode))
+	}
+	topBackground, bottomBackground := isBackground, isBackground
+	leftBackground, rightBackground := isBackground, isBackground
+	if opts.PerSideBackground && (mode == ModeBlack || mode == ModeWhite) {
+		top, bottom, left, right := sideModes(img, lv)
+		tracef("side backgrounds top=%s bottom=%s left=%s right=%s", top, bottom, left, right)
+		topBackground, bottomBackground = sideBackground(top), sideBackground(bottom)
+		leftBackground, rightBackground = sideBackground(left), sideBackground(right)
+	}
 
-			if mode == ModeBlack && isPixelBlack(r8, g8, b8) {
-				matchCount++
-			} else if mode == ModeWhite && isPixelWhite(r8, g8, b8) {
-				matchCount++
-			}
+	scanner := crop.Scanner{
+		IsBackground: [4]func(x, y int) bool{topBackground, bottomBackground, leftBackground, rightBackground},
+		// Each side may override the noise tolerance for its scan.
+		NoiseTolerance: [4]float64{opts.ToleranceTop, opts.ToleranceBottom, opts.ToleranceLeft, opts.ToleranceRight},
+		LookaheadGap:   crop.DefaultLookaheadGap,
+		// With -max-detect-depth, each scan gives up that far in from its
+		// edge.
+		MaxDepth:     opts.MaxDetectDepth,
+		MinRun:       opts.MinRun,
+		ColumnsFirst: opts.ScanOrder == scanHorizontalFirst,
+		// With -ignore-protrusions, a row or column whose content is only
+		// thin slivers (e.g. the cross-section of a connector line running
+		// into the margin) counts as background.
+		MinContentRun: opts.IgnoreProtrusions,
+		// A cancelled scan finds no more removable lines, so every side
+		// stops.
+		Stop: func() bool {
+			return opts.ctx != nil && opts.ctx.Err() != nil
+		},
+		Trace: tracef,
+	}
+	for side, t := range scanner.NoiseTolerance {
+		if t == 0 {
+			scanner.NoiseTolerance[side] = opts.NoiseTolerance
 		}
+	}
+	if opts.trace == nil {
+		scanner.Trace = nil
+	}
 
-		total := float64(height)
-		return float64(matchCount)/total >= noiseTolerance
+	result := scanner.Scan(bounds)
+	// Every row is background.
+	if result == (image.Rectangle{}) {
+		return detection{Mode: mode, Reason: reason, IsBackground: isBackground}
 	}
 
-	// Scan MinY (Top)
-	minY = bounds.Min.Y
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		if isRowRemovable(y) {
-			minY = y + 1
-			continue
+	// JPEG block artifacts next to the content can stop the scans up to a
+	// block short of it; step over lines that are only faintly off.
+	if opts.SnapBlocks && !result.Empty() {
+		looseBackground := func(x, y int) bool {
+			return isBackground(x, y) || mode != ModeTransparent && isBackgroundColor(img.At(x, y), mode, lv.Loosen(blockArtifactSlack))
 		}
-		// Lookahead
-		allNextRemovable := true
-		if y+lookaheadGap >= bounds.Max.Y {
-			allNextRemovable = false
-		} else {
-			for k := 1; k <= lookaheadGap; k++ {
-				if !isRowRemovable(y + k) {
-					allNextRemovable = false
-					break
-				}
-			}
+		snapped := snapBlocks(result, looseBackground)
+		if snapped != result {
+			tracef("snapped %v past block artifacts to %v", result, snapped)
+			result = snapped
 		}
-		if allNextRemovable {
-			minY = y + 1
-		} else {
-			break
+	}
+
+	// Discard stray marks such as a timestamp stamp in a corner by keeping
+	// only the largest connected region of content.
+	if opts.KeepLargest && !result.Empty() {
+		if largest := newContentMask(result, isBackground).LargestComponent(); !largest.Empty() {
+			tracef("largest content region %v", largest)
+			result = largest
 		}
 	}
 
-	// If whole image is removable (minY reached MaxY), return empty
-	if minY >= bounds.Max.Y {
-		return image.Rectangle{}
+	if opts.MinBorder > 0 {
+		result = dropThinTrims(bounds, result, opts.MinBorder)
 	}
 
-	// Scan MaxY (Bottom)
-	maxY = bounds.Max.Y
-	for y := bounds.Max.Y - 1; y >= minY; y-- {
-		if isRowRemovable(y) {
-			maxY = y
-			continue
-		}
-		// Lookahead (Upwards)
-		allPriorRemovable := true
-		if y-lookaheadGap < minY {
-			allPriorRemovable = false
-		} else {
-			for k := 1; k <= lookaheadGap; k++ {
-				if !isRowRemovable(y - k) {
-					allPriorRemovable = false
-					break
-				}
+	// A second band of the other background color just inside the first,
+	// e.g. a black frame inside a white mat, is peeled as well.
+	if opts.TwoColorBorder && !result.Empty() {
+		inner := clippedImage{img, result}
+		if innerMode := cornerConsensus(inner, lv); innerMode != ModeNone && innerMode != mode {
+			tracef("inner band is %s, peeling it", innerMode)
+			innerOpts := opts
+			innerOpts.TwoColorBorder = false
+			if d := detect(inner, innerOpts); !d.Bounds.Empty() {
+				result = d.Bounds
 			}
 		}
-		if allPriorRemovable {
-			maxY = y
-		} else {
-			break
-		}
 	}
+	tracef("content bounds %v", result)
+	return detection{Bounds: result, Mode: mode, Reason: reason, IsBackground: isBackground, Content: content(result)}
+}
 
-	// Scan MinX (Left)
-	minX = bounds.Min.X
-	for x := bounds.Min.X; x < bounds.Max.X; x++ {
-		if isColRemovable(x) {
-			minX = x + 1
-			continue
-		}
-		// Lookahead
-		allNextRemovable := true
-		if x+lookaheadGap >= bounds.Max.X {
-			allNextRemovable = false
-		} else {
-			for k := 1; k <= lookaheadGap; k++ {
-				if !isColRemovable(x + k) {
-					allNextRemovable = false
-					break
-				}
-			}
-		}
-		if allNextRemovable {
-			minX = x + 1
-		} else {
-			break
-		}
+// provenanceKey is the PNG tEXt keyword used by -embed-provenance.
+const provenanceKey = "CropInfo"
+
+// provenance describes a crop for embedding in the output file.
+func provenance(orig image.Point, crop image.Rectangle) string {
+	return fmt.Sprintf("cropped by gazounomawarinoiranaifuchiwokesu; original=%dx%d; crop=%d,%d,%d,%d",
+		orig.X, orig.Y, crop.Min.X, crop.Min.Y, crop.Max.X, crop.Max.Y)
+}
+
+// aspectChange returns the factor (>= 1) by which the aspect ratio of crop
+// differs from that of orig.
+func aspectChange(orig, crop image.Rectangle) float64 {
+	origAspect := float64(orig.Dx()) / float64(orig.Dy())
+	cropAspect := float64(crop.Dx()) / float64(crop.Dy())
+	if cropAspect > origAspect {
+		return cropAspect / origAspect
 	}
+	return origAspect / cropAspect
+}
 
-	// Scan MaxX (Right)
-	maxX = bounds.Max.X
-	for x := bounds.Max.X - 1; x >= minX; x-- {
-		if isColRemovable(x) {
-			maxX = x
-			continue
-		}
-		// Lookahead (Leftwards)
-		allPriorRemovable := true
-		if x-lookaheadGap < minX {
-			allPriorRemovable = false
-		} else {
-			for k := 1; k <= lookaheadGap; k++ {
-				if !isColRemovable(x - k) {
-					allPriorRemovable = false
-					break
-				}
+// jpegBlockSize is the size of the blocks JPEG compresses independently.
+const jpegBlockSize = 8
+
+// blockArtifactSlack is how many levels -snap-blocks loosens the background
+// thresholds by to see past JPEG block artifacts.
+const blockArtifactSlack = 40
+
+// snapBlocks moves each side of crop inward past up to jpegBlockSize lines
+// that are entirely background under isBackground, measured across crop.
+func snapBlocks(crop image.Rectangle, isBackground func(x, y int) bool) image.Rectangle {
+	rowClear := func(y int) bool {
+		for x := crop.Min.X; x < crop.Max.X; x++ {
+			if !isBackground(x, y) {
+				return false
 			}
 		}
-		if allPriorRemovable {
-			maxX = x
-		} else {
-			break
+		return true
+	}
+	colClear := func(x int) bool {
+		for y := crop.Min.Y; y < crop.Max.Y; y++ {
+			if !isBackground(x, y) {
+				return false
+			}
 		}
+		return true
+	}
+
+	for i := 0; i < jpegBlockSize && crop.Dy() > 1 && rowClear(crop.Min.Y); i++ {
+		crop.Min.Y++
+	}
+	for i := 0; i < jpegBlockSize && crop.Dy() > 1 && rowClear(crop.Max.Y-1); i++ {
+		crop.Max.Y--
+	}
+	for i := 0; i < jpegBlockSize && crop.Dx() > 1 && colClear(crop.Min.X); i++ {
+		crop.Min.X++
+	}
+	for i := 0; i < jpegBlockSize && crop.Dx() > 1 && colClear(crop.Max.X-1); i++ {
+		crop.Max.X--
+	}
+	return crop
+}
+
+// matchAspect grows crop along one axis to the aspect ratio of orig, so the
+// output has the same shape as the input. The grown rectangle stays centered
+// on crop where there is room and is shifted inward at the edges of limit.
+func matchAspect(orig, crop, limit image.Rectangle) image.Rectangle {
+	w, h := crop.Dx(), crop.Dy()
+	if w*orig.Dy() > h*orig.Dx() {
+		h = min(limit.Dy(), (w*orig.Dy()+orig.Dx()/2)/orig.Dx())
+	} else {
+		w = min(limit.Dx(), (h*orig.Dx()+orig.Dy()/2)/orig.Dy())
+	}
+
+	center := crop.Min.Add(crop.Max).Div(2)
+	x := max(limit.Min.X, min(center.X-w/2, limit.Max.X-w))
+	y := max(limit.Min.Y, min(center.Y-h/2, limit.Max.Y-h))
+	return image.Rect(x, y, x+w, y+h)
+}
+
+// dropThinTrims restores each side of crop whose trim from bounds is thinner
+// than minBorder pixels, so only "real" borders are removed.
+func dropThinTrims(bounds, crop image.Rectangle, minBorder int) image.Rectangle {
+	if crop.Min.Y-bounds.Min.Y < minBorder {
+		crop.Min.Y = bounds.Min.Y
+	}
+	if bounds.Max.Y-crop.Max.Y < minBorder {
+		crop.Max.Y = bounds.Max.Y
+	}
+	if crop.Min.X-bounds.Min.X < minBorder {
+		crop.Min.X = bounds.Min.X
 	}
+	if bounds.Max.X-crop.Max.X < minBorder {
+		crop.Max.X = bounds.Max.X
+	}
+	return crop
+}
+
+// saveOptions controls how saveImage encodes its output.
+type saveOptions struct {
+	// Text holds tEXt chunks to embed in PNG output. It is ignored for
+	// other formats.
+	Text map[string]string
+	// JPEGSubsampling is the chroma subsampling of JPEG output; see
+	// jpegSubsamplings.
+	JPEGSubsampling string
+	// Resolution is recorded in TIFF output, to keep the pixel aspect ratio
+	// of a TIFF input. Nil leaves the encoder's default.
+	Resolution *tiffResolution
+	// PNGBitDepth converts PNG output to 8 or 16 bits per channel; see
+	// convertPNGDepth. Zero writes the image's own depth.
+	PNGBitDepth int
+}
 
-	return image.Rect(minX, minY, maxX, maxY)
+// jpegSubsamplings lists the -jpeg-subsampling values. image/jpeg writes
+// 4:2:0; 4:4:4 goes through encodeJPEG444.
+var jpegSubsamplings = map[string]bool{
+	"420": true,
+	"444": true,
 }
 
-func cropImage(img image.Image, rect image.Rectangle) image.Image {
-	// For sub-image support (if the image implementation supports it)
-	if subImg, ok := img.(interface {
-		SubImage(r image.Rectangle) image.Image
-	}); ok {
-		return subImg.SubImage(rect)
+// saveImage writes img to path atomically, see writeFileAtomic.
+func saveImage(path string, img image.Image, format string, so saveOptions) error {
+	return writeFileAtomic(path, func(w io.Writer) error {
+		return encodeImage(w, img, format, so)
+	})
+}
+
+// writeVerified writes the image at path with write. With opts.Verify, it then
+// decodes the file back and checks it has the expected size, to catch disk
+// or encoder corruption; a failed check writes once more, and if that is
+// bad too the output is removed and an error returned.
+func writeVerified(path string, size image.Point, opts options, write func() error) error {
+	if err := write(); err != nil || !opts.Verify {
+		return err
+	}
+	err := verifyImage(path, size)
+	if err == nil {
+		return nil
 	}
 
-	// Fallback: create a new image and draw
-	dst := image.NewRGBA(rect.Sub(rect.Min))
-	draw.Draw(dst, dst.Bounds(), img, rect.Min, draw.Src)
-	return dst
+	opts.logf("  Verification of %s failed (%v), writing it again\n", filepath.Base(path), err)
+	if err := write(); err != nil {
+		return err
+	}
+	if err := verifyImage(path, size); err != nil {
+		os.Remove(path)
+		return fmt.Errorf("verification failed: %w", err)
+	}
+	return nil
 }
 
-func saveImage(path string, img image.Image, format string) error {
-	file, err := os.Create(path)
+// verifyImage decodes the image at path and checks that it has the given
+// size.
+func verifyImage(path string, size image.Point) error {
+	img, _, err := loadImage(path)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	if got := img.Bounds().Size(); got != size {
+		return fmt.Errorf("decoded size %v, expected %v", got, size)
+	}
+	return nil
+}
 
+// copyFile copies the file at src to dst atomically, see writeFileAtomic.
+func copyFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	return writeFileAtomic(dst, func(w io.Writer) error {
+		_, err := io.Copy(w, in)
+		return err
+	})
+}
+
+// writeFileAtomic writes path with write: the data goes into a temporary
+// file in the same directory, which is renamed over path only once complete,
+// so an interrupted or failed save never leaves a partial output behind.
+func writeFileAtomic(path string, write func(io.Writer) error) (err error) {
+	// The temporary name is hidden so a later run skips leftovers from a
+	// crash.
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			file.Close()
+			os.Remove(file.Name())
+		}
+	}()
+
+	// CreateTemp creates the file owner-only; outputs should be readable
+	// like any other file written by the tool.
+	if err := file.Chmod(0o644); err != nil {
+		return err
+	}
+	if err := write(file); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// encodeImage writes img to w in the named format.
+func encodeImage(w io.Writer, img image.Image, format string, so saveOptions) error {
 	switch format {
 	case "jpeg":
-		return jpeg.Encode(file, img, nil)
+		switch so.JPEGSubsampling {
+		case "", "420":
+			return jpeg.Encode(w, img, nil)
+		case "444":
+			return encodeJPEG444(w, img)
+		}
+		return fmt.Errorf("%w: JPEG subsampling %s", ErrUnsupportedFormat, so.JPEGSubsampling)
+	case "gif":
+		return gif.Encode(w, img, nil)
 	case "png":
-		return png.Encode(file, img)
+		img = convertPNGDepth(img, so.PNGBitDepth)
+		if len(so.Text) == 0 {
+			return png.Encode(w, img)
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			return err
+		}
+		keys := make([]string, 0, len(so.Text))
+		for k := range so.Text {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		data, err := insertPNGText(buf.Bytes(), keys, so.Text)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
 	default:
-		return fmt.Errorf("unsupported format: %s", format)
+		if f := formats[format]; f.Encode && f.encode != nil {
+			return f.encode(w, img, so)
+		}
+		return fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+	}
+}
+
+// sourceResolution returns the resolution recorded in the image file at
+// path if it is a TIFF (format "tiff"); other formats report none.
+func sourceResolution(path, format string) (tiffResolution, bool) {
+	if format != "tiff" {
+		return tiffResolution{}, false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return tiffResolution{}, false
 	}
+	return readTIFFResolution(data)
 }
@@ -1,154 +1,1731 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"image"
 	"image/color"
 	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io/fs"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"gazounomawarinoiranaifuchiwokesu/crop"
 )
 
-func TestIsBlack(t *testing.T) {
+func TestAspectChange(t *testing.T) {
 	tests := []struct {
 		name     string
-		color    color.Color
-		expected bool
+		orig     image.Rectangle
+		crop     image.Rectangle
+		expected float64
 	}{
-		{"Black", color.RGBA{0, 0, 0, 255}, true},
-		{"Near Black", color.RGBA{10, 10, 10, 255}, true},
-		{"Old Threshold Limit (15)", color.RGBA{15, 15, 15, 255}, true},
-		{"New Threshold Limit (60)", color.RGBA{60, 60, 60, 255}, true},
-		{"Above Check (61)", color.RGBA{61, 61, 61, 255}, false},
-		{"White", color.RGBA{255, 255, 255, 255}, false},
-		{"Red", color.RGBA{255, 0, 0, 255}, false},
+		{"Same Aspect", image.Rect(0, 0, 100, 50), image.Rect(10, 5, 90, 45), 1},
+		{"Wider", image.Rect(0, 0, 100, 100), image.Rect(0, 25, 100, 75), 2},
+		{"Taller", image.Rect(0, 0, 100, 100), image.Rect(25, 0, 75, 100), 2},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := isBlack(tt.color); got != tt.expected {
-				t.Errorf("isBlack() = %v, want %v", got, tt.expected)
+			if got := aspectChange(tt.orig, tt.crop); got != tt.expected {
+				t.Errorf("aspectChange() = %v, want %v", got, tt.expected)
 			}
 		})
 	}
 }
 
-func TestFindContentBounds(t *testing.T) {
-	// Helper to create a uniform image
-	createImage := func(w, h int, c color.Color) *image.RGBA {
-		img := image.NewRGBA(image.Rect(0, 0, w, h))
-		draw.Draw(img, img.Bounds(), &image.Uniform{c}, image.Point{}, draw.Src)
-		return img
+func TestMaxAspectChangeRejectsThinStrip(t *testing.T) {
+	// 100x100 black image with a thin white strip: detection crops to a
+	// 80x10 strip, which should be rejected as a distorting crop.
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(10, 45, 90, 55), &image.Uniform{color.White}, image.Point{}, draw.Src)
+
+	dir := t.TempDir()
+	writePNG(t, filepath.Join(dir, "strip.png"), img)
+
+	if _, err := processImage(filepath.Join(dir, "strip.png"), dir, "strip.png", options{MaxAspectChange: 3}); err != nil {
+		t.Fatalf("processImage() error = %v", err)
+	}
+
+	out := readPNG(t, filepath.Join(dir, "processed_strip.png"))
+	if got := out.Bounds(); got != img.Bounds() {
+		t.Errorf("Expected original bounds %v to be kept, got %v", img.Bounds(), got)
+	}
+
+	// Without the guard the strip crop goes through.
+	if _, err := processImage(filepath.Join(dir, "strip.png"), dir, "strip.png", options{}); err != nil {
+		t.Fatalf("processImage() error = %v", err)
+	}
+	out = readPNG(t, filepath.Join(dir, "processed_strip.png"))
+	if got, want := out.Bounds().Size(), image.Pt(80, 10); got != want {
+		t.Errorf("Expected strip size %v without guard, got %v", want, got)
+	}
+}
+
+// borderedImage returns a black-bordered image with white content at
+// content.
+func borderedImage(size, content image.Rectangle) *image.RGBA {
+	img := image.NewRGBA(size)
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, content, &image.Uniform{color.White}, image.Point{}, draw.Src)
+	return img
+}
+
+func writePNG(t *testing.T, path string, img image.Image) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readPNG(t *testing.T, path string) image.Image {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func TestDetectModeEdgeMidpointFallback(t *testing.T) {
+	// Black frame whose four corners are covered by colored overlays, so the
+	// corner vote is inconclusive and the edge midpoints must decide.
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(20, 20, 80, 80), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	red := color.RGBA{255, 0, 0, 255}
+	for _, r := range []image.Rectangle{
+		image.Rect(0, 0, 2, 2),
+		image.Rect(98, 0, 100, 2),
+		image.Rect(0, 98, 2, 100),
+		image.Rect(98, 98, 100, 100),
+	} {
+		draw.Draw(img, r, &image.Uniform{red}, image.Point{}, draw.Src)
+	}
+
+	if got := detectMode(img, defaultLevels); got != ModeBlack {
+		t.Errorf("detectMode() = %v, want ModeBlack", got)
+	}
+
+	expected := image.Rect(20, 20, 80, 80)
+	if bounds := findContentBounds(img, defaultLevels); bounds != expected {
+		t.Errorf("Expected %v, got %v", expected, bounds)
+	}
+}
+
+func TestMinContentFractionRejectsSpeck(t *testing.T) {
+	// A 10x10 speck on a 100x100 black image covers 1% of the area.
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(60, 30, 70, 40), &image.Uniform{color.White}, image.Point{}, draw.Src)
+
+	dir := t.TempDir()
+	writePNG(t, filepath.Join(dir, "speck.png"), img)
+
+	res, err := processImage(filepath.Join(dir, "speck.png"), dir, "speck.png", options{MinContentFraction: 0.05})
+	if err != nil {
+		t.Fatalf("processImage() error = %v", err)
+	}
+	if res.Bounds != img.Bounds() {
+		t.Errorf("Expected original bounds %v to be kept, got %v", img.Bounds(), res.Bounds)
+	}
+	if got := readPNG(t, filepath.Join(dir, "processed_speck.png")).Bounds(); got != img.Bounds() {
+		t.Errorf("Expected output bounds %v, got %v", img.Bounds(), got)
+	}
+
+	// A lower fraction lets the crop through.
+	res, err = processImage(filepath.Join(dir, "speck.png"), dir, "speck.png", options{MinContentFraction: 0.005})
+	if err != nil {
+		t.Fatalf("processImage() error = %v", err)
+	}
+	if expected := image.Rect(60, 30, 70, 40); res.Bounds != expected {
+		t.Errorf("Expected %v, got %v", expected, res.Bounds)
+	}
+}
+
+func TestProcessPathSingleFile(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(20, 20, 80, 80), &image.Uniform{color.White}, image.Point{}, draw.Src)
+
+	dir := t.TempDir()
+	writePNG(t, filepath.Join(dir, "one.png"), img)
+	writePNG(t, filepath.Join(dir, "other.png"), img)
+
+	if err := processPath(filepath.Join(dir, "one.png"), options{}); err != nil {
+		t.Fatalf("processPath() error = %v", err)
+	}
+
+	out := readPNG(t, filepath.Join(dir, "processed_one.png"))
+	if got, want := out.Bounds().Size(), image.Pt(60, 60); got != want {
+		t.Errorf("Expected size %v, got %v", want, got)
+	}
+
+	// Only the named file is processed, not its siblings.
+	if _, err := os.Stat(filepath.Join(dir, "processed_other.png")); !os.IsNotExist(err) {
+		t.Errorf("Expected processed_other.png not to exist, got err = %v", err)
+	}
+
+	if err := processPath(filepath.Join(dir, "missing.png"), options{}); err == nil {
+		t.Errorf("Expected error for missing file")
+	}
+}
+
+func TestProcessDirectoryHiddenFiles(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(20, 20, 80, 80), &image.Uniform{color.White}, image.Point{}, draw.Src)
+
+	dir := t.TempDir()
+	writePNG(t, filepath.Join(dir, ".screenshot.png"), img)
+	outPath := filepath.Join(dir, "processed_.screenshot.png")
+
+	if err := processDirectory(dir, options{}); err != nil {
+		t.Fatalf("processDirectory() error = %v", err)
+	}
+	if _, err := os.Stat(outPath); !os.IsNotExist(err) {
+		t.Errorf("Expected hidden file to be skipped by default, got err = %v", err)
+	}
+
+	if err := processDirectory(dir, options{IncludeHidden: true}); err != nil {
+		t.Fatalf("processDirectory() error = %v", err)
+	}
+	if _, err := os.Stat(outPath); err != nil {
+		t.Errorf("Expected hidden file to be processed with -hidden, got err = %v", err)
+	}
+}
+
+func TestProcessImageTruncatedJPEG(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(16, 16, 48, 48), &image.Uniform{color.White}, image.Point{}, draw.Src)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	// Simulate a partial download by dropping the second half of the file.
+	truncated := buf.Bytes()[:buf.Len()/2]
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "partial.jpg")
+	if err := os.WriteFile(path, truncated, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := processImage(path, dir, "partial.jpg", options{})
+	if !errors.Is(err, ErrDecode) {
+		t.Fatalf("Expected ErrDecode, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "processed_partial.jpg")); !os.IsNotExist(err) {
+		t.Errorf("Expected no output for a truncated image, got err = %v", err)
+	}
+
+	// With -move-bad the file is moved into the quarantine folder.
+	_, err = processImage(path, dir, "partial.jpg", options{MoveBad: true})
+	if !errors.Is(err, ErrDecode) {
+		t.Fatalf("Expected ErrDecode, got %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected original to be moved away, got err = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, quarantineDir, "partial.jpg")); err != nil {
+		t.Errorf("Expected file in quarantine, got err = %v", err)
+	}
+}
+
+func TestNoOpOnColorImages(t *testing.T) {
+	// A full-frame "photo" whose corners are neither black nor white.
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{90, 140, 200, 255}}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(30, 30, 70, 70), &image.Uniform{color.RGBA{200, 120, 40, 255}}, image.Point{}, draw.Src)
+
+	dir := t.TempDir()
+	writePNG(t, filepath.Join(dir, "photo.png"), img)
+	outPath := filepath.Join(dir, "processed_photo.png")
+
+	res, err := processImage(filepath.Join(dir, "photo.png"), dir, "photo.png", options{NoOpOnColorImages: true})
+	if err != nil {
+		t.Fatalf("processImage() error = %v", err)
+	}
+	if res.Mode != ModeNone || res.Output != "" {
+		t.Errorf("Expected ModeNone with no output, got mode %v output %q", res.Mode, res.Output)
+	}
+	if _, err := os.Stat(outPath); !os.IsNotExist(err) {
+		t.Errorf("Expected no output file, got err = %v", err)
+	}
+
+	// By default an identical copy is still written.
+	if _, err := processImage(filepath.Join(dir, "photo.png"), dir, "photo.png", options{}); err != nil {
+		t.Fatalf("processImage() error = %v", err)
+	}
+	if _, err := os.Stat(outPath); err != nil {
+		t.Errorf("Expected output file by default, got err = %v", err)
+	}
+}
+
+func TestKeepUniform(t *testing.T) {
+	// A solid pale-blue placeholder: every edge is (white-ish) background.
+	img := image.NewRGBA(image.Rect(0, 0, 40, 30))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{220, 235, 250, 255}}, image.Point{}, draw.Src)
+
+	dir := t.TempDir()
+	writePNG(t, filepath.Join(dir, "placeholder.png"), img)
+
+	if _, err := processImage(filepath.Join(dir, "placeholder.png"), dir, "placeholder.png", options{}); err == nil {
+		t.Fatalf("Expected uniform image to fail without -keep-uniform")
 	}
 
-	// Helper to draw a rect
-	drawRect := func(img *image.RGBA, x0, y0, x1, y1 int, c color.Color) {
-		draw.Draw(img, image.Rect(x0, y0, x1, y1), &image.Uniform{c}, image.Point{}, draw.Src)
+	res, err := processImage(filepath.Join(dir, "placeholder.png"), dir, "placeholder.png", options{KeepUniform: true})
+	if err != nil {
+		t.Fatalf("processImage() error = %v", err)
+	}
+	if res.Bounds != img.Bounds() {
+		t.Errorf("Expected original bounds %v, got %v", img.Bounds(), res.Bounds)
 	}
+	if got := readPNG(t, filepath.Join(dir, "processed_placeholder.png")).Bounds(); got != img.Bounds() {
+		t.Errorf("Expected output bounds %v, got %v", img.Bounds(), got)
+	}
+}
 
-	t.Run("Black Border with White Content", func(t *testing.T) {
-		// 100x100 black image (target: Black)
-		img := createImage(100, 100, color.Black)
-		// White content rect at (20,20)-(80,80)
-		// Even if content is white, it should NOT be cropped because target is Black.
-		// Wait, if target is Black, white pixels are "content".
-		drawRect(img, 20, 20, 80, 80, color.White)
+func TestDetectOnlyBorderWidth(t *testing.T) {
+	t.Run("Bordered Images Unchanged", func(t *testing.T) {
+		for _, content := range []image.Rectangle{
+			image.Rect(20, 20, 80, 80),
+			image.Rect(0, 30, 100, 100), // border on the top only
+			image.Rect(3, 3, 97, 97),    // border thinner than the band
+		} {
+			img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+			draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+			draw.Draw(img, content, &image.Uniform{color.White}, image.Point{}, draw.Src)
 
-		bounds := findContentBounds(img)
-		// Expect crop to the white box
-		expected := image.Rect(20, 20, 80, 80)
-		if bounds != expected {
-			t.Errorf("Expected %v, got %v", expected, bounds)
+			full := detect(img, options{}).Bounds
+			prechecked := detect(img, options{DetectOnlyBorderWidth: 5}).Bounds
+			if full != prechecked {
+				t.Errorf("content %v: pre-check changed result from %v to %v", content, full, prechecked)
+			}
 		}
 	})
 
-	t.Run("White Border with Black Content", func(t *testing.T) {
-		// 100x100 white image (target: White)
-		img := createImage(100, 100, color.White)
-		// Black content rect at (20,20)-(80,80)
-		// Target is White, so Black pixels are content.
-		drawRect(img, 20, 20, 80, 80, color.Black)
-
-		bounds := findContentBounds(img)
-		expected := image.Rect(20, 20, 80, 80)
-		if bounds != expected {
-			t.Errorf("Expected %v, got %v", expected, bounds)
+	t.Run("Full Bleed Returns Original", func(t *testing.T) {
+		// Black corners, but the edges are otherwise content.
+		img := fullBleedImage()
+		got := detect(img, options{DetectOnlyBorderWidth: 5})
+		if got.Bounds != img.Bounds() {
+			t.Errorf("Expected full bounds %v, got %v", img.Bounds(), got.Bounds)
 		}
 	})
+}
 
-	t.Run("Mixed Background (Ambiguous)", func(t *testing.T) {
-		// If corners are mixed, we expect NO cropping (safe fallback).
-		img := createImage(100, 100, color.Gray16{Y: 30000}) // Gray
-		// TopLeft: Black
-		img.Set(0, 0, color.Black)
-		// BottomRight: White
-		img.Set(99, 99, color.White)
+// fullBleedImage returns a 1000x1000 image whose content runs to the edges
+// apart from black corner pixels, so the corner vote picks ModeBlack.
+func fullBleedImage() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 1000, 1000))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{120, 160, 90, 255}}, image.Point{}, draw.Src)
+	for _, p := range []image.Point{{0, 0}, {999, 0}, {0, 999}, {999, 999}} {
+		img.Set(p.X, p.Y, color.Black)
+	}
+	return img
+}
 
-		bounds := findContentBounds(img)
-		expected := image.Rect(0, 0, 100, 100)
-		if bounds != expected {
-			t.Errorf("Expected full image %v, got %v", expected, bounds)
+func BenchmarkFindContentBoundsFullBleed(b *testing.B) {
+	img := fullBleedImage()
+	b.Run("FullScan", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			detect(img, options{})
+		}
+	})
+	b.Run("PreCheck", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			detect(img, options{DetectOnlyBorderWidth: 5})
 		}
 	})
+}
 
-	t.Run("Black Border protects White Content edge", func(t *testing.T) {
-		// Scenario: Black border, but inside there is a White block touching the crop edge.
-		// If we didn't lock the mode to Black, the White block might be eaten if we treated White as removable too.
-		img := createImage(100, 100, color.Black)
-		// Draw White Content at (10, 10) to (90, 90)
-		drawRect(img, 10, 10, 90, 90, color.White)
+func TestFindContentBoundsAndCentroid(t *testing.T) {
+	// Off-center L-shaped content: a 40x10 bar and a 10x30 bar below its
+	// left end.
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(50, 10, 90, 20), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(50, 20, 60, 50), &image.Uniform{color.White}, image.Point{}, draw.Src)
 
-		// Corners are Black (0,0), (99,0) etc. -> Mode = Black.
-		// Process should remove black border 0-10.
-		// At y=10, row becomes White.
-		// Since Mode=Black, White pixels are NOT removable.
-		// So cropping should stop exactly at 10.
+	bounds, c, ok := findContentBoundsAndCentroid(img, options{})
+	if !ok {
+		t.Fatalf("Expected a centroid")
+	}
+	if expected := image.Rect(50, 10, 90, 50); bounds != expected {
+		t.Errorf("Expected bounds %v, got %v", expected, bounds)
+	}
+
+	// Bar: 400 px centered at (69.5, 14.5); stem: 300 px centered at (54.5, 34.5).
+	expected := centroid{
+		X: (400*69.5 + 300*54.5) / 700,
+		Y: (400*14.5 + 300*34.5) / 700,
+	}
+	if math.Abs(c.X-expected.X) > 1e-9 || math.Abs(c.Y-expected.Y) > 1e-9 {
+		t.Errorf("Expected centroid %v, got %v", expected, c)
+	}
+}
+
+func TestContentCentroidColoredBackground(t *testing.T) {
+	// The L of TestFindContentBoundsAndCentroid on a teal matte: the
+	// matte inside the bounds is background, not content.
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{40, 120, 140, 255}}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(50, 10, 90, 20), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(50, 20, 60, 50), &image.Uniform{color.White}, image.Point{}, draw.Src)
+
+	bounds, c, ok := findContentBoundsAndCentroid(img, options{CornerAgreementTolerance: 16})
+	if !ok || bounds != image.Rect(50, 10, 90, 50) {
+		t.Fatalf("Expected bounds (50,10)-(90,50) and a centroid, got %v, %v", bounds, ok)
+	}
+	expected := centroid{
+		X: (400*69.5 + 300*54.5) / 700,
+		Y: (400*14.5 + 300*34.5) / 700,
+	}
+	if math.Abs(c.X-expected.X) > 1e-9 || math.Abs(c.Y-expected.Y) > 1e-9 {
+		t.Errorf("Expected centroid %v, got %v", expected, c)
+	}
+
+	det := detect(img, options{CornerAgreementTolerance: 16})
+	r, ok := contentRotatedRect(img.Bounds(), det.isBackground)
+	if !ok || r.Width != 40 || r.Height != 40 {
+		t.Errorf("Expected the 40x40 rotated rectangle of the L, got %+v", r)
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected time.Time
+		wantErr  bool
+	}{
+		{"2024-05-01T12:00:00Z", time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), false},
+		{"@1700000000", time.Unix(1700000000, 0), false},
+		{"@soon", time.Time{}, true},
+		{"yesterday", time.Time{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseTimestamp(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTimestamp(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if !got.Equal(tt.expected) {
+				t.Errorf("parseTimestamp(%q) = %v, want %v", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestModifiedSince(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(20, 20, 80, 80), &image.Uniform{color.White}, image.Point{}, draw.Src)
 
-		bounds := findContentBounds(img)
-		expected := image.Rect(10, 10, 90, 90)
-		if bounds != expected {
-			t.Errorf("Expected %v, got %v", expected, bounds)
+	dir := t.TempDir()
+	cutoff := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mtimes := map[string]time.Time{
+		"old.png":    cutoff.Add(-24 * time.Hour),
+		"exact.png":  cutoff,
+		"recent.png": cutoff.Add(24 * time.Hour),
+	}
+	for name, mtime := range mtimes {
+		path := filepath.Join(dir, name)
+		writePNG(t, path, img)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
 		}
-	})
+	}
+
+	if err := processDirectory(dir, options{ModifiedSince: cutoff}); err != nil {
+		t.Fatalf("processDirectory() error = %v", err)
+	}
+
+	for name, want := range map[string]bool{"old.png": false, "exact.png": true, "recent.png": true} {
+		_, err := os.Stat(filepath.Join(dir, "processed_"+name))
+		if got := err == nil; got != want {
+			t.Errorf("%s processed = %v, want %v", name, got, want)
+		}
+	}
+}
 
-	t.Run("Noise Tolerance (Black Border)", func(t *testing.T) {
-		// 100x100 Black
-		img := createImage(100, 100, color.Black)
-		// Content
-		drawRect(img, 20, 20, 80, 80, color.White)
+func TestErrorKinds(t *testing.T) {
+	dir := t.TempDir()
 
-		// Add noise to the black border (e.g. at y=5, put some white dots)
-		// 95% tolerance means in a 100px row, we can have up to 5 bad pixels.
-		for x := 0; x < 4; x++ {
-			img.Set(x, 5, color.White)
+	t.Run("Decode", func(t *testing.T) {
+		path := filepath.Join(dir, "broken.png")
+		if err := os.WriteFile(path, append([]byte(nil), pngSignature...), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := processImage(path, dir, "broken.png", options{})
+		if !errors.Is(err, ErrDecode) {
+			t.Errorf("Expected ErrDecode, got %v", err)
 		}
+	})
 
-		bounds := findContentBounds(img)
-		expected := image.Rect(20, 20, 80, 80)
-		if bounds != expected {
-			t.Errorf("Expected %v, got %v", expected, bounds)
+	t.Run("Unsupported Input", func(t *testing.T) {
+		path := filepath.Join(dir, "notes.txt")
+		if err := os.WriteFile(path, []byte("hello"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		_, _, err := loadImage(path)
+		if !errors.Is(err, ErrUnsupportedFormat) {
+			t.Errorf("Expected ErrUnsupportedFormat, got %v", err)
 		}
 	})
 
-	t.Run("Lookahead Gap (Skipping dirty lines)", func(t *testing.T) {
-		// 100x100 Black
-		img := createImage(100, 100, color.Black)
-		// Content starts at 30
-		drawRect(img, 30, 30, 70, 70, color.White)
+	t.Run("Unsupported Output", func(t *testing.T) {
+		err := saveImage(filepath.Join(dir, "out.bmp"), image.NewRGBA(image.Rect(0, 0, 1, 1)), "bmp", saveOptions{})
+		if !errors.Is(err, ErrUnsupportedFormat) {
+			t.Errorf("Expected ErrUnsupportedFormat, got %v", err)
+		}
+	})
 
-		// Dirty line at y=10 (Full white line)
-		// This line is NOT removable (it's 100% white, and mode is Black).
-		// But it's followed by 19 lines of pure Black (11 to 29).
-		// Logic with lookaheadGap=5 should skip this single dirty line IF lookahead sees removable lines.
-		// Wait, lookaheadGap=5 checks only next 5 lines.
-		// The lines 11,12,13,14,15 are Black (Removable).
-		// So y=10 should be skipped.
-		for x := 0; x < 100; x++ {
-			img.Set(x, 10, color.White)
+	t.Run("Empty Crop", func(t *testing.T) {
+		img := image.NewRGBA(image.Rect(0, 0, 10, 10))
+		draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+		path := filepath.Join(dir, "black.png")
+		writePNG(t, path, img)
+		_, err := processImage(path, dir, "black.png", options{})
+		if !errors.Is(err, ErrEmptyCrop) {
+			t.Errorf("Expected ErrEmptyCrop, got %v", err)
 		}
+	})
 
-		bounds := findContentBounds(img)
-		expected := image.Rect(30, 30, 70, 70)
-		if bounds != expected {
-			t.Errorf("Expected %v, got %v", expected, bounds)
+	t.Run("IO", func(t *testing.T) {
+		_, err := processImage(filepath.Join(dir, "missing.png"), dir, "missing.png", options{})
+		if err == nil || errors.Is(err, ErrDecode) || errors.Is(err, ErrUnsupportedFormat) || errors.Is(err, ErrEmptyCrop) {
+			t.Errorf("Expected a plain I/O error, got %v", err)
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Expected fs.ErrNotExist, got %v", err)
 		}
 	})
 }
+
+func TestVignetteTolerance(t *testing.T) {
+	// A thin black frame, then a dark-gray vignette band that a strict
+	// threshold keeps, around bright content with a dark detail in the middle.
+	vignette := color.RGBA{80, 80, 80, 255}
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(5, 5, 95, 95), &image.Uniform{vignette}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(30, 30, 70, 70), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(45, 45, 55, 55), &image.Uniform{vignette}, image.Point{}, draw.Src)
+
+	if got, expected := detect(img, options{}).Bounds, image.Rect(5, 5, 95, 95); got != expected {
+		t.Errorf("Strict threshold: expected %v, got %v", expected, got)
+	}
+	if got, expected := detect(img, options{VignetteTolerance: 100}).Bounds, image.Rect(30, 30, 70, 70); got != expected {
+		t.Errorf("Vignette tolerance: expected %v, got %v", expected, got)
+	}
+
+	// The same dark gray is background near the frame but content at the
+	// center.
+	bounds := img.Bounds()
+	if !isBackgroundColor(vignette, ModeBlack, defaultLevels.Loosen(vignetteSlack(bounds, 10, 10, 100))) {
+		t.Errorf("Expected vignette pixel near the corner to be background")
+	}
+	if isBackgroundColor(vignette, ModeBlack, defaultLevels.Loosen(vignetteSlack(bounds, 50, 50, 100))) {
+		t.Errorf("Expected dark pixel at the center to be content")
+	}
+}
+
+func TestMinBorder(t *testing.T) {
+	// A 2px border on the left and a 20px border elsewhere.
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(2, 20, 80, 80), &image.Uniform{color.White}, image.Point{}, draw.Src)
+
+	if got, expected := detect(img, options{}).Bounds, image.Rect(2, 20, 80, 80); got != expected {
+		t.Errorf("Without -min-border: expected %v, got %v", expected, got)
+	}
+	if got, expected := detect(img, options{MinBorder: 5}).Bounds, image.Rect(0, 20, 80, 80); got != expected {
+		t.Errorf("With -min-border 5: expected %v, got %v", expected, got)
+	}
+}
+
+func TestDespeckle(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(30, 30, 70, 70), &image.Uniform{color.White}, image.Point{}, draw.Src)
+
+	// Dust: six isolated 1px specks on each of rows 5-10, enough to make
+	// those rows non-removable and defeat the lookahead.
+	for y := 5; y <= 10; y++ {
+		for k := 0; k < 6; k++ {
+			img.Set(5+15*k+2*(y-5), y, color.White)
+		}
+	}
+
+	if got := detect(img, options{}).Bounds; got.Min.Y > 10 {
+		t.Fatalf("Expected specks to block trimming without -despeckle, got %v", got)
+	}
+	if got, expected := detect(img, options{Despeckle: 1}).Bounds, image.Rect(30, 30, 70, 70); got != expected {
+		t.Errorf("With -despeckle 1: expected %v, got %v", expected, got)
+	}
+}
+
+func TestStrictCorners(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(20, 20, 80, 80), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	// Only three of the four corners are black.
+	img.Set(99, 0, color.White)
+
+	res, err := planCrop(img, options{})
+	if err != nil {
+		t.Fatalf("planCrop() error = %v", err)
+	}
+	if expected := image.Rect(20, 20, 80, 80); res.Bounds != expected || res.Status != "cropped" {
+		t.Errorf("Without -strict-corners: expected %v cropped, got %v %q", expected, res.Bounds, res.Status)
+	}
+
+	res, err = planCrop(img, options{StrictCorners: true})
+	if err != nil {
+		t.Fatalf("planCrop() error = %v", err)
+	}
+	if res.Bounds != img.Bounds() || res.Status != "skipped: corners disagree" {
+		t.Errorf("With -strict-corners: expected %v skipped, got %v %q", img.Bounds(), res.Bounds, res.Status)
+	}
+
+	img.Set(99, 0, color.Black)
+	if res, _ := planCrop(img, options{StrictCorners: true}); res.Status != "cropped" {
+		t.Errorf("Expected agreeing corners to crop, got %q", res.Status)
+	}
+
+	// Corners of an agreeing color are background of the color mode.
+	matte := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(matte, matte.Bounds(), &image.Uniform{color.RGBA{40, 120, 140, 255}}, image.Point{}, draw.Src)
+	draw.Draw(matte, image.Rect(20, 20, 80, 80), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	res, err = planCrop(matte, options{StrictCorners: true, CornerAgreementTolerance: 16})
+	if err != nil {
+		t.Fatalf("planCrop() error = %v", err)
+	}
+	if expected := image.Rect(20, 20, 80, 80); res.Bounds != expected || res.Status != "cropped" {
+		t.Errorf("Colored matte with -strict-corners: expected %v cropped, got %v %q", expected, res.Bounds, res.Status)
+	}
+}
+
+func TestMinWhiteRatio(t *testing.T) {
+	// A document: white page with lines of black text and a white margin.
+	doc := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(doc, doc.Bounds(), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	for y := 20; y < 80; y += 10 {
+		draw.Draw(doc, image.Rect(15, y, 85, y+3), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	}
+
+	// A photo: mid-tone content inside a thin white frame.
+	photo := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(photo, photo.Bounds(), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	draw.Draw(photo, image.Rect(5, 5, 95, 95), &image.Uniform{color.RGBA{120, 90, 60, 255}}, image.Point{}, draw.Src)
+
+	opts := options{MinWhiteRatio: 0.5}
+
+	res, err := planCrop(doc, opts)
+	if err != nil {
+		t.Fatalf("planCrop(doc) error = %v", err)
+	}
+	if expected := image.Rect(15, 20, 85, 73); res.Status != "cropped" || res.Bounds != expected {
+		t.Errorf("Document: expected %v cropped, got %v %q", expected, res.Bounds, res.Status)
+	}
+
+	res, err = planCrop(photo, opts)
+	if err != nil {
+		t.Fatalf("planCrop(photo) error = %v", err)
+	}
+	if !strings.HasPrefix(res.Status, "skipped: white ratio") || res.Bounds != photo.Bounds() {
+		t.Errorf("Photo: expected to be skipped, got %v %q", res.Bounds, res.Status)
+	}
+}
+
+func TestDebugTrace(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 20, 20))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(5, 4, 15, 16), &image.Uniform{color.White}, image.Point{}, draw.Src)
+
+	var buf bytes.Buffer
+	if got := detect(img, options{trace: &buf}).Bounds; got != image.Rect(5, 4, 15, 16) {
+		t.Fatalf("Unexpected bounds %v", got)
+	}
+	trace := buf.String()
+	for _, line := range []string{
+		"  trace: background mode black (detected-black)\n",
+		"  trace: row 3: 20/20 background, removable=true\n",
+		"  trace: row 4: 10/20 background, removable=false\n",
+		"  trace: row 5: 10/20 background, removable=false\n",
+		"  trace: top: lookahead past row 4 failed, stopping\n",
+		"  trace: col 15: 12/12 background, removable=true\n",
+		"  trace: right: lookahead past col 14 failed, stopping\n",
+		"  trace: content bounds (5,4)-(15,16)\n",
+	} {
+		if !strings.Contains(trace, line) {
+			t.Errorf("Expected trace to contain %q", line)
+		}
+	}
+
+	// Only the file named by TraceFile is traced.
+	dir := t.TempDir()
+	writePNG(t, filepath.Join(dir, "a.png"), img)
+	writePNG(t, filepath.Join(dir, "b.png"), img)
+	buf.Reset()
+	saved := logOutput
+	logOutput = &buf
+	defer func() { logOutput = saved }()
+	if err := processDirectory(dir, options{DebugTrace: true, TraceFile: "b.png"}); err != nil {
+		t.Fatalf("processDirectory() error = %v", err)
+	}
+	out := buf.String()
+	if i, j := strings.Index(out, "Processing: b.png"), strings.Index(out, "trace:"); j < 0 || j < i {
+		t.Errorf("Expected trace output only for b.png, got:\n%s", out)
+	}
+}
+
+func TestJPEGSubsampling(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 40, 40))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(8, 8, 32, 32), &image.Uniform{color.RGBA{200, 40, 40, 255}}, image.Point{}, draw.Src)
+	var in bytes.Buffer
+	if err := png.Encode(&in, img); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		mode     string
+		sampling byte
+	}{
+		{"420", 0x22},
+		{"444", 0x11},
+	} {
+		var out bytes.Buffer
+		if _, err := cropStream(bytes.NewReader(in.Bytes()), &out, options{Format: "jpeg", JPEGSubsampling: tt.mode}); err != nil {
+			t.Fatalf("%s: cropStream() error = %v", tt.mode, err)
+		}
+		if got := jpegLumaSampling(t, out.Bytes()); got != tt.sampling {
+			t.Errorf("%s: expected luma sampling factors %#x, got %#x", tt.mode, tt.sampling, got)
+		}
+		decoded, err := jpeg.Decode(&out)
+		if err != nil {
+			t.Fatalf("%s: decoding output: %v", tt.mode, err)
+		}
+		if size := decoded.Bounds().Size(); size != image.Pt(24, 24) {
+			t.Errorf("%s: expected 24x24 output, got %v", tt.mode, size)
+		}
+		if r, g, b, _ := decoded.At(12, 12).RGBA(); r>>8 < 180 || g>>8 > 60 || b>>8 > 60 {
+			t.Errorf("%s: expected the red content, got %d,%d,%d", tt.mode, r>>8, g>>8, b>>8)
+		}
+	}
+
+	// 4:4:4 keeps one-pixel chroma detail that 4:2:0 averages away.
+	stripes := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			c := color.RGBA{255, 0, 0, 255}
+			if x%2 == 1 {
+				c = color.RGBA{0, 0, 255, 255}
+			}
+			stripes.SetRGBA(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := encodeImage(&buf, stripes, "jpeg", saveOptions{JPEGSubsampling: "444"}); err != nil {
+		t.Fatalf("encodeImage(444) error = %v", err)
+	}
+	decoded, err := jpeg.Decode(&buf)
+	if err != nil {
+		t.Fatalf("Decoding 4:4:4 output: %v", err)
+	}
+	if ycc, ok := decoded.(*image.YCbCr); !ok || ycc.SubsampleRatio != image.YCbCrSubsampleRatio444 {
+		t.Errorf("Expected a 4:4:4 YCbCr image, got %T", decoded)
+	}
+	if r, _, b, _ := decoded.At(4, 4).RGBA(); r <= b {
+		t.Errorf("Expected a red stripe at x=4, got r=%d b=%d", r>>8, b>>8)
+	}
+	if r, _, b, _ := decoded.At(5, 4).RGBA(); b <= r {
+		t.Errorf("Expected a blue stripe at x=5, got r=%d b=%d", r>>8, b>>8)
+	}
+
+	// Library callers that skip the flag validation get an error rather
+	// than a silently subsampled image.
+	err = encodeImage(&bytes.Buffer{}, img, "jpeg", saveOptions{JPEGSubsampling: "411"})
+	if !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("Expected ErrUnsupportedFormat for 411, got %v", err)
+	}
+}
+
+// jpegLumaSampling returns the sampling factors byte of the first component
+// in a baseline JPEG's SOF0 segment.
+func jpegLumaSampling(t *testing.T, data []byte) byte {
+	t.Helper()
+	i := bytes.Index(data, []byte{0xFF, 0xC0})
+	if i < 0 || i+12 > len(data) {
+		t.Fatal("No SOF0 segment in JPEG output")
+	}
+	// Marker(2) length(2) precision(1) height(2) width(2) count(1) id(1).
+	return data[i+11]
+}
+
+func TestSaveImageAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "processed_a.png")
+	img := image.NewRGBA(image.Rect(0, 0, 10, 10))
+
+	// An encode failure leaves neither the output nor a temporary file.
+	if err := saveImage(path, img, "bmp", saveOptions{}); err == nil {
+		t.Fatal("Expected an error for an unencodable format")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected no files after a failed save, got %v", entries)
+	}
+
+	// A failed save doesn't clobber an existing output either.
+	if err := saveImage(path, img, "png", saveOptions{}); err != nil {
+		t.Fatalf("saveImage() error = %v", err)
+	}
+	if err := saveImage(path, img, "bmp", saveOptions{}); err == nil {
+		t.Fatal("Expected an error for an unencodable format")
+	}
+	if got := readPNG(t, path).Bounds().Size(); got != image.Pt(10, 10) {
+		t.Errorf("Expected the earlier output to survive, got size %v", got)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("Expected only the output file, got %v", entries)
+	}
+}
+
+func TestAlphaThreshold(t *testing.T) {
+	// Opaque white content with a 3px feathered edge on a transparent
+	// background.
+	img := image.NewNRGBA(image.Rect(0, 0, 100, 100))
+	for i, alpha := range []uint8{40, 80, 120} {
+		r := image.Rect(27+i, 27+i, 73-i, 73-i)
+		draw.Draw(img, r, &image.Uniform{color.NRGBA{255, 255, 255, alpha}}, image.Point{}, draw.Src)
+	}
+	draw.Draw(img, image.Rect(30, 30, 70, 70), &image.Uniform{color.White}, image.Point{}, draw.Src)
+
+	if got := detect(img, options{}).Bounds; got == image.Rect(30, 30, 70, 70) {
+		t.Fatalf("Expected the feathered edge to be kept without -alpha-threshold, got %v", got)
+	}
+	if got, expected := detect(img, options{AlphaThreshold: 128}).Bounds, image.Rect(30, 30, 70, 70); got != expected {
+		t.Errorf("With -alpha-threshold 128: expected %v, got %v", expected, got)
+	}
+}
+
+func TestTransparentMode(t *testing.T) {
+	// The feathered image of TestAlphaThreshold: its corners are fully
+	// transparent, so the transparent mode trims below alpha 128, or
+	// below -alpha-threshold if set.
+	img := image.NewNRGBA(image.Rect(0, 0, 100, 100))
+	for i, alpha := range []uint8{40, 80, 120} {
+		r := image.Rect(27+i, 27+i, 73-i, 73-i)
+		draw.Draw(img, r, &image.Uniform{color.NRGBA{255, 255, 255, alpha}}, image.Point{}, draw.Src)
+	}
+	draw.Draw(img, image.Rect(30, 30, 70, 70), &image.Uniform{color.White}, image.Point{}, draw.Src)
+
+	for _, tt := range []struct {
+		opts     options
+		expected image.Rectangle
+	}{
+		{options{}, image.Rect(30, 30, 70, 70)},
+		{options{Mode: "transparent"}, image.Rect(30, 30, 70, 70)},
+		{options{Mode: "transparent", AlphaThreshold: 60}, image.Rect(28, 28, 72, 72)},
+		{options{Mode: "transparent", AlphaThreshold: 60, SnapBlocks: true}, image.Rect(28, 28, 72, 72)},
+	} {
+		res, err := planCrop(img, tt.opts)
+		if err != nil {
+			t.Fatalf("%+v: planCrop() error = %v", tt.opts, err)
+		}
+		if res.Bounds != tt.expected || res.ModeReason != "transparent-corners" {
+			t.Errorf("%+v: expected %v (transparent-corners), got %v (%s)", tt.opts, tt.expected, res.Bounds, res.ModeReason)
+		}
+	}
+
+	// Padding a transparent image keeps the padding transparent.
+	res, err := planCrop(img, options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := backgroundFill(img, res.Mode, res.isBackground); got != color.Transparent {
+		t.Errorf("backgroundFill() = %v, want transparent", got)
+	}
+}
+
+func TestTransparentBorder(t *testing.T) {
+	// A dark sticker with a semi-transparent shadow and a transparent hole
+	// on a transparent margin.
+	img := image.NewNRGBA(image.Rect(0, 0, 80, 60))
+	sticker := image.Rect(10, 15, 70, 45)
+	draw.Draw(img, sticker, &image.Uniform{color.NRGBA{20, 20, 30, 255}}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(10, 40, 70, 45), &image.Uniform{color.NRGBA{0, 0, 0, 150}}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(30, 25, 50, 35), &image.Uniform{color.Transparent}, image.Point{}, draw.Src)
+
+	dir := t.TempDir()
+	writePNG(t, filepath.Join(dir, "sticker.png"), img)
+	res, err := processImage(filepath.Join(dir, "sticker.png"), dir, "sticker.png", options{})
+	if err != nil {
+		t.Fatalf("processImage() error = %v", err)
+	}
+	if res.ModeReason != "transparent-corners" {
+		t.Errorf("Expected the transparent corners to decide the mode, got %s", res.ModeReason)
+	}
+
+	// Only the margin goes, and every pixel keeps its alpha.
+	out := readPNG(t, filepath.Join(dir, "processed_sticker.png"))
+	if got := out.Bounds().Size(); got != sticker.Size() {
+		t.Fatalf("Expected a %v output, got %v", sticker.Size(), got)
+	}
+	for y := 0; y < sticker.Dy(); y++ {
+		for x := 0; x < sticker.Dx(); x++ {
+			got := color.NRGBAModel.Convert(out.At(out.Bounds().Min.X+x, out.Bounds().Min.Y+y))
+			if want := img.NRGBAAt(sticker.Min.X+x, sticker.Min.Y+y); got != want {
+				t.Fatalf("Pixel (%d, %d): expected %v, got %v", x, y, want, got)
+			}
+		}
+	}
+}
+
+func TestIgnoreProtrusions(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(20, 20, 60, 60), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	// A 6px connector line running from the block into the right margin,
+	// too thick for the noise tolerance to ignore.
+	draw.Draw(img, image.Rect(60, 38, 95, 44), &image.Uniform{color.White}, image.Point{}, draw.Src)
+
+	if got, expected := detect(img, options{}).Bounds, image.Rect(20, 20, 95, 60); got != expected {
+		t.Fatalf("Without -ignore-protrusions: expected %v, got %v", expected, got)
+	}
+	if got, expected := detect(img, options{IgnoreProtrusions: 10}).Bounds, image.Rect(20, 20, 60, 60); got != expected {
+		t.Errorf("With -ignore-protrusions 10: expected %v, got %v", expected, got)
+	}
+}
+
+func TestIsSupportedImageExtensionFallback(t *testing.T) {
+	dir := t.TempDir()
+	img := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	writePNG(t, filepath.Join(dir, "valid.png"), img)
+	writePNG(t, filepath.Join(dir, "valid.dat"), img)
+	if err := os.WriteFile(filepath.Join(dir, "bogus.png"), []byte("not really a png"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// Only the header is checked here; a truncated body is processImage's
+	// problem.
+	full, err := os.ReadFile(filepath.Join(dir, "valid.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "truncated.png"), full[:len(full)/2], 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a header the sniffer doesn't recognize.
+	saved := detectContentType
+	detectContentType = func([]byte) string { return "application/octet-stream" }
+	defer func() { detectContentType = saved }()
+
+	tests := []struct {
+		name     string
+		expected bool
+	}{
+		{"valid.png", true},  // known extension, decodes
+		{"valid.dat", false}, // decodes, but the extension isn't an image's
+		{"bogus.png", false}, // known extension, doesn't decode
+		{"truncated.png", true},
+	}
+	for _, tt := range tests {
+		if got := isSupportedImage(filepath.Join(dir, tt.name)); got != tt.expected {
+			t.Errorf("isSupportedImage(%s) = %v, want %v", tt.name, got, tt.expected)
+		}
+	}
+}
+
+func TestTwoColorBorder(t *testing.T) {
+	// A white mat around a black frame around gray content.
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(10, 10, 90, 90), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(20, 20, 80, 80), &image.Uniform{color.Gray{128}}, image.Point{}, draw.Src)
+
+	if got, expected := detect(img, options{}).Bounds, image.Rect(10, 10, 90, 90); got != expected {
+		t.Errorf("Without -two-color-border: expected %v, got %v", expected, got)
+	}
+	det := detect(img, options{TwoColorBorder: true})
+	if expected := image.Rect(20, 20, 80, 80); det.Bounds != expected {
+		t.Errorf("With -two-color-border: expected %v, got %v", expected, det.Bounds)
+	}
+	if det.Mode != ModeWhite {
+		t.Errorf("Expected the outer band's mode white, got %v", det.Mode)
+	}
+}
+
+func TestPreserveExactBytes(t *testing.T) {
+	dir := t.TempDir()
+
+	// A full-bleed image, encoded differently from how saveImage would.
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{120, 160, 90, 255}}, image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	enc := png.Encoder{CompressionLevel: png.NoCompression}
+	if err := enc.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	original := buf.Bytes()
+	path := filepath.Join(dir, "a.png")
+	if err := os.WriteFile(path, original, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := processImage(path, dir, "a.png", options{}); err != nil {
+		t.Fatalf("processImage() error = %v", err)
+	}
+	reencoded, err := os.ReadFile(filepath.Join(dir, "processed_a.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(reencoded, original) {
+		t.Fatal("Expected a re-encode to change the bytes, the test image is not discriminating")
+	}
+
+	if _, err := processImage(path, dir, "a.png", options{PreserveExactBytes: true}); err != nil {
+		t.Fatalf("processImage() error = %v", err)
+	}
+	copied, err := os.ReadFile(filepath.Join(dir, "processed_a.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(copied, original) {
+		t.Error("Expected -preserve-exact-bytes to copy the original bytes")
+	}
+
+	// Any other transform still re-encodes.
+	if _, err := processImage(path, dir, "a.png", options{PreserveExactBytes: true, MaxDim: 32, ResizeFilter: "nearest"}); err != nil {
+		t.Fatalf("processImage() error = %v", err)
+	}
+	if got := readPNG(t, filepath.Join(dir, "processed_a.png")).Bounds().Size(); got != image.Pt(32, 32) {
+		t.Errorf("Expected the resize to apply, got size %v", got)
+	}
+}
+
+func TestPerSideTolerance(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(30, 30, 70, 71), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	// Scrollbar-like artifacts down the left border: 10% of each column.
+	for x := 0; x < 20; x++ {
+		for y := 0; y < 100; y += 10 {
+			img.Set(x, y, color.White)
+		}
+	}
+	// A faintly noisy band just above the content: 8% of each row, over
+	// the content's columns.
+	for y := 26; y < 30; y++ {
+		for x := 30; x < 70; x += 5 {
+			img.Set(x, y, color.White)
+		}
+	}
+
+	if got, expected := detect(img, options{}).Bounds, image.Rect(0, 26, 70, 71); got != expected {
+		t.Fatalf("With default tolerances: expected %v, got %v", expected, got)
+	}
+	// Loosening the left side trims the artifacts; the top keeps the strict
+	// default and still stops at the noisy band.
+	if got, expected := detect(img, options{ToleranceLeft: 0.8}).Bounds, image.Rect(30, 26, 70, 71); got != expected {
+		t.Errorf("With -tolerance-left 0.8: expected %v, got %v", expected, got)
+	}
+	if got, expected := detect(img, options{ToleranceLeft: 0.8, ToleranceTop: 0.8}).Bounds, image.Rect(30, 30, 70, 71); got != expected {
+		t.Errorf("With -tolerance-top 0.8 as well: expected %v, got %v", expected, got)
+	}
+}
+
+func TestSkipNonRegularAndEmptyFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	img := image.NewRGBA(image.Rect(0, 0, 20, 20))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(5, 5, 15, 15), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	writePNG(t, filepath.Join(dir, "a.png"), img)
+	if err := os.WriteFile(filepath.Join(dir, "empty.png"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "subdir.png"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	reportPath := filepath.Join(t.TempDir(), "report.csv")
+	if err := processDirectory(dir, options{TrimReport: reportPath}); err != nil {
+		t.Fatalf("processDirectory() error = %v", err)
+	}
+
+	statuses := map[string]string{}
+	for _, row := range readCSV(t, reportPath)[1:] {
+		statuses[row[0]] = row[8]
+	}
+	expected := map[string]string{
+		"a.png":      "cropped",
+		"empty.png":  "skipped: empty file",
+		"subdir.png": "skipped: directory",
+	}
+	for name, want := range expected {
+		if got := statuses[name]; got != want {
+			t.Errorf("%s: status %q, want %q", name, got, want)
+		}
+	}
+
+	if got := skipReason(filepath.Join(dir, "empty.png"), options{}); got != "empty file" {
+		t.Errorf("skipReason(empty.png) = %q, want %q", got, "empty file")
+	}
+}
+
+func TestMaxDetectDepth(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(80, 10, 120, 90), &image.Uniform{color.White}, image.Point{}, draw.Src)
+
+	if got, expected := detect(img, options{}).Bounds, image.Rect(80, 10, 120, 90); got != expected {
+		t.Fatalf("Without a depth limit: expected %v, got %v", expected, got)
+	}
+	// The left and right borders are deeper than the limit, so they are
+	// only trimmed up to it; top and bottom are within it.
+	if got, expected := detect(img, options{MaxDetectDepth: 30}).Bounds, image.Rect(30, 10, 170, 90); got != expected {
+		t.Errorf("With -max-detect-depth 30: expected %v, got %v", expected, got)
+	}
+
+	// An image with nothing but background is still empty with a limit,
+	// rather than cropped to the depth on each side.
+	blank := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	draw.Draw(blank, blank.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	for _, order := range []string{scanVerticalFirst, scanHorizontalFirst} {
+		if got := detect(blank, options{MaxDetectDepth: 30, ScanOrder: order}).Bounds; !got.Empty() {
+			t.Errorf("All-black image with -max-detect-depth 30 -scan-order %s: expected empty bounds, got %v", order, got)
+		}
+	}
+}
+
+func BenchmarkDetectPanorama(b *testing.B) {
+	img := image.NewRGBA(image.Rect(0, 0, 6000, 400))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(2000, 20, 4000, 380), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	b.Run("FullScan", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			detect(img, options{})
+		}
+	})
+	b.Run("MaxDetectDepth", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			detect(img, options{MaxDetectDepth: 100})
+		}
+	})
+}
+
+func TestNoCrop(t *testing.T) {
+	dir := t.TempDir()
+
+	img := image.NewRGBA(image.Rect(0, 0, 120, 80))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(20, 20, 100, 60), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	path := filepath.Join(dir, "a.png")
+	writePNG(t, path, img)
+
+	res, err := processImage(path, dir, "a.png", options{NoCrop: true, Format: "jpeg"})
+	if err != nil {
+		t.Fatalf("processImage() error = %v", err)
+	}
+	if res.Output != "processed_a.jpg" || res.Status != "unchanged: no crop" {
+		t.Errorf("Expected processed_a.jpg with status %q, got %s with %q", "unchanged: no crop", res.Output, res.Status)
+	}
+
+	out, format, err := loadImage(filepath.Join(dir, res.Output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "jpeg" {
+		t.Errorf("Expected a jpeg output, got %s", format)
+	}
+	if out.Bounds() != img.Bounds() {
+		t.Errorf("Expected the border kept at %v, got %v", img.Bounds(), out.Bounds())
+	}
+}
+
+func TestPerChannelLevels(t *testing.T) {
+	// A near-black border with a blue cast: dark in red and green, but
+	// above the default black threshold in blue.
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{30, 30, 90, 255}}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(25, 20, 75, 80), &image.Uniform{color.White}, image.Point{}, draw.Src)
+
+	if got := detect(img, options{}).Bounds; got != img.Bounds() {
+		t.Errorf("With default levels: expected the blue border kept, got %v", got)
+	}
+
+	// -black-b overrides -black-threshold for blue only.
+	set := [3]int{blackThreshold, blackThreshold, 100}
+	lv, err := parseLevels(blackThreshold, whiteThreshold, set, [3]int{whiteThreshold, whiteThreshold, whiteThreshold})
+	if err != nil {
+		t.Fatalf("parseLevels() error = %v", err)
+	}
+	if lv.Black != [3]uint8{blackThreshold, blackThreshold, 100} || lv.White != defaultLevels.White {
+		t.Fatalf("parseLevels() = %+v, expected only the blue black level changed", lv)
+	}
+	if got, expected := detect(img, options{Levels: &lv}).Bounds, image.Rect(25, 20, 75, 80); got != expected {
+		t.Errorf("With -black-b 100: expected %v, got %v", expected, got)
+	}
+	if got, expected := findContentBounds(img, lv), image.Rect(25, 20, 75, 80); got != expected {
+		t.Errorf("findContentBounds() with -black-b 100: expected %v, got %v", expected, got)
+	}
+}
+
+func TestParseLevels(t *testing.T) {
+	uniform := func(v int) [3]int { return [3]int{v, v, v} }
+	for _, tt := range []struct {
+		name         string
+		black, white int
+		blacks       [3]int
+		whites       [3]int
+		wantErr      bool
+	}{
+		{"defaults", blackThreshold, whiteThreshold, uniform(blackThreshold), uniform(whiteThreshold), false},
+		{"zero black", 0, whiteThreshold, uniform(0), uniform(whiteThreshold), false},
+		{"black out of range", -1, whiteThreshold, uniform(-1), uniform(whiteThreshold), true},
+		{"white out of range", blackThreshold, 256, uniform(blackThreshold), uniform(256), true},
+		{"black above white", 200, 100, uniform(200), uniform(100), true},
+		{"channel out of range", blackThreshold, whiteThreshold, [3]int{blackThreshold, 300, blackThreshold}, uniform(whiteThreshold), true},
+		{"channel black above white", blackThreshold, whiteThreshold, uniform(blackThreshold), [3]int{whiteThreshold, whiteThreshold, 50}, true},
+	} {
+		_, err := parseLevels(tt.black, tt.white, tt.blacks, tt.whites)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: parseLevels() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	// A black threshold of zero stays zero rather than falling back to
+	// the default, so the dark gray border is kept.
+	lv, err := parseLevels(0, whiteThreshold, uniform(0), uniform(whiteThreshold))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := (options{Levels: &lv}).levels(); got.Black != [3]uint8{} {
+		t.Errorf("-black-threshold 0: expected black levels of 0, got %v", got.Black)
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 60, 60))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Gray{20}}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(10, 10, 50, 50), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	if got := detect(img, options{Levels: &lv}).Bounds; got != img.Bounds() {
+		t.Errorf("-black-threshold 0: expected the gray border kept, got %v", got)
+	}
+	if got := findContentBounds(img, lv); got != img.Bounds() {
+		t.Errorf("findContentBounds() at -black-threshold 0: expected the gray border kept, got %v", got)
+	}
+}
+
+func TestThresholdLevels(t *testing.T) {
+	// A black frame around a page whose dark gray text box (50) touches
+	// the top of the content.
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(10, 10, 90, 90), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(10, 10, 90, 30), &image.Uniform{color.Gray{50}}, image.Point{}, draw.Src)
+
+	for _, tt := range []struct {
+		black, white uint8
+		expected     image.Rectangle
+	}{
+		{blackThreshold, whiteThreshold, image.Rect(10, 30, 90, 90)}, // the text box is eaten
+		{30, whiteThreshold, image.Rect(10, 10, 90, 90)},
+	} {
+		lv := crop.UniformLevels(tt.black, tt.white)
+		if got := detect(img, options{Levels: &lv}).Bounds; got != tt.expected {
+			t.Errorf("Thresholds %d/%d: expected %v, got %v", tt.black, tt.white, tt.expected, got)
+		}
+	}
+}
+
+func TestWriteVerified(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "processed_a.png")
+
+	img := image.NewRGBA(image.Rect(0, 0, 40, 30))
+	var good bytes.Buffer
+	if err := png.Encode(&good, img); err != nil {
+		t.Fatal(err)
+	}
+	corrupt := good.Bytes()[:good.Len()/2]
+
+	// writes returns a write function producing each of outputs in turn,
+	// then repeating the last one.
+	writes := func(outputs ...[]byte) (func() error, *int) {
+		n := 0
+		return func() error {
+			data := outputs[min(n, len(outputs)-1)]
+			n++
+			return os.WriteFile(path, data, 0o644)
+		}, &n
+	}
+
+	write, n := writes(good.Bytes())
+	if err := writeVerified(path, image.Pt(40, 30), options{Verify: true}, write); err != nil || *n != 1 {
+		t.Errorf("Good write: expected success in 1 write, got %v after %d", err, *n)
+	}
+
+	// A corrupt first write is caught and written again.
+	write, n = writes(corrupt, good.Bytes())
+	if err := writeVerified(path, image.Pt(40, 30), options{Verify: true}, write); err != nil || *n != 2 {
+		t.Errorf("Corrupt then good write: expected success in 2 writes, got %v after %d", err, *n)
+	}
+
+	// Persistent corruption fails and leaves no bad output behind.
+	write, _ = writes(corrupt)
+	if err := writeVerified(path, image.Pt(40, 30), options{Verify: true}, write); err == nil {
+		t.Error("Corrupt writes: expected a verification error")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Corrupt writes: expected the output removed, got %v", err)
+	}
+
+	// A decodable file of the wrong size fails too.
+	write, _ = writes(good.Bytes())
+	if err := writeVerified(path, image.Pt(41, 30), options{Verify: true}, write); err == nil {
+		t.Error("Wrong size: expected a verification error")
+	}
+
+	// Without verify, the write is trusted.
+	write, _ = writes(corrupt)
+	if err := writeVerified(path, image.Pt(40, 30), options{}, write); err != nil {
+		t.Errorf("Without verify: expected no error, got %v", err)
+	}
+}
+
+func TestKeepLargest(t *testing.T) {
+	// A photo plus a small date stamp in the lower right corner.
+	img := image.NewRGBA(image.Rect(0, 0, 200, 150))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(20, 20, 140, 110), &image.Uniform{color.RGBA{80, 60, 40, 255}}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(170, 130, 190, 140), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+
+	if got, expected := detect(img, options{}).Bounds, image.Rect(20, 20, 190, 140); got != expected {
+		t.Fatalf("Without -keep-largest: expected %v, got %v", expected, got)
+	}
+	if got, expected := detect(img, options{KeepLargest: true}).Bounds, image.Rect(20, 20, 140, 110); got != expected {
+		t.Errorf("With -keep-largest: expected %v, got %v", expected, got)
+	}
+}
+
+func TestPreserveOriginalAspect(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name     string
+		content  image.Rectangle
+		expected image.Rectangle
+	}{
+		{"centered", image.Rect(80, 20, 120, 80), image.Rect(40, 20, 160, 80)},
+		{"at the edge", image.Rect(0, 20, 40, 80), image.Rect(0, 20, 120, 80)},
+		{"wide", image.Rect(20, 40, 180, 50), image.Rect(20, 5, 180, 85)},
+	}
+	for _, tt := range tests {
+		img := image.NewRGBA(image.Rect(0, 0, 200, 100))
+		draw.Draw(img, img.Bounds(), &image.Uniform{color.White}, image.Point{}, draw.Src)
+		draw.Draw(img, tt.content, &image.Uniform{color.RGBA{90, 30, 30, 255}}, image.Point{}, draw.Src)
+		writePNG(t, filepath.Join(dir, "a.png"), img)
+
+		res, err := processImage(filepath.Join(dir, "a.png"), dir, "a.png", options{PreserveOriginalAspect: true})
+		if err != nil {
+			t.Fatalf("%s: processImage() error = %v", tt.name, err)
+		}
+		if res.Bounds != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.expected, res.Bounds)
+		}
+		out, _, err := loadImage(filepath.Join(dir, res.Output))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size := out.Bounds().Size(); size.X != 2*size.Y {
+			t.Errorf("%s: expected a 2:1 output like the input, got %v", tt.name, size)
+		}
+	}
+}
+
+func TestSnapBlocks(t *testing.T) {
+	// A band of faint 8x8 block artifacts, just above the black threshold,
+	// right above the content.
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(24, 24, 72, 72), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	for y := 16; y < 24; y++ {
+		for x := 24; x < 72; x++ {
+			if (x/4+y/4)%2 == 0 {
+				img.Set(x, y, color.RGBA{70, 70, 70, 255})
+			}
+		}
+	}
+
+	if got, expected := detect(img, options{}).Bounds, image.Rect(24, 16, 72, 72); got != expected {
+		t.Fatalf("Without -snap-blocks: expected the band kept at %v, got %v", expected, got)
+	}
+	if got, expected := detect(img, options{SnapBlocks: true}).Bounds, image.Rect(24, 24, 72, 72); got != expected {
+		t.Errorf("With -snap-blocks: expected %v, got %v", expected, got)
+	}
+}
+
+func TestMinRun(t *testing.T) {
+	// Gray content up to the edges, except for one black row along the top.
+	img := image.NewRGBA(image.Rect(0, 0, 60, 60))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{128, 128, 128, 255}}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, 0, 60, 1), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+
+	if got, expected := detect(img, options{}).Bounds, image.Rect(0, 1, 60, 60); got != expected {
+		t.Fatalf("Without -min-run: expected the edge row trimmed to %v, got %v", expected, got)
+	}
+	if got, expected := detect(img, options{MinRun: 2}).Bounds, img.Bounds(); got != expected {
+		t.Errorf("With -min-run 2: expected the lone edge row kept, %v, got %v", expected, got)
+	}
+
+	// A real border at least that deep is still trimmed.
+	draw.Draw(img, image.Rect(0, 0, 60, 3), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	if got, expected := detect(img, options{MinRun: 2}).Bounds, image.Rect(0, 3, 60, 60); got != expected {
+		t.Errorf("With -min-run 2 and a 3px border: expected %v, got %v", expected, got)
+	}
+}
+
+// slowImage is an image whose pixels take a while to read.
+type slowImage struct {
+	image.Image
+}
+
+func (s slowImage) At(x, y int) color.Color {
+	time.Sleep(50 * time.Microsecond)
+	return s.Image.At(x, y)
+}
+
+func TestImageTimeout(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 200, 200))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(50, 50, 150, 150), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	opts := options{ImageTimeout: 50 * time.Millisecond}
+
+	start := time.Now()
+	res, err := planCrop(slowImage{img}, opts)
+	if err != nil {
+		t.Fatalf("planCrop() error = %v", err)
+	}
+	if res.Status != "skipped: timed out after 50ms" {
+		t.Errorf("Expected the slow image to time out, got status %q", res.Status)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the scan to stop soon after the timeout, took %v", elapsed)
+	}
+
+	res, err = planCrop(img, opts)
+	if err != nil || res.Status != "cropped" {
+		t.Errorf("Expected the fast image to be cropped, got %q, %v", res.Status, err)
+	}
+}
+
+func TestRequireBorder(t *testing.T) {
+	dir := t.TempDir()
+
+	bordered := image.NewRGBA(image.Rect(0, 0, 60, 60))
+	draw.Draw(bordered, bordered.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(bordered, image.Rect(10, 10, 50, 50), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	writePNG(t, filepath.Join(dir, "bordered.png"), bordered)
+
+	photo := image.NewRGBA(image.Rect(0, 0, 60, 60))
+	draw.Draw(photo, photo.Bounds(), &image.Uniform{color.RGBA{90, 140, 60, 255}}, image.Point{}, draw.Src)
+	writePNG(t, filepath.Join(dir, "photo.png"), photo)
+
+	if err := processDirectory(dir, options{}); err != nil {
+		t.Fatalf("Without -require-border: processDirectory() error = %v", err)
+	}
+
+	err := processDirectory(dir, options{RequireBorder: true})
+	if !errors.Is(err, ErrNoBorder) || !strings.Contains(err.Error(), "in 1 image(s)") {
+		t.Fatalf("With -require-border: expected ErrNoBorder for 1 image, got %v", err)
+	}
+	if _, err := processImage(filepath.Join(dir, "bordered.png"), dir, "bordered.png", options{RequireBorder: true}); err != nil {
+		t.Errorf("Expected the bordered image to pass, got %v", err)
+	}
+}
+
+func TestBgMatchTolerance(t *testing.T) {
+	// Dark gray content, below the black threshold, next to white content,
+	// and a band of white specks in the top border.
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(30, 30, 70, 70), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(20, 30, 30, 70), &image.Uniform{color.RGBA{45, 45, 45, 255}}, image.Point{}, draw.Src)
+	for y := 10; y < 17; y++ {
+		for x := 5; x < 100; x += 12 {
+			img.Set(x, y, color.White)
+		}
+	}
+
+	if got := detect(img, options{NoiseTolerance: 0.9}).Bounds; got != image.Rect(30, 30, 70, 70) {
+		t.Fatalf("Without -bg-match-tolerance: expected the gray content eroded, got %v", got)
+	}
+	if got := detect(img, options{BgMatchTolerance: 10}).Bounds; got.Min.Y != 10 {
+		t.Errorf("With -bg-match-tolerance alone: expected the specks to stop the top at row 10, got %v", got)
+	}
+	if got, expected := detect(img, options{BgMatchTolerance: 10, NoiseTolerance: 0.9}).Bounds, image.Rect(20, 30, 70, 70); got != expected {
+		t.Errorf("With -bg-match-tolerance and -noise-tolerance: expected %v, got %v", expected, got)
+	}
+}
+
+func TestCloseRadius(t *testing.T) {
+	// A dotted white frame around the content, sparse enough that each of
+	// its rows and columns passes as background.
+	img := image.NewRGBA(image.Rect(0, 0, 200, 200))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(60, 60, 140, 140), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	for _, p := range []int{20, 60, 100, 140, 178} {
+		for _, dot := range []image.Point{{p, 20}, {p, 178}, {20, p}, {178, p}} {
+			draw.Draw(img, image.Rectangle{dot, dot.Add(image.Pt(2, 2))}, &image.Uniform{color.White}, image.Point{}, draw.Src)
+		}
+	}
+
+	if got, expected := detect(img, options{}).Bounds, image.Rect(60, 60, 140, 140); got != expected {
+		t.Fatalf("Without -close-radius: expected the dotted frame trimmed through to %v, got %v", expected, got)
+	}
+	if got, expected := detect(img, options{CloseRadius: 20}).Bounds, image.Rect(20, 20, 180, 180); got != expected {
+		t.Errorf("With -close-radius 20: expected the crop at the frame, %v, got %v", expected, got)
+	}
+}
+
+func TestColumnScanWithinRowBand(t *testing.T) {
+	// A mark in the top margin, above the content's rows.
+	img := image.NewRGBA(image.Rect(0, 0, 200, 200))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(50, 50, 150, 150), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(10, 0, 18, 40), &image.Uniform{color.White}, image.Point{}, draw.Src)
+
+	// Measured down the full height, the mark's columns would be 20%
+	// content and stop the left scan at x=10. Within the kept rows they
+	// are background.
+	if got, expected := detect(img, options{}).Bounds, image.Rect(50, 50, 150, 150); got != expected {
+		t.Errorf("Expected the columns scanned within rows 50-150, %v, got %v", expected, got)
+	}
+
+	// L-shaped content: a bar down the left and a foot along the bottom.
+	// The foot keeps its columns within the kept rows.
+	img = image.NewRGBA(image.Rect(0, 0, 200, 160))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(20, 10, 40, 150), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(20, 130, 180, 150), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	if got, expected := detect(img, options{}).Bounds, image.Rect(20, 10, 180, 150); got != expected {
+		t.Errorf("L-shape: expected %v, got %v", expected, got)
+	}
+}
+
+func TestScanOrder(t *testing.T) {
+	// L-shaped content: a block with a thin arm reaching up into the top
+	// margin, off to its left.
+	img := image.NewRGBA(image.Rect(0, 0, 200, 200))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(50, 50, 150, 150), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(10, 0, 18, 40), &image.Uniform{color.White}, image.Point{}, draw.Src)
+
+	// transpose swaps x and y, so the row scans see what the column scans
+	// saw.
+	transpose := func(src image.Image) *image.RGBA {
+		b := src.Bounds()
+		dst := image.NewRGBA(image.Rect(b.Min.Y, b.Min.X, b.Max.Y, b.Max.X))
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				dst.Set(y, x, src.At(x, y))
+			}
+		}
+		return dst
+	}
+	swap := func(r image.Rectangle) image.Rectangle {
+		return image.Rect(r.Min.Y, r.Min.X, r.Max.Y, r.Max.X)
+	}
+
+	for _, tt := range []struct {
+		order    string
+		expected image.Rectangle
+	}{
+		// The arm is 4% of each row it crosses, so the top scan trims it,
+		// and the columns within the rows kept are background.
+		{"", image.Rect(50, 50, 150, 150)},
+		{scanVerticalFirst, image.Rect(50, 50, 150, 150)},
+		// The arm is 20% of each full-height column, so the left scan
+		// keeps it, and within the columns kept it is too much of each
+		// row for the top scan.
+		{scanHorizontalFirst, image.Rect(10, 0, 150, 150)},
+	} {
+		got := detect(img, options{ScanOrder: tt.order}).Bounds
+		if got != tt.expected {
+			t.Errorf("-scan-order %q: expected %v, got %v", tt.order, tt.expected, got)
+		}
+		// Scanning the other axis first is the same as scanning the
+		// transposed image.
+		other := scanHorizontalFirst
+		if tt.order == scanHorizontalFirst {
+			other = scanVerticalFirst
+		}
+		if transposed := detect(transpose(img), options{ScanOrder: other}).Bounds; transposed != swap(got) {
+			t.Errorf("-scan-order %q on the transposed image: expected %v, got %v", other, swap(got), transposed)
+		}
+	}
+}
+
+func TestModeReason(t *testing.T) {
+	// solid returns a 40x40 image of fill with the given corner colors
+	// (top-left, top-right, bottom-left, bottom-right) painted in.
+	solid := func(fill color.Color, corners ...color.Color) image.Image {
+		img := image.NewRGBA(image.Rect(0, 0, 40, 40))
+		draw.Draw(img, img.Bounds(), &image.Uniform{fill}, image.Point{}, draw.Src)
+		for i, c := range corners {
+			img.Set(i%2*39, i/2*39, c)
+		}
+		return img
+	}
+	red := color.RGBA{200, 30, 30, 255}
+
+	tests := []struct {
+		name     string
+		img      image.Image
+		mode     backgroundMode
+		expected string
+	}{
+		{"black", solid(color.Black), ModeBlack, "detected-black"},
+		{"white", solid(color.White), ModeWhite, "detected-white"},
+		{"tie", solid(red, color.Black, color.White, color.White, color.Black), ModeBlack, "tie-black"},
+		{"midpoints", solid(color.White, red, red, red, red), ModeWhite, "midpoints-white"},
+		{"colored", solid(red), ModeNone, "colored-corners"},
+		{"no background", solid(red, color.RGBA{30, 200, 30, 255}, color.RGBA{30, 30, 200, 255}, red, color.RGBA{200, 200, 30, 255}), ModeNone, "tie-no-background"},
+	}
+	for _, tt := range tests {
+		got := detect(tt.img, options{})
+		if got.Mode != tt.mode || got.Reason != tt.expected {
+			t.Errorf("%s: expected %s (%s), got %s (%s)", tt.name, tt.mode, tt.expected, got.Mode, got.Reason)
+		}
+	}
+
+	if got := detect(solid(red), options{Fuzz: "5%"}).Reason; got != "fuzz" {
+		t.Errorf("With -fuzz: expected reason fuzz, got %s", got)
+	}
+}
+
+func TestPerSideBackground(t *testing.T) {
+	// A scanned page: a black bar on top, a white bar at the bottom.
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{128, 128, 128, 255}}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, 0, 100, 10), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, 88, 100, 100), &image.Uniform{color.White}, image.Point{}, draw.Src)
+
+	if got := detect(img, options{}).Bounds; got != image.Rect(0, 10, 100, 100) {
+		t.Fatalf("Without -per-side-background: expected only the black bar trimmed, got %v", got)
+	}
+	if got := detect(img, options{PerSideBackground: true}).Bounds; got != image.Rect(0, 10, 100, 88) {
+		t.Errorf("With -per-side-background: expected both bars trimmed, got %v", got)
+	}
+}
+
+func TestOnlyIfSmaller(t *testing.T) {
+	dir := t.TempDir()
+
+	// A low-quality JPEG with no border: re-encoding it at the default
+	// quality only makes it bigger.
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 4), uint8(y * 4), uint8(x ^ y), 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 10}); err != nil {
+		t.Fatal(err)
+	}
+	original := buf.Bytes()
+	if err := os.WriteFile(filepath.Join(dir, "photo.jpg"), original, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := processImage(filepath.Join(dir, "photo.jpg"), dir, "photo.jpg", options{OnlyIfSmaller: true})
+	if err != nil {
+		t.Fatalf("processImage() error = %v", err)
+	}
+	if !strings.HasPrefix(res.Status, "kept original: output") {
+		t.Errorf("Expected the re-encode to be suppressed, got status %q", res.Status)
+	}
+	if got, err := os.ReadFile(filepath.Join(dir, res.Output)); err != nil || !bytes.Equal(got, original) {
+		t.Errorf("Expected the original bytes in %s (%v)", res.Output, err)
+	}
+
+	// A real crop still shrinks the file and is written.
+	bordered := image.NewRGBA(image.Rect(0, 0, 200, 200))
+	draw.Draw(bordered, image.Rect(60, 60, 124, 124), img, image.Point{}, draw.Src)
+	writePNG(t, filepath.Join(dir, "bordered.png"), bordered)
+	res, err = processImage(filepath.Join(dir, "bordered.png"), dir, "bordered.png", options{OnlyIfSmaller: true})
+	if err != nil {
+		t.Fatalf("processImage() error = %v", err)
+	}
+	if res.Status != "cropped" || res.Bounds != image.Rect(60, 60, 124, 124) {
+		t.Errorf("Expected the bordered image cropped, got %q %v", res.Status, res.Bounds)
+	}
+}
+
+func TestFindContentBoundsMatchesDetect(t *testing.T) {
+	// The crop package and detect must agree when no option is set.
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 50; i++ {
+		img := image.NewRGBA(image.Rect(0, 0, 40+rng.Intn(40), 40+rng.Intn(40)))
+		bg := []color.Color{color.Black, color.White, color.RGBA{120, 80, 40, 255}}[rng.Intn(3)]
+		draw.Draw(img, img.Bounds(), &image.Uniform{bg}, image.Point{}, draw.Src)
+		for n := rng.Intn(300); n > 0; n-- {
+			img.Set(rng.Intn(img.Bounds().Dx()), rng.Intn(img.Bounds().Dy()), color.Gray{uint8(rng.Intn(256))})
+		}
+		x, y := rng.Intn(30), rng.Intn(30)
+		draw.Draw(img, image.Rect(x, y, x+5+rng.Intn(30), y+5+rng.Intn(30)), &image.Uniform{color.RGBA{200, 30, 30, 255}}, image.Point{}, draw.Src)
+
+		if got, want := findContentBounds(img, defaultLevels), detect(img, options{}).Bounds; got != want {
+			t.Errorf("Image %d: findContentBounds() = %v, detect() = %v", i, got, want)
+		}
+	}
+}
+
+func TestQuantizeAlpha(t *testing.T) {
+	// Opaque red content with a soft alpha gradient fading out over 8px on
+	// a transparent background.
+	img := image.NewNRGBA(image.Rect(0, 0, 100, 100))
+	for i := 0; i < 8; i++ {
+		r := image.Rect(22+i, 22+i, 78-i, 78-i)
+		draw.Draw(img, r, &image.Uniform{color.NRGBA{255, 0, 0, uint8(30 * (i + 1))}}, image.Point{}, draw.Src)
+	}
+	draw.Draw(img, image.Rect(30, 30, 70, 70), &image.Uniform{color.NRGBA{255, 0, 0, 255}}, image.Point{}, draw.Src)
+
+	// Alphas 30..240: from 128 on (the rings at i >= 4) is opaque.
+	if got, expected := detect(img, options{QuantizeAlpha: 128}).Bounds, image.Rect(26, 26, 74, 74); got != expected {
+		t.Errorf("With -quantize-alpha 128: expected %v, got %v", expected, got)
+	}
+	if got, expected := detect(img, options{QuantizeAlpha: 200}).Bounds, image.Rect(28, 28, 72, 72); got != expected {
+		t.Errorf("With -quantize-alpha 200: expected %v, got %v", expected, got)
+	}
+	if c := img.NRGBAAt(24, 50); c.A != 90 {
+		t.Errorf("Expected the image itself unchanged, got %v", c)
+	}
+}
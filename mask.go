@@ -0,0 +1,144 @@
+package main
+
+import "image"
+
+// contentMask marks which pixels of a rectangle count as content (true) or
+// background (false) for detection. Morphological operations on the mask
+// let detection ignore specks or bridge gaps without touching the output.
+type contentMask struct {
+	rect image.Rectangle
+	bits []bool
+}
+
+// newContentMask classifies every pixel of rect with isBackground.
+func newContentMask(rect image.Rectangle, isBackground func(x, y int) bool) *contentMask {
+	m := &contentMask{rect: rect, bits: make([]bool, rect.Dx()*rect.Dy())}
+	i := 0
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			m.bits[i] = !isBackground(x, y)
+			i++
+		}
+	}
+	return m
+}
+
+// At reports whether (x, y) is content.
+func (m *contentMask) At(x, y int) bool {
+	return m.bits[(y-m.rect.Min.Y)*m.rect.Dx()+(x-m.rect.Min.X)]
+}
+
+// IsBackground is the inverse of At, for use as a detection classifier.
+func (m *contentMask) IsBackground(x, y int) bool {
+	return !m.At(x, y)
+}
+
+// Open erodes then dilates the content by a square of the given radius,
+// removing content specks smaller than 2*radius+1 pixels across while
+// leaving larger regions in place.
+func (m *contentMask) Open(radius int) *contentMask {
+	return m.morph(radius, true).morph(radius, false)
+}
+
+// Close dilates then erodes the content by a square of the given radius,
+// filling background gaps narrower than 2*radius+1 pixels. The mask is
+// padded with background for it, so the margin between the content and the
+// edge of the rectangle is not filled in.
+func (m *contentMask) Close(radius int) *contentMask {
+	padded := newContentMask(m.rect.Inset(-radius), func(x, y int) bool {
+		return !image.Pt(x, y).In(m.rect) || m.IsBackground(x, y)
+	})
+	closed := padded.morph(radius, false).morph(radius, true)
+	return newContentMask(m.rect, closed.IsBackground)
+}
+
+// morph applies an erosion (erode=true) or dilation of the content by a
+// (2*radius+1)-square, separably along rows and then columns. Pixels outside
+// the rectangle are ignored.
+func (m *contentMask) morph(radius int, erode bool) *contentMask {
+	w, h := m.rect.Dx(), m.rect.Dy()
+	tmp := make([]bool, len(m.bits))
+	out := make([]bool, len(m.bits))
+
+	// window applies the operation to n values read through get, writing
+	// through set, using a running count of content in the window.
+	window := func(n int, get func(i int) bool, set func(i int, v bool)) {
+		count := 0
+		lo, hi := 0, 0 // current window is [lo, hi)
+		for i := 0; i < n; i++ {
+			for hi < n && hi <= i+radius {
+				if get(hi) {
+					count++
+				}
+				hi++
+			}
+			for lo < i-radius {
+				if get(lo) {
+					count--
+				}
+				lo++
+			}
+			if erode {
+				set(i, count == hi-lo)
+			} else {
+				set(i, count > 0)
+			}
+		}
+	}
+
+	for y := 0; y < h; y++ {
+		row := y * w
+		window(w, func(i int) bool { return m.bits[row+i] }, func(i int, v bool) { tmp[row+i] = v })
+	}
+	for x := 0; x < w; x++ {
+		window(h, func(i int) bool { return tmp[i*w+x] }, func(i int, v bool) { out[i*w+x] = v })
+	}
+	return &contentMask{rect: m.rect, bits: out}
+}
+
+// LargestComponent returns the bounding box of the largest 8-connected
+// region of content, or an empty rectangle if there is no content.
+func (m *contentMask) LargestComponent() image.Rectangle {
+	w := m.rect.Dx()
+	seen := make([]bool, len(m.bits))
+	var largest image.Rectangle
+	largestSize := 0
+
+	var stack []int
+	for start, content := range m.bits {
+		if !content || seen[start] {
+			continue
+		}
+
+		// Flood-fill the component containing start.
+		size := 0
+		box := image.Rectangle{}
+		seen[start] = true
+		stack = append(stack[:0], start)
+		for len(stack) > 0 {
+			i := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			x, y := i%w, i/w
+			size++
+			box = box.Union(image.Rect(x, y, x+1, y+1))
+
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					nx, ny := x+dx, y+dy
+					if nx < 0 || nx >= w || ny < 0 || ny >= m.rect.Dy() {
+						continue
+					}
+					if j := ny*w + nx; m.bits[j] && !seen[j] {
+						seen[j] = true
+						stack = append(stack, j)
+					}
+				}
+			}
+		}
+
+		if size > largestSize {
+			largest, largestSize = box, size
+		}
+	}
+	return largest.Add(m.rect.Min)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"image"
+	"strings"
+	"testing"
+)
+
+// maskFromRows builds a mask from rows of '#' (content) and '.' (background).
+func maskFromRows(rows ...string) *contentMask {
+	rect := image.Rect(0, 0, len(rows[0]), len(rows))
+	return newContentMask(rect, func(x, y int) bool { return rows[y][x] != '#' })
+}
+
+func (m *contentMask) String() string {
+	var sb strings.Builder
+	for y := m.rect.Min.Y; y < m.rect.Max.Y; y++ {
+		for x := m.rect.Min.X; x < m.rect.Max.X; x++ {
+			if m.At(x, y) {
+				sb.WriteByte('#')
+			} else {
+				sb.WriteByte('.')
+			}
+		}
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+func TestContentMaskOpen(t *testing.T) {
+	m := maskFromRows(
+		"#.......",
+		"........",
+		"...###..",
+		"...###..",
+		"...###..",
+		"........",
+		"......#.",
+	)
+	expected := maskFromRows(
+		"........",
+		"........",
+		"...###..",
+		"...###..",
+		"...###..",
+		"........",
+		"........",
+	)
+	if got := m.Open(1); got.String() != expected.String() {
+		t.Errorf("Open(1) =\n%s\nwant\n%s", got, expected)
+	}
+}
+
+func TestContentMaskLargestComponent(t *testing.T) {
+	m := maskFromRows(
+		"##......",
+		"#.......",
+		"....##..",
+		"...###..",
+		"....#...",
+		".......#",
+	)
+	if got, expected := m.LargestComponent(), image.Rect(3, 2, 6, 5); got != expected {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+	if got := maskFromRows("....").LargestComponent(); !got.Empty() {
+		t.Errorf("Expected an empty rectangle for an empty mask, got %v", got)
+	}
+}
+
+func TestContentMaskClose(t *testing.T) {
+	// The gaps between the dashes are filled; the margin to the edge is not.
+	m := maskFromRows(
+		"..........",
+		".##.##.##.",
+		"..........",
+	)
+	expected := maskFromRows(
+		"..........",
+		".########.",
+		"..........",
+	)
+	if got := m.Close(1); got.String() != expected.String() {
+		t.Errorf("Close(1) =\n%s\nwant\n%s", got, expected)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// memoryBudget limits the estimated memory held by images being processed
+// at once. Callers Acquire an image's estimated cost before decoding it and
+// Release it when done, waiting while the budget is exhausted.
+type memoryBudget struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	limit int64
+	used  int64
+}
+
+func newMemoryBudget(limit int64) *memoryBudget {
+	b := &memoryBudget{limit: limit}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// Acquire blocks until n bytes fit in the budget. A request larger than the
+// whole budget is admitted once nothing else is held, so a single huge image
+// is still processed rather than waiting forever.
+func (b *memoryBudget) Acquire(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.used > 0 && b.used+n > b.limit {
+		b.cond.Wait()
+	}
+	b.used += n
+}
+
+// Release returns n bytes to the budget and wakes any waiters.
+func (b *memoryBudget) Release(n int64) {
+	b.mu.Lock()
+	b.used -= n
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+// Used returns the number of bytes currently held.
+func (b *memoryBudget) Used() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+// estimateMemory returns the approximate decoded size of the image at path
+// (width*height*4 bytes), read from its header without decoding the pixels.
+func estimateMemory(path string) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	cfg, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return 0, err
+	}
+	return int64(cfg.Width) * int64(cfg.Height) * 4, nil
+}
+
+// parseByteSize parses a size such as "512MB", "2G" or "1048576".
+func parseByteSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		mult   int64
+	}{
+		{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30},
+		{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30},
+		{"B", 1},
+	}
+
+	upper := strings.ToUpper(strings.TrimSpace(s))
+	mult := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(upper, u.suffix) {
+			upper = strings.TrimSuffix(upper, u.suffix)
+			mult = u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(upper), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * mult, nil
+}
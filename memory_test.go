@@ -0,0 +1,122 @@
+package main
+
+import (
+	"image"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryBudgetBackpressure(t *testing.T) {
+	b := newMemoryBudget(100)
+
+	b.Acquire(60)
+
+	// A second 60-byte image does not fit until the first is released.
+	acquired := make(chan struct{})
+	go func() {
+		b.Acquire(60)
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatalf("Expected Acquire to wait while the budget is exhausted")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// A small image still fits alongside the first one.
+	b.Acquire(30)
+	if got := b.Used(); got != 90 {
+		t.Errorf("Used() = %d, want 90", got)
+	}
+	b.Release(30)
+
+	b.Release(60)
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected Acquire to proceed after Release")
+	}
+	if got := b.Used(); got != 60 {
+		t.Errorf("Used() = %d, want 60", got)
+	}
+	b.Release(60)
+}
+
+func TestMemoryBudgetOversizedRequest(t *testing.T) {
+	// A request above the limit is admitted when nothing else is held.
+	b := newMemoryBudget(100)
+	b.Acquire(500)
+	b.Release(500)
+}
+
+func TestMemoryBudgetNeverExceedsLimit(t *testing.T) {
+	b := newMemoryBudget(100)
+	sizes := []int64{40, 70, 20, 90, 10, 50, 30, 60}
+
+	var mu sync.Mutex
+	peak := int64(0)
+	var wg sync.WaitGroup
+	for _, n := range sizes {
+		wg.Add(1)
+		go func(n int64) {
+			defer wg.Done()
+			b.Acquire(n)
+			mu.Lock()
+			peak = max(peak, b.Used())
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+			b.Release(n)
+		}(n)
+	}
+	wg.Wait()
+
+	if peak > 100 {
+		t.Errorf("Peak usage %d exceeded the limit", peak)
+	}
+	if got := b.Used(); got != 0 {
+		t.Errorf("Used() = %d after all releases, want 0", got)
+	}
+}
+
+func TestEstimateMemory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.png")
+	writePNG(t, path, image.NewRGBA(image.Rect(0, 0, 30, 20)))
+
+	got, err := estimateMemory(path)
+	if err != nil {
+		t.Fatalf("estimateMemory() error = %v", err)
+	}
+	if got != 30*20*4 {
+		t.Errorf("estimateMemory() = %d, want %d", got, 30*20*4)
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+		wantErr  bool
+	}{
+		{"1048576", 1 << 20, false},
+		{"512MB", 512 << 20, false},
+		{"2g", 2 << 30, false},
+		{"64K", 64 << 10, false},
+		{"lots", 0, true},
+		{"-1M", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseByteSize(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseByteSize(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.expected {
+				t.Errorf("parseByteSize(%q) = %d, want %d", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
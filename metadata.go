@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// embeddedOptions returns the per-image options that the producer of the
+// image file in data embedded under key, for -metadata-options: a PNG tEXt
+// chunk with that keyword, or a JPEG EXIF ImageDescription of the form
+// "<key>=<options>".
+func embeddedOptions(data []byte, key string) (string, bool) {
+	if bytes.HasPrefix(data, pngSignature) {
+		text, err := readPNGText(bytes.NewReader(data))
+		if err != nil {
+			return "", false
+		}
+		value, ok := text[key]
+		return value, ok
+	}
+	tiff, ok := jpegEXIF(data)
+	if !ok {
+		return "", false
+	}
+	desc, ok := exifString(tiff, "ImageDescription")
+	if !ok {
+		return "", false
+	}
+	return strings.CutPrefix(desc, key+"=")
+}
+
+// applyMetadataOptions returns opts overridden by the options embedded in
+// the image at path under opts.MetadataOptions, in the sidecar's JSON form
+// (see mergeOptions), or opts unchanged if it has none.
+func applyMetadataOptions(path string, opts options) (options, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return opts, err
+	}
+	value, ok := embeddedOptions(data, opts.MetadataOptions)
+	if !ok {
+		return opts, nil
+	}
+	merged, err := mergeOptions(opts, []byte(value))
+	if err != nil {
+		return opts, fmt.Errorf("embedded %s options: %w", opts.MetadataOptions, err)
+	}
+	return merged, nil
+}
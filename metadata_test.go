@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMetadataOptions(t *testing.T) {
+	dir := t.TempDir()
+
+	// A dark gray border, too light for the default black threshold.
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{80, 80, 80, 255}}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(20, 20, 80, 80), &image.Uniform{color.RGBA{200, 30, 30, 255}}, image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	text := map[string]string{"CropOptions": `{"black-threshold": 100}`}
+	data, err := insertPNGText(buf.Bytes(), []string{"CropOptions"}, text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "a.png")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := processImage(path, dir, "a.png", options{})
+	if err != nil {
+		t.Fatalf("processImage() error = %v", err)
+	}
+	if res.Bounds != img.Bounds() {
+		t.Fatalf("Without -metadata-options: expected the image kept whole, got %v", res.Bounds)
+	}
+
+	res, err = processImage(path, dir, "a.png", options{MetadataOptions: "CropOptions"})
+	if err != nil {
+		t.Fatalf("processImage() error = %v", err)
+	}
+	if expected := image.Rect(20, 20, 80, 80); res.Bounds != expected {
+		t.Errorf("With the embedded threshold: expected %v, got %v", expected, res.Bounds)
+	}
+
+	// A sidecar still overrides the embedded options.
+	if err := os.WriteFile(path+sidecarSuffix, []byte(`{"no-crop": true}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	res, err = processImage(path, dir, "a.png", options{MetadataOptions: "CropOptions"})
+	if err != nil {
+		t.Fatalf("processImage() error = %v", err)
+	}
+	if res.Bounds != img.Bounds() {
+		t.Errorf("Expected the sidecar's -no-crop to win, got %v", res.Bounds)
+	}
+}
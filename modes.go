@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"strings"
+)
+
+// The detection modes of -mode, which takes one or a comma-separated chain
+// of them.
+const (
+	// modeBW detects a black or white background with the fixed levels.
+	modeBW = "bw"
+	// modeAdaptive is modeBW with the levels derived from each image's
+	// luminance histogram; see adaptiveLevels.
+	modeAdaptive = "adaptive"
+	// modeTransparent is modeBW that also trims the transparent border of
+	// images whose corners are fully transparent.
+	modeTransparent = "transparent"
+	// modeAutoColor also detects a colored background whose corners agree;
+	// see -corner-agreement-tolerance.
+	modeAutoColor = "auto-color"
+	// modeNone leaves the image uncropped.
+	modeNone = "none"
+)
+
+// defaultModes is the chain run without -mode: bw, trimming a transparent
+// border too.
+var defaultModes = []string{modeTransparent}
+
+// autoColorTolerance is the corner agreement tolerance of auto-color when
+// -corner-agreement-tolerance is not set.
+const autoColorTolerance = 16
+
+// parseModes parses a -mode chain such as "bw,auto-color,none". Empty is
+// "bw"; detectModes runs defaultModes instead.
+func parseModes(s string) ([]string, error) {
+	if s == "" {
+		return []string{modeBW}, nil
+	}
+	var modes []string
+	for _, m := range strings.Split(s, ",") {
+		switch m = strings.TrimSpace(m); m {
+		case modeBW, modeTransparent, modeAdaptive, modeAutoColor, modeNone:
+			modes = append(modes, m)
+		default:
+			return nil, fmt.Errorf("unknown mode %q: want %s, %s, %s, %s or %s", m, modeBW, modeTransparent, modeAdaptive, modeAutoColor, modeNone)
+		}
+	}
+	return modes, nil
+}
+
+// optionsForMode returns opts set up to detect img's background with mode.
+func optionsForMode(img image.Image, opts options, mode string) options {
+	switch mode {
+	case modeTransparent:
+		opts.transparent = true
+	case modeAdaptive:
+		if lv, ok := adaptiveLevels(img); ok {
+			opts.Levels = &lv
+			opts.logf("  Adaptive thresholds: black %d, white %d\n", lv.Black[0], lv.White[0])
+		}
+	case modeAutoColor:
+		if opts.CornerAgreementTolerance == 0 {
+			opts.CornerAgreementTolerance = autoColorTolerance
+		}
+	}
+	return opts
+}
+
+// detectModes detects img's content with each mode of opts.Mode in turn
+// until one finds a crop: a background, and bounds other than the whole
+// image. It returns that detection, or else the last mode's, with the
+// options it was made with.
+func detectModes(img image.Image, opts options) (detection, options) {
+	modes, _ := parseModes(opts.Mode)
+	if opts.Mode == "" {
+		modes = defaultModes
+	}
+	var det detection
+	modeOpts := opts
+	for i, mode := range modes {
+		if mode == modeNone {
+			return detection{Bounds: img.Bounds(), Mode: ModeNone, Reason: "mode-none"}, opts
+		}
+		modeOpts = optionsForMode(img, opts, mode)
+		det = detect(img, modeOpts)
+		found := det.Mode != ModeNone && det.Bounds != img.Bounds()
+		if found || i == len(modes)-1 || (opts.ctx != nil && opts.ctx.Err() != nil) {
+			break
+		}
+		if opts.trace != nil {
+			fmt.Fprintf(opts.trace, "  trace: mode %s found no crop, trying %s\n", mode, modes[i+1])
+		}
+	}
+	return det, modeOpts
+}
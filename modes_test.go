@@ -0,0 +1,52 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestModeChain(t *testing.T) {
+	content := image.Rect(20, 20, 80, 80)
+	matte := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(matte, matte.Bounds(), &image.Uniform{color.RGBA{40, 120, 140, 255}}, image.Point{}, draw.Src)
+	draw.Draw(matte, content, &image.Uniform{color.RGBA{230, 200, 160, 255}}, image.Point{}, draw.Src)
+
+	bordered := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(bordered, content, &image.Uniform{color.White}, image.Point{}, draw.Src)
+
+	tests := []struct {
+		name   string
+		img    image.Image
+		mode   string
+		bounds image.Rectangle
+		reason string
+	}{
+		{"matte, bw only", matte, "bw", matte.Bounds(), "colored-corners"},
+		{"matte falls through to auto-color", matte, "bw,auto-color,none", content, "agreeing-corners"},
+		{"black border stops at bw", bordered, "bw,auto-color,none", content, "detected-black"},
+		{"none", bordered, "none", bordered.Bounds(), "mode-none"},
+	}
+	for _, tt := range tests {
+		res, err := planCrop(tt.img, options{Mode: tt.mode})
+		if err != nil {
+			t.Fatalf("%s: planCrop() error = %v", tt.name, err)
+		}
+		if res.Bounds != tt.bounds || res.ModeReason != tt.reason {
+			t.Errorf("%s: expected %v (%s), got %v (%s)", tt.name, tt.bounds, tt.reason, res.Bounds, res.ModeReason)
+		}
+	}
+}
+
+func TestParseModes(t *testing.T) {
+	if modes, err := parseModes(""); err != nil || len(modes) != 1 || modes[0] != modeBW {
+		t.Errorf("parseModes(\"\") = %v, %v, want [bw]", modes, err)
+	}
+	if modes, err := parseModes("adaptive, none"); err != nil || len(modes) != 2 || modes[1] != modeNone {
+		t.Errorf("parseModes(\"adaptive, none\") = %v, %v", modes, err)
+	}
+	if _, err := parseModes("bw,sepia"); err == nil {
+		t.Error("Expected an error for an unknown mode")
+	}
+}
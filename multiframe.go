@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// parseFrames parses a -frames list such as "0,2,5" or "1-3,7" into frame
+// indices, in the order given.
+func parseFrames(s string) ([]int, error) {
+	var indices []int
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		first, last, isRange := strings.Cut(part, "-")
+		from, err := strconv.Atoi(first)
+		if err != nil || from < 0 {
+			return nil, fmt.Errorf("invalid frame %q", part)
+		}
+		to := from
+		if isRange {
+			if to, err = strconv.Atoi(last); err != nil || to < from {
+				return nil, fmt.Errorf("invalid frame range %q", part)
+			}
+		}
+		for i := from; i <= to; i++ {
+			indices = append(indices, i)
+		}
+	}
+	return indices, nil
+}
+
+// loadFrames decodes every frame of the image at path. An animated GIF's
+// frames are composited as they are displayed; other formats have a single
+// frame.
+func loadFrames(path string) ([]image.Image, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	img, format, err := decodeImage(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if format != "gif" {
+		return []image.Image{img}, nil
+	}
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecode, err)
+	}
+	return compositeGIF(g), nil
+}
+
+// compositeGIF renders each frame of g over the frames before it, honoring
+// their disposal methods.
+func compositeGIF(g *gif.GIF) []image.Image {
+	canvas := image.NewRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+	frames := make([]image.Image, 0, len(g.Image))
+	for i, frame := range g.Image {
+		var previous *image.RGBA
+		if g.Disposal[i] == gif.DisposalPrevious {
+			previous = image.NewRGBA(canvas.Bounds())
+			copy(previous.Pix, canvas.Pix)
+		}
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+
+		rendered := image.NewRGBA(canvas.Bounds())
+		copy(rendered.Pix, canvas.Pix)
+		frames = append(frames, rendered)
+
+		switch g.Disposal[i] {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return frames
+}
+
+// processFrames crops the frames of filePath selected by opts.Frames, each
+// to its own content or, with opts.UniformCrop, all to the union of theirs,
+// and writes them as processed_<name>_f<index> in the output format (PNG
+// unless -format says otherwise). The result describes the first selected
+// frame, with the union as its Bounds and no Output.
+func processFrames(filePath, dirPath, filename string, opts options) (fileResult, error) {
+	res := fileResult{Filename: filename}
+
+	indices, err := parseFrames(opts.Frames)
+	if err != nil {
+		return res, err
+	}
+	frames, err := loadFrames(filePath)
+	if err != nil {
+		return res, err
+	}
+	for _, i := range indices {
+		if i >= len(frames) {
+			return res, fmt.Errorf("frame %d out of range: %s has %d frame(s)", i, filename, len(frames))
+		}
+	}
+
+	plans := make([]fileResult, len(indices))
+	var union image.Rectangle
+	for k, i := range indices {
+		plans[k], err = planCrop(frames[i], opts)
+		if err != nil {
+			plans[k].Filename = filename
+			return plans[k], fmt.Errorf("frame %d: %w", i, err)
+		}
+		union = union.Union(plans[k].Bounds)
+	}
+
+	format := "png"
+	if opts.Format != "" {
+		format = opts.Format
+	}
+	base := opts.prefix() + strings.TrimSuffix(filename, filepath.Ext(filename))
+	so := saveOptions{JPEGSubsampling: opts.JPEGSubsampling, PNGBitDepth: opts.PNGBitDepth}
+	for k, i := range indices {
+		plan := plans[k]
+		if opts.UniformCrop {
+			plan.Bounds = union
+		}
+		cropped, err := renderCrop(frames[i], plan, opts)
+		if err != nil {
+			return res, err
+		}
+		outFilename := fmt.Sprintf("%s_f%d%s", base, i, formats[format].Extension)
+		outPath := filepath.Join(dirPath, outFilename)
+		write := func() error { return saveImage(outPath, cropped, format, so) }
+		if err := writeVerified(outPath, cropped.Bounds().Size(), opts, write); err != nil {
+			return res, err
+		}
+		opts.logf("  Saved %s\n", outFilename)
+	}
+
+	res = plans[0]
+	res.Filename = filename
+	res.Bounds = union
+	return res, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/gif"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeAnimatedGIF writes a 100x80 black GIF with one frame per rect, each
+// showing a white rectangle at rect.
+func writeAnimatedGIF(t *testing.T, path string, rects ...image.Rectangle) {
+	t.Helper()
+	g := &gif.GIF{}
+	for _, r := range rects {
+		frame := image.NewPaletted(image.Rect(0, 0, 100, 80), color.Palette{color.Black, color.White})
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				frame.SetColorIndex(x, y, 1)
+			}
+		}
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, 10)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := gif.EncodeAll(f, g); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFrames(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "anim.gif")
+	writeAnimatedGIF(t, path,
+		image.Rect(10, 10, 30, 30),
+		image.Rect(40, 40, 90, 70),
+		image.Rect(50, 20, 80, 60),
+	)
+
+	sizes := func(names ...string) []image.Point {
+		var out []image.Point
+		for _, name := range names {
+			img, _, err := loadImage(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			out = append(out, img.Bounds().Size())
+		}
+		return out
+	}
+
+	if _, err := processImage(path, dir, "anim.gif", options{Frames: "0,2"}); err != nil {
+		t.Fatalf("processImage() error = %v", err)
+	}
+	if got, expected := sizes("processed_anim_f0.png", "processed_anim_f2.png"), []image.Point{{20, 20}, {30, 40}}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected each frame cropped to its own content %v, got %v", expected, got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "processed_anim_f1.png")); err == nil {
+		t.Errorf("Expected frame 1 not to be written")
+	}
+
+	res, err := processImage(path, dir, "anim.gif", options{Frames: "0,2", UniformCrop: true})
+	if err != nil {
+		t.Fatalf("processImage() with -uniform-crop error = %v", err)
+	}
+	if expected := image.Rect(10, 10, 80, 60); res.Bounds != expected {
+		t.Errorf("Expected the union %v, got %v", expected, res.Bounds)
+	}
+	if got, expected := sizes("processed_anim_f0.png", "processed_anim_f2.png"), []image.Point{{70, 50}, {70, 50}}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("With -uniform-crop: expected %v, got %v", expected, got)
+	}
+
+	_, err = processImage(path, dir, "anim.gif", options{Frames: "1-3"})
+	if err == nil || !strings.Contains(err.Error(), "frame 3 out of range") {
+		t.Errorf("Expected an out-of-range error for frame 3, got %v", err)
+	}
+}
+
+func TestParseFrames(t *testing.T) {
+	got, err := parseFrames("0, 2-4,7")
+	if expected := []int{0, 2, 3, 4, 7}; err != nil || !reflect.DeepEqual(got, expected) {
+		t.Errorf("parseFrames() = %v, %v, want %v", got, err, expected)
+	}
+	for _, s := range []string{"", "a", "-1", "3-1", "1,"} {
+		if _, err := parseFrames(s); err == nil {
+			t.Errorf("parseFrames(%q): expected an error", s)
+		}
+	}
+}
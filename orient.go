@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"image"
+)
+
+// Orientations accepted by -orient.
+const (
+	orientPortrait  = "portrait"
+	orientLandscape = "landscape"
+)
+
+// orientImage rotates img 90 degrees clockwise if its aspect doesn't match
+// orientation ("portrait" or "landscape"). Square images and an empty
+// orientation leave img unchanged.
+func orientImage(img image.Image, orientation string) (image.Image, error) {
+	switch orientation {
+	case "", orientPortrait, orientLandscape:
+	default:
+		return nil, fmt.Errorf("unknown orientation: %s", orientation)
+	}
+	if needsRotation(img.Bounds().Size(), orientation) {
+		return rotateClockwise(img), nil
+	}
+	return img, nil
+}
+
+// needsRotation reports whether an image of the given size has the wrong
+// aspect for orientation.
+func needsRotation(size image.Point, orientation string) bool {
+	switch orientation {
+	case orientPortrait:
+		return size.X > size.Y
+	case orientLandscape:
+		return size.Y > size.X
+	}
+	return false
+}
+
+// rotateClockwise returns a copy of img turned 90 degrees clockwise.
+func rotateClockwise(img image.Image) image.Image {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dy(), b.Dx()))
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			dst.Set(b.Max.Y-1-y, x-b.Min.X, img.At(x, y))
+		}
+	}
+	return dst
+}
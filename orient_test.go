@@ -0,0 +1,43 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestOrientPortrait(t *testing.T) {
+	// Landscape content with a red marker in its top-left corner.
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(20, 35, 80, 65), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	red := color.RGBA{255, 0, 0, 255}
+	draw.Draw(img, image.Rect(20, 35, 25, 40), &image.Uniform{red}, image.Point{}, draw.Src)
+
+	res, err := planCrop(img, options{})
+	if err != nil {
+		t.Fatalf("planCrop() error = %v", err)
+	}
+	out, err := renderCrop(img, res, options{Orient: orientPortrait})
+	if err != nil {
+		t.Fatalf("renderCrop() error = %v", err)
+	}
+
+	if size, expected := out.Bounds().Size(), image.Pt(30, 60); size != expected {
+		t.Fatalf("Expected portrait size %v, got %v", expected, size)
+	}
+	// Turning clockwise moves the top-left corner to the top-right.
+	b := out.Bounds()
+	if got := color.RGBAModel.Convert(out.At(b.Max.X-1, b.Min.Y)); got != red {
+		t.Errorf("Expected the marker at the top-right after rotating clockwise, got %v", got)
+	}
+
+	// Already-portrait and square images are left alone.
+	for _, size := range []image.Point{{30, 60}, {40, 40}} {
+		src := image.NewRGBA(image.Rectangle{Max: size})
+		if got, _ := orientImage(src, orientPortrait); got.Bounds().Size() != size {
+			t.Errorf("Expected %v to stay %v, got %v", size, size, got.Bounds().Size())
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// outputPrefix is the prefix of output filenames, which also marks them so
+// later runs over the same directory skip them.
+const outputPrefix = "processed_"
+
+// prefix returns the prefix for output filenames under opts: outputPrefix,
+// unless -no-prefix drops it for outputs written to a separate -out.
+func (opts options) prefix() string {
+	if opts.NoPrefix && opts.OutDir != "" {
+		return ""
+	}
+	return outputPrefix
+}
+
+// outputDir returns the directory the outputs for the images in dirPath are
+// written to: dirPath itself, or with -out the output root, under the
+// subdirectory mirroring the image's own.
+func outputDir(dirPath string, opts options) string {
+	if opts.OutDir == "" {
+		return dirPath
+	}
+	return filepath.Join(opts.OutDir, opts.outSubdir)
+}
+
+// mirrorSubdir returns the subdirectory of -out that mirrors dir: dir
+// relative to the working directory when it lies below it, and otherwise
+// its absolute path without the volume name.
+func mirrorSubdir(dir string) string {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return filepath.Clean(dir)
+	}
+	if wd, err := filepath.Abs("."); err == nil {
+		if rel, err := filepath.Rel(wd, abs); err == nil && filepath.IsLocal(rel) {
+			return rel
+		}
+	}
+	return strings.TrimPrefix(abs, filepath.VolumeName(abs))
+}
+
+// sameFile reports whether paths a and b both exist and are the same file,
+// so that writing a would overwrite b.
+func sameFile(a, b string) bool {
+	ai, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bi, err := os.Stat(b)
+	return err == nil && os.SameFile(ai, bi)
+}
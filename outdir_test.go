@@ -0,0 +1,78 @@
+package main
+
+import (
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOutDir(t *testing.T) {
+	dir := t.TempDir()
+	writePNG(t, filepath.Join(dir, "scan.png"), borderedImage(image.Rect(0, 0, 60, 60), image.Rect(10, 10, 50, 50)))
+	out := filepath.Join(t.TempDir(), "out", "nested")
+
+	if err := processDirectory(dir, options{OutDir: out}); err != nil {
+		t.Fatalf("processDirectory() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "processed_scan.png")); err != nil {
+		t.Errorf("Expected the output under -out: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "processed_scan.png")); err == nil {
+		t.Errorf("Expected nothing written next to the original")
+	}
+
+	// Without the prefix, the output takes the original's name.
+	if err := processDirectory(dir, options{OutDir: out, NoPrefix: true}); err != nil {
+		t.Fatalf("processDirectory() error = %v", err)
+	}
+	if got := readImageSize(t, filepath.Join(out, "scan.png")); got != image.Pt(40, 40) {
+		t.Errorf("Expected a 40x40 output, got %v", got)
+	}
+
+	// An -out that is the input directory must not overwrite originals.
+	_, err := processImage(filepath.Join(dir, "scan.png"), dir, "scan.png", options{OutDir: dir, NoPrefix: true})
+	if err == nil || !strings.Contains(err.Error(), "overwrite") {
+		t.Errorf("Expected an overwrite error, got %v", err)
+	}
+	if got := readImageSize(t, filepath.Join(dir, "scan.png")); got != image.Pt(60, 60) {
+		t.Errorf("Original changed to %v", got)
+	}
+}
+
+func TestOutDirMirrorsStdinList(t *testing.T) {
+	root := t.TempDir()
+	var paths []string
+	for _, sub := range []string{"a", "b"} {
+		path := filepath.Join(root, sub, "scan.png")
+		if err := os.Mkdir(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		writePNG(t, path, borderedImage(image.Rect(0, 0, 60, 60), image.Rect(10, 10, 50, 50)))
+		paths = append(paths, path)
+	}
+	out := t.TempDir()
+
+	list := strings.NewReader(strings.Join(paths, "\n"))
+	if err := processList(list, io.Discard, options{OutDir: out}); err != nil {
+		t.Fatalf("processList() error = %v", err)
+	}
+	for _, path := range paths {
+		mirrored := filepath.Join(out, mirrorSubdir(filepath.Dir(path)), "processed_scan.png")
+		if _, err := os.Stat(mirrored); err != nil {
+			t.Errorf("Expected %s: %v", mirrored, err)
+		}
+	}
+}
+
+// readImageSize returns the size of the image at path.
+func readImageSize(t *testing.T, path string) image.Point {
+	t.Helper()
+	img, _, err := loadImage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img.Bounds().Size()
+}
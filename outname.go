@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// templateFallback stands in for an absent EXIF tag whose placeholder gives
+// no text of its own.
+const templateFallback = "unknown"
+
+var templatePlaceholder = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// nameUnsafe replaces the characters of a placeholder's value that don't
+// belong in a file name, such as the colons of EXIF dates.
+var nameUnsafe = strings.NewReplacer(":", "-", " ", "_", "/", "_", `\`, "_")
+
+// expandOutputTemplate fills in an -output-template for filename, whose
+// EXIF payload is exif (nil if it has none). The placeholders are
+//
+//	{name}                 the file name without its extension
+//	{exif:Tag}             an EXIF tag from exifTags, or templateFallback
+//	{exif:Tag|text}        the tag, or text if the image doesn't have it
+//
+// The template is checked with checkOutputTemplate whoever set it, and the
+// result must be a plain file name, so the output stays in its directory.
+func expandOutputTemplate(tmpl, filename string, exif []byte) (string, error) {
+	if err := checkOutputTemplate(tmpl); err != nil {
+		return "", err
+	}
+	name := templatePlaceholder.ReplaceAllStringFunc(tmpl, func(p string) string {
+		field := p[1 : len(p)-1]
+		if field == "name" {
+			return strings.TrimSuffix(filename, filepath.Ext(filename))
+		}
+		tag, fallback, ok := strings.Cut(strings.TrimPrefix(field, "exif:"), "|")
+		if !ok {
+			fallback = templateFallback
+		}
+		if value, ok := exifString(exif, tag); ok {
+			return nameUnsafe.Replace(value)
+		}
+		return nameUnsafe.Replace(fallback)
+	})
+	if name == "" || name == "." || name == ".." || filepath.Base(name) != name {
+		return "", fmt.Errorf("template %q gives the invalid file name %q", tmpl, name)
+	}
+	return name, nil
+}
+
+// checkOutputTemplate reports placeholders and EXIF tags
+// expandOutputTemplate doesn't know, and templates that would leave the
+// output directory.
+func checkOutputTemplate(tmpl string) error {
+	if strings.ContainsAny(templatePlaceholder.ReplaceAllString(tmpl, ""), `/\`) {
+		return fmt.Errorf("template %q must not contain path separators", tmpl)
+	}
+	for _, m := range templatePlaceholder.FindAllStringSubmatch(tmpl, -1) {
+		field := m[1]
+		if field == "name" {
+			continue
+		}
+		tag, ok := strings.CutPrefix(field, "exif:")
+		tag, _, _ = strings.Cut(tag, "|")
+		if _, known := exifTags[tag]; !ok || !known {
+			return fmt.Errorf("unknown placeholder {%s}", field)
+		}
+	}
+	return nil
+}
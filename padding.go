@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// inset is one side of a -padding value: either a pixel count or a
+// percentage of the cropped dimension on that axis.
+type inset struct {
+	value   float64
+	percent bool
+}
+
+// padding holds the top, right, bottom and left insets, in CSS order.
+type padding [4]inset
+
+// parsePadding parses a -padding value. It takes one value for all sides or
+// four comma-separated values (top,right,bottom,left). Each value is a pixel
+// count ("10" or "10px") or a percentage ("5%").
+func parsePadding(s string) (padding, error) {
+	var p padding
+	if s == "" {
+		return p, nil
+	}
+
+	parts := strings.Split(s, ",")
+	if len(parts) != 1 && len(parts) != 4 {
+		return p, fmt.Errorf("invalid padding %q: want 1 or 4 comma-separated values", s)
+	}
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		in := inset{}
+		switch {
+		case strings.HasSuffix(part, "%"):
+			in.percent = true
+			part = strings.TrimSuffix(part, "%")
+		case strings.HasSuffix(part, "px"):
+			part = strings.TrimSuffix(part, "px")
+		}
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil || v < 0 || math.IsInf(v, 0) || math.IsNaN(v) {
+			return p, fmt.Errorf("invalid padding value %q", parts[i])
+		}
+		in.value = v
+		p[i] = in
+	}
+	if len(parts) == 1 {
+		p[1], p[2], p[3] = p[0], p[0], p[0]
+	}
+	return p, nil
+}
+
+// pixels resolves the insets against crop: percentages of the top and bottom
+// insets refer to the crop height, those of the left and right to its width.
+func (p padding) pixels(crop image.Rectangle) (top, right, bottom, left int) {
+	resolve := func(in inset, dim int) int {
+		v := in.value
+		if in.percent {
+			v *= float64(dim) / 100
+		}
+		// Clamp before converting; checkPaddedSize rejects the result.
+		return int(math.Round(min(v, math.MaxInt32)))
+	}
+	return resolve(p[0], crop.Dy()), resolve(p[1], crop.Dx()), resolve(p[2], crop.Dy()), resolve(p[3], crop.Dx())
+}
+
+// grow returns crop grown outward by the padding.
+func (p padding) grow(crop image.Rectangle) image.Rectangle {
+	top, right, bottom, left := p.pixels(crop)
+	return image.Rect(crop.Min.X-left, crop.Min.Y-top, crop.Max.X+right, crop.Max.Y+bottom)
+}
+
+// expand grows crop outward by the padding, clipped to limit.
+func (p padding) expand(crop, limit image.Rectangle) image.Rectangle {
+	return p.grow(crop).Intersect(limit)
+}
+
+// maxPaddedPixels bounds the canvas that padding past the image edge may
+// allocate: 1<<28 pixels take 1 GiB as RGBA.
+const maxPaddedPixels = 1 << 28
+
+// checkPaddedSize reports a crop padded past the image edge that is too
+// large to allocate: over maxPaddedPixels, or over maxMemory (-max-memory,
+// zero for none) at 4 bytes per pixel.
+func checkPaddedSize(r image.Rectangle, maxMemory int64) error {
+	limit := float64(maxPaddedPixels)
+	if maxMemory > 0 {
+		limit = min(limit, float64(maxMemory)/4)
+	}
+	if float64(r.Dx())*float64(r.Dy()) > limit {
+		return fmt.Errorf("padded crop %dx%d is too large", r.Dx(), r.Dy())
+	}
+	return nil
+}
+
+// -padding-color values other than a hex color. paddingColorAuto, the
+// default, fills with the image's own background color; paddingColorClip
+// keeps padding within the image so there is nothing to fill.
+const (
+	paddingColorAuto = "auto"
+	paddingColorClip = "clip"
+)
+
+// parsePaddingColor parses a -padding-color value: paddingColorAuto (or
+// empty), paddingColorClip or a hex color "#rrggbb". The returned color is
+// nil unless it's a hex color.
+func parsePaddingColor(s string) (color.Color, error) {
+	if s == "" || s == paddingColorAuto || s == paddingColorClip {
+		return nil, nil
+	}
+	c, ok := parseHexColor(s)
+	if !ok {
+		return nil, fmt.Errorf("invalid padding color %q: want %q, %q or #rrggbb", s, paddingColorAuto, paddingColorClip)
+	}
+	return c, nil
+}
+
+// parseHexColor parses an opaque "#rrggbb" color.
+func parseHexColor(s string) (color.Color, bool) {
+	hex, ok := strings.CutPrefix(s, "#")
+	if !ok || len(hex) != 6 {
+		return nil, false
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return nil, false
+	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}, true
+}
+
+// backgroundFill returns the color to pad img with under paddingColorAuto,
+// given the background mode and classifier it was detected with:
+// transparent for a transparent background, else the mean of the corners
+// that are background, or plain black or white if the background was only
+// seen at the edge midpoints.
+func backgroundFill(img image.Image, mode backgroundMode, isBackground func(x, y int) bool) color.Color {
+	if mode == ModeTransparent {
+		return color.Transparent
+	}
+	corners, _ := samplePoints(img.Bounds())
+	var r, g, b, n uint32
+	for _, p := range corners {
+		if mode != ModeNone && !isBackground(p.X, p.Y) {
+			continue
+		}
+		cr, cg, cb, _ := img.At(p.X, p.Y).RGBA()
+		r, g, b, n = r+cr>>8, g+cg>>8, b+cb>>8, n+1
+	}
+	switch {
+	case n > 0:
+		return color.RGBA{uint8(r / n), uint8(g / n), uint8(b / n), 0xff}
+	case mode == ModeWhite:
+		return color.White
+	default:
+		return color.Black
+	}
+}
+
+// padImage returns the rect portion of img on a canvas filled with fill, so
+// the parts of rect outside img take that color.
+func padImage(img image.Image, rect image.Rectangle, fill color.Color) image.Image {
+	dst := image.NewRGBA(rect.Sub(rect.Min))
+	draw.Draw(dst, dst.Bounds(), &image.Uniform{fill}, image.Point{}, draw.Src)
+	inside := rect.Intersect(img.Bounds())
+	draw.Draw(dst, inside.Sub(rect.Min), img, inside.Min, draw.Src)
+	return dst
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"path/filepath"
+	"testing"
+)
+
+func TestParsePadding(t *testing.T) {
+	tests := []struct {
+		spec     string
+		expected padding
+		wantErr  bool
+	}{
+		{"", padding{}, false},
+		{"10", padding{{10, false}, {10, false}, {10, false}, {10, false}}, false},
+		{"5%", padding{{5, true}, {5, true}, {5, true}, {5, true}}, false},
+		{"10px,5%,10px,5%", padding{{10, false}, {5, true}, {10, false}, {5, true}}, false},
+		{"1,2", padding{}, true},
+		{"-3", padding{}, true},
+		{"abc%", padding{}, true},
+		{"Inf", padding{}, true},
+		{"NaN%", padding{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := parsePadding(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePadding(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.expected {
+				t.Errorf("parsePadding(%q) = %v, want %v", tt.spec, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPercentagePaddingScales(t *testing.T) {
+	dir := t.TempDir()
+
+	// The same layout at two scales: content fills the middle 60%.
+	for _, size := range []int{100, 200} {
+		img := image.NewRGBA(image.Rect(0, 0, size, size))
+		draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+		draw.Draw(img, image.Rect(size/5, size/5, size*4/5, size*4/5), &image.Uniform{color.White}, image.Point{}, draw.Src)
+
+		name := "small.png"
+		if size == 200 {
+			name = "large.png"
+		}
+		writePNG(t, filepath.Join(dir, name), img)
+
+		res, err := processImage(filepath.Join(dir, name), dir, name, options{Padding: "10%"})
+		if err != nil {
+			t.Fatalf("processImage() error = %v", err)
+		}
+
+		// 10% of the 0.6*size crop on each side.
+		pad := size * 6 / 100
+		expected := image.Rect(size/5-pad, size/5-pad, size*4/5+pad, size*4/5+pad)
+		if res.Bounds != expected {
+			t.Errorf("size %d: expected %v, got %v", size, expected, res.Bounds)
+		}
+	}
+}
+
+func TestMixedPaddingClipsToImage(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(10, 20, 90, 70), &image.Uniform{color.White}, image.Point{}, draw.Src)
+
+	p, err := parsePadding("30px,5%,4,50%")
+	if err != nil {
+		t.Fatal(err)
+	}
+	crop := findContentBounds(img, defaultLevels)
+	// top 30px (clipped at 0), right 5% of 80 = 4, bottom 4, left 50% of 80 = 40 (clipped at 0).
+	expected := image.Rect(0, 0, 94, 74)
+	if got := p.expand(crop, img.Bounds()); got != expected {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestPaddingColorPastEdge(t *testing.T) {
+	dir := t.TempDir()
+
+	// White content reaching the left edge of a black image, so left
+	// padding has no original pixels to come from.
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, 30, 60, 70), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	writePNG(t, filepath.Join(dir, "a.png"), img)
+
+	tests := []struct {
+		paddingColor string
+		fill         color.RGBA
+	}{
+		{"#ff0000", color.RGBA{255, 0, 0, 255}},
+		{paddingColorAuto, color.RGBA{0, 0, 0, 255}},
+		{"", color.RGBA{0, 0, 0, 255}}, // the default
+	}
+	for _, tt := range tests {
+		res, err := processImage(filepath.Join(dir, "a.png"), dir, "a.png", options{Padding: "10", PaddingColor: tt.paddingColor})
+		if err != nil {
+			t.Fatalf("%s: processImage() error = %v", tt.paddingColor, err)
+		}
+		if expected := image.Rect(-10, 20, 70, 80); res.Bounds != expected {
+			t.Errorf("%s: expected bounds %v, got %v", tt.paddingColor, expected, res.Bounds)
+		}
+
+		out, _, err := loadImage(filepath.Join(dir, res.Output))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := out.Bounds().Size(); got != image.Pt(80, 60) {
+			t.Errorf("%s: expected an 80x60 output, got %v", tt.paddingColor, got)
+		}
+		if got := color.RGBAModel.Convert(out.At(5, 30)); got != tt.fill {
+			t.Errorf("%s: expected padding past the edge filled with %v, got %v", tt.paddingColor, tt.fill, got)
+		}
+		if got := color.RGBAModel.Convert(out.At(15, 30)); got != (color.RGBA{255, 255, 255, 255}) {
+			t.Errorf("%s: expected the content at the old edge, got %v", tt.paddingColor, got)
+		}
+	}
+}
+
+func TestPaddingColorClip(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, 30, 60, 70), &image.Uniform{color.White}, image.Point{}, draw.Src)
+
+	res, err := planCrop(img, options{Padding: "10", PaddingColor: paddingColorClip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := image.Rect(0, 20, 70, 80); res.Bounds != expected {
+		t.Errorf("Expected bounds clipped to %v, got %v", expected, res.Bounds)
+	}
+}
+
+func TestPaddingTooLarge(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 20, 20))
+	draw.Draw(img, image.Rect(5, 5, 15, 15), &image.Uniform{color.White}, image.Point{}, draw.Src)
+
+	for _, tt := range []struct {
+		opts options
+		ok   bool
+	}{
+		{options{Padding: "1e9"}, false},
+		{options{Padding: "100000"}, false},
+		{options{Padding: "1e300%"}, false},
+		{options{Padding: "100", MaxMemory: 1 << 10}, false},
+		{options{Padding: "100"}, true},
+	} {
+		_, err := planCrop(img, tt.opts)
+		if (err == nil) != tt.ok {
+			t.Errorf("%+v: planCrop() error = %v, want ok %v", tt.opts, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import "image"
+
+// paletteBackground returns the palette index -palette-match trims from p:
+// index itself if it is not negative, otherwise the index the most corners
+// share, the top-left corner winning ties. reason says which.
+func paletteBackground(p *image.Paletted, index int) (bg uint8, reason string) {
+	if index >= 0 {
+		return uint8(index), "palette-index"
+	}
+	corners, _ := samplePoints(p.Bounds())
+	counts := make(map[uint8]int, len(corners))
+	bg = p.ColorIndexAt(corners[0].X, corners[0].Y)
+	for _, c := range corners {
+		i := p.ColorIndexAt(c.X, c.Y)
+		counts[i]++
+		if counts[i] > counts[bg] {
+			bg = i
+		}
+	}
+	return bg, "palette-corners"
+}
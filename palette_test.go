@@ -0,0 +1,58 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestPaletteMatch(t *testing.T) {
+	// Indexes 1 and 2 are the same blue; only the index tells the
+	// background (2) from the content's own blue panel (1).
+	blue := color.RGBA{40, 60, 200, 255}
+	pal := color.Palette{color.RGBA{200, 30, 30, 255}, blue, blue}
+	img := image.NewPaletted(image.Rect(0, 0, 80, 60), pal)
+	for i := range img.Pix {
+		img.Pix[i] = 2
+	}
+	for y := 10; y < 50; y++ {
+		for x := 20; x < 70; x++ {
+			img.SetColorIndex(x, y, 1)
+		}
+	}
+	img.SetColorIndex(40, 30, 0)
+
+	if got := detect(img, options{BgIndex: -1}).Bounds; got != img.Bounds() {
+		t.Fatalf("Without -palette-match: expected no crop, got %v", got)
+	}
+
+	d := detect(img, options{PaletteMatch: true, BgIndex: -1})
+	if expected := image.Rect(20, 10, 70, 50); d.Bounds != expected {
+		t.Errorf("Auto index: expected %v, got %v", expected, d.Bounds)
+	}
+	if d.Mode != ModePalette || d.Reason != "palette-corners" {
+		t.Errorf("Auto index: expected palette (palette-corners), got %s (%s)", d.Mode, d.Reason)
+	}
+
+	// Naming the index gives the same crop.
+	d = detect(img, options{PaletteMatch: true, BgIndex: 2})
+	if expected := image.Rect(20, 10, 70, 50); d.Bounds != expected || d.Reason != "palette-index" {
+		t.Errorf("Explicit index: expected %v (palette-index), got %v (%s)", expected, d.Bounds, d.Reason)
+	}
+
+	// The fill ratio and -strict-corners go by the index as well: a patch
+	// of the background index inside the panel is not content.
+	for y := 25; y < 35; y++ {
+		for x := 50; x < 60; x++ {
+			img.SetColorIndex(x, y, 2)
+		}
+	}
+	d = detect(img, options{PaletteMatch: true, BgIndex: -1, JSONReport: "report.json"})
+	if expected := 50*40 - 10*10; d.Content != expected {
+		t.Errorf("Expected %d content pixels, got %d", expected, d.Content)
+	}
+	res, err := planCrop(img, options{PaletteMatch: true, BgIndex: -1, StrictCorners: true})
+	if err != nil || res.Status != "cropped" {
+		t.Errorf("With -strict-corners: expected the background index corners to crop, got %q, %v", res.Status, err)
+	}
+}
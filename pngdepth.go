@@ -0,0 +1,67 @@
+package main
+
+import (
+	"image"
+	"image/color"
+)
+
+// is16Bit reports whether img holds 16 bits per channel, which the PNG
+// encoder writes as a 16-bit PNG.
+func is16Bit(img image.Image) bool {
+	switch img.(type) {
+	case *image.RGBA64, *image.NRGBA64, *image.Gray16:
+		return true
+	}
+	return false
+}
+
+// to8Bit scales a 16-bit channel value to 8 bits, rounding to nearest
+// rather than dropping the low byte.
+func to8Bit(v uint16) uint8 {
+	return uint8((uint32(v) + 128) / 257)
+}
+
+// convertPNGDepth returns img with depth bits per channel for the PNG
+// encoder: 8 rounds 16-bit images down, 16 widens 8-bit ones. Other depths,
+// and images already at depth, are returned unchanged.
+func convertPNGDepth(img image.Image, depth int) image.Image {
+	bounds := img.Bounds()
+	switch {
+	case depth == 8 && is16Bit(img):
+		if gray, ok := img.(*image.Gray16); ok {
+			out := image.NewGray(bounds)
+			for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+				for x := bounds.Min.X; x < bounds.Max.X; x++ {
+					out.SetGray(x, y, color.Gray{to8Bit(gray.Gray16At(x, y).Y)})
+				}
+			}
+			return out
+		}
+		out := image.NewNRGBA(bounds)
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				c := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+				out.SetNRGBA(x, y, color.NRGBA{to8Bit(c.R), to8Bit(c.G), to8Bit(c.B), to8Bit(c.A)})
+			}
+		}
+		return out
+	case depth == 16 && !is16Bit(img):
+		if _, ok := img.(*image.Gray); ok {
+			out := image.NewGray16(bounds)
+			for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+				for x := bounds.Min.X; x < bounds.Max.X; x++ {
+					out.Set(x, y, img.At(x, y))
+				}
+			}
+			return out
+		}
+		out := image.NewNRGBA64(bounds)
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				out.Set(x, y, img.At(x, y))
+			}
+		}
+		return out
+	}
+	return img
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPNGBitDepth(t *testing.T) {
+	dir := t.TempDir()
+
+	img := image.NewNRGBA64(image.Rect(0, 0, 40, 40))
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 40; x++ {
+			img.SetNRGBA64(x, y, color.NRGBA64{A: 0xffff})
+		}
+	}
+	// 0xff00 is 254.0 in 8 bits: dropping the low byte would give 255.
+	for y := 10; y < 30; y++ {
+		for x := 10; x < 30; x++ {
+			img.SetNRGBA64(x, y, color.NRGBA64{R: 0xff00, G: 0xff00, B: 0xff00, A: 0xffff})
+		}
+	}
+	f, err := os.Create(filepath.Join(dir, "deep.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	// bitDepth returns the bit depth recorded in the PNG's IHDR chunk.
+	bitDepth := func() byte {
+		data, err := os.ReadFile(filepath.Join(dir, "processed_deep.png"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data[24]
+	}
+
+	if _, err := processImage(filepath.Join(dir, "deep.png"), dir, "deep.png", options{}); err != nil {
+		t.Fatalf("processImage() error = %v", err)
+	}
+	if got := bitDepth(); got != 16 {
+		t.Errorf("By default: expected the 16-bit depth kept, got %d", got)
+	}
+
+	if _, err := processImage(filepath.Join(dir, "deep.png"), dir, "deep.png", options{PNGBitDepth: 8}); err != nil {
+		t.Fatalf("processImage() error = %v", err)
+	}
+	if got := bitDepth(); got != 8 {
+		t.Errorf("With -png-bit-depth 8: expected depth 8, got %d", got)
+	}
+	out, _, err := loadImage(filepath.Join(dir, "processed_deep.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, _, _, _ := out.At(0, 0).RGBA(); r>>8 != 254 {
+		t.Errorf("Expected 0xff00 rounded to 254, got %d", r>>8)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+// pngSignature is the 8-byte header every PNG file starts with.
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+var errNotPNG = errors.New("not a PNG stream")
+
+// insertPNGText returns a copy of the encoded PNG data with a tEXt chunk for
+// each key/value pair inserted right after the IHDR chunk. image/png cannot
+// write ancillary chunks itself.
+func insertPNGText(data []byte, keys []string, text map[string]string) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngSignature) || len(data) < len(pngSignature)+8 {
+		return nil, errNotPNG
+	}
+	// IHDR is always the first chunk: length, type, data, CRC.
+	ihdrLen := int(binary.BigEndian.Uint32(data[len(pngSignature):]))
+	ihdrEnd := len(pngSignature) + 12 + ihdrLen
+	if ihdrEnd > len(data) {
+		return nil, errNotPNG
+	}
+
+	var buf bytes.Buffer
+	buf.Write(data[:ihdrEnd])
+	for _, key := range keys {
+		payload := append([]byte(key), 0)
+		payload = append(payload, text[key]...)
+		writePNGChunk(&buf, "tEXt", payload)
+	}
+	buf.Write(data[ihdrEnd:])
+	return buf.Bytes(), nil
+}
+
+func writePNGChunk(w *bytes.Buffer, typ string, payload []byte) {
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(payload)))
+	copy(header[4:], typ)
+	w.Write(header[:])
+	w.Write(payload)
+
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(payload)
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+	w.Write(sum[:])
+}
+
+// readPNGText returns the tEXt chunks of the PNG stream read from r.
+func readPNGText(r io.Reader) (map[string]string, error) {
+	sig := make([]byte, len(pngSignature))
+	if _, err := io.ReadFull(r, sig); err != nil || !bytes.Equal(sig, pngSignature) {
+		return nil, errNotPNG
+	}
+
+	text := map[string]string{}
+	var header [8]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return nil, err
+		}
+		length := binary.BigEndian.Uint32(header[:4])
+		typ := string(header[4:])
+		if typ == "IEND" {
+			return text, nil
+		}
+		if typ != "tEXt" {
+			// Skip the chunk data and CRC.
+			if _, err := io.CopyN(io.Discard, r, int64(length)+4); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		payload := make([]byte, length+4)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return nil, err
+		}
+		payload = payload[:length]
+		if key, value, ok := bytes.Cut(payload, []byte{0}); ok {
+			text[string(key)] = string(value)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInsertPNGText(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	text := map[string]string{"A": "one", "B": "two"}
+	data, err := insertPNGText(buf.Bytes(), []string{"A", "B"}, text)
+	if err != nil {
+		t.Fatalf("insertPNGText() error = %v", err)
+	}
+
+	// The result must still decode.
+	if _, err := png.Decode(bytes.NewReader(data)); err != nil {
+		t.Fatalf("Decoding PNG with text chunks failed: %v", err)
+	}
+
+	got, err := readPNGText(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("readPNGText() error = %v", err)
+	}
+	if len(got) != 2 || got["A"] != "one" || got["B"] != "two" {
+		t.Errorf("readPNGText() = %v, want %v", got, text)
+	}
+
+	if _, err := insertPNGText([]byte("not a png"), nil, nil); err == nil {
+		t.Errorf("Expected error for non-PNG data")
+	}
+}
+
+func TestEmbedProvenance(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(20, 20, 80, 80), &image.Uniform{color.White}, image.Point{}, draw.Src)
+
+	dir := t.TempDir()
+	writePNG(t, filepath.Join(dir, "a.png"), img)
+
+	if _, err := processImage(filepath.Join(dir, "a.png"), dir, "a.png", options{EmbedProvenance: true}); err != nil {
+		t.Fatalf("processImage() error = %v", err)
+	}
+
+	f, err := os.Open(filepath.Join(dir, "processed_a.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	text, err := readPNGText(f)
+	if err != nil {
+		t.Fatalf("readPNGText() error = %v", err)
+	}
+
+	expected := "cropped by gazounomawarinoiranaifuchiwokesu; original=100x100; crop=20,20,80,80"
+	if got := text[provenanceKey]; got != expected {
+		t.Errorf("%s = %q, want %q", provenanceKey, got, expected)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"strings"
+)
+
+// preserveColorSlack is how far (RMS over R, G and B, 0-255) a pixel may be
+// from a -preserve-color and still match it, to allow for scanning and
+// compression noise.
+const preserveColorSlack = 16
+
+// parsePreserveColors parses a -preserve-color list of "#rrggbb" colors
+// separated by commas.
+func parsePreserveColors(s string) ([]color.Color, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var colors []color.Color
+	for _, part := range strings.Split(s, ",") {
+		c, ok := parseHexColor(strings.TrimSpace(part))
+		if !ok {
+			return nil, fmt.Errorf("invalid preserve color %q: want #rrggbb", part)
+		}
+		colors = append(colors, c)
+	}
+	return colors, nil
+}
+
+// isPreserved reports whether c matches one of colors.
+func isPreserved(c color.Color, colors []color.Color) bool {
+	for _, p := range colors {
+		if colorDistance(c, p) <= preserveColorSlack {
+			return true
+		}
+	}
+	return false
+}
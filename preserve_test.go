@@ -0,0 +1,52 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestPreserveColor(t *testing.T) {
+	photo := &image.Uniform{color.RGBA{70, 110, 150, 255}}
+
+	// A black scanner border around a photo in a white matte.
+	scanned := image.NewRGBA(image.Rect(0, 0, 120, 120))
+	draw.Draw(scanned, scanned.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(scanned, image.Rect(10, 10, 110, 110), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	draw.Draw(scanned, image.Rect(30, 30, 90, 90), photo, image.Point{}, draw.Src)
+
+	// The same matte without a scanner border.
+	matted := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(matted, matted.Bounds(), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	draw.Draw(matted, image.Rect(20, 20, 80, 80), photo, image.Point{}, draw.Src)
+
+	tests := []struct {
+		name     string
+		img      image.Image
+		preserve string
+		expected image.Rectangle
+	}{
+		{"scanned", scanned, "", image.Rect(30, 30, 90, 90)},
+		{"scanned, white preserved", scanned, "#ffffff", image.Rect(10, 10, 110, 110)},
+		{"matted", matted, "", image.Rect(20, 20, 80, 80)},
+		{"matted, white preserved", matted, "#ff0000, #FFFFFF", matted.Bounds()},
+	}
+	for _, tt := range tests {
+		// -two-color-border would peel the matte after the scanner border.
+		opts := options{TwoColorBorder: true, PreserveColor: tt.preserve}
+		if got := detect(tt.img, opts).Bounds; got != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestParsePreserveColors(t *testing.T) {
+	colors, err := parsePreserveColors("#ffffff,#102030")
+	if err != nil || len(colors) != 2 || colors[1] != (color.RGBA{0x10, 0x20, 0x30, 0xff}) {
+		t.Errorf("parsePreserveColors() = %v, %v", colors, err)
+	}
+	if _, err := parsePreserveColors("white"); err == nil {
+		t.Errorf("Expected an error for a color name")
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// referenceImage is the loaded -reference image.
+type referenceImage struct {
+	path string
+	img  image.Image
+}
+
+// withReference returns opts with the image named by opts.Reference loaded,
+// unless it already is.
+func withReference(opts options) (options, error) {
+	if opts.Reference == "" || (opts.reference != nil && opts.reference.path == opts.Reference) {
+		return opts, nil
+	}
+	img, _, err := loadImage(opts.Reference)
+	if err != nil {
+		return opts, fmt.Errorf("reference %s: %w", opts.Reference, err)
+	}
+	opts.reference = &referenceImage{path: opts.Reference, img: img}
+	return opts, nil
+}
+
+// withinDifference reports whether each of the R, G and B channels of c
+// differs from ref's by at most tolerance levels.
+func withinDifference(c, ref color.Color, tolerance int) bool {
+	r, g, b, _ := c.RGBA()
+	rr, rg, rb, _ := ref.RGBA()
+	within := func(a, b uint32) bool {
+		d := int(a>>8) - int(b>>8)
+		return d >= -tolerance && d <= tolerance
+	}
+	return within(r, rr) && within(g, rg) && within(b, rb)
+}
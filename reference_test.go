@@ -0,0 +1,68 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReference(t *testing.T) {
+	dir := t.TempDir()
+
+	// A patterned stage that no color-based mode can call background.
+	stage := image.NewRGBA(image.Rect(0, 0, 120, 90))
+	for y := 0; y < 90; y++ {
+		for x := 0; x < 120; x++ {
+			stage.Set(x, y, color.RGBA{uint8(x * 2), uint8(y * 2), uint8((x + y) % 7 * 30), 255})
+		}
+	}
+	writePNG(t, filepath.Join(dir, "stage.png"), stage)
+
+	shot := image.NewRGBA(stage.Bounds())
+	draw.Draw(shot, shot.Bounds(), stage, image.Point{}, draw.Src)
+	// Lighting drifts a little between shots.
+	for y := 0; y < 90; y++ {
+		for x := 0; x < 120; x++ {
+			c := shot.RGBAAt(x, y)
+			c.R = min(c.R+5, 255)
+			shot.SetRGBA(x, y, c)
+		}
+	}
+	product := image.Rect(30, 25, 95, 70)
+	draw.Draw(shot, product, &image.Uniform{color.RGBA{20, 200, 90, 255}}, image.Point{}, draw.Src)
+
+	if got := findContentBounds(shot, defaultLevels); got != shot.Bounds() {
+		t.Fatalf("Expected no crop without a reference, got %v", got)
+	}
+
+	opts := options{Reference: filepath.Join(dir, "stage.png"), DiffTolerance: 8}
+	res, err := planCrop(shot, opts)
+	if err != nil {
+		t.Fatalf("planCrop() error = %v", err)
+	}
+	if res.Bounds != product || res.Mode != ModeReference {
+		t.Errorf("Expected a reference crop to %v, got %v (%s)", product, res.Bounds, res.Mode)
+	}
+
+	// An input whose bounds don't start at the origin is compared by
+	// offset, not by absolute coordinates.
+	offset := image.Pt(7, 11)
+	moved := image.NewRGBA(shot.Bounds().Add(offset))
+	draw.Draw(moved, moved.Bounds(), shot, image.Point{}, draw.Src)
+	res, err = planCrop(moved, opts)
+	if err != nil {
+		t.Fatalf("planCrop() with an offset image error = %v", err)
+	}
+	if expected := product.Add(offset); res.Bounds != expected {
+		t.Errorf("Expected an offset reference crop to %v, got %v", expected, res.Bounds)
+	}
+
+	// The reference has to match the input's dimensions.
+	small := image.NewRGBA(image.Rect(0, 0, 60, 45))
+	if _, err := planCrop(small, opts); err == nil || !strings.Contains(err.Error(), "reference") {
+		t.Errorf("Expected a dimension mismatch error, got %v", err)
+	}
+}
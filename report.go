@@ -0,0 +1,470 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime/debug"
+	"strconv"
+)
+
+// jsonReportVersion is the version of the JSON report schema. It is
+// incremented whenever a field is renamed, removed or changes meaning;
+// adding an optional field does not change it.
+const jsonReportVersion = 2
+
+// version is the tool version recorded in the JSON report. Release builds
+// set it with -ldflags "-X main.version=..."; otherwise the module version
+// from the build info is used.
+var version = ""
+
+// toolVersion returns the version of this build, or "devel" when unknown.
+func toolVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "devel"
+}
+
+// reports fans each fileResult out to the reports enabled in the options.
+type reports struct {
+	csv  *trimReport
+	json *jsonReport
+}
+
+// openReports opens the reports requested by opts. Reports that are not
+// requested are simply not written.
+func openReports(opts options) (*reports, error) {
+	r := &reports{}
+	if opts.TrimReport != "" {
+		csv, err := openTrimReport(opts.TrimReport, opts.TruncateReport)
+		if err != nil {
+			return nil, err
+		}
+		r.csv = csv
+	}
+	if opts.JSONReport != "" {
+		r.json = newJSONReport(opts.JSONReport, opts)
+	}
+	return r, nil
+}
+
+// Add records res in every open report.
+func (r *reports) Add(res fileResult) error {
+	if r.csv != nil {
+		if err := r.csv.Add(res); err != nil {
+			return err
+		}
+	}
+	if r.json != nil {
+		r.json.Add(res)
+	}
+	return nil
+}
+
+// Close finishes every open report.
+func (r *reports) Close() error {
+	var err error
+	if r.csv != nil {
+		err = r.csv.Close()
+	}
+	if r.json != nil {
+		if jerr := r.json.Close(); err == nil {
+			err = jerr
+		}
+	}
+	return err
+}
+
+// trimReportHeader is the first row of a -trim-report CSV file.
+var trimReportHeader = []string{
+	"filename", "orig_w", "orig_h",
+	"crop_x0", "crop_y0", "crop_x1", "crop_y1",
+	"mode", "status", "fill_ratio",
+}
+
+// trimReport writes one CSV row per file attempted.
+type trimReport struct {
+	file *os.File
+	w    *csv.Writer
+	// columns is the number of columns of the report's header: a report
+	// written before fill_ratio was added keeps its 9 columns when it is
+	// appended to.
+	columns int
+}
+
+// openTrimReport opens the CSV report at path. An existing report is appended
+// to unless truncate is set; the header is only written to an empty file.
+func openTrimReport(path string, truncate bool) (*trimReport, error) {
+	flags := os.O_RDWR | os.O_CREATE | os.O_APPEND
+	if truncate {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	file, err := os.OpenFile(path, flags, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	r := &trimReport{file: file, w: csv.NewWriter(file), columns: len(trimReportHeader)}
+	if info.Size() == 0 {
+		if err := r.w.Write(trimReportHeader); err != nil {
+			file.Close()
+			return nil, err
+		}
+		return r, nil
+	}
+	header, err := csv.NewReader(io.NewSectionReader(file, 0, info.Size())).Read()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("reading the header of %s: %w", path, err)
+	}
+	r.columns = min(len(header), len(trimReportHeader))
+	return r, nil
+}
+
+// Add writes the row for res. Rows are flushed immediately so the report
+// stays usable even if the run is interrupted.
+func (r *trimReport) Add(res fileResult) error {
+	itoa := strconv.Itoa
+	fill := ""
+	if res.FillRatio != nil {
+		fill = strconv.FormatFloat(*res.FillRatio, 'f', 4, 64)
+	}
+	row := []string{
+		res.Filename, itoa(res.Size.X), itoa(res.Size.Y),
+		itoa(res.Bounds.Min.X), itoa(res.Bounds.Min.Y), itoa(res.Bounds.Max.X), itoa(res.Bounds.Max.Y),
+		res.Mode.String(), res.Status, fill,
+	}
+	if err := r.w.Write(row[:r.columns]); err != nil {
+		return err
+	}
+	r.w.Flush()
+	return r.w.Error()
+}
+
+// Close flushes and closes the report file.
+func (r *trimReport) Close() error {
+	r.w.Flush()
+	if err := r.w.Error(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
+
+// jsonRecord is the JSON report entry for a single file.
+type jsonRecord struct {
+	Filename string `json:"filename"`
+	OrigW    int    `json:"orig_w"`
+	OrigH    int    `json:"orig_h"`
+	CropX0   int    `json:"crop_x0"`
+	CropY0   int    `json:"crop_y0"`
+	CropX1   int    `json:"crop_x1"`
+	CropY1   int    `json:"crop_y1"`
+	// OffsetX and OffsetY are subtracted from coordinates in the original
+	// image to map them into the cropped image.
+	OffsetX int    `json:"offset_x"`
+	OffsetY int    `json:"offset_y"`
+	Mode    string `json:"mode"`
+	// ModeReason is how the mode was decided, e.g. "colored-corners".
+	ModeReason string `json:"mode_reason,omitempty"`
+	Status     string `json:"status"`
+	// Centroid is the mean position of the content pixels, in original
+	// image coordinates.
+	Centroid *jsonPoint `json:"centroid,omitempty"`
+	// RotatedRect is the minimum-area rotated rectangle around the content,
+	// reported with -progressive-scan.
+	RotatedRect *jsonRotatedRect `json:"rotated_rect,omitempty"`
+	// FillRatio is the fraction of the crop that is content rather than
+	// background.
+	FillRatio *float64 `json:"fill_ratio,omitempty"`
+}
+
+// jsonPoint is a fractional image coordinate in the JSON report.
+type jsonPoint struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// jsonRotatedRect is a rotated rectangle in the JSON report. Angle is in
+// degrees, clockwise on screen.
+type jsonRotatedRect struct {
+	CenterX float64 `json:"center_x"`
+	CenterY float64 `json:"center_y"`
+	Width   float64 `json:"width"`
+	Height  float64 `json:"height"`
+	Angle   float64 `json:"angle"`
+}
+
+// jsonDocument is the top-level structure of the JSON report:
+//
+//	{
+//	  "version": 2,            // jsonReportVersion
+//	  "tool_version": "v1.2.0",
+//	  "options": {...},        // jsonOptions: the effective options
+//	  "files": [...]           // one jsonRecord per file attempted
+//	}
+type jsonDocument struct {
+	Version     int          `json:"version"`
+	ToolVersion string       `json:"tool_version"`
+	Options     jsonOptions  `json:"options"`
+	Files       []jsonRecord `json:"files"`
+}
+
+// jsonOptions is the options block of the JSON report: the run's settings,
+// each keyed by its flag's name with "_" for "-", and the per-channel
+// levels in effect. It is spelled out rather than marshaling options, so
+// renaming a field of options doesn't change the report.
+type jsonOptions struct {
+	MaxAspectChange          float64  `json:"max_aspect_change"`
+	DetectOnlyBorderWidth    int      `json:"detect_only_border_width"`
+	VignetteTolerance        int      `json:"vignette_tolerance"`
+	Fuzz                     string   `json:"fuzz"`
+	BlackLevels              [3]uint8 `json:"black_levels"`
+	WhiteLevels              [3]uint8 `json:"white_levels"`
+	GradientBg               int      `json:"gradient_bg"`
+	Reference                string   `json:"reference"`
+	DiffTolerance            int      `json:"diff_tolerance"`
+	CornerAgreementTolerance int      `json:"corner_agreement_tolerance"`
+	Mode                     string   `json:"mode"`
+	EdgeSampleRate           int      `json:"edge_sample_rate"`
+	HueTolerance             float64  `json:"hue_tolerance"`
+	QuantizeAlpha            int      `json:"quantize_alpha"`
+	AlphaThreshold           int      `json:"alpha_threshold"`
+	NoiseTolerance           float64  `json:"noise_tolerance"`
+	PreserveColor            string   `json:"preserve_color"`
+	AutoEscalate             bool     `json:"auto_escalate"`
+	PerSideBackground        bool     `json:"per_side_background"`
+	BgMatchTolerance         int      `json:"bg_match_tolerance"`
+	ToleranceTop             float64  `json:"tolerance_top"`
+	ToleranceBottom          float64  `json:"tolerance_bottom"`
+	ToleranceLeft            float64  `json:"tolerance_left"`
+	ToleranceRight           float64  `json:"tolerance_right"`
+	MaxDetectDepth           int      `json:"max_detect_depth"`
+	ScanOrder                string   `json:"scan_order"`
+	MinRun                   int      `json:"min_run"`
+	IgnoreProtrusions        int      `json:"ignore_protrusions"`
+	CloseRadius              int      `json:"close_radius"`
+	Despeckle                int      `json:"despeckle"`
+	PaletteMatch             bool     `json:"palette_match"`
+	BgIndex                  int      `json:"bg_index"`
+	SnapBlocks               bool     `json:"snap_blocks"`
+	KeepLargest              bool     `json:"keep_largest"`
+	TwoColorBorder           bool     `json:"two_color_border"`
+	StrictCorners            bool     `json:"strict_corners"`
+	MinBorder                int      `json:"min_border"`
+	MinWhiteRatio            float64  `json:"min_white_ratio"`
+	MinContentFraction       float64  `json:"min_content_fraction"`
+	Padding                  string   `json:"padding"`
+	PaddingColor             string   `json:"padding_color"`
+	PreserveOriginalAspect   bool     `json:"preserve_original_aspect"`
+	Orient                   string   `json:"orient"`
+	MaxDim                   int      `json:"max_dim"`
+	ResizeFilter             string   `json:"resize_filter"`
+	MaxFiles                 int      `json:"max_files"`
+	Workers                  int      `json:"jobs"`
+	IncludeHidden            bool     `json:"hidden"`
+	RequireBorder            bool     `json:"require_border"`
+	NoOpOnColorImages        bool     `json:"no_op_on_color_images"`
+	KeepUniform              bool     `json:"keep_uniform"`
+	PNGBitDepth              int      `json:"png_bit_depth"`
+	JPEGSubsampling          string   `json:"jpeg_subsampling"`
+	OutDir                   string   `json:"out"`
+	NoPrefix                 bool     `json:"no_prefix"`
+	InPlace                  bool     `json:"in_place"`
+	OutputTemplate           string   `json:"output_template"`
+	Format                   string   `json:"format"`
+	Frames                   string   `json:"frames"`
+	UniformCrop              bool     `json:"uniform_crop"`
+	Annotate                 bool     `json:"annotate"`
+	AnnotateColor            string   `json:"annotate_color"`
+	DryRun                   bool     `json:"dry_run"`
+	DryRunDiff               bool     `json:"dry_run_diff"`
+	ExtractFrame             bool     `json:"extract_frame"`
+	NoCrop                   bool     `json:"no_crop"`
+	Verify                   bool     `json:"verify"`
+	PreserveExactBytes       bool     `json:"preserve_exact_bytes"`
+	NoReencodeJPEG           bool     `json:"no_reencode_jpeg"`
+	SnapMCU                  bool     `json:"snap_mcu"`
+	OnlyIfSmaller            bool     `json:"only_if_smaller"`
+	Copy                     bool     `json:"copy"`
+	Dedupe                   string   `json:"dedupe"`
+	ContactSheet             string   `json:"contact_sheet"`
+	Columns                  int      `json:"columns"`
+	ThumbSize                int      `json:"thumb_size"`
+	EmbedProvenance          bool     `json:"embed_provenance"`
+	ChecksumSkip             bool     `json:"checksum_skip"`
+	MetadataOptions          string   `json:"metadata_options"`
+	FailuresDir              string   `json:"failures_dir"`
+	MoveBad                  bool     `json:"move_bad"`
+	Incremental              bool     `json:"incremental"`
+	ImageTimeout             string   `json:"image_timeout"`
+	TrimReport               string   `json:"trim_report"`
+	TruncateReport           bool     `json:"truncate_report"`
+	JSONReport               string   `json:"json_report"`
+	ProgressiveScan          bool     `json:"progressive_scan"`
+}
+
+// newJSONOptions returns the options block for opts.
+func newJSONOptions(opts options) jsonOptions {
+	lv := opts.levels()
+	return jsonOptions{
+		MaxAspectChange:          opts.MaxAspectChange,
+		DetectOnlyBorderWidth:    opts.DetectOnlyBorderWidth,
+		VignetteTolerance:        opts.VignetteTolerance,
+		Fuzz:                     opts.Fuzz,
+		BlackLevels:              lv.Black,
+		WhiteLevels:              lv.White,
+		GradientBg:               opts.GradientBg,
+		Reference:                opts.Reference,
+		DiffTolerance:            opts.DiffTolerance,
+		CornerAgreementTolerance: opts.CornerAgreementTolerance,
+		Mode:                     opts.Mode,
+		EdgeSampleRate:           opts.EdgeSampleRate,
+		HueTolerance:             opts.HueTolerance,
+		QuantizeAlpha:            opts.QuantizeAlpha,
+		AlphaThreshold:           opts.AlphaThreshold,
+		NoiseTolerance:           opts.NoiseTolerance,
+		PreserveColor:            opts.PreserveColor,
+		AutoEscalate:             opts.AutoEscalate,
+		PerSideBackground:        opts.PerSideBackground,
+		BgMatchTolerance:         opts.BgMatchTolerance,
+		ToleranceTop:             opts.ToleranceTop,
+		ToleranceBottom:          opts.ToleranceBottom,
+		ToleranceLeft:            opts.ToleranceLeft,
+		ToleranceRight:           opts.ToleranceRight,
+		MaxDetectDepth:           opts.MaxDetectDepth,
+		ScanOrder:                opts.ScanOrder,
+		MinRun:                   opts.MinRun,
+		IgnoreProtrusions:        opts.IgnoreProtrusions,
+		CloseRadius:              opts.CloseRadius,
+		Despeckle:                opts.Despeckle,
+		PaletteMatch:             opts.PaletteMatch,
+		BgIndex:                  opts.BgIndex,
+		SnapBlocks:               opts.SnapBlocks,
+		KeepLargest:              opts.KeepLargest,
+		TwoColorBorder:           opts.TwoColorBorder,
+		StrictCorners:            opts.StrictCorners,
+		MinBorder:                opts.MinBorder,
+		MinWhiteRatio:            opts.MinWhiteRatio,
+		MinContentFraction:       opts.MinContentFraction,
+		Padding:                  opts.Padding,
+		PaddingColor:             opts.PaddingColor,
+		PreserveOriginalAspect:   opts.PreserveOriginalAspect,
+		Orient:                   opts.Orient,
+		MaxDim:                   opts.MaxDim,
+		ResizeFilter:             opts.ResizeFilter,
+		MaxFiles:                 opts.MaxFiles,
+		Workers:                  opts.Workers,
+		IncludeHidden:            opts.IncludeHidden,
+		RequireBorder:            opts.RequireBorder,
+		NoOpOnColorImages:        opts.NoOpOnColorImages,
+		KeepUniform:              opts.KeepUniform,
+		PNGBitDepth:              opts.PNGBitDepth,
+		JPEGSubsampling:          opts.JPEGSubsampling,
+		OutDir:                   opts.OutDir,
+		NoPrefix:                 opts.NoPrefix,
+		InPlace:                  opts.InPlace,
+		OutputTemplate:           opts.OutputTemplate,
+		Format:                   opts.Format,
+		Frames:                   opts.Frames,
+		UniformCrop:              opts.UniformCrop,
+		Annotate:                 opts.Annotate,
+		AnnotateColor:            opts.AnnotateColor,
+		DryRun:                   opts.DryRun,
+		DryRunDiff:               opts.DryRunDiff,
+		ExtractFrame:             opts.ExtractFrame,
+		NoCrop:                   opts.NoCrop,
+		Verify:                   opts.Verify,
+		PreserveExactBytes:       opts.PreserveExactBytes,
+		NoReencodeJPEG:           opts.NoReencodeJPEG,
+		SnapMCU:                  opts.SnapMCU,
+		OnlyIfSmaller:            opts.OnlyIfSmaller,
+		Copy:                     opts.Copy,
+		Dedupe:                   opts.Dedupe,
+		ContactSheet:             opts.ContactSheet,
+		Columns:                  opts.Columns,
+		ThumbSize:                opts.ThumbSize,
+		EmbedProvenance:          opts.EmbedProvenance,
+		ChecksumSkip:             opts.ChecksumSkip,
+		MetadataOptions:          opts.MetadataOptions,
+		FailuresDir:              opts.FailuresDir,
+		MoveBad:                  opts.MoveBad,
+		Incremental:              opts.Incremental,
+		ImageTimeout:             opts.ImageTimeout.String(),
+		TrimReport:               opts.TrimReport,
+		TruncateReport:           opts.TruncateReport,
+		JSONReport:               opts.JSONReport,
+		ProgressiveScan:          opts.ProgressiveScan,
+	}
+}
+
+// jsonReport collects one record per file attempted and writes them as a
+// single JSON document when closed.
+type jsonReport struct {
+	path string
+	doc  jsonDocument
+}
+
+// newJSONReport returns a report to be written to path, with a header
+// recording the tool version and opts.
+func newJSONReport(path string, opts options) *jsonReport {
+	return &jsonReport{path: path, doc: jsonDocument{
+		Version:     jsonReportVersion,
+		ToolVersion: toolVersion(),
+		Options:     newJSONOptions(opts),
+	}}
+}
+
+// Add appends the record for res.
+func (r *jsonReport) Add(res fileResult) {
+	offset := res.Offset()
+	var cent *jsonPoint
+	if res.Centroid != nil {
+		cent = &jsonPoint{X: res.Centroid.X, Y: res.Centroid.Y}
+	}
+	var rot *jsonRotatedRect
+	if r := res.RotatedRect; r != nil {
+		rot = &jsonRotatedRect{CenterX: r.CenterX, CenterY: r.CenterY, Width: r.Width, Height: r.Height, Angle: r.Angle}
+	}
+	r.doc.Files = append(r.doc.Files, jsonRecord{
+		Filename:    res.Filename,
+		OrigW:       res.Size.X,
+		OrigH:       res.Size.Y,
+		CropX0:      res.Bounds.Min.X,
+		CropY0:      res.Bounds.Min.Y,
+		CropX1:      res.Bounds.Max.X,
+		CropY1:      res.Bounds.Max.Y,
+		OffsetX:     offset.X,
+		OffsetY:     offset.Y,
+		Mode:        res.Mode.String(),
+		ModeReason:  res.ModeReason,
+		Status:      res.Status,
+		Centroid:    cent,
+		RotatedRect: rot,
+		FillRatio:   res.FillRatio,
+	})
+}
+
+// Close writes the collected records to the report file.
+func (r *jsonReport) Close() error {
+	if r.doc.Files == nil {
+		r.doc.Files = []jsonRecord{}
+	}
+	data, err := json.MarshalIndent(r.doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(r.path, append(data, '\n'), 0o644)
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestTrimReport(t *testing.T) {
+	dir := t.TempDir()
+
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(20, 20, 80, 80), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	writePNG(t, filepath.Join(dir, "a.png"), img)
+
+	black := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	draw.Draw(black, black.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	writePNG(t, filepath.Join(dir, "b.png"), black)
+
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not an image"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	reportPath := filepath.Join(t.TempDir(), "report.csv")
+	opts := options{TrimReport: reportPath}
+	if err := processDirectory(dir, opts); err != nil {
+		t.Fatalf("processDirectory() error = %v", err)
+	}
+
+	expected := [][]string{
+		trimReportHeader,
+		{"a.png", "100", "100", "20", "20", "80", "80", "black", "cropped", "1.0000"},
+		{"b.png", "10", "10", "0", "0", "0", "0", "black", "failed: image is completely black or empty", ""},
+		{"notes.txt", "0", "0", "0", "0", "0", "0", "none", "skipped: unsupported format", ""},
+	}
+	if got := readCSV(t, reportPath); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Report mismatch\ngot:  %v\nwant: %v", got, expected)
+	}
+
+	// A second run appends rows without repeating the header. The
+	// processed_ output from the first run now shows up as a skipped file.
+	if err := processDirectory(dir, opts); err != nil {
+		t.Fatalf("processDirectory() error = %v", err)
+	}
+	rows := readCSV(t, reportPath)
+	if len(rows) != 2*len(expected) {
+		t.Fatalf("Expected appended report with %d rows, got %d", 2*len(expected), len(rows))
+	}
+	if !reflect.DeepEqual(rows[0], trimReportHeader) {
+		t.Errorf("Expected header row, got %v", rows[0])
+	}
+
+	// Truncating starts the report over.
+	opts.TruncateReport = true
+	if err := processDirectory(dir, opts); err != nil {
+		t.Fatalf("processDirectory() error = %v", err)
+	}
+	rows = readCSV(t, reportPath)
+	if len(rows) != len(expected)+1 {
+		t.Fatalf("Expected truncated report with %d rows, got %d", len(expected)+1, len(rows))
+	}
+	if got, want := rows[4], []string{"processed_a.png", "0", "0", "0", "0", "0", "0", "none", "skipped: already processed", ""}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func readCSV(t *testing.T, path string) [][]string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rows
+}
+
+func TestJSONReportOffset(t *testing.T) {
+	dir := t.TempDir()
+
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(15, 30, 70, 90), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	writePNG(t, filepath.Join(dir, "a.png"), img)
+
+	detected := findContentBounds(img, defaultLevels)
+
+	res, err := processImage(filepath.Join(dir, "a.png"), dir, "a.png", options{})
+	if err != nil {
+		t.Fatalf("processImage() error = %v", err)
+	}
+	if res.Offset() != detected.Min {
+		t.Errorf("Offset() = %v, want %v", res.Offset(), detected.Min)
+	}
+
+	reportPath := filepath.Join(t.TempDir(), "report.json")
+	if err := processDirectory(dir, options{JSONReport: reportPath}); err != nil {
+		t.Fatalf("processDirectory() error = %v", err)
+	}
+
+	data, err := os.ReadFile(reportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc jsonDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	var rec *jsonRecord
+	for i := range doc.Files {
+		if doc.Files[i].Filename == "a.png" {
+			rec = &doc.Files[i]
+		}
+	}
+	if rec == nil {
+		t.Fatalf("Expected a record for a.png, got %+v", doc.Files)
+	}
+	if got := image.Pt(rec.OffsetX, rec.OffsetY); got != detected.Min {
+		t.Errorf("Reported offset = %v, want %v", got, detected.Min)
+	}
+	if got := image.Pt(rec.CropX0, rec.CropY0); got != detected.Min {
+		t.Errorf("Reported crop origin = %v, want %v", got, detected.Min)
+	}
+}
+
+func TestJSONReportCentroid(t *testing.T) {
+	dir := t.TempDir()
+
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(60, 70, 80, 90), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	writePNG(t, filepath.Join(dir, "a.png"), img)
+
+	reportPath := filepath.Join(t.TempDir(), "report.json")
+	if err := processDirectory(dir, options{JSONReport: reportPath}); err != nil {
+		t.Fatalf("processDirectory() error = %v", err)
+	}
+
+	data, err := os.ReadFile(reportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc jsonDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Files) != 1 || doc.Files[0].Centroid == nil {
+		t.Fatalf("Expected one record with a centroid, got %+v", doc.Files)
+	}
+	if got, want := *doc.Files[0].Centroid, (jsonPoint{X: 69.5, Y: 79.5}); got != want {
+		t.Errorf("Centroid = %v, want %v", got, want)
+	}
+}
+
+func TestJSONReportRotatedRect(t *testing.T) {
+	dir := t.TempDir()
+
+	// A 60x30 white rectangle rotated by 20 degrees about (100, 100).
+	const angle = 20.0
+	sin, cos := math.Sincos(angle * math.Pi / 180)
+	img := image.NewRGBA(image.Rect(0, 0, 200, 200))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 200; x++ {
+			px, py := float64(x)+0.5-100, float64(y)+0.5-100
+			u, v := px*cos+py*sin, -px*sin+py*cos
+			if math.Abs(u) <= 30 && math.Abs(v) <= 15 {
+				img.Set(x, y, color.White)
+			}
+		}
+	}
+	writePNG(t, filepath.Join(dir, "a.png"), img)
+
+	reportPath := filepath.Join(t.TempDir(), "report.json")
+	if err := processDirectory(dir, options{JSONReport: reportPath, ProgressiveScan: true}); err != nil {
+		t.Fatalf("processDirectory() error = %v", err)
+	}
+
+	data, err := os.ReadFile(reportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc jsonDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Files) != 1 || doc.Files[0].RotatedRect == nil {
+		t.Fatalf("Expected one record with a rotated rect, got %+v", doc.Files)
+	}
+	r := *doc.Files[0].RotatedRect
+	if math.Abs(r.Angle-angle) > 1.5 {
+		t.Errorf("Angle = %.2f, want about %.0f", r.Angle, angle)
+	}
+	if math.Abs(r.Width-60) > 3 || math.Abs(r.Height-30) > 3 {
+		t.Errorf("Size = %.1fx%.1f, want about 60x30", r.Width, r.Height)
+	}
+	if math.Abs(r.CenterX-100) > 1.5 || math.Abs(r.CenterY-100) > 1.5 {
+		t.Errorf("Center = (%.1f, %.1f), want about (100, 100)", r.CenterX, r.CenterY)
+	}
+}
+
+func TestReportFillRatio(t *testing.T) {
+	dir := t.TempDir()
+
+	// 60x60 content; padding widens the crop to 80x80, so 3600/6400 of it
+	// is content.
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(20, 20, 80, 80), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	writePNG(t, filepath.Join(dir, "a.png"), img)
+
+	reportDir := t.TempDir()
+	opts := options{
+		Padding:    "10px",
+		TrimReport: filepath.Join(reportDir, "report.csv"),
+		JSONReport: filepath.Join(reportDir, "report.json"),
+	}
+	if err := processDirectory(dir, opts); err != nil {
+		t.Fatalf("processDirectory() error = %v", err)
+	}
+
+	rows := readCSV(t, opts.TrimReport)
+	if len(rows) != 2 || rows[1][len(rows[1])-1] != "0.5625" {
+		t.Errorf("Expected CSV fill_ratio 0.5625, got %v", rows)
+	}
+
+	data, err := os.ReadFile(opts.JSONReport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc jsonDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Files) != 1 || doc.Files[0].FillRatio == nil || *doc.Files[0].FillRatio != 0.5625 {
+		t.Errorf("Expected JSON fill_ratio 0.5625, got %+v", doc.Files)
+	}
+}
+
+func TestTrimReportAppendOldFormat(t *testing.T) {
+	dir := t.TempDir()
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(20, 20, 80, 80), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	writePNG(t, filepath.Join(dir, "a.png"), img)
+
+	// A report written before the fill_ratio column existed.
+	report := filepath.Join(t.TempDir(), "report.csv")
+	old := "filename,orig_w,orig_h,crop_x0,crop_y0,crop_x1,crop_y1,mode,status\nold.png,10,10,0,0,10,10,black,cropped\n"
+	if err := os.WriteFile(report, []byte(old), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := processDirectory(dir, options{TrimReport: report}); err != nil {
+		t.Fatalf("processDirectory() error = %v", err)
+	}
+
+	// readCSV fails on rows of differing lengths.
+	rows := readCSV(t, report)
+	if len(rows) != 3 || len(rows[2]) != 9 || rows[2][0] != "a.png" {
+		t.Errorf("Expected a 9-column row appended, got %v", rows)
+	}
+}
+
+func TestFillRatioAdaptiveLevels(t *testing.T) {
+	// A dark gray matte, above the fixed black level, around 60x60
+	// content with a 20x20 hole of the matte's gray.
+	dir := t.TempDir()
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Gray{90}}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(20, 20, 80, 80), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(40, 40, 60, 60), &image.Uniform{color.Gray{90}}, image.Point{}, draw.Src)
+	writePNG(t, filepath.Join(dir, "a.png"), img)
+
+	// The hole is background under the adaptive levels the crop was made
+	// with, so 3200 of the 3600 pixels are content.
+	opts := options{Mode: "adaptive", TrimReport: filepath.Join(t.TempDir(), "report.csv")}
+	res, err := processImage(filepath.Join(dir, "a.png"), dir, "a.png", opts)
+	if err != nil {
+		t.Fatalf("processImage() error = %v", err)
+	}
+	if res.Bounds != image.Rect(20, 20, 80, 80) {
+		t.Fatalf("Expected the adaptive levels to crop to the content, got %v", res.Bounds)
+	}
+	if want := 3200.0 / 3600; res.FillRatio == nil || math.Abs(*res.FillRatio-want) > 1e-9 {
+		t.Errorf("Expected fill ratio %.4f, got %v", want, res.FillRatio)
+	}
+}
+
+func TestJSONReportModeReason(t *testing.T) {
+	dir := t.TempDir()
+
+	img := image.NewRGBA(image.Rect(0, 0, 40, 40))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{200, 30, 30, 255}}, image.Point{}, draw.Src)
+	writePNG(t, filepath.Join(dir, "a.png"), img)
+
+	reportPath := filepath.Join(t.TempDir(), "report.json")
+	if err := processDirectory(dir, options{JSONReport: reportPath}); err != nil {
+		t.Fatalf("processDirectory() error = %v", err)
+	}
+	data, err := os.ReadFile(reportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc jsonDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Files) != 1 || doc.Files[0].ModeReason != "colored-corners" {
+		t.Errorf("Expected mode_reason colored-corners, got %+v", doc.Files)
+	}
+}
+
+func TestJSONReportHeader(t *testing.T) {
+	dir := t.TempDir()
+
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(20, 20, 80, 80), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	writePNG(t, filepath.Join(dir, "a.png"), img)
+
+	reportPath := filepath.Join(t.TempDir(), "report.json")
+	opts := options{JSONReport: reportPath, Padding: "5", MinContentFraction: 0.25}
+	if err := processDirectory(dir, opts); err != nil {
+		t.Fatalf("processDirectory() error = %v", err)
+	}
+
+	data, err := os.ReadFile(reportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var header map[string]json.RawMessage
+	if err := json.Unmarshal(data, &header); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"version", "tool_version", "options", "files"} {
+		if _, ok := header[key]; !ok {
+			t.Errorf("Expected a top-level %q field", key)
+		}
+	}
+
+	var doc jsonDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Version != jsonReportVersion {
+		t.Errorf("version = %d, want %d", doc.Version, jsonReportVersion)
+	}
+	if doc.ToolVersion != toolVersion() {
+		t.Errorf("tool_version = %q, want %q", doc.ToolVersion, toolVersion())
+	}
+	if doc.Options.Padding != "5" || doc.Options.MinContentFraction != 0.25 || doc.Options.JSONReport != reportPath {
+		t.Errorf("Expected the run's options in the header, got %+v", doc.Options)
+	}
+	if doc.Options.BlackLevels != defaultLevels.Black || doc.Options.WhiteLevels != defaultLevels.White {
+		t.Errorf("Expected the default levels in the header, got %v and %v", doc.Options.BlackLevels, doc.Options.WhiteLevels)
+	}
+
+	// The options are keyed by flag name, not by Go field name.
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(header["options"], &keys); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"padding", "min_content_fraction", "json_report", "black_levels"} {
+		if _, ok := keys[key]; !ok {
+			t.Errorf("Expected an options key %q, got %s", key, header["options"])
+		}
+	}
+	if _, ok := keys["Reviewer"]; ok {
+		t.Errorf("Expected no internal fields in the options, got %s", header["options"])
+	}
+}
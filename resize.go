@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"image"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// resizeFilters maps the -resize-filter names to their scalers.
+var resizeFilters = map[string]xdraw.Scaler{
+	"nearest":    xdraw.NearestNeighbor,
+	"bilinear":   xdraw.BiLinear,
+	"catmullrom": xdraw.CatmullRom,
+}
+
+// fitSize returns the size of an image of the given size scaled down so its
+// longer side is at most maxDim, preserving the aspect ratio. Images that
+// already fit are returned unchanged.
+func fitSize(size image.Point, maxDim int) image.Point {
+	if maxDim <= 0 || (size.X <= maxDim && size.Y <= maxDim) {
+		return size
+	}
+	if size.X >= size.Y {
+		return image.Pt(maxDim, max(1, size.Y*maxDim/size.X))
+	}
+	return image.Pt(max(1, size.X*maxDim/size.Y), maxDim)
+}
+
+// resizeImage scales img to size using the named filter.
+func resizeImage(img image.Image, size image.Point, filter string) (image.Image, error) {
+	scaler, ok := resizeFilters[filter]
+	if !ok {
+		return nil, fmt.Errorf("unknown resize filter: %s", filter)
+	}
+	dst := image.NewRGBA(image.Rectangle{Max: size})
+	scaler.Scale(dst, dst.Bounds(), img, img.Bounds(), xdraw.Src, nil)
+	return dst, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestFitSize(t *testing.T) {
+	tests := []struct {
+		name     string
+		size     image.Point
+		maxDim   int
+		expected image.Point
+	}{
+		{"Disabled", image.Pt(400, 200), 0, image.Pt(400, 200)},
+		{"Already Fits", image.Pt(40, 20), 100, image.Pt(40, 20)},
+		{"Landscape", image.Pt(400, 200), 100, image.Pt(100, 50)},
+		{"Portrait", image.Pt(200, 400), 100, image.Pt(50, 100)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fitSize(tt.size, tt.maxDim); got != tt.expected {
+				t.Errorf("fitSize() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestResizeFilters(t *testing.T) {
+	// Left half black, right half white: a single hard vertical edge.
+	img := image.NewRGBA(image.Rect(0, 0, 9, 9))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(4, 0, 9, 9), &image.Uniform{color.White}, image.Point{}, draw.Src)
+
+	// countGrays returns the number of pixels that are neither black nor white.
+	countGrays := func(img image.Image) int {
+		n := 0
+		b := img.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				r, _, _, _ := img.At(x, y).RGBA()
+				if r != 0 && r != 0xffff {
+					n++
+				}
+			}
+		}
+		return n
+	}
+
+	nearest, err := resizeImage(img, image.Pt(3, 3), "nearest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := countGrays(nearest); n != 0 {
+		t.Errorf("Expected nearest to keep hard edges, got %d intermediate pixels", n)
+	}
+
+	smooth, err := resizeImage(img, image.Pt(3, 3), "catmullrom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := countGrays(smooth); n == 0 {
+		t.Errorf("Expected catmullrom to smooth the edge, got only black and white pixels")
+	}
+
+	if _, err := resizeImage(img, image.Pt(3, 3), "lanczos"); err == nil {
+		t.Errorf("Expected error for unknown filter")
+	}
+}
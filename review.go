@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"strings"
+)
+
+// reviewDecision is the user's answer to a proposed crop in -review mode.
+type reviewDecision int
+
+const (
+	// reviewAccept writes the proposed crop.
+	reviewAccept reviewDecision = iota
+	// reviewReject keeps the original image uncropped.
+	reviewReject
+	// reviewSkip writes nothing for the image.
+	reviewSkip
+)
+
+// previewColumns is the width of the ASCII preview in characters.
+const previewColumns = 60
+
+// reviewer shows each proposed crop and asks whether to apply it.
+type reviewer struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+func newReviewer(in io.Reader, out io.Writer) *reviewer {
+	return &reviewer{in: bufio.NewReader(in), out: out}
+}
+
+// isTerminal reports whether f is attached to a terminal, so -review can
+// fall back to accepting every crop when input is piped.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// Review renders the proposed crop of img and prompts until the user answers
+// y (accept), n (reject) or s (skip). If the input ends, the crop is accepted.
+func (r *reviewer) Review(filename string, img image.Image, bounds image.Rectangle) (reviewDecision, error) {
+	fmt.Fprint(r.out, renderPreview(img, bounds, previewColumns))
+	for {
+		fmt.Fprintf(r.out, "Crop %s from %v to %v? [y/n/s] ", filename, img.Bounds(), bounds)
+		line, err := r.in.ReadString('\n')
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "y", "yes":
+			return reviewAccept, nil
+		case "n", "no":
+			return reviewReject, nil
+		case "s", "skip":
+			return reviewSkip, nil
+		}
+		if err == io.EOF {
+			fmt.Fprintln(r.out)
+			return reviewAccept, nil
+		} else if err != nil {
+			return reviewSkip, err
+		}
+	}
+}
+
+// renderPreview draws img as ASCII art, cols characters wide, with the crop
+// rectangle outlined. Each character covers a cell twice as tall as it is
+// wide to roughly match terminal glyph proportions.
+func renderPreview(img image.Image, bounds image.Rectangle, cols int) string {
+	const ramp = " .:-=+*%@"
+
+	b := img.Bounds()
+	cols = min(cols, b.Dx())
+	cell := float64(b.Dx()) / float64(cols)
+	rows := max(1, int(float64(b.Dy())/(cell*2)))
+	cellH := float64(b.Dy()) / float64(rows)
+
+	// Map the crop rectangle onto character cells.
+	left := int(float64(bounds.Min.X-b.Min.X) / cell)
+	right := int(float64(bounds.Max.X-b.Min.X-1) / cell)
+	top := int(float64(bounds.Min.Y-b.Min.Y) / cellH)
+	bottom := int(float64(bounds.Max.Y-b.Min.Y-1) / cellH)
+
+	var sb strings.Builder
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			onX := (col == left || col == right) && row >= top && row <= bottom
+			onY := (row == top || row == bottom) && col >= left && col <= right
+			switch {
+			case onX && onY:
+				sb.WriteByte('+')
+			case onY:
+				sb.WriteByte('-')
+			case onX:
+				sb.WriteByte('|')
+			default:
+				x := b.Min.X + int((float64(col)+0.5)*cell)
+				y := b.Min.Y + int((float64(row)+0.5)*cellH)
+				r, g, bl, _ := img.At(x, y).RGBA()
+				lum := (299*r + 587*g + 114*bl) / 1000
+				sb.WriteByte(ramp[int(lum)*(len(ramp)-1)/0xffff])
+			}
+		}
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRenderPreview(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(20, 20, 80, 80), &image.Uniform{color.White}, image.Point{}, draw.Src)
+
+	// 10 columns of 10px, 5 rows of 20px.
+	preview := renderPreview(img, image.Rect(20, 20, 80, 80), 10)
+	expected := strings.Join([]string{
+		"          ",
+		"  +----+  ",
+		"  |@@@@|  ",
+		"  +----+  ",
+		"          ",
+	}, "\n") + "\n"
+	if preview != expected {
+		t.Errorf("renderPreview() =\n%s\nwant\n%s", preview, expected)
+	}
+}
+
+func TestReviewDecisions(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(20, 20, 80, 80), &image.Uniform{color.White}, image.Point{}, draw.Src)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.png")
+	outPath := filepath.Join(dir, "processed_a.png")
+	writePNG(t, path, img)
+
+	tests := []struct {
+		name     string
+		input    string
+		wantSize image.Point // zero means no output
+	}{
+		{"Accept", "y\n", image.Pt(60, 60)},
+		{"Reject", "n\n", image.Pt(100, 100)},
+		{"Skip", "s\n", image.Point{}},
+		{"Reprompt", "maybe\ny\n", image.Pt(60, 60)},
+		{"EOF Accepts", "", image.Pt(60, 60)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Remove(outPath)
+			opts := options{Reviewer: newReviewer(strings.NewReader(tt.input), io.Discard)}
+			if _, err := processImage(path, dir, "a.png", opts); err != nil {
+				t.Fatalf("processImage() error = %v", err)
+			}
+			if tt.wantSize == (image.Point{}) {
+				if _, err := os.Stat(outPath); !os.IsNotExist(err) {
+					t.Errorf("Expected no output, got err = %v", err)
+				}
+				return
+			}
+			if got := readPNG(t, outPath).Bounds().Size(); got != tt.wantSize {
+				t.Errorf("Expected output size %v, got %v", tt.wantSize, got)
+			}
+		})
+	}
+}
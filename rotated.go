@@ -0,0 +1,127 @@
+package main
+
+import (
+	"image"
+	"math"
+	"sort"
+)
+
+// rotatedRect is a rectangle that may be rotated relative to the image axes.
+type rotatedRect struct {
+	// CenterX and CenterY are the rectangle's center in image coordinates.
+	CenterX, CenterY float64
+	Width, Height    float64
+	// Angle is the rotation of the rectangle's width axis from the x axis,
+	// in degrees within (-45, 45]. With y pointing down, positive angles are
+	// clockwise on screen.
+	Angle float64
+}
+
+// contentRotatedRect returns the minimum-area rotated rectangle enclosing the
+// pixels inside bounds that are not background. ok is false when there are
+// no content pixels.
+func contentRotatedRect(bounds image.Rectangle, isBackground func(x, y int) bool) (rotatedRect, bool) {
+	// Only the outermost content pixels of each row can be on the hull. Use
+	// their outer corners so an axis-aligned block measures exactly.
+	var points []image.Point
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		left, right := -1, -1
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if !isBackground(x, y) {
+				if left < 0 {
+					left = x
+				}
+				right = x
+			}
+		}
+		if left >= 0 {
+			points = append(points,
+				image.Pt(left, y), image.Pt(left, y+1),
+				image.Pt(right+1, y), image.Pt(right+1, y+1))
+		}
+	}
+	if len(points) == 0 {
+		return rotatedRect{}, false
+	}
+	return minAreaRect(convexHull(points)), true
+}
+
+// convexHull returns the convex hull of points in counter-clockwise order,
+// using Andrew's monotone chain.
+func convexHull(points []image.Point) []image.Point {
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].X != points[j].X {
+			return points[i].X < points[j].X
+		}
+		return points[i].Y < points[j].Y
+	})
+	cross := func(o, a, b image.Point) int {
+		return (a.X-o.X)*(b.Y-o.Y) - (a.Y-o.Y)*(b.X-o.X)
+	}
+
+	hull := make([]image.Point, 0, 2*len(points))
+	for _, p := range points {
+		for len(hull) >= 2 && cross(hull[len(hull)-2], hull[len(hull)-1], p) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, p)
+	}
+	lower := len(hull) + 1
+	for i := len(points) - 2; i >= 0; i-- {
+		p := points[i]
+		for len(hull) >= lower && cross(hull[len(hull)-2], hull[len(hull)-1], p) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, p)
+	}
+	return hull[:len(hull)-1]
+}
+
+// minAreaRect finds the minimum-area rectangle enclosing the convex polygon
+// hull with rotating calipers: the best rectangle has a side collinear with
+// one of the hull's edges, so each edge direction is tried in turn.
+func minAreaRect(hull []image.Point) rotatedRect {
+	best := rotatedRect{}
+	bestArea := math.Inf(1)
+	for i := range hull {
+		a, b := hull[i], hull[(i+1)%len(hull)]
+		dx, dy := float64(b.X-a.X), float64(b.Y-a.Y)
+		length := math.Hypot(dx, dy)
+		if length == 0 {
+			continue
+		}
+		dx, dy = dx/length, dy/length
+
+		minU, maxU := math.Inf(1), math.Inf(-1)
+		minV, maxV := math.Inf(1), math.Inf(-1)
+		for _, p := range hull {
+			u := float64(p.X)*dx + float64(p.Y)*dy
+			v := -float64(p.X)*dy + float64(p.Y)*dx
+			minU, maxU = math.Min(minU, u), math.Max(maxU, u)
+			minV, maxV = math.Min(minV, v), math.Max(maxV, v)
+		}
+		if area := (maxU - minU) * (maxV - minV); area < bestArea {
+			bestArea = area
+			cu, cv := (minU+maxU)/2, (minV+maxV)/2
+			best = rotatedRect{
+				CenterX: cu*dx - cv*dy,
+				CenterY: cu*dy + cv*dx,
+				Width:   maxU - minU,
+				Height:  maxV - minV,
+				Angle:   math.Atan2(dy, dx) * 180 / math.Pi,
+			}
+		}
+	}
+
+	// A rectangle turned by 90 degrees is the same rectangle with its sides
+	// swapped; report the smallest equivalent rotation.
+	for best.Angle > 45 {
+		best.Angle -= 90
+		best.Width, best.Height = best.Height, best.Width
+	}
+	for best.Angle <= -45 {
+		best.Angle += 90
+		best.Width, best.Height = best.Height, best.Width
+	}
+	return best
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// sidecarSuffix is appended to an image's path to find its per-image
+// options file, e.g. "scan.png.crop.json".
+const sidecarSuffix = ".crop.json"
+
+// applySidecar returns opts overridden by the sidecar options file next to
+// the image at path, or opts unchanged if there is none.
+func applySidecar(path string, opts options) (options, error) {
+	sidecar := path + sidecarSuffix
+	data, err := os.ReadFile(sidecar)
+	if errors.Is(err, fs.ErrNotExist) {
+		return opts, nil
+	} else if err != nil {
+		return opts, err
+	}
+
+	merged, err := mergeOptions(opts, data)
+	if err != nil {
+		return opts, fmt.Errorf("%s: %w", filepath.Base(sidecar), err)
+	}
+	return merged, nil
+}
+
+// perImageOptions maps the flags a sidecar or embedded options can set to
+// the options fields they set: how the border is detected and how the crop
+// around the content is shaped. Everything else, such as output naming and
+// location, reports and file selection, applies to the whole run and stays
+// as the flags set it. The thresholds are in levelFlags.
+var perImageOptions = map[string]string{
+	"max-aspect-change":          "MaxAspectChange",
+	"detect-only-border-width":   "DetectOnlyBorderWidth",
+	"vignette-tolerance":         "VignetteTolerance",
+	"fuzz":                       "Fuzz",
+	"hue-tolerance":              "HueTolerance",
+	"mode":                       "Mode",
+	"edge-sample-rate":           "EdgeSampleRate",
+	"corner-agreement-tolerance": "CornerAgreementTolerance",
+	"gradient-bg":                "GradientBg",
+	"diff-tolerance":             "DiffTolerance",
+	"alpha-threshold":            "AlphaThreshold",
+	"quantize-alpha":             "QuantizeAlpha",
+	"preserve-color":             "PreserveColor",
+	"bg-match-tolerance":         "BgMatchTolerance",
+	"per-side-background":        "PerSideBackground",
+	"auto-escalate":              "AutoEscalate",
+	"noise-tolerance":            "NoiseTolerance",
+	"tolerance-top":              "ToleranceTop",
+	"tolerance-bottom":           "ToleranceBottom",
+	"tolerance-left":             "ToleranceLeft",
+	"tolerance-right":            "ToleranceRight",
+	"max-detect-depth":           "MaxDetectDepth",
+	"scan-order":                 "ScanOrder",
+	"min-run":                    "MinRun",
+	"ignore-protrusions":         "IgnoreProtrusions",
+	"despeckle":                  "Despeckle",
+	"close-radius":               "CloseRadius",
+	"palette-match":              "PaletteMatch",
+	"bg-index":                   "BgIndex",
+	"snap-blocks":                "SnapBlocks",
+	"keep-largest":               "KeepLargest",
+	"min-border":                 "MinBorder",
+	"two-color-border":           "TwoColorBorder",
+	"strict-corners":             "StrictCorners",
+	"min-white-ratio":            "MinWhiteRatio",
+	"min-content-fraction":       "MinContentFraction",
+	"padding":                    "Padding",
+	"padding-color":              "PaddingColor",
+	"preserve-original-aspect":   "PreserveOriginalAspect",
+	"no-crop":                    "NoCrop",
+	"no-op-on-color-images":      "NoOpOnColorImages",
+	"require-border":             "RequireBorder",
+	"keep-uniform":               "KeepUniform",
+}
+
+// levelFlag is a threshold flag, which sets the black or white level of
+// some channels of Levels.
+type levelFlag struct {
+	name     string
+	white    bool
+	channels []int
+}
+
+// levelFlags are the threshold flags a sidecar or embedded options can
+// set. As on the command line, the per-channel flags override
+// -black-threshold and -white-threshold.
+var levelFlags = []levelFlag{
+	{"black-threshold", false, []int{0, 1, 2}},
+	{"white-threshold", true, []int{0, 1, 2}},
+	{"black-r", false, []int{0}},
+	{"black-g", false, []int{1}},
+	{"black-b", false, []int{2}},
+	{"white-r", true, []int{0}},
+	{"white-g", true, []int{1}},
+	{"white-b", true, []int{2}},
+}
+
+// mergeOptions overrides opts with the JSON object in data, which uses the
+// flag names (e.g. {"min-content-fraction": 0.5}). Only the perImageOptions
+// and levelFlags flags can be set, matched exactly, and the result must
+// pass validateOptions.
+func mergeOptions(opts options, data []byte) (options, error) {
+	var flags map[string]json.RawMessage
+	if err := json.Unmarshal(data, &flags); err != nil {
+		return opts, err
+	}
+
+	merged := opts
+	for name, value := range flags {
+		field, ok := perImageOptions[name]
+		if !ok {
+			if !slices.ContainsFunc(levelFlags, func(f levelFlag) bool { return f.name == name }) {
+				return opts, fmt.Errorf("option %q can't be set per image", name)
+			}
+			continue
+		}
+		// Decode under the field's own name, which encoding/json can't
+		// mistake for another field.
+		obj, err := json.Marshal(map[string]json.RawMessage{field: value})
+		if err != nil {
+			return opts, err
+		}
+		if err := json.Unmarshal(obj, &merged); err != nil {
+			return opts, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	// Set the levels in a copy, which the other images don't share, so
+	// that setting some of them keeps the rest.
+	lv := opts.levels()
+	for _, f := range levelFlags {
+		value, ok := flags[f.name]
+		if !ok {
+			continue
+		}
+		var level int
+		if err := json.Unmarshal(value, &level); err != nil {
+			return opts, fmt.Errorf("%s: %w", f.name, err)
+		}
+		if level < 0 || level > 255 {
+			return opts, fmt.Errorf("-%s must be between 0 and 255", f.name)
+		}
+		for _, i := range f.channels {
+			if f.white {
+				lv.White[i] = uint8(level)
+			} else {
+				lv.Black[i] = uint8(level)
+			}
+		}
+		merged.Levels = &lv
+	}
+
+	if err := validateOptions(merged); err != nil {
+		return opts, err
+	}
+	return merged, nil
+}
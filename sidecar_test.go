@@ -0,0 +1,85 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSidecarOverride(t *testing.T) {
+	dir := t.TempDir()
+
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(40, 40, 60, 60), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	writePNG(t, filepath.Join(dir, "a.png"), img)
+	writePNG(t, filepath.Join(dir, "b.png"), img)
+
+	// b.png's content is only 4% of the image, below its sidecar's limit.
+	if err := os.WriteFile(filepath.Join(dir, "b.png"+sidecarSuffix), []byte(`{"min-content-fraction": 0.1}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := processDirectory(dir, options{}); err != nil {
+		t.Fatalf("processDirectory() error = %v", err)
+	}
+
+	if got, expected := readPNG(t, filepath.Join(dir, "processed_a.png")).Bounds().Size(), image.Pt(20, 20); got != expected {
+		t.Errorf("a.png: expected crop to %v, got %v", expected, got)
+	}
+	if got, expected := readPNG(t, filepath.Join(dir, "processed_b.png")).Bounds().Size(), image.Pt(100, 100); got != expected {
+		t.Errorf("b.png: expected sidecar to keep the original %v, got %v", expected, got)
+	}
+}
+
+func TestSidecarUnknownField(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.png")
+	if err := os.WriteFile(path+sidecarSuffix, []byte(`{"black-treshold": 80}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := applySidecar(path, options{}); err == nil {
+		t.Error("Expected an error for an unknown sidecar field")
+	}
+}
+
+func TestSidecarRestricted(t *testing.T) {
+	lv := defaultLevels
+	opts := options{OutDir: "out", Levels: &lv}
+	for _, data := range []string{
+		`{"output-template": "/../../escaped"}`,
+		`{"out": "/tmp"}`,
+		`{"trim-report": "report.csv"}`,
+		// Field names are not flag names, in any case.
+		`{"MinContentFraction": 0.5}`,
+		`{"mincontentfraction": 0.5}`,
+		`{"Levels": {"Black": [100, 100, 100]}}`,
+		`{"min-content-fraction": 2}`,
+		`{"min-content-fraction": "0.5"}`,
+		`{"padding": "-5"}`,
+		`{"black-threshold": 200}`,
+		`{"black-r": 256}`,
+		`{"white-threshold": 50, "white-g": 200}`,
+	} {
+		if merged, err := mergeOptions(opts, []byte(data)); err == nil {
+			t.Errorf("mergeOptions(%s): expected an error, got %+v", data, merged)
+		}
+	}
+
+	// Levels are merged into a copy: the per-channel flags override the
+	// threshold, the other levels keep their values and the caller's levels
+	// are untouched.
+	merged, err := mergeOptions(opts, []byte(`{"black-g": 80, "black-threshold": 100}`))
+	if err != nil {
+		t.Fatalf("mergeOptions() error = %v", err)
+	}
+	if merged.Levels.Black != [3]uint8{100, 80, 100} || merged.Levels.White != defaultLevels.White {
+		t.Errorf("Expected black 100, 80, 100 with the default white, got %+v", *merged.Levels)
+	}
+	if lv != defaultLevels {
+		t.Errorf("Expected the shared levels unchanged, got %+v", lv)
+	}
+}
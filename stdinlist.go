@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// processList processes each path read from r, one per line, and writes a
+// "path<TAB>status<TAB>bounds" line per path to w. Failures are reported on
+// their line and do not stop the stream.
+func processList(r io.Reader, w io.Writer, opts options) (err error) {
+	rep, err := openReports(opts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := rep.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	noBorder, collected, undecodable := 0, 0, 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		path := strings.TrimSpace(scanner.Text())
+		if path == "" {
+			continue
+		}
+
+		res := fileResult{Filename: filepath.Base(path)}
+		if reason := skipReason(path, opts); reason != "" {
+			res.Status = "skipped: " + reason
+		} else {
+			fmt.Fprintf(logOutput, "Processing: %s\n", path)
+			fileOpts := opts
+			if opts.OutDir != "" {
+				fileOpts.outSubdir = mirrorSubdir(filepath.Dir(path))
+			}
+			res, err = processImage(path, filepath.Dir(path), res.Filename, fileOpts)
+			if opts.FailuresDir != "" && !opts.DryRun && isCollectedFailure(res, err) {
+				// Paths from different directories can share a name, so
+				// mirror their directories as -out does.
+				failures := filepath.Join(opts.FailuresDir, mirrorSubdir(filepath.Dir(path)))
+				if cerr := collectFailure(failures, path); cerr != nil {
+					return cerr
+				}
+				collected++
+			}
+			if err != nil {
+				res.Status = "failed: " + err.Error()
+				if errors.Is(err, ErrNoBorder) {
+					noBorder++
+				}
+				if errors.Is(err, ErrDecode) {
+					undecodable++
+				}
+			}
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\n", path, res.Status, formatBounds(res.Bounds))
+		if err := rep.Add(res); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	failuresSummary(collected, opts.FailuresDir)
+	if opts.DryRun {
+		if err := decodeFailureError(undecodable); err != nil {
+			return err
+		}
+	}
+	return noBorderError(noBorder)
+}
+
+// formatBounds renders r as "x0,y0,x1,y1", or "-" for an empty rectangle.
+func formatBounds(r image.Rectangle) string {
+	if r.Empty() {
+		return "-"
+	}
+	return fmt.Sprintf("%d,%d,%d,%d", r.Min.X, r.Min.Y, r.Max.X, r.Max.Y)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProcessList(t *testing.T) {
+	dir := t.TempDir()
+
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(20, 20, 80, 80), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	good := filepath.Join(dir, "good.png")
+	writePNG(t, good, img)
+
+	black := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	draw.Draw(black, black.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	empty := filepath.Join(dir, "black.png")
+	writePNG(t, empty, black)
+
+	text := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(text, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing.png")
+
+	// Feed the paths through a pipe, as `find ... | cropper -stdin-list` would.
+	pr, pw := io.Pipe()
+	go func() {
+		for _, p := range []string{good, "", empty, text, missing} {
+			fmt.Fprintln(pw, p)
+		}
+		pw.Close()
+	}()
+
+	var out bytes.Buffer
+	if err := processList(pr, &out, options{}); err != nil {
+		t.Fatalf("processList() error = %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("Expected 4 status lines, got %d:\n%s", len(lines), out.String())
+	}
+
+	_, statErr := os.Stat(missing)
+	expected := []string{
+		good + "\tcropped\t20,20,80,80",
+		empty + "\tfailed: image is completely black or empty\t-",
+		text + "\tskipped: unsupported format\t-",
+		missing + "\tskipped: unreadable: " + statErr.Error() + "\t-",
+	}
+	for i, want := range expected {
+		if lines[i] != want {
+			t.Errorf("line %d = %q, want %q", i, lines[i], want)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "processed_good.png")); err != nil {
+		t.Errorf("Expected processed_good.png, got err = %v", err)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// sniffLen is how much of a stream is buffered to detect its content type,
+// the most http.DetectContentType looks at.
+const sniffLen = 512
+
+// cropStream decodes an image from r, crops its border as planCrop decides
+// and encodes the result to w, in opts.Format if set or else the input's
+// format. It never touches the filesystem, so archive entries go through
+// it. An image that opts says to leave alone is written out uncropped.
+func cropStream(r io.Reader, w io.Writer, opts options) (fileResult, error) {
+	var res fileResult
+	// Peek rather than read, so the sniffed bytes are still there for the
+	// decoder.
+	br := bufio.NewReaderSize(r, sniffLen)
+	head, err := br.Peek(sniffLen)
+	if err != nil && err != io.EOF {
+		return res, err
+	}
+	mime := detectContentType(head)
+	if _, ok := formatByMIME(mime); !ok {
+		return res, fmt.Errorf("%w: %s", ErrUnsupportedFormat, mime)
+	}
+
+	img, format, err := decodeImage(br)
+	if err != nil {
+		return res, err
+	}
+
+	res, err = planCrop(img, opts)
+	if err != nil {
+		return res, err
+	}
+	cropped, err := renderCrop(img, res, opts)
+	if err != nil {
+		return res, err
+	}
+
+	if opts.Format != "" {
+		format = opts.Format
+	}
+	return res, encodeImage(w, cropped, format, saveOptionsFor(res, opts))
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func TestCropStream(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(20, 30, 80, 60), &image.Uniform{color.White}, image.Point{}, draw.Src)
+
+	tests := []struct {
+		name   string
+		encode func(*bytes.Buffer) error
+		format string
+	}{
+		{"png", func(b *bytes.Buffer) error { return png.Encode(b, img) }, "png"},
+		{"jpeg", func(b *bytes.Buffer) error { return jpeg.Encode(b, img, &jpeg.Options{Quality: 100}) }, "jpeg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var in, out bytes.Buffer
+			if err := tt.encode(&in); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := cropStream(&in, &out, options{}); err != nil {
+				t.Fatalf("cropStream() error = %v", err)
+			}
+
+			got, format, err := image.Decode(&out)
+			if err != nil {
+				t.Fatalf("Decoding output: %v", err)
+			}
+			if format != tt.format {
+				t.Errorf("Expected %s output, got %s", tt.format, format)
+			}
+			if size, expected := got.Bounds().Size(), image.Pt(60, 30); size != expected {
+				t.Errorf("Expected size %v, got %v", expected, size)
+			}
+		})
+	}
+}
+
+func TestCropStreamForcedFormat(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 50, 50))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(10, 10, 40, 40), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+
+	var in, out bytes.Buffer
+	if err := png.Encode(&in, img); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cropStream(&in, &out, options{Format: "jpeg"}); err != nil {
+		t.Fatalf("cropStream() error = %v", err)
+	}
+	if _, format, err := image.DecodeConfig(&out); err != nil || format != "jpeg" {
+		t.Errorf("Expected jpeg output, got %q (err %v)", format, err)
+	}
+}
+
+func TestCropStreamUnsupported(t *testing.T) {
+	_, err := cropStream(bytes.NewReader([]byte("not an image")), &bytes.Buffer{}, options{})
+	if !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("Expected ErrUnsupportedFormat, got %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// dominantEdgeColor returns the most common color among the corner and edge
+// midpoint samples used for background detection, formatted as "#RRGGBB".
+// Ties go to the sample that appears first, so corners win over midpoints.
+func dominantEdgeColor(img image.Image) string {
+	corners, midpoints := samplePoints(img.Bounds())
+	counts := map[string]int{}
+	best := ""
+	for _, p := range append(corners, midpoints...) {
+		c := color.RGBAModel.Convert(img.At(p.X, p.Y)).(color.RGBA)
+		hex := fmt.Sprintf("#%02X%02X%02X", c.R, c.G, c.B)
+		counts[hex]++
+		if best == "" || counts[hex] > counts[best] {
+			best = hex
+		}
+	}
+	return best
+}
+
+// surveyBorderColors tallies the dominant edge color of every image under
+// path (a directory or a single file) without cropping anything.
+func surveyBorderColors(path string, opts options) (map[string]int, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := []string{path}
+	if info.IsDir() {
+		files, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		paths = paths[:0]
+		for _, file := range files {
+			if file.IsDir() {
+				continue
+			}
+			fullPath := filepath.Join(path, file.Name())
+			if skipReason(fullPath, opts) == "" {
+				paths = append(paths, fullPath)
+			}
+		}
+	}
+
+	tally := map[string]int{}
+	for _, p := range paths {
+		img, _, err := loadImage(p)
+		if err != nil {
+			fmt.Fprintf(logOutput, "  Failed to read %s: %v\n", filepath.Base(p), err)
+			continue
+		}
+		tally[dominantEdgeColor(img)]++
+	}
+	return tally, nil
+}
+
+// writeColorHistogram prints tally as "#000000: 412, #FFFFFF: 203", most
+// common color first.
+func writeColorHistogram(w io.Writer, tally map[string]int) {
+	colors := make([]string, 0, len(tally))
+	for c := range tally {
+		colors = append(colors, c)
+	}
+	sort.Slice(colors, func(i, j int) bool {
+		if tally[colors[i]] != tally[colors[j]] {
+			return tally[colors[i]] > tally[colors[j]]
+		}
+		return colors[i] < colors[j]
+	})
+
+	for i, c := range colors {
+		if i > 0 {
+			fmt.Fprint(w, ", ")
+		}
+		fmt.Fprintf(w, "%s: %d", c, tally[c])
+	}
+	fmt.Fprintln(w)
+}
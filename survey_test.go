@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBorderColorSurvey(t *testing.T) {
+	dir := t.TempDir()
+
+	bordered := func(name string, bg color.Color) {
+		img := image.NewRGBA(image.Rect(0, 0, 50, 50))
+		draw.Draw(img, img.Bounds(), &image.Uniform{bg}, image.Point{}, draw.Src)
+		draw.Draw(img, image.Rect(10, 10, 40, 40), &image.Uniform{color.RGBA{200, 30, 30, 255}}, image.Point{}, draw.Src)
+		writePNG(t, filepath.Join(dir, name), img)
+	}
+	bordered("a.png", color.Black)
+	bordered("b.png", color.Black)
+	bordered("c.png", color.White)
+	bordered("d.png", color.RGBA{0xF2, 0xF2, 0xF2, 0xFF})
+
+	tally, err := surveyBorderColors(dir, options{})
+	if err != nil {
+		t.Fatalf("surveyBorderColors() error = %v", err)
+	}
+
+	var buf bytes.Buffer
+	writeColorHistogram(&buf, tally)
+	expected := "#000000: 2, #F2F2F2: 1, #FFFFFF: 1\n"
+	if buf.String() != expected {
+		t.Errorf("Histogram = %q, want %q", buf.String(), expected)
+	}
+
+	// The survey is read-only.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 {
+		t.Errorf("Expected no files to be written, found %d entries", len(entries))
+	}
+}
+
+func TestDominantEdgeColorOccludedCorner(t *testing.T) {
+	// One corner covered by a watermark: the majority still wins.
+	img := image.NewRGBA(image.Rect(0, 0, 50, 50))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, 0, 5, 5), &image.Uniform{color.RGBA{255, 0, 0, 255}}, image.Point{}, draw.Src)
+
+	if got := dominantEdgeColor(img); got != "#FFFFFF" {
+		t.Errorf("dominantEdgeColor() = %s, want #FFFFFF", got)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// TIFF tags and field types used for the resolution.
+const (
+	tiffTagXResolution    = 282
+	tiffTagYResolution    = 283
+	tiffTagResolutionUnit = 296
+
+	tiffTypeASCII    = 2
+	tiffTypeShort    = 3
+	tiffTypeLong     = 4
+	tiffTypeRational = 5
+)
+
+// tiffResolution is the physical resolution recorded in a TIFF file. X and
+// Y are rationals (numerator, denominator) in pixels per Unit; when they
+// differ, the pixels are not square.
+type tiffResolution struct {
+	X, Y [2]uint32
+	Unit uint16
+}
+
+// square reports whether the resolution describes square pixels.
+func (r tiffResolution) square() bool {
+	return uint64(r.X[0])*uint64(r.Y[1]) == uint64(r.Y[0])*uint64(r.X[1])
+}
+
+// swapped returns r for the image rotated by 90 degrees.
+func (r tiffResolution) swapped() tiffResolution {
+	r.X, r.Y = r.Y, r.X
+	return r
+}
+
+func (r tiffResolution) String() string {
+	return fmt.Sprintf("%d/%d x %d/%d", r.X[0], r.X[1], r.Y[0], r.Y[1])
+}
+
+// tiffEntry is a 12-byte entry of a TIFF image file directory.
+type tiffEntry struct {
+	offset int // of the entry within the file
+	tag    uint16
+	typ    uint16
+	count  uint32
+}
+
+// tiffEntries returns the byte order of the TIFF file in data and the
+// entries of its first image file directory.
+func tiffEntries(data []byte) (binary.ByteOrder, []tiffEntry, error) {
+	if len(data) < 8 {
+		return nil, nil, errors.New("tiff: short header")
+	}
+	var order binary.ByteOrder
+	switch string(data[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return nil, nil, errors.New("tiff: bad header")
+	}
+
+	entries, err := tiffDirectory(data, order, int(order.Uint32(data[4:8])))
+	if err != nil {
+		return nil, nil, err
+	}
+	return order, entries, nil
+}
+
+// tiffDirectory returns the entries of the image file directory at offset
+// ifd of the TIFF file in data.
+func tiffDirectory(data []byte, order binary.ByteOrder, ifd int) ([]tiffEntry, error) {
+	if ifd < 8 || ifd+2 > len(data) {
+		return nil, errors.New("tiff: bad directory offset")
+	}
+	n := int(order.Uint16(data[ifd:]))
+	if ifd+2+12*n > len(data) {
+		return nil, errors.New("tiff: truncated directory")
+	}
+	entries := make([]tiffEntry, n)
+	for i := range entries {
+		off := ifd + 2 + 12*i
+		entries[i] = tiffEntry{
+			offset: off,
+			tag:    order.Uint16(data[off:]),
+			typ:    order.Uint16(data[off+2:]),
+			count:  order.Uint32(data[off+4:]),
+		}
+	}
+	return entries, nil
+}
+
+// readTIFFResolution returns the resolution recorded in the TIFF file in
+// data. ok is false if it has none.
+func readTIFFResolution(data []byte) (res tiffResolution, ok bool) {
+	order, entries, err := tiffEntries(data)
+	if err != nil {
+		return res, false
+	}
+	res.Unit = 2 // inches, the TIFF default
+	var haveX, haveY bool
+	for _, e := range entries {
+		switch {
+		case e.tag == tiffTagXResolution && e.typ == tiffTypeRational && e.count == 1:
+			res.X, haveX = readRational(data, order, e)
+		case e.tag == tiffTagYResolution && e.typ == tiffTypeRational && e.count == 1:
+			res.Y, haveY = readRational(data, order, e)
+		case e.tag == tiffTagResolutionUnit && e.typ == tiffTypeShort && e.count == 1:
+			res.Unit = order.Uint16(data[e.offset+8:])
+		}
+	}
+	ok = haveX && haveY && res.X[1] != 0 && res.Y[1] != 0
+	return res, ok
+}
+
+func readRational(data []byte, order binary.ByteOrder, e tiffEntry) ([2]uint32, bool) {
+	off := int(order.Uint32(data[e.offset+8:]))
+	if off < 0 || off+8 > len(data) {
+		return [2]uint32{}, false
+	}
+	return [2]uint32{order.Uint32(data[off:]), order.Uint32(data[off+4:])}, true
+}
+
+// setTIFFResolution overwrites the resolution fields of the TIFF file in
+// data, which must already have them, with res.
+func setTIFFResolution(data []byte, res tiffResolution) error {
+	order, entries, err := tiffEntries(data)
+	if err != nil {
+		return err
+	}
+	found := 0
+	for _, e := range entries {
+		var value [2]uint32
+		switch {
+		case e.tag == tiffTagXResolution && e.typ == tiffTypeRational && e.count == 1:
+			value = res.X
+		case e.tag == tiffTagYResolution && e.typ == tiffTypeRational && e.count == 1:
+			value = res.Y
+		case e.tag == tiffTagResolutionUnit && e.typ == tiffTypeShort && e.count == 1:
+			order.PutUint16(data[e.offset+8:], res.Unit)
+			found++
+			continue
+		default:
+			continue
+		}
+		off := int(order.Uint32(data[e.offset+8:]))
+		if off < 0 || off+8 > len(data) {
+			return errors.New("tiff: bad resolution offset")
+		}
+		order.PutUint32(data[off:], value[0])
+		order.PutUint32(data[off+4:], value[1])
+		found++
+	}
+	if found != 3 {
+		return errors.New("tiff: resolution fields missing")
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"testing"
+
+	"golang.org/x/image/tiff"
+)
+
+func TestTIFFResolution(t *testing.T) {
+	var buf bytes.Buffer
+	if err := tiff.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 8, 4)), nil); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	// The encoder always records 72 dpi.
+	got, ok := readTIFFResolution(data)
+	if want := (tiffResolution{X: [2]uint32{72, 1}, Y: [2]uint32{72, 1}, Unit: 2}); !ok || got != want {
+		t.Fatalf("readTIFFResolution() = %v, %t, want %v", got, ok, want)
+	}
+	if !got.square() {
+		t.Errorf("Expected 72x72 dpi to be square")
+	}
+
+	want := tiffResolution{X: [2]uint32{300, 1}, Y: [2]uint32{600, 1}, Unit: 3}
+	if err := setTIFFResolution(data, want); err != nil {
+		t.Fatalf("setTIFFResolution() error = %v", err)
+	}
+	if got, ok := readTIFFResolution(data); !ok || got != want {
+		t.Errorf("After setTIFFResolution: got %v, %t, want %v", got, ok, want)
+	}
+	if want.square() {
+		t.Errorf("Expected 300x600 to be non-square")
+	}
+	if got := want.swapped(); got.X != want.Y || got.Y != want.X {
+		t.Errorf("swapped() = %v", got)
+	}
+
+	if _, ok := readTIFFResolution([]byte("\x89PNG\r\n\x1a\n")); ok {
+		t.Error("Expected no resolution from a non-TIFF file")
+	}
+}